@@ -0,0 +1,37 @@
+package main
+
+// Adoption is the share of an institution's population (headcount or
+// roster size) seen using eduroam in the range
+type Adoption struct {
+    Population  int     `json:"population"`
+    UniqueUsers int     `json:"unique_users"`
+    Percent     float64 `json:"percent"`
+}
+
+// ComputeAdoption returns the unique users of result as a percentage of
+// population, or nil without a population
+func ComputeAdoption(result *Result, population int) *Adoption {
+    if population <= 0 {
+        return nil
+    }
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    users := len(result.Users)
+    return &Adoption{
+        Population:  population,
+        UniqueUsers: users,
+        Percent:     float64(users) / float64(population) * 100,
+    }
+}
+
+// Headcount returns the configured population of the first of names (the
+// domain argument and its realm) with a HEADCOUNT line, or 0
+func (props Properties) Headcount(names ...string) int {
+    for _, name := range names {
+        if population, ok := props.Headcounts[name]; ok {
+            return population
+        }
+    }
+    return 0
+}
@@ -0,0 +1,717 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: eduroamidp.proto
+
+// gRPC API of eduroam-idp, served by "eduroam-idp serve". The Go code in
+// this directory is generated from this file (go generate ./api).
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AnalyzeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Domain is given as on the command line: a realm, which gets the
+	// "eduroam." prefix unless no_prefix is set, or a shortcut such as an
+	// ALIAS of the properties file
+	Domain string `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Range takes the command line forms: days, Ny, yxxxx or DD-MM-YYYY
+	// (default: one day)
+	Range string `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
+	// MessageType is accept (default), reject, challenge or accounting
+	MessageType string `protobuf:"bytes,3,opt,name=message_type,json=messageType,proto3" json:"message_type,omitempty"`
+	// Formats are the output formats written (default: json)
+	Formats []string `protobuf:"bytes,4,rep,name=formats,proto3" json:"formats,omitempty"`
+	// NoPrefix uses the domain as the realm as-is (-no-prefix)
+	NoPrefix      bool `protobuf:"varint,5,opt,name=no_prefix,json=noPrefix,proto3" json:"no_prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeRequest) Reset() {
+	*x = AnalyzeRequest{}
+	mi := &file_eduroamidp_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeRequest) ProtoMessage() {}
+
+func (x *AnalyzeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeRequest.ProtoReflect.Descriptor instead.
+func (*AnalyzeRequest) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AnalyzeRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetRange() string {
+	if x != nil {
+		return x.Range
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetMessageType() string {
+	if x != nil {
+		return x.MessageType
+	}
+	return ""
+}
+
+func (x *AnalyzeRequest) GetFormats() []string {
+	if x != nil {
+		return x.Formats
+	}
+	return nil
+}
+
+func (x *AnalyzeRequest) GetNoPrefix() bool {
+	if x != nil {
+		return x.NoPrefix
+	}
+	return false
+}
+
+type GetRunRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetRunRequest) Reset() {
+	*x = GetRunRequest{}
+	mi := &file_eduroamidp_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRunRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRunRequest) ProtoMessage() {}
+
+func (x *GetRunRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRunRequest.ProtoReflect.Descriptor instead.
+func (*GetRunRequest) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetRunRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type ListRunsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Domain restricts the list to the runs of one domain
+	Domain string `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	// Limit is the number of most recent runs returned (default: 20); a
+	// negative limit returns all runs
+	Limit         int32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRunsRequest) Reset() {
+	*x = ListRunsRequest{}
+	mi := &file_eduroamidp_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRunsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRunsRequest) ProtoMessage() {}
+
+func (x *ListRunsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRunsRequest.ProtoReflect.Descriptor instead.
+func (*ListRunsRequest) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListRunsRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ListRunsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListRunsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Runs          []*Run                 `protobuf:"bytes,1,rep,name=runs,proto3" json:"runs,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRunsResponse) Reset() {
+	*x = ListRunsResponse{}
+	mi := &file_eduroamidp_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRunsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRunsResponse) ProtoMessage() {}
+
+func (x *ListRunsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRunsResponse.ProtoReflect.Descriptor instead.
+func (*ListRunsResponse) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListRunsResponse) GetRuns() []*Run {
+	if x != nil {
+		return x.Runs
+	}
+	return nil
+}
+
+type StreamProgressRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Domain restricts the stream to the events of one domain
+	Domain        string `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamProgressRequest) Reset() {
+	*x = StreamProgressRequest{}
+	mi := &file_eduroamidp_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamProgressRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamProgressRequest) ProtoMessage() {}
+
+func (x *StreamProgressRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamProgressRequest.ProtoReflect.Descriptor instead.
+func (*StreamProgressRequest) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamProgressRequest) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+// Run is a run of the run history ("eduroam-idp runs show")
+type Run struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Domain    string                 `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	Range     string                 `protobuf:"bytes,3,opt,name=range,proto3" json:"range,omitempty"`
+	StartDate string                 `protobuf:"bytes,4,opt,name=start_date,json=startDate,proto3" json:"start_date,omitempty"`
+	EndDate   string                 `protobuf:"bytes,5,opt,name=end_date,json=endDate,proto3" json:"end_date,omitempty"`
+	Days      int32                  `protobuf:"varint,6,opt,name=days,proto3" json:"days,omitempty"`
+	// Started is the RFC 3339 start time of the run
+	Started      string `protobuf:"bytes,7,opt,name=started,proto3" json:"started,omitempty"`
+	DurationMs   int64  `protobuf:"varint,8,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	TotalHits    int64  `protobuf:"varint,9,opt,name=total_hits,json=totalHits,proto3" json:"total_hits,omitempty"`
+	Users        int32  `protobuf:"varint,10,opt,name=users,proto3" json:"users,omitempty"`
+	Providers    int32  `protobuf:"varint,11,opt,name=providers,proto3" json:"providers,omitempty"`
+	Partial      bool   `protobuf:"varint,12,opt,name=partial,proto3" json:"partial,omitempty"`
+	Environment  string `protobuf:"bytes,13,opt,name=environment,proto3" json:"environment,omitempty"`
+	PeakRssBytes int64  `protobuf:"varint,14,opt,name=peak_rss_bytes,json=peakRssBytes,proto3" json:"peak_rss_bytes,omitempty"`
+	Version      string `protobuf:"bytes,15,opt,name=version,proto3" json:"version,omitempty"`
+	// Files are the output files of the run on the server
+	Files         []string `protobuf:"bytes,16,rep,name=files,proto3" json:"files,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Run) Reset() {
+	*x = Run{}
+	mi := &file_eduroamidp_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Run) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Run) ProtoMessage() {}
+
+func (x *Run) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Run.ProtoReflect.Descriptor instead.
+func (*Run) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Run) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Run) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *Run) GetRange() string {
+	if x != nil {
+		return x.Range
+	}
+	return ""
+}
+
+func (x *Run) GetStartDate() string {
+	if x != nil {
+		return x.StartDate
+	}
+	return ""
+}
+
+func (x *Run) GetEndDate() string {
+	if x != nil {
+		return x.EndDate
+	}
+	return ""
+}
+
+func (x *Run) GetDays() int32 {
+	if x != nil {
+		return x.Days
+	}
+	return 0
+}
+
+func (x *Run) GetStarted() string {
+	if x != nil {
+		return x.Started
+	}
+	return ""
+}
+
+func (x *Run) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *Run) GetTotalHits() int64 {
+	if x != nil {
+		return x.TotalHits
+	}
+	return 0
+}
+
+func (x *Run) GetUsers() int32 {
+	if x != nil {
+		return x.Users
+	}
+	return 0
+}
+
+func (x *Run) GetProviders() int32 {
+	if x != nil {
+		return x.Providers
+	}
+	return 0
+}
+
+func (x *Run) GetPartial() bool {
+	if x != nil {
+		return x.Partial
+	}
+	return false
+}
+
+func (x *Run) GetEnvironment() string {
+	if x != nil {
+		return x.Environment
+	}
+	return ""
+}
+
+func (x *Run) GetPeakRssBytes() int64 {
+	if x != nil {
+		return x.PeakRssBytes
+	}
+	return 0
+}
+
+func (x *Run) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Run) GetFiles() []string {
+	if x != nil {
+		return x.Files
+	}
+	return nil
+}
+
+// ProgressEvent is an event of the /events stream of the status listener
+type ProgressEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Type is start, progress, done or error
+	Type          string  `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Domain        string  `protobuf:"bytes,2,opt,name=domain,proto3" json:"domain,omitempty"`
+	ProcessedDays int32   `protobuf:"varint,3,opt,name=processed_days,json=processedDays,proto3" json:"processed_days,omitempty"`
+	TotalDays     int32   `protobuf:"varint,4,opt,name=total_days,json=totalDays,proto3" json:"total_days,omitempty"`
+	TotalHits     int64   `protobuf:"varint,5,opt,name=total_hits,json=totalHits,proto3" json:"total_hits,omitempty"`
+	InFlight      int32   `protobuf:"varint,6,opt,name=in_flight,json=inFlight,proto3" json:"in_flight,omitempty"`
+	Percent       float64 `protobuf:"fixed64,7,opt,name=percent,proto3" json:"percent,omitempty"`
+	Elapsed       string  `protobuf:"bytes,8,opt,name=elapsed,proto3" json:"elapsed,omitempty"`
+	Message       string  `protobuf:"bytes,9,opt,name=message,proto3" json:"message,omitempty"`
+	ErrorClass    string  `protobuf:"bytes,10,opt,name=error_class,json=errorClass,proto3" json:"error_class,omitempty"`
+	// Time is the RFC 3339 time of the event
+	Time          string `protobuf:"bytes,11,opt,name=time,proto3" json:"time,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ProgressEvent) Reset() {
+	*x = ProgressEvent{}
+	mi := &file_eduroamidp_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ProgressEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ProgressEvent) ProtoMessage() {}
+
+func (x *ProgressEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_eduroamidp_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ProgressEvent.ProtoReflect.Descriptor instead.
+func (*ProgressEvent) Descriptor() ([]byte, []int) {
+	return file_eduroamidp_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ProgressEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetProcessedDays() int32 {
+	if x != nil {
+		return x.ProcessedDays
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetTotalDays() int32 {
+	if x != nil {
+		return x.TotalDays
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetTotalHits() int64 {
+	if x != nil {
+		return x.TotalHits
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetInFlight() int32 {
+	if x != nil {
+		return x.InFlight
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetPercent() float64 {
+	if x != nil {
+		return x.Percent
+	}
+	return 0
+}
+
+func (x *ProgressEvent) GetElapsed() string {
+	if x != nil {
+		return x.Elapsed
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetErrorClass() string {
+	if x != nil {
+		return x.ErrorClass
+	}
+	return ""
+}
+
+func (x *ProgressEvent) GetTime() string {
+	if x != nil {
+		return x.Time
+	}
+	return ""
+}
+
+var File_eduroamidp_proto protoreflect.FileDescriptor
+
+const file_eduroamidp_proto_rawDesc = "" +
+	"\n" +
+	"\x10eduroamidp.proto\x12\reduroamidp.v1\"\x98\x01\n" +
+	"\x0eAnalyzeRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\x14\n" +
+	"\x05range\x18\x02 \x01(\tR\x05range\x12!\n" +
+	"\fmessage_type\x18\x03 \x01(\tR\vmessageType\x12\x18\n" +
+	"\aformats\x18\x04 \x03(\tR\aformats\x12\x1b\n" +
+	"\tno_prefix\x18\x05 \x01(\bR\bnoPrefix\"\x1f\n" +
+	"\rGetRunRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\"?\n" +
+	"\x0fListRunsRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\":\n" +
+	"\x10ListRunsResponse\x12&\n" +
+	"\x04runs\x18\x01 \x03(\v2\x12.eduroamidp.v1.RunR\x04runs\"/\n" +
+	"\x15StreamProgressRequest\x12\x16\n" +
+	"\x06domain\x18\x01 \x01(\tR\x06domain\"\xb1\x03\n" +
+	"\x03Run\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12\x14\n" +
+	"\x05range\x18\x03 \x01(\tR\x05range\x12\x1d\n" +
+	"\n" +
+	"start_date\x18\x04 \x01(\tR\tstartDate\x12\x19\n" +
+	"\bend_date\x18\x05 \x01(\tR\aendDate\x12\x12\n" +
+	"\x04days\x18\x06 \x01(\x05R\x04days\x12\x18\n" +
+	"\astarted\x18\a \x01(\tR\astarted\x12\x1f\n" +
+	"\vduration_ms\x18\b \x01(\x03R\n" +
+	"durationMs\x12\x1d\n" +
+	"\n" +
+	"total_hits\x18\t \x01(\x03R\ttotalHits\x12\x14\n" +
+	"\x05users\x18\n" +
+	" \x01(\x05R\x05users\x12\x1c\n" +
+	"\tproviders\x18\v \x01(\x05R\tproviders\x12\x18\n" +
+	"\apartial\x18\f \x01(\bR\apartial\x12 \n" +
+	"\venvironment\x18\r \x01(\tR\venvironment\x12$\n" +
+	"\x0epeak_rss_bytes\x18\x0e \x01(\x03R\fpeakRssBytes\x12\x18\n" +
+	"\aversion\x18\x0f \x01(\tR\aversion\x12\x14\n" +
+	"\x05files\x18\x10 \x03(\tR\x05files\"\xc0\x02\n" +
+	"\rProgressEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x16\n" +
+	"\x06domain\x18\x02 \x01(\tR\x06domain\x12%\n" +
+	"\x0eprocessed_days\x18\x03 \x01(\x05R\rprocessedDays\x12\x1d\n" +
+	"\n" +
+	"total_days\x18\x04 \x01(\x05R\ttotalDays\x12\x1d\n" +
+	"\n" +
+	"total_hits\x18\x05 \x01(\x03R\ttotalHits\x12\x1b\n" +
+	"\tin_flight\x18\x06 \x01(\x05R\binFlight\x12\x18\n" +
+	"\apercent\x18\a \x01(\x01R\apercent\x12\x18\n" +
+	"\aelapsed\x18\b \x01(\tR\aelapsed\x12\x18\n" +
+	"\amessage\x18\t \x01(\tR\amessage\x12\x1f\n" +
+	"\verror_class\x18\n" +
+	" \x01(\tR\n" +
+	"errorClass\x12\x12\n" +
+	"\x04time\x18\v \x01(\tR\x04time2\xb0\x02\n" +
+	"\x0fAnalysisService\x12<\n" +
+	"\aAnalyze\x12\x1d.eduroamidp.v1.AnalyzeRequest\x1a\x12.eduroamidp.v1.Run\x12:\n" +
+	"\x06GetRun\x12\x1c.eduroamidp.v1.GetRunRequest\x1a\x12.eduroamidp.v1.Run\x12K\n" +
+	"\bListRuns\x12\x1e.eduroamidp.v1.ListRunsRequest\x1a\x1f.eduroamidp.v1.ListRunsResponse\x12V\n" +
+	"\x0eStreamProgress\x12$.eduroamidp.v1.StreamProgressRequest\x1a\x1c.eduroamidp.v1.ProgressEvent0\x01B\x15Z\x13edutoam-idp/api;apib\x06proto3"
+
+var (
+	file_eduroamidp_proto_rawDescOnce sync.Once
+	file_eduroamidp_proto_rawDescData []byte
+)
+
+func file_eduroamidp_proto_rawDescGZIP() []byte {
+	file_eduroamidp_proto_rawDescOnce.Do(func() {
+		file_eduroamidp_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_eduroamidp_proto_rawDesc), len(file_eduroamidp_proto_rawDesc)))
+	})
+	return file_eduroamidp_proto_rawDescData
+}
+
+var file_eduroamidp_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_eduroamidp_proto_goTypes = []any{
+	(*AnalyzeRequest)(nil),        // 0: eduroamidp.v1.AnalyzeRequest
+	(*GetRunRequest)(nil),         // 1: eduroamidp.v1.GetRunRequest
+	(*ListRunsRequest)(nil),       // 2: eduroamidp.v1.ListRunsRequest
+	(*ListRunsResponse)(nil),      // 3: eduroamidp.v1.ListRunsResponse
+	(*StreamProgressRequest)(nil), // 4: eduroamidp.v1.StreamProgressRequest
+	(*Run)(nil),                   // 5: eduroamidp.v1.Run
+	(*ProgressEvent)(nil),         // 6: eduroamidp.v1.ProgressEvent
+}
+var file_eduroamidp_proto_depIdxs = []int32{
+	5, // 0: eduroamidp.v1.ListRunsResponse.runs:type_name -> eduroamidp.v1.Run
+	0, // 1: eduroamidp.v1.AnalysisService.Analyze:input_type -> eduroamidp.v1.AnalyzeRequest
+	1, // 2: eduroamidp.v1.AnalysisService.GetRun:input_type -> eduroamidp.v1.GetRunRequest
+	2, // 3: eduroamidp.v1.AnalysisService.ListRuns:input_type -> eduroamidp.v1.ListRunsRequest
+	4, // 4: eduroamidp.v1.AnalysisService.StreamProgress:input_type -> eduroamidp.v1.StreamProgressRequest
+	5, // 5: eduroamidp.v1.AnalysisService.Analyze:output_type -> eduroamidp.v1.Run
+	5, // 6: eduroamidp.v1.AnalysisService.GetRun:output_type -> eduroamidp.v1.Run
+	3, // 7: eduroamidp.v1.AnalysisService.ListRuns:output_type -> eduroamidp.v1.ListRunsResponse
+	6, // 8: eduroamidp.v1.AnalysisService.StreamProgress:output_type -> eduroamidp.v1.ProgressEvent
+	5, // [5:9] is the sub-list for method output_type
+	1, // [1:5] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_eduroamidp_proto_init() }
+func file_eduroamidp_proto_init() {
+	if File_eduroamidp_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_eduroamidp_proto_rawDesc), len(file_eduroamidp_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_eduroamidp_proto_goTypes,
+		DependencyIndexes: file_eduroamidp_proto_depIdxs,
+		MessageInfos:      file_eduroamidp_proto_msgTypes,
+	}.Build()
+	File_eduroamidp_proto = out.File
+	file_eduroamidp_proto_goTypes = nil
+	file_eduroamidp_proto_depIdxs = nil
+}
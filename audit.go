@@ -0,0 +1,113 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "os/user"
+    "sync"
+    "time"
+)
+
+// AuditRecord is a single entry of the query audit log
+type AuditRecord struct {
+    Timestamp    string `json:"timestamp"`
+    Operator     string `json:"operator"`
+    QuickwitUser string `json:"quickwit_user"`
+    Domain       string `json:"domain"`
+    Query        string `json:"query"`
+    WindowStart  string `json:"window_start,omitempty"`
+    WindowEnd    string `json:"window_end,omitempty"`
+    DurationMs   int64  `json:"duration_ms"`
+    Hits         int64  `json:"hits"`
+    Status       string `json:"status"`
+    Error        string `json:"error,omitempty"`
+    ErrorClass   string `json:"error_class,omitempty"`
+}
+
+// AuditLog appends one JSON line per Quickwit query to a file opened in
+// append-only mode
+type AuditLog struct {
+    mu       sync.Mutex
+    file     *os.File
+    operator string
+    domain   string
+    // writeErr is the first write error; it is logged once and returned
+    // by Close
+    writeErr error
+}
+
+// OpenAuditLog opens (or creates) the audit log at path for appending
+func OpenAuditLog(path, domain string) (*AuditLog, error) {
+    file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return nil, fmt.Errorf("error opening audit log: %w", err)
+    }
+
+    operator := "unknown"
+    if current, err := user.Current(); err == nil {
+        operator = current.Username
+    }
+
+    return &AuditLog{file: file, operator: operator, domain: domain}, nil
+}
+
+// Record appends an audit entry for a query issued by qwUser. The query map
+// is the request body sent to Quickwit; response may be nil on failure.
+func (a *AuditLog) Record(qwUser string, query map[string]interface{}, response *SearchResponse, duration time.Duration, queryErr error) {
+    if a == nil {
+        return
+    }
+
+    record := AuditRecord{
+        Timestamp:    time.Now().Format(time.RFC3339),
+        Operator:     a.operator,
+        QuickwitUser: qwUser,
+        Domain:       a.domain,
+        Query:        fmt.Sprint(query["query"]),
+        DurationMs:   duration.Milliseconds(),
+        Status:       "ok",
+    }
+    if start, ok := query["start_timestamp"].(int64); ok {
+        record.WindowStart = time.Unix(start, 0).Format(time.RFC3339)
+    }
+    if end, ok := query["end_timestamp"].(int64); ok {
+        record.WindowEnd = time.Unix(end, 0).Format(time.RFC3339)
+    }
+    if response != nil {
+        record.Hits = int64(response.NumHits)
+    }
+    if queryErr != nil {
+        record.Status = "error"
+        record.Error = queryErr.Error()
+        record.ErrorClass = ErrorClass(queryErr)
+    }
+
+    line, err := json.Marshal(record)
+    if err != nil {
+        return
+    }
+
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if _, err := a.file.Write(append(line, '\n')); err != nil && a.writeErr == nil {
+        a.writeErr = fmt.Errorf("%w: %w", ErrAuditLog, err)
+        log.Printf("Warning: %v; audit records are being lost", a.writeErr)
+    }
+}
+
+// Close closes the underlying file, returning the first write error if
+// records were lost
+func (a *AuditLog) Close() error {
+    if a == nil {
+        return nil
+    }
+    err := a.file.Close()
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if a.writeErr != nil {
+        return a.writeErr
+    }
+    return err
+}
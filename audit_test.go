@@ -0,0 +1,27 @@
+package main
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestAuditLogWriteError(t *testing.T) {
+    audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"), "uni.example")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Writes to a closed file fail like those to a full disk
+    audit.file.Close()
+    audit.Record("user", map[string]interface{}{"query": "*"}, nil, time.Second, nil)
+    audit.Record("user", map[string]interface{}{"query": "*"}, nil, time.Second, nil)
+    err = audit.Close()
+    if !errors.Is(err, os.ErrClosed) {
+        t.Errorf("Close = %v, want the write error", err)
+    }
+    if ExitCode(err) != ExitAuditLog {
+        t.Errorf("exit status of %v = %d, want %d", err, ExitCode(err), ExitAuditLog)
+    }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+    "sort"
+    "strconv"
+)
+
+// AuthHistogramBucket is the number of users whose auth count falls in a range
+type AuthHistogramBucket struct {
+    Range string `json:"range"`
+    Users int    `json:"users"`
+}
+
+// AuthDistribution summarises the number of authentications per user. A long
+// tail of very high counts usually points at devices stuck in re-auth loops.
+type AuthDistribution struct {
+    Mean      float64               `json:"mean"`
+    Median    int64                 `json:"median"`
+    P95       int64                 `json:"p95"`
+    Max       int64                 `json:"max"`
+    Histogram []AuthHistogramBucket `json:"histogram"`
+}
+
+// authHistogramBounds are the inclusive upper bounds of the histogram
+// buckets; users above the last bound fall in a final open bucket
+var authHistogramBounds = []struct {
+    Label string
+    Max   int64
+}{
+    {"1-10", 10},
+    {"11-100", 100},
+}
+
+// ComputeAuthDistribution returns the auths-per-user statistics of a result
+func ComputeAuthDistribution(result *Result) *AuthDistribution {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Users) == 0 {
+        return nil
+    }
+
+    hits := make([]int64, 0, len(result.Users))
+    var total int64
+    for _, stats := range result.Users {
+        hits = append(hits, stats.Hits)
+        total += stats.Hits
+    }
+    sort.Slice(hits, func(i, j int) bool { return hits[i] < hits[j] })
+
+    dist := &AuthDistribution{
+        Mean:   float64(total) / float64(len(hits)),
+        Median: percentileHits(hits, 50),
+        P95:    percentileHits(hits, 95),
+        Max:    hits[len(hits)-1],
+    }
+
+    counts := make([]int, len(authHistogramBounds)+1)
+    for _, h := range hits {
+        bucket := len(authHistogramBounds)
+        for i, bound := range authHistogramBounds {
+            if h <= bound.Max {
+                bucket = i
+                break
+            }
+        }
+        counts[bucket]++
+    }
+    for i, bound := range authHistogramBounds {
+        dist.Histogram = append(dist.Histogram, AuthHistogramBucket{Range: bound.Label, Users: counts[i]})
+    }
+    last := authHistogramBounds[len(authHistogramBounds)-1].Max
+    dist.Histogram = append(dist.Histogram, AuthHistogramBucket{
+        Range: strconv.FormatInt(last+1, 10) + "+",
+        Users: counts[len(authHistogramBounds)],
+    })
+    return dist
+}
+
+// percentileHits returns the p-th percentile of sorted hit counts
+func percentileHits(sorted []int64, p float64) int64 {
+    index := int(p/100*float64(len(sorted))+0.5) - 1
+    if index < 0 {
+        index = 0
+    }
+    if index >= len(sorted) {
+        index = len(sorted) - 1
+    }
+    return sorted[index]
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultBackfillParallel is the number of manifest entries run at once
+    DefaultBackfillParallel = 1
+
+    // backfillInterruptWait is how long an interrupted run may take to save
+    // its partial result before it is killed
+    backfillInterruptWait = 2 * time.Minute
+
+    // Backfill entry states kept in the status file. A partial entry
+    // (ExitPartialRange) saved output for part of its range and is run
+    // again on resume; a truncated entry (ExitBucketTruncated) saved its
+    // whole range with truncated days and is not, as a new run would
+    // truncate the same days.
+    BackfillPending   = "pending"
+    BackfillRunning   = "running"
+    BackfillDone      = "done"
+    BackfillPartial   = "partial"
+    BackfillTruncated = "truncated"
+    BackfillFailed    = "failed"
+)
+
+// ErrInvalidManifest is returned for manifests that cannot be parsed
+var ErrInvalidManifest = errors.New("invalid backfill manifest")
+
+// BackfillEntry is one (domain, range) analysis of a backfill manifest
+type BackfillEntry struct {
+    Domain string
+    Range  string
+    // Flags are passed to the analysis before the entry's domain and range,
+    // after the manifest-wide flags
+    Flags []string
+}
+
+// Key identifies the entry in the status file
+func (e BackfillEntry) Key() string {
+    if e.Range == "" {
+        return e.Domain
+    }
+    return e.Domain + " " + e.Range
+}
+
+// BackfillManifest lists the analyses of a backfill
+type BackfillManifest struct {
+    Parallel int
+    Flags    []string
+    Entries  []BackfillEntry
+}
+
+// BackfillStatus is the recorded state of one manifest entry
+type BackfillStatus struct {
+    State    string    `json:"state"`
+    Attempts int       `json:"attempts"`
+    Started  time.Time `json:"started,omitempty"`
+    Finished time.Time `json:"finished,omitempty"`
+    Error    string    `json:"error,omitempty"`
+    Log      string    `json:"log,omitempty"`
+}
+
+// ParseBackfillManifest reads the YAML subset used by backfill manifests:
+//
+//  parallel: 2
+//  flags: [-store, -output-dir, /data/reports]
+//  entries:
+//    - domain: uni
+//      range: y2023
+//    - domain: chula.ac.th
+//      range: 01-03-2024
+//      flags: [-realm-ci]
+//
+// Comments start with '#'; lists may also be written as "- item" lines.
+// Ranges must be absolute (yxxxx or DD-MM-YYYY): a relative range would
+// cover a different window when an interrupted backfill is resumed.
+func ParseBackfillManifest(path string) (BackfillManifest, error) {
+    manifest := BackfillManifest{Parallel: DefaultBackfillParallel}
+    file, err := os.Open(path)
+    if err != nil {
+        return manifest, fmt.Errorf("failed to open manifest: %w", err)
+    }
+    defer file.Close()
+
+    var section string
+    var entry *BackfillEntry
+    var entryList string
+    entryIndent := 0
+    lineNo := 0
+    invalid := func(format string, args ...interface{}) error {
+        return fmt.Errorf("%w: line %d: %s", ErrInvalidManifest, lineNo, fmt.Sprintf(format, args...))
+    }
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lineNo++
+        line := stripYAMLComment(scanner.Text())
+        if strings.TrimSpace(line) == "" {
+            continue
+        }
+        indent := len(line) - len(strings.TrimLeft(line, " "))
+        line = strings.TrimSpace(line)
+
+        if indent == 0 {
+            key, value, ok := strings.Cut(line, ":")
+            if !ok {
+                return manifest, invalid("expected key: value")
+            }
+            section, entry, entryList = strings.TrimSpace(key), nil, ""
+            value = strings.TrimSpace(value)
+            switch section {
+            case "parallel":
+                if manifest.Parallel, err = strconv.Atoi(value); err != nil || manifest.Parallel < 1 {
+                    return manifest, invalid("parallel must be a positive number")
+                }
+            case "flags":
+                manifest.Flags = parseYAMLList(value)
+            case "entries":
+                if value != "" {
+                    return manifest, invalid("entries must be a list")
+                }
+            default:
+                return manifest, invalid("unknown key %q", section)
+            }
+            continue
+        }
+
+        item, isItem := strings.CutPrefix(line, "- ")
+        if line == "-" {
+            item, isItem = "", true
+        }
+        switch {
+        case section == "flags" && isItem:
+            manifest.Flags = append(manifest.Flags, unquoteYAML(item))
+        case section == "entries" && entryList != "" && isItem && entry != nil && indent > entryIndent:
+            entry.Flags = append(entry.Flags, unquoteYAML(item))
+        case section == "entries" && isItem:
+            manifest.Entries = append(manifest.Entries, BackfillEntry{})
+            entry, entryList, entryIndent = &manifest.Entries[len(manifest.Entries)-1], "", indent
+            if item != "" {
+                if err := setBackfillField(entry, item, &entryList); err != nil {
+                    return manifest, invalid("%v", err)
+                }
+            }
+        case section == "entries" && entry != nil:
+            if err := setBackfillField(entry, line, &entryList); err != nil {
+                return manifest, invalid("%v", err)
+            }
+        default:
+            return manifest, invalid("unexpected %q", line)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return manifest, err
+    }
+
+    seen := make(map[string]bool)
+    for i, entry := range manifest.Entries {
+        if entry.Domain == "" {
+            return manifest, fmt.Errorf("%w: entry %d has no domain", ErrInvalidManifest, i+1)
+        }
+        timeRange, err := ResolveTimeRange(entry.Range)
+        if err != nil {
+            return manifest, fmt.Errorf("%w: entry %s: %w", ErrInvalidManifest, entry.Key(), err)
+        }
+        if !timeRange.SpecificYear && !timeRange.SpecificDate {
+            return manifest, fmt.Errorf("%w: entry %s: range %q is relative to the current day, use yxxxx or DD-MM-YYYY", ErrInvalidManifest, entry.Key(), entry.Range)
+        }
+        if seen[entry.Key()] {
+            return manifest, fmt.Errorf("%w: duplicate entry %s", ErrInvalidManifest, entry.Key())
+        }
+        seen[entry.Key()] = true
+    }
+    return manifest, nil
+}
+
+// setBackfillField sets one "key: value" field of an entry; a "flags:" key
+// without value starts a list continued on the following lines
+func setBackfillField(entry *BackfillEntry, line string, list *string) error {
+    key, value, ok := strings.Cut(line, ":")
+    if !ok {
+        return fmt.Errorf("expected key: value, got %q", line)
+    }
+    value = strings.TrimSpace(value)
+    *list = ""
+    switch strings.TrimSpace(key) {
+    case "domain":
+        entry.Domain = unquoteYAML(value)
+    case "range":
+        entry.Range = unquoteYAML(value)
+    case "flags":
+        if value == "" {
+            *list = "flags"
+        }
+        entry.Flags = parseYAMLList(value)
+    default:
+        return fmt.Errorf("unknown entry key %q", key)
+    }
+    return nil
+}
+
+// stripYAMLComment removes a '#' comment outside of quotes
+func stripYAMLComment(line string) string {
+    var quote rune
+    for i, r := range line {
+        switch {
+        case quote != 0:
+            if r == quote {
+                quote = 0
+            }
+        case r == '"' || r == '\'':
+            quote = r
+        case r == '#' && (i == 0 || line[i-1] == ' '):
+            return line[:i]
+        }
+    }
+    return line
+}
+
+// parseYAMLList parses an inline "[a, b]" list; a bare value is a one-item list
+func parseYAMLList(value string) []string {
+    value = strings.TrimSpace(value)
+    if value == "" || value == "[]" {
+        return nil
+    }
+    if inner, ok := strings.CutPrefix(value, "["); ok {
+        value = strings.TrimSuffix(inner, "]")
+    } else {
+        return []string{unquoteYAML(value)}
+    }
+    var items []string
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, unquoteYAML(item))
+        }
+    }
+    return items
+}
+
+// unquoteYAML removes matching single or double quotes around a scalar
+func unquoteYAML(value string) string {
+    value = strings.TrimSpace(value)
+    if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+        return value[1 : len(value)-1]
+    }
+    return value
+}
+
+// BackfillTracker keeps the per-entry status of a backfill in a JSON file so
+// that an interrupted backfill can be resumed
+type BackfillTracker struct {
+    mu     sync.Mutex
+    path   string
+    status map[string]*BackfillStatus
+}
+
+// OpenBackfillTracker loads the status file at path, if any
+func OpenBackfillTracker(path string) (*BackfillTracker, error) {
+    tracker := &BackfillTracker{path: path, status: make(map[string]*BackfillStatus)}
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return tracker, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(data, &tracker.status); err != nil {
+        return nil, fmt.Errorf("error decoding backfill status %s: %w", path, err)
+    }
+    return tracker, nil
+}
+
+// Get returns a copy of the status of key; unknown entries are pending
+func (t *BackfillTracker) Get(key string) BackfillStatus {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if status, ok := t.status[key]; ok {
+        return *status
+    }
+    return BackfillStatus{State: BackfillPending}
+}
+
+// Update changes the status of key and saves the status file
+func (t *BackfillTracker) Update(key string, update func(*BackfillStatus)) error {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    status, ok := t.status[key]
+    if !ok {
+        status = &BackfillStatus{State: BackfillPending}
+        t.status[key] = status
+    }
+    update(status)
+
+    data, err := json.MarshalIndent(t.status, "", "  ")
+    if err != nil {
+        return err
+    }
+    tmp := t.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, t.path)
+}
+
+// BackfillFinished reports whether an entry in state is not run again
+func BackfillFinished(state string) bool {
+    return state == BackfillDone || state == BackfillTruncated
+}
+
+// backfillOutcome returns the state and error text of an entry whose
+// analysis exited with err
+func backfillOutcome(err error) (state, message string) {
+    var exitErr *exec.ExitError
+    switch {
+    case err == nil:
+        return BackfillDone, ""
+    case errors.As(err, &exitErr) && exitErr.ExitCode() == ExitPartialRange:
+        return BackfillPartial, ErrPartialRange.Error()
+    case errors.As(err, &exitErr) && exitErr.ExitCode() == ExitBucketTruncated:
+        return BackfillTruncated, ErrBucketTruncated.Error()
+    }
+    return BackfillFailed, err.Error()
+}
+
+// RunBackfill runs the entries of manifest that are not finished yet, at
+// most parallel at a time, each as a separate analysis run of executable.
+// The output of each run goes to its own file in logDir. It returns the
+// number of entries that failed and that only covered part of their range.
+func RunBackfill(ctx context.Context, manifest BackfillManifest, tracker *BackfillTracker, executable, logDir string, parallel int) (failed, partial int, err error) {
+    if err := os.MkdirAll(logDir, 0755); err != nil {
+        return 0, 0, fmt.Errorf("failed to create log directory: %w", err)
+    }
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    sem := make(chan struct{}, parallel)
+    for _, entry := range manifest.Entries {
+        if BackfillFinished(tracker.Get(entry.Key()).State) {
+            continue
+        }
+        select {
+        case sem <- struct{}{}:
+        case <-ctx.Done():
+            wg.Wait()
+            return failed, partial, ctx.Err()
+        }
+        wg.Add(1)
+        go func(entry BackfillEntry) {
+            defer wg.Done()
+            defer func() { <-sem }()
+            state, err := runBackfillEntry(ctx, manifest, entry, tracker, executable, logDir)
+            if err != nil {
+                log.Printf("Backfill %s failed: %v", entry.Key(), err)
+            }
+            mu.Lock()
+            switch {
+            case err != nil:
+                failed++
+            case state == BackfillPartial:
+                partial++
+            }
+            mu.Unlock()
+        }(entry)
+    }
+    wg.Wait()
+    return failed, partial, ctx.Err()
+}
+
+// runBackfillEntry runs the analysis of one entry and records its outcome.
+// Partial and truncated runs saved their output, so they are returned as
+// states rather than errors.
+func runBackfillEntry(ctx context.Context, manifest BackfillManifest, entry BackfillEntry, tracker *BackfillTracker, executable, logDir string) (string, error) {
+    key := entry.Key()
+    logFile := filepath.Join(logDir, strings.NewReplacer(" ", "-", "/", "_").Replace(key)+".log")
+    output, err := os.Create(logFile)
+    if err != nil {
+        return BackfillFailed, err
+    }
+    defer output.Close()
+
+    args := append(append(append([]string{}, manifest.Flags...), entry.Flags...), entry.Domain)
+    if entry.Range != "" {
+        args = append(args, entry.Range)
+    }
+
+    tracker.Update(key, func(s *BackfillStatus) {
+        s.State, s.Started, s.Finished, s.Error, s.Log = BackfillRunning, time.Now(), time.Time{}, "", logFile
+        s.Attempts++
+    })
+    fmt.Printf("Started %s\n", key)
+
+    // A cancelled backfill interrupts its runs like Ctrl-C, so that they
+    // save their partial results, and only kills those that do not exit
+    cmd := exec.CommandContext(ctx, executable, args...)
+    cmd.Cancel = func() error {
+        if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+            return cmd.Process.Kill()
+        }
+        return nil
+    }
+    cmd.WaitDelay = backfillInterruptWait
+    cmd.Stdout = output
+    cmd.Stderr = output
+    runErr := cmd.Run()
+    if cmd.ProcessState != nil && cmd.ProcessState.Success() {
+        // Finished before it noticed the interrupt
+        runErr = nil
+    }
+    state, message := backfillOutcome(runErr)
+
+    if err := tracker.Update(key, func(s *BackfillStatus) {
+        s.Finished = time.Now()
+        s.State, s.Error = state, message
+    }); err != nil {
+        return BackfillFailed, fmt.Errorf("error saving backfill status: %w", err)
+    }
+    switch state {
+    case BackfillFailed:
+        return state, fmt.Errorf("%w (see %s)", runErr, logFile)
+    case BackfillDone:
+        fmt.Printf("Finished %s\n", key)
+    default:
+        fmt.Printf("Finished %s: %s (see %s)\n", key, message, logFile)
+    }
+    return state, nil
+}
+
+// runBackfill implements the "backfill" subcommand
+func runBackfill(args []string) int {
+    flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+    parallel := flags.Int("parallel", 0, "Number of entries analysed at once (default: parallel of the manifest, else 1)")
+    statusFile := flags.String("status", "", "Status file used to resume (default: <manifest>.status.json)")
+    logDir := flags.String("log-dir", "", "Directory for the output of each entry (default: <manifest>.logs)")
+    restart := flags.Bool("restart", false, "Ignore the status file and run every entry again")
+    dryRun := flags.Bool("dry-run", false, "List the entries and their status without running them")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp backfill [flags] <manifest.yaml>")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        flags.Usage()
+        return 1
+    }
+    manifestPath := flags.Arg(0)
+    manifest, err := ParseBackfillManifest(manifestPath)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    if *parallel > 0 {
+        manifest.Parallel = *parallel
+    }
+    base := strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath))
+    if *statusFile == "" {
+        *statusFile = base + ".status.json"
+    }
+    if *logDir == "" {
+        *logDir = base + ".logs"
+    }
+
+    if *restart {
+        if err := os.Remove(*statusFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+            log.Printf("Error: %v", err)
+            return 1
+        }
+    }
+    tracker, err := OpenBackfillTracker(*statusFile)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    if *dryRun {
+        for _, entry := range manifest.Entries {
+            status := tracker.Get(entry.Key())
+            fmt.Printf("  %-40s %-8s %d attempts\n", entry.Key(), status.State, status.Attempts)
+        }
+        return 0
+    }
+
+    executable, err := os.Executable()
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    fmt.Printf("Backfilling %d entries, %d at a time\n", len(manifest.Entries), manifest.Parallel)
+    failed, partial, err := RunBackfill(ctx, manifest, tracker, executable, *logDir, manifest.Parallel)
+    if err != nil {
+        log.Printf("Backfill interrupted: %v", err)
+        return 1
+    }
+    if failed > 0 {
+        log.Printf("%d entries failed; run the backfill again to retry them", failed)
+        return 1
+    }
+    if partial > 0 {
+        log.Printf("%d entries only cover part of their range; run the backfill again to complete them", partial)
+        return ExitPartialRange
+    }
+    fmt.Println("Backfill complete")
+    return 0
+}
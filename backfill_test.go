@@ -0,0 +1,102 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestRunBackfillExitStates(t *testing.T) {
+    dir := t.TempDir()
+    // The fake analysis exits with the code given as its domain
+    executable := filepath.Join(dir, "analysis.sh")
+    if err := os.WriteFile(executable, []byte("#!/bin/sh\nexit $1\n"), 0755); err != nil {
+        t.Fatal(err)
+    }
+    tracker, err := OpenBackfillTracker(filepath.Join(dir, "status.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    manifest := BackfillManifest{Entries: []BackfillEntry{{Domain: "0"}, {Domain: "1"}, {Domain: "7"}, {Domain: "8"}}}
+
+    failed, partial, err := RunBackfill(context.Background(), manifest, tracker, executable, filepath.Join(dir, "logs"), 2)
+    if err != nil || failed != 1 || partial != 1 {
+        t.Fatalf("RunBackfill = %d failed, %d partial, %v; want 1, 1, nil", failed, partial, err)
+    }
+    want := map[string]string{"0": BackfillDone, "1": BackfillFailed, "7": BackfillPartial, "8": BackfillTruncated}
+    for domain, state := range want {
+        if got := tracker.Get(domain).State; got != state {
+            t.Errorf("state of %s = %q, want %q", domain, got, state)
+        }
+    }
+
+    // Only the failed and partial entries run again
+    if _, _, err := RunBackfill(context.Background(), manifest, tracker, executable, filepath.Join(dir, "logs"), 2); err != nil {
+        t.Fatal(err)
+    }
+    for domain, attempts := range map[string]int{"0": 1, "1": 2, "7": 2, "8": 1} {
+        if got := tracker.Get(domain).Attempts; got != attempts {
+            t.Errorf("attempts of %s = %d, want %d", domain, got, attempts)
+        }
+    }
+}
+
+func TestBackfillManifestRanges(t *testing.T) {
+    tests := []struct {
+        entries string
+        valid   bool
+    }{
+        {"  - domain: uni\n    range: y2023\n", true},
+        {"  - domain: uni\n    range: 01-03-2024\n", true},
+        {"  - domain: uni\n    range: 365\n", false},
+        {"  - domain: uni\n    range: 2y\n", false},
+        {"  - domain: uni\n", false},
+    }
+    for _, tt := range tests {
+        path := filepath.Join(t.TempDir(), "manifest.yaml")
+        if err := os.WriteFile(path, []byte("entries:\n"+tt.entries), 0600); err != nil {
+            t.Fatal(err)
+        }
+        _, err := ParseBackfillManifest(path)
+        if valid := err == nil; valid != tt.valid || (err != nil && !errors.Is(err, ErrInvalidManifest)) {
+            t.Errorf("manifest %q: %v", tt.entries, err)
+        }
+    }
+}
+
+func TestRunBackfillInterrupts(t *testing.T) {
+    dir := t.TempDir()
+    // The fake analysis saves a partial result when interrupted
+    executable := filepath.Join(dir, "analysis.sh")
+    script := "#!/bin/sh\ntrap 'exit 7' INT\necho started\nwhile :; do sleep 0.05; done\n"
+    if err := os.WriteFile(executable, []byte(script), 0755); err != nil {
+        t.Fatal(err)
+    }
+    tracker, err := OpenBackfillTracker(filepath.Join(dir, "status.json"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    manifest := BackfillManifest{Entries: []BackfillEntry{{Domain: "uni", Range: "y2023"}}}
+    logDir := filepath.Join(dir, "logs")
+
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        // Interrupt once the run is under way
+        for {
+            if data, _ := os.ReadFile(filepath.Join(logDir, "uni-y2023.log")); len(data) > 0 {
+                cancel()
+                return
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }()
+    if _, _, err := RunBackfill(ctx, manifest, tracker, executable, logDir, 1); !errors.Is(err, context.Canceled) {
+        t.Fatalf("RunBackfill = %v, want cancelled", err)
+    }
+    if state := tracker.Get("uni y2023").State; state != BackfillPartial {
+        t.Errorf("state = %q, want %q", state, BackfillPartial)
+    }
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// BaselineComparison compares a result with the window immediately before
+// its range. Changes are in percent and omitted when the baseline value is
+// zero. Windows of different lengths are best compared per day.
+type BaselineComparison struct {
+    StartDate      string  `json:"start_date"`
+    EndDate        string  `json:"end_date"`
+    Days           int     `json:"days"`
+    Users          int     `json:"users"`
+    Providers      int     `json:"providers"`
+    Hits           int64   `json:"hits"`
+    HitsPerDay     float64 `json:"hits_per_day"`
+    MeanDailyUsers float64 `json:"mean_daily_users"`
+    Partial        bool    `json:"partial,omitempty"`
+
+    UsersChange          *float64 `json:"users_change_percent,omitempty"`
+    ProvidersChange      *float64 `json:"providers_change_percent,omitempty"`
+    HitsChange           *float64 `json:"hits_change_percent,omitempty"`
+    HitsPerDayChange     *float64 `json:"hits_per_day_change_percent,omitempty"`
+    MeanDailyUsersChange *float64 `json:"mean_daily_users_change_percent,omitempty"`
+}
+
+// ParseBaselineDays parses the -baseline window length: a number of days,
+// optionally followed by "d" (e.g. 30d)
+func ParseBaselineDays(value string) (int, error) {
+    days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+    if err != nil || days < 1 || days > MaxDaysRange {
+        return 0, fmt.Errorf("invalid -baseline %q: use 1d-%dd", value, MaxDaysRange)
+    }
+    return days, nil
+}
+
+// BaselineRange returns the days-long window that ends right before
+// timeRange starts
+func BaselineRange(timeRange TimeRange, days int) TimeRange {
+    end := startOfDay(timeRange.StartDate).Add(-time.Second)
+    return dayRange(startOfDay(end).AddDate(0, 0, -days+1), end)
+}
+
+// meanDailyUsers returns the unique users per day averaged over days
+func meanDailyUsers(result *Result, days int) float64 {
+    if days <= 0 {
+        return 0
+    }
+    total := 0
+    for _, day := range DailyStats(result) {
+        total += day.UniqueUsers
+    }
+    return float64(total) / float64(days)
+}
+
+// changePercent returns the change from previous to current in percent,
+// or nil when there is nothing to compare with
+func changePercent(previous, current float64) *float64 {
+    if previous == 0 {
+        return nil
+    }
+    change := (current - previous) / previous * 100
+    return &change
+}
+
+// CompareBaseline compares result over timeRange with the baseline result
+// over baselineRange
+func CompareBaseline(result *Result, timeRange TimeRange, baseline *Result, baselineRange TimeRange) *BaselineComparison {
+    comparison := &BaselineComparison{
+        StartDate:      baselineRange.StartDate.Format(DateTimeFormat),
+        EndDate:        baselineRange.EndDate.Format(DateTimeFormat),
+        Days:           baselineRange.Days,
+        Users:          len(baseline.Users),
+        Providers:      len(baseline.Providers),
+        Hits:           baseline.TotalHits,
+        HitsPerDay:     perDay(baseline.TotalHits, baselineRange.Days),
+        MeanDailyUsers: meanDailyUsers(baseline, baselineRange.Days),
+        Partial:        baseline.Partial,
+    }
+    comparison.UsersChange = changePercent(float64(comparison.Users), float64(len(result.Users)))
+    comparison.ProvidersChange = changePercent(float64(comparison.Providers), float64(len(result.Providers)))
+    comparison.HitsChange = changePercent(float64(comparison.Hits), float64(result.TotalHits))
+    comparison.HitsPerDayChange = changePercent(comparison.HitsPerDay, perDay(result.TotalHits, timeRange.Days))
+    comparison.MeanDailyUsersChange = changePercent(comparison.MeanDailyUsers, meanDailyUsers(result, timeRange.Days))
+    return comparison
+}
+
+// csvChangePercent formats an optional change for CSV, empty without one
+func csvChangePercent(change *float64) string {
+    if change == nil {
+        return ""
+    }
+    return strconv.FormatFloat(*change, 'f', 2, 64)
+}
+
+// formatChangePercent formats an optional change with its sign
+func formatChangePercent(change *float64) string {
+    if change == nil {
+        return "n/a"
+    }
+    return fmt.Sprintf("%+.1f%%", *change)
+}
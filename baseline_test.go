@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "testing"
+    "time"
+)
+
+// baselineResult returns a result with the given users, each active on
+// every one of days, and hits
+func baselineResult(start time.Time, days int, hits int64, users ...string) *Result {
+    result := &Result{
+        Users:     make(map[string]*UserStats),
+        Providers: map[string]*ProviderStats{"sp1.example.org": {}},
+        Days:      make(map[string]*DayStats),
+        TotalHits: hits,
+    }
+    for _, user := range users {
+        result.Users[user] = &UserStats{}
+    }
+    for i := 0; i < days; i++ {
+        day := &DayStats{}
+        for _, user := range users {
+            day.Add(user, "sp1.example.org")
+        }
+        result.Days[start.AddDate(0, 0, i).Format(DateFormat)] = day
+    }
+    return result
+}
+
+func TestParseBaselineDays(t *testing.T) {
+    for value, want := range map[string]int{"30d": 30, "7": 7} {
+        if days, err := ParseBaselineDays(value); err != nil || days != want {
+            t.Errorf("ParseBaselineDays(%q) = %d, %v, want %d", value, days, err, want)
+        }
+    }
+    for _, value := range []string{"0d", "d", "1y", fmt.Sprintf("%dd", MaxDaysRange+1)} {
+        if _, err := ParseBaselineDays(value); err == nil {
+            t.Errorf("ParseBaselineDays(%q) accepted", value)
+        }
+    }
+}
+
+func TestCompareBaseline(t *testing.T) {
+    timeRange := testRange(t, 3)
+    baselineRange := BaselineRange(timeRange, 7)
+    if baselineRange.Days != 7 || !baselineRange.EndDate.Equal(timeRange.StartDate.Add(-time.Second)) ||
+        !baselineRange.StartDate.Equal(timeRange.StartDate.AddDate(0, 0, -7)) {
+        t.Fatalf("baseline range %v - %v (%d days)", baselineRange.StartDate, baselineRange.EndDate, baselineRange.Days)
+    }
+
+    result := baselineResult(timeRange.StartDate, 3, 300, "a", "b", "c")
+    baseline := baselineResult(baselineRange.StartDate, 7, 700, "a")
+    baseline.Providers = map[string]*ProviderStats{}
+
+    comparison := CompareBaseline(result, timeRange, baseline, baselineRange)
+    if comparison.Users != 1 || comparison.Hits != 700 || comparison.HitsPerDay != 100 || comparison.MeanDailyUsers != 1 {
+        t.Errorf("baseline figures: %+v", comparison)
+    }
+    if comparison.ProvidersChange != nil {
+        t.Errorf("providers change against no providers: %v", *comparison.ProvidersChange)
+    }
+    changes := map[string]struct {
+        got  *float64
+        want float64
+    }{
+        "users":            {comparison.UsersChange, 200},
+        "hits":             {comparison.HitsChange, -400.0 / 7},
+        "hits per day":     {comparison.HitsPerDayChange, 0},
+        "mean daily users": {comparison.MeanDailyUsersChange, 200},
+    }
+    for name, change := range changes {
+        if change.got == nil || math.Abs(*change.got-change.want) > 1e-9 {
+            t.Errorf("%s change = %v, want %v", name, change.got, change.want)
+        }
+    }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// ReadDomainList reads a batch domain list: one domain per line, with '#'
+// comments and blank lines ignored
+func ReadDomainList(path string) ([]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open domain list: %w", err)
+    }
+    defer file.Close()
+
+    var domains []string
+    seen := make(map[string]bool)
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        domain := strings.TrimSpace(stripYAMLComment(scanner.Text()))
+        if domain == "" || seen[domain] {
+            continue
+        }
+        seen[domain] = true
+        domains = append(domains, domain)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, err
+    }
+    if len(domains) == 0 {
+        return nil, fmt.Errorf("no domains in %s", path)
+    }
+    return domains, nil
+}
+
+// PrintBackfillReport writes the recorded status of every entry of manifest
+// followed by the number of entries in each state
+func PrintBackfillReport(manifest BackfillManifest, tracker *BackfillTracker) {
+    counts := make(map[string]int)
+    for _, entry := range manifest.Entries {
+        status := tracker.Get(entry.Key())
+        counts[status.State]++
+        duration := ""
+        if !status.Started.IsZero() && !status.Finished.IsZero() {
+            duration = status.Finished.Sub(status.Started).Round(time.Second).String()
+        }
+        fmt.Printf("  %-40s %-8s %2d attempts %8s", entry.Key(), status.State, status.Attempts, duration)
+        if status.Error != "" {
+            fmt.Printf("  %s (see %s)", status.Error, status.Log)
+        }
+        fmt.Println()
+    }
+
+    states := make([]string, 0, len(counts))
+    for state := range counts {
+        states = append(states, state)
+    }
+    sort.Strings(states)
+    summary := make([]string, len(states))
+    for i, state := range states {
+        summary[i] = fmt.Sprintf("%d %s", counts[state], state)
+    }
+    fmt.Printf("%d entries: %s\n", len(manifest.Entries), strings.Join(summary, ", "))
+}
+
+// runBatch implements the "batch" subcommand
+func runBatch(args []string) int {
+    flags := flag.NewFlagSet("batch", flag.ExitOnError)
+    parallel := flags.Int("parallel", DefaultBackfillParallel, "Number of domains analysed at once")
+    analysisFlags := flags.String("analysis-flags", "", "Flags of every analysis, e.g. \"-store -output-dir /data/reports\"")
+    statusFile := flags.String("status", "", "Per-domain status file used to resume (default: <list>.status.json)")
+    logDir := flags.String("log-dir", "", "Directory for the output of each domain (default: <list>.logs)")
+    restart := flags.Bool("restart", false, "Ignore the status file and analyse every domain again")
+    dryRun := flags.Bool("dry-run", false, "List the domains and their status without running them")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp batch [flags] <domains.txt> [days|Ny|yxxxx|DD-MM-YYYY]")
+        fmt.Fprintln(flags.Output(), "Analyses every domain of the list (one per line), skipping domains finished by an earlier run.")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() < 1 || flags.NArg() > 2 {
+        flags.Usage()
+        return 1
+    }
+    listPath := flags.Arg(0)
+    timeRange := flags.Arg(1)
+    if _, err := ResolveTimeRange(timeRange); err != nil {
+        fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+        return 1
+    }
+    domains, err := ReadDomainList(listPath)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    manifest := BackfillManifest{Parallel: max(1, *parallel), Flags: strings.Fields(*analysisFlags)}
+    for _, domain := range domains {
+        manifest.Entries = append(manifest.Entries, BackfillEntry{Domain: domain, Range: timeRange})
+    }
+
+    base := strings.TrimSuffix(listPath, filepath.Ext(listPath))
+    if *statusFile == "" {
+        *statusFile = base + ".status.json"
+    }
+    if *logDir == "" {
+        *logDir = base + ".logs"
+    }
+    if *restart {
+        if err := os.Remove(*statusFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+            log.Printf("Error: %v", err)
+            return 1
+        }
+    }
+    tracker, err := OpenBackfillTracker(*statusFile)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    if *dryRun {
+        PrintBackfillReport(manifest, tracker)
+        return 0
+    }
+
+    executable, err := os.Executable()
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    pending := 0
+    for _, entry := range manifest.Entries {
+        if !BackfillFinished(tracker.Get(entry.Key()).State) {
+            pending++
+        }
+    }
+    fmt.Printf("Analysing %d of %d domains, %d at a time\n", pending, len(manifest.Entries), manifest.Parallel)
+    failed, partial, err := RunBackfill(ctx, manifest, tracker, executable, *logDir, manifest.Parallel)
+
+    fmt.Println()
+    PrintBackfillReport(manifest, tracker)
+    if err != nil {
+        log.Printf("Batch interrupted: %v; run it again to resume", err)
+        return 1
+    }
+    if failed > 0 {
+        log.Printf("%d domains failed; run the batch again to retry them", failed)
+        return 1
+    }
+    if partial > 0 {
+        log.Printf("%d domains only cover part of their range; run the batch again to complete them", partial)
+        return ExitPartialRange
+    }
+    fmt.Println("Batch complete")
+    return 0
+}
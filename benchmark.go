@@ -0,0 +1,170 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultBenchmarkDays is the number of recent days queried per concurrency level
+    DefaultBenchmarkDays = 14
+
+    // DefaultBenchmarkLevels are the worker counts measured by -benchmark
+    DefaultBenchmarkLevels = "1,2,4,8,16,32"
+
+    // BenchmarkThroughputTolerance is the fraction of the best throughput a
+    // smaller worker count must reach to be recommended instead
+    BenchmarkThroughputTolerance = 0.95
+)
+
+// BenchmarkResult holds latency and throughput figures for one worker count
+type BenchmarkResult struct {
+    Workers    int
+    Queries    int
+    Errors     int
+    P50        time.Duration
+    P90        time.Duration
+    P99        time.Duration
+    Max        time.Duration
+    Wall       time.Duration
+    Throughput float64
+}
+
+// ParseBenchmarkLevels parses a comma-separated list of worker counts
+func ParseBenchmarkLevels(value string) ([]int, error) {
+    var levels []int
+    for _, part := range strings.Split(value, ",") {
+        part = strings.TrimSpace(part)
+        if part == "" {
+            continue
+        }
+        n, err := strconv.Atoi(part)
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("invalid benchmark level %q", part)
+        }
+        levels = append(levels, n)
+    }
+    if len(levels) == 0 {
+        return nil, fmt.Errorf("no benchmark levels given")
+    }
+    sort.Ints(levels)
+    return levels, nil
+}
+
+// RunBenchmark issues the per-day aggregation query for every job at each
+// concurrency level and measures latency percentiles and throughput. A single
+// warm-up query is sent first so the first level is not penalised by cold
+// Quickwit caches.
+func RunBenchmark(ctx context.Context, client *HTTPClient, query map[string]interface{}, jobs []Job, levels []int) ([]BenchmarkResult, error) {
+    if len(jobs) == 0 {
+        return nil, fmt.Errorf("%w: no jobs to benchmark", ErrInvalidDateRange)
+    }
+    if _, err := client.SendQuickwitRequest(ctx, BuildJobQuery(query, jobs[0], QueryOptions{})); err != nil {
+        return nil, fmt.Errorf("warm-up query failed: %w", err)
+    }
+
+    results := make([]BenchmarkResult, 0, len(levels))
+    for _, workers := range levels {
+        if err := ctx.Err(); err != nil {
+            return results, err
+        }
+
+        jobChan := make(chan Job, len(jobs))
+        for _, job := range jobs {
+            jobChan <- job
+        }
+        close(jobChan)
+
+        var (
+            mu        sync.Mutex
+            latencies []time.Duration
+            errCount  int
+            wg        sync.WaitGroup
+        )
+
+        start := time.Now()
+        for w := 0; w < workers; w++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                for job := range jobChan {
+                    queryStart := time.Now()
+                    _, err := client.SendQuickwitRequest(ctx, BuildJobQuery(query, job, QueryOptions{}))
+                    latency := time.Since(queryStart)
+
+                    mu.Lock()
+                    if err != nil {
+                        errCount++
+                    } else {
+                        latencies = append(latencies, latency)
+                    }
+                    mu.Unlock()
+                }
+            }()
+        }
+        wg.Wait()
+        wall := time.Since(start)
+
+        sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+        result := BenchmarkResult{
+            Workers: workers,
+            Queries: len(latencies),
+            Errors:  errCount,
+            P50:     percentileDuration(latencies, 50),
+            P90:     percentileDuration(latencies, 90),
+            P99:     percentileDuration(latencies, 99),
+            Wall:    wall,
+        }
+        if len(latencies) > 0 {
+            result.Max = latencies[len(latencies)-1]
+        }
+        if wall > 0 {
+            result.Throughput = float64(len(latencies)) / wall.Seconds()
+        }
+        results = append(results, result)
+
+        fmt.Printf("  workers=%-3d queries=%-4d errors=%-3d p50=%-10v p90=%-10v p99=%-10v %.2f q/s\n",
+            workers, result.Queries, result.Errors,
+            result.P50.Round(time.Millisecond), result.P90.Round(time.Millisecond),
+            result.P99.Round(time.Millisecond), result.Throughput)
+    }
+
+    return results, nil
+}
+
+// RecommendWorkers returns the smallest worker count whose throughput is
+// within BenchmarkThroughputTolerance of the best error-free level
+func RecommendWorkers(results []BenchmarkResult) int {
+    best := 0.0
+    for _, r := range results {
+        if r.Errors == 0 && r.Throughput > best {
+            best = r.Throughput
+        }
+    }
+    for _, r := range results {
+        if r.Errors == 0 && r.Throughput >= best*BenchmarkThroughputTolerance {
+            return r.Workers
+        }
+    }
+    return DefaultNumWorkers
+}
+
+// percentileDuration returns the p-th percentile of sorted durations
+func percentileDuration(sorted []time.Duration, p float64) time.Duration {
+    if len(sorted) == 0 {
+        return 0
+    }
+    index := int(p/100*float64(len(sorted))+0.5) - 1
+    if index < 0 {
+        index = 0
+    }
+    if index >= len(sorted) {
+        index = len(sorted) - 1
+    }
+    return sorted[index]
+}
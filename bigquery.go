@@ -0,0 +1,327 @@
+package main
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultBigQueryEndpoint is the BigQuery REST API root
+    DefaultBigQueryEndpoint = "https://bigquery.googleapis.com"
+
+    // bigQueryScope is the OAuth scope of the service account token
+    bigQueryScope = "https://www.googleapis.com/auth/bigquery"
+
+    // bigQueryBatch is the number of rows per insertAll request
+    bigQueryBatch = 500
+)
+
+// errTableNotFound indicates that the target table does not exist yet
+var errTableNotFound = errors.New("table not found")
+
+// bigQuerySchema is the fixed schema of the rows: one per domain, day, user
+// and provider the user authenticated at. New columns are only ever added
+// as NULLABLE so existing tables keep accepting rows.
+var bigQuerySchema = []map[string]string{
+    {"name": "domain", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "date", "type": "DATE", "mode": "REQUIRED"},
+    {"name": "username", "type": "STRING", "mode": "NULLABLE"},
+    {"name": "service_provider", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "run_id", "type": "STRING", "mode": "REQUIRED"},
+    {"name": "version", "type": "STRING", "mode": "NULLABLE"},
+}
+
+// BigQueryTarget is the table the bigquery format streams rows into
+// (BQ_PROJECT, BQ_DATASET, BQ_TABLE and BQ_CREDENTIALS properties)
+type BigQueryTarget struct {
+    Project  string
+    Dataset  string
+    Table    string
+    Endpoint string
+    account  serviceAccount
+    key      *rsa.PrivateKey
+    client   *http.Client
+    // accessToken is the current OAuth token, fetched on first use and
+    // again when the API rejects it
+    accessToken string
+}
+
+// serviceAccount holds the fields of a Google service account key file
+type serviceAccount struct {
+    ClientEmail string `json:"client_email"`
+    PrivateKey  string `json:"private_key"`
+    TokenURI    string `json:"token_uri"`
+}
+
+// NewBigQueryTarget returns the BigQuery table configured in props
+func NewBigQueryTarget(props Properties) (*BigQueryTarget, error) {
+    if props.BQProject == "" || props.BQDataset == "" || props.BQTable == "" || props.BQCredentials == "" {
+        return nil, fmt.Errorf("%w: BQ_PROJECT, BQ_DATASET, BQ_TABLE and BQ_CREDENTIALS", ErrMissingConfiguration)
+    }
+    data, err := os.ReadFile(props.BQCredentials)
+    if err != nil {
+        return nil, fmt.Errorf("error reading BigQuery credentials: %w", err)
+    }
+    t := &BigQueryTarget{
+        Project:  props.BQProject,
+        Dataset:  props.BQDataset,
+        Table:    props.BQTable,
+        Endpoint: strings.TrimSuffix(props.BQEndpoint, "/"),
+        client:   &http.Client{Timeout: DefaultHTTPTimeout},
+    }
+    if t.Endpoint == "" {
+        t.Endpoint = DefaultBigQueryEndpoint
+    }
+    if err := json.Unmarshal(data, &t.account); err != nil {
+        return nil, fmt.Errorf("error parsing BigQuery credentials: %w", err)
+    }
+    if t.account.ClientEmail == "" || t.account.TokenURI == "" {
+        return nil, fmt.Errorf("BigQuery credentials %s are not a service account key", props.BQCredentials)
+    }
+    block, _ := pem.Decode([]byte(t.account.PrivateKey))
+    if block == nil {
+        return nil, fmt.Errorf("BigQuery credentials %s have no private key", props.BQCredentials)
+    }
+    key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("error parsing BigQuery private key: %w", err)
+    }
+    var ok bool
+    if t.key, ok = key.(*rsa.PrivateKey); !ok {
+        return nil, fmt.Errorf("BigQuery private key is not an RSA key")
+    }
+    return t, nil
+}
+
+// String returns the table as project.dataset.table
+func (t *BigQueryTarget) String() string {
+    return t.Project + "." + t.Dataset + "." + t.Table
+}
+
+// token exchanges a signed JWT for an OAuth access token
+func (t *BigQueryTarget) token() (string, error) {
+    encode := base64.RawURLEncoding.EncodeToString
+    now := time.Now()
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+    claims, _ := json.Marshal(map[string]interface{}{
+        "iss":   t.account.ClientEmail,
+        "scope": bigQueryScope,
+        "aud":   t.account.TokenURI,
+        "iat":   now.Unix(),
+        "exp":   now.Add(time.Hour).Unix(),
+    })
+    unsigned := encode(header) + "." + encode(claims)
+    digest := sha256.Sum256([]byte(unsigned))
+    signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, digest[:])
+    if err != nil {
+        return "", fmt.Errorf("error signing BigQuery token request: %w", err)
+    }
+
+    resp, err := t.client.PostForm(t.account.TokenURI, url.Values{
+        "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+        "assertion":  {unsigned + "." + encode(signature)},
+    })
+    if err != nil {
+        return "", fmt.Errorf("error requesting BigQuery token: %w", err)
+    }
+    defer resp.Body.Close()
+    var token struct {
+        AccessToken string `json:"access_token"`
+    }
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return "", fmt.Errorf("error requesting BigQuery token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || token.AccessToken == "" {
+        return "", fmt.Errorf("invalid BigQuery token response")
+    }
+    return token.AccessToken, nil
+}
+
+// call sends a JSON request to the BigQuery API and decodes the answer. A
+// request rejected with 401 is sent once more with a fresh token, as the
+// token expires after an hour.
+func (t *BigQueryTarget) call(method, path string, body, answer interface{}) error {
+    data, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+    var resp *http.Response
+    for refreshed := false; ; refreshed = true {
+        if t.accessToken == "" || refreshed {
+            if t.accessToken, err = t.token(); err != nil {
+                return err
+            }
+        }
+        req, err := http.NewRequest(method, t.Endpoint+"/bigquery/v2/projects/"+url.PathEscape(t.Project)+path, bytes.NewReader(data))
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Authorization", "Bearer "+t.accessToken)
+        req.Header.Set("Content-Type", "application/json")
+        resp, err = t.client.Do(req)
+        if err != nil {
+            return fmt.Errorf("BigQuery request failed: %w", err)
+        }
+        if resp.StatusCode != http.StatusUnauthorized || refreshed {
+            break
+        }
+        resp.Body.Close()
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound {
+        return errTableNotFound
+    }
+    if resp.StatusCode != http.StatusOK {
+        body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+        return fmt.Errorf("BigQuery request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+    }
+    if answer != nil {
+        return json.NewDecoder(resp.Body).Decode(answer)
+    }
+    return nil
+}
+
+// createTable creates the table with bigQuerySchema, partitioned by date
+func (t *BigQueryTarget) createTable() error {
+    return t.call(http.MethodPost, "/datasets/"+url.PathEscape(t.Dataset)+"/tables", map[string]interface{}{
+        "tableReference":   map[string]string{"projectId": t.Project, "datasetId": t.Dataset, "tableId": t.Table},
+        "schema":           map[string]interface{}{"fields": bigQuerySchema},
+        "timePartitioning": map[string]string{"type": "DAY", "field": "date"},
+    }, nil)
+}
+
+// insert streams one batch of rows
+func (t *BigQueryTarget) insert(rows []map[string]interface{}) error {
+    var answer struct {
+        InsertErrors []struct {
+            Index  int `json:"index"`
+            Errors []struct {
+                Reason  string `json:"reason"`
+                Message string `json:"message"`
+            } `json:"errors"`
+        } `json:"insertErrors"`
+    }
+    path := "/datasets/" + url.PathEscape(t.Dataset) + "/tables/" + url.PathEscape(t.Table) + "/insertAll"
+    if err := t.call(http.MethodPost, path, map[string]interface{}{"rows": rows}, &answer); err != nil {
+        return err
+    }
+    if len(answer.InsertErrors) > 0 {
+        first := answer.InsertErrors[0]
+        message := "unknown error"
+        if len(first.Errors) > 0 {
+            message = first.Errors[0].Reason + ": " + first.Errors[0].Message
+        }
+        return fmt.Errorf("BigQuery rejected %d rows, first at %d: %s", len(answer.InsertErrors), first.Index, message)
+    }
+    return nil
+}
+
+// bigQueryRows returns the domain, day, user and provider rows of a result
+// in a stable order. The insert IDs depend only on the domain, day, user and
+// provider, not on the run, so BigQuery's best-effort deduplication drops
+// the rows of a run retried within its window of about a minute; public
+// outputs leave the username empty.
+func bigQueryRows(result *Result, meta ExportMeta, runID string) []map[string]interface{} {
+    domain := RealmToUnicode(meta.Domain)
+
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    dates := make([]string, 0, len(result.Days))
+    for date := range result.Days {
+        dates = append(dates, date)
+    }
+    sort.Strings(dates)
+
+    var rows []map[string]interface{}
+    for _, date := range dates {
+        users := make(map[string][]string)
+        for username, providers := range result.Days[date].Visits.ByUser() {
+            users[username] = providers
+        }
+        usernames := make([]string, 0, len(users))
+        for username := range users {
+            usernames = append(usernames, username)
+        }
+        sort.Strings(usernames)
+        for _, username := range usernames {
+            providers := users[username]
+            sort.Strings(providers)
+            for _, provider := range providers {
+                row := map[string]interface{}{
+                    "domain":           domain,
+                    "date":             date,
+                    "service_provider": provider,
+                    "run_id":           runID,
+                    "version":          Version,
+                }
+                if !meta.Public {
+                    row["username"] = username
+                }
+                digest := sha256.Sum256([]byte(domain + "\x00" + date + "\x00" + username + "\x00" + provider))
+                rows = append(rows, map[string]interface{}{
+                    "insertId": base64.RawURLEncoding.EncodeToString(digest[:18]),
+                    "json":     row,
+                })
+            }
+        }
+    }
+    return rows
+}
+
+// ExportBigQuery streams the per-day user and provider rows of the result
+// into the configured table, creating it on first use. It writes no files.
+func ExportBigQuery(result *Result, meta ExportMeta) ([]string, error) {
+    target := meta.BigQuery
+    if target == nil {
+        return nil, fmt.Errorf("%w: BigQuery target", ErrMissingConfiguration)
+    }
+    if result.Pivot == PivotSP {
+        return nil, fmt.Errorf("the bigquery format does not support -pivot %s", PivotSP)
+    }
+
+    runID := OutputBaseFilename(meta)
+    rows := bigQueryRows(result, meta, runID)
+    if len(rows) == 0 {
+        return nil, nil
+    }
+    created := false
+    for start := 0; start < len(rows); {
+        batch := rows[start:min(len(rows), start+bigQueryBatch)]
+        err := target.insert(batch)
+        if errors.Is(err, errTableNotFound) && !created {
+            if err := target.createTable(); err != nil {
+                return nil, fmt.Errorf("error creating BigQuery table %s: %w", target, err)
+            }
+            created = true
+            continue
+        }
+        if err != nil {
+            return nil, fmt.Errorf("error streaming to BigQuery table %s: %w", target, err)
+        }
+        start += len(batch)
+    }
+    fmt.Printf("Streamed %d rows to BigQuery table %s\n", len(rows), target)
+    return nil, nil
+}
+
+func init() {
+    RegisterExporter("bigquery", ExporterFunc(ExportBigQuery))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// testBigQueryTarget returns a target whose token and API requests go to a
+// test server. The server rejects the first token it issued with 401.
+func testBigQueryTarget(t *testing.T) (*BigQueryTarget, *[]string) {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var requests []string
+    var tokens int
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/token" {
+            tokens++
+            json.NewEncoder(w).Encode(map[string]string{"access_token": fmt.Sprintf("token-%d", tokens)})
+            return
+        }
+        requests = append(requests, r.Header.Get("Authorization"))
+        if r.Header.Get("Authorization") == "Bearer token-1" {
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        w.Write([]byte("{}"))
+    }))
+    t.Cleanup(server.Close)
+
+    credentials := filepath.Join(t.TempDir(), "key.json")
+    data, _ := json.Marshal(map[string]string{
+        "client_email": "idp@example.iam.gserviceaccount.com",
+        "private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+        "token_uri":    server.URL + "/token",
+    })
+    if err := os.WriteFile(credentials, data, 0600); err != nil {
+        t.Fatal(err)
+    }
+    target, err := NewBigQueryTarget(Properties{BQProject: "p", BQDataset: "d", BQTable: "t", BQCredentials: credentials, BQEndpoint: server.URL})
+    if err != nil {
+        t.Fatal(err)
+    }
+    return target, &requests
+}
+
+func TestExportBigQueryRefreshesToken(t *testing.T) {
+    target, requests := testBigQueryTarget(t)
+    day := testDay(1, map[string][]string{"alice": {"sp.example"}})
+    result := &Result{Days: map[string]*DayStats{"2024-01-01": day}}
+    meta := ExportMeta{Domain: "uni.example", TimeRange: testRange(t, 1), BigQuery: target}
+
+    if _, err := ExportBigQuery(result, meta); err != nil {
+        t.Fatal(err)
+    }
+    want := []string{"Bearer token-1", "Bearer token-2"}
+    if fmt.Sprint(*requests) != fmt.Sprint(want) {
+        t.Errorf("requests = %q, want %q", *requests, want)
+    }
+}
+
+func TestBigQueryInsertIDsStable(t *testing.T) {
+    day := testDay(1, map[string][]string{"alice": {"sp.example"}})
+    result := &Result{Days: map[string]*DayStats{"2024-01-01": day}}
+    meta := ExportMeta{Domain: "uni.example", TimeRange: testRange(t, 1)}
+
+    first := bigQueryRows(result, meta, "20240102-030405-1d")
+    retried := bigQueryRows(result, meta, "20240102-030911-1d")
+    if len(first) != 1 || first[0]["insertId"] != retried[0]["insertId"] {
+        t.Errorf("insert IDs of a retried run differ: %v and %v", first, retried)
+    }
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// ManifestFile is one artifact listed in a run manifest
+type ManifestFile struct {
+    Name string `json:"name"`
+    Size int64  `json:"size"`
+}
+
+// RunManifest describes the run that produced a bundle
+type RunManifest struct {
+    RunInfo   RunInfo        `json:"run_info"`
+    Domain    string         `json:"domain"`
+    StartDate string         `json:"start_date"`
+    EndDate   string         `json:"end_date"`
+    Days      int            `json:"days"`
+    Partial   bool           `json:"partial,omitempty"`
+    Created   string         `json:"created,omitempty"`
+    Files     []ManifestFile `json:"files"`
+}
+
+// BundleOutputs packs the files of a run and a manifest.json into
+// <base>.tar.gz in the domain's output directory and removes the packed
+// files. Deterministic bundles carry no creation time.
+func BundleOutputs(files []string, meta ExportMeta) (string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    base := OutputBaseFilename(meta)
+    filename := filepath.Join(outputDir, base+".tar.gz")
+
+    manifest := RunManifest{
+        RunInfo:   GetRunInfo(),
+        Domain:    RealmToUnicode(meta.Domain),
+        StartDate: meta.TimeRange.StartDate.Format(DateTimeFormat),
+        EndDate:   meta.TimeRange.EndDate.Format(DateTimeFormat),
+        Days:      meta.TimeRange.Days,
+        Partial:   meta.Partial,
+        Files:     make([]ManifestFile, 0, len(files)),
+    }
+    modTime := time.Unix(0, 0)
+    if !meta.Deterministic {
+        modTime = time.Now()
+        manifest.Created = modTime.Format(time.RFC3339)
+    }
+    for _, file := range files {
+        info, err := os.Stat(file)
+        if err != nil {
+            return "", fmt.Errorf("error bundling %s: %w", file, err)
+        }
+        manifest.Files = append(manifest.Files, ManifestFile{Name: filepath.Base(file), Size: info.Size()})
+    }
+    manifestData, err := json.MarshalIndent(manifest, "", "  ")
+    if err != nil {
+        return "", fmt.Errorf("error marshaling manifest: %w", err)
+    }
+
+    tmp := filename + ".tmp"
+    if err := writeBundle(tmp, base, files, manifestData, modTime); err != nil {
+        os.Remove(tmp)
+        return "", err
+    }
+    if err := os.Rename(tmp, filename); err != nil {
+        os.Remove(tmp)
+        return "", fmt.Errorf("error writing bundle: %w", err)
+    }
+    for _, file := range files {
+        if err := os.Remove(file); err != nil {
+            return filename, fmt.Errorf("error removing bundled file: %w", err)
+        }
+    }
+    return filename, nil
+}
+
+// writeBundle writes the files and the manifest below the directory dir of
+// a new gzip-compressed tar archive
+func writeBundle(filename, dir string, files []string, manifest []byte, modTime time.Time) error {
+    out, err := os.Create(filename)
+    if err != nil {
+        return fmt.Errorf("error creating bundle: %w", err)
+    }
+    defer out.Close()
+
+    gz := gzip.NewWriter(out)
+    gz.ModTime = modTime
+    tw := tar.NewWriter(gz)
+
+    header := func(name string, size int64) *tar.Header {
+        return &tar.Header{Name: dir + "/" + name, Mode: 0644, Size: size, ModTime: modTime, Format: tar.FormatPAX}
+    }
+    if err := tw.WriteHeader(header("manifest.json", int64(len(manifest)))); err != nil {
+        return fmt.Errorf("error writing bundle: %w", err)
+    }
+    if _, err := tw.Write(manifest); err != nil {
+        return fmt.Errorf("error writing bundle: %w", err)
+    }
+    for _, file := range files {
+        if err := addBundleFile(tw, file, header); err != nil {
+            return err
+        }
+    }
+
+    if err := tw.Close(); err != nil {
+        return fmt.Errorf("error writing bundle: %w", err)
+    }
+    if err := gz.Close(); err != nil {
+        return fmt.Errorf("error writing bundle: %w", err)
+    }
+    return out.Close()
+}
+
+// addBundleFile copies one file into the archive
+func addBundleFile(tw *tar.Writer, file string, header func(string, int64) *tar.Header) error {
+    in, err := os.Open(file)
+    if err != nil {
+        return fmt.Errorf("error bundling %s: %w", file, err)
+    }
+    defer in.Close()
+    info, err := in.Stat()
+    if err != nil {
+        return fmt.Errorf("error bundling %s: %w", file, err)
+    }
+    if err := tw.WriteHeader(header(filepath.Base(file), info.Size())); err != nil {
+        return fmt.Errorf("error writing bundle: %w", err)
+    }
+    if _, err := io.Copy(tw, in); err != nil {
+        return fmt.Errorf("error bundling %s: %w", file, err)
+    }
+    return nil
+}
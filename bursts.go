@@ -0,0 +1,242 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "time"
+)
+
+const (
+    // DefaultBurstWindow is the length of the windows rejects are counted in
+    DefaultBurstWindow = 10 * time.Minute
+
+    // DefaultBurstUsers is the number of distinct usernames rejected at one
+    // provider within a window that is reported as a burst
+    DefaultBurstUsers = 20
+
+    // burstProviderLimit bounds the providers aggregated per day
+    burstProviderLimit = 1000
+)
+
+// BuildRejectQuery returns the query string for Access-Reject events, of the
+// realms if any are given
+func BuildRejectQuery(realms []string) string {
+    if len(realms) == 0 {
+        return `message_type:"Access-Reject"`
+    }
+    return fmt.Sprintf(`message_type:"Access-Reject" AND %s`, realmClause(realms))
+}
+
+// RejectBurst is a run of consecutive windows in which a service provider
+// had rejects for at least the threshold of distinct usernames, a possible
+// password spray
+type RejectBurst struct {
+    Provider string
+    Start    time.Time
+    End      time.Time
+    // MaxUsers is the highest number of distinct usernames in one window
+    MaxUsers int64
+    Rejects  int64
+}
+
+// burstProviderBucket is a bucket of the providers aggregation of
+// FindRejectBursts with its windows of rejects
+type burstProviderBucket struct {
+    Key     bucketKey `json:"key"`
+    Windows struct {
+        Buckets []struct {
+            Key      bucketKey   `json:"key"`
+            DocCount docCount    `json:"doc_count"`
+            Users    valueMetric `json:"users"`
+        } `json:"buckets"`
+    } `json:"windows"`
+}
+
+// FindRejectBursts queries the rejects of each day in the time range per
+// service provider and window and merges adjacent windows with at least
+// minUsers distinct (approximately counted) usernames into bursts, ordered
+// by start time
+func FindRejectBursts(ctx context.Context, client *HTTPClient, queryString string, timeRange TimeRange, window time.Duration, minUsers int64) ([]RejectBurst, error) {
+    seconds := int64(window / time.Second)
+    if seconds < 1 {
+        return nil, fmt.Errorf("invalid burst window %s", window)
+    }
+
+    var bursts []RejectBurst
+    open := make(map[string]*RejectBurst)
+    for _, job := range GenerateJobs(timeRange) {
+        query := map[string]interface{}{
+            "query":           queryString,
+            "start_timestamp": job.StartTimestamp,
+            "end_timestamp":   job.EndTimestamp,
+            "max_hits":        0,
+            "aggs": map[string]interface{}{
+                "providers": map[string]interface{}{
+                    "terms": map[string]interface{}{
+                        "field": "service_provider",
+                        "size":  burstProviderLimit,
+                    },
+                    "aggs": map[string]interface{}{
+                        "windows": map[string]interface{}{
+                            "date_histogram": map[string]interface{}{
+                                "field":          "timestamp",
+                                "fixed_interval": fmt.Sprintf("%ds", seconds),
+                                "min_doc_count":  minUsers,
+                            },
+                            "aggs": map[string]interface{}{
+                                "users": map[string]interface{}{
+                                    "cardinality": map[string]interface{}{"field": "username"},
+                                },
+                            },
+                        },
+                    },
+                },
+            },
+        }
+        result, err := client.SendQuickwitRequest(ctx, query)
+        if err != nil {
+            return nil, fmt.Errorf("error querying rejects of %s: %w", job.Date.Format(DateFormat), err)
+        }
+        data, err := responseAggregation(result, "providers")
+        if err != nil {
+            return nil, err
+        }
+        buckets, err := decodeBuckets[burstProviderBucket]("providers", data)
+        if err != nil {
+            return nil, err
+        }
+        for _, providerBucket := range buckets {
+            provider := string(providerBucket.Key)
+            for _, windowBucket := range providerBucket.Windows.Buckets {
+                key, err := windowBucket.Key.float()
+                if err != nil {
+                    return nil, err
+                }
+                users, rejects := int64(windowBucket.Users.Value), int64(windowBucket.DocCount)
+                if users < minUsers {
+                    continue
+                }
+
+                start := time.UnixMilli(int64(key))
+                end := start.Add(window)
+                if burst := open[provider]; burst != nil && !start.After(burst.End) {
+                    burst.End = end
+                    burst.MaxUsers = max(burst.MaxUsers, users)
+                    burst.Rejects += rejects
+                    continue
+                }
+                if burst := open[provider]; burst != nil {
+                    bursts = append(bursts, *burst)
+                }
+                open[provider] = &RejectBurst{Provider: provider, Start: start, End: end, MaxUsers: users, Rejects: rejects}
+            }
+        }
+    }
+    for _, burst := range open {
+        bursts = append(bursts, *burst)
+    }
+
+    sort.Slice(bursts, func(i, j int) bool {
+        if !bursts[i].Start.Equal(bursts[j].Start) {
+            return bursts[i].Start.Before(bursts[j].Start)
+        }
+        return bursts[i].Provider < bursts[j].Provider
+    })
+    return bursts, nil
+}
+
+// writeBurstsCSV writes the bursts to filename
+func writeBurstsCSV(bursts []RejectBurst, filename string) error {
+    records := [][]string{{"Service Provider", "Start", "End", "Max Distinct Usernames", "Rejects"}}
+    for _, burst := range bursts {
+        records = append(records, []string{
+            burst.Provider,
+            burst.Start.Format(DateTimeFormat),
+            burst.End.Format(DateTimeFormat),
+            strconv.FormatInt(burst.MaxUsers, 10),
+            strconv.FormatInt(burst.Rejects, 10),
+        })
+    }
+    return writeCSVFile(filename, records)
+}
+
+// runBursts implements the "bursts" subcommand, which reports possible
+// password-spray incidents from Access-Reject events
+func runBursts(args []string) int {
+    flags := flag.NewFlagSet("bursts", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* Quickwit environment of the configuration file (default: DEFAULT_ENV)")
+    domain := flags.String("domain", "", "Only count rejects of this realm or alias (default: all realms)")
+    window := flags.Duration("window", DefaultBurstWindow, "Length of the windows rejects are counted in")
+    minUsers := flags.Int64("min-users", DefaultBurstUsers, "Distinct usernames rejected at one provider within a window that make a burst")
+    outputDir := flags.String("output-dir", "", "Base directory for the CSV file (default: no file)")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp bursts [flags] [days|Ny|yxxxx|DD-MM-YYYY]")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() > 1 || *minUsers < 1 {
+        flags.Usage()
+        return 1
+    }
+    timeRange, err := ResolveTimeRange(flags.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+        return 1
+    }
+
+    client, err := LoadClient(*configFile, *profile, *env)
+    if err != nil {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+    var realm string
+    var realms []string
+    target := "all realms"
+    if *domain != "" {
+        realm = GetDomain(*domain, client.properties().Aliases, false)
+        realms = RealmVariants([]string{realm})
+        target = RealmToUnicode(realm)
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    bursts, err := FindRejectBursts(ctx, client, BuildRejectQuery(realms), timeRange, *window, *minUsers)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    fmt.Printf("Reject bursts of %d+ usernames per %s for %s from %s to %s:\n", *minUsers, *window, target,
+        timeRange.StartDate.Format(DateFormat), timeRange.EndDate.Format(DateFormat))
+    for _, burst := range bursts {
+        fmt.Printf("  %-40s %s - %s  %6d usernames  %8d rejects\n", burst.Provider,
+            burst.Start.Format(DateTimeFormat), burst.End.Format("15:04:05"), burst.MaxUsers, burst.Rejects)
+    }
+    fmt.Printf("%d possible password-spray incidents\n", len(bursts))
+
+    if *outputDir != "" {
+        dir, err := PrepareOutputDir(*outputDir, realm)
+        if err != nil {
+            log.Printf("Error: %v", err)
+            return 1
+        }
+        filename := filepath.Join(dir, fmt.Sprintf("bursts-%s-%s.csv",
+            timeRange.StartDate.Format("20060102"), timeRange.EndDate.Format("20060102")))
+        if err := writeBurstsCSV(bursts, filename); err != nil {
+            log.Printf("Error saving output: %v", err)
+            return 1
+        }
+        fmt.Printf("Results have been saved to:\n  - %s\n", filename)
+    }
+    return 0
+}
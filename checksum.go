@@ -0,0 +1,283 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// Signing tools usable with -sign
+const (
+    SignMinisign = "minisign"
+    SignGPG      = "gpg"
+)
+
+// ErrChecksumMismatch indicates files that differ from their sums file
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Signer signs sums files with an external tool
+type Signer struct {
+    Tool string
+    // Key is the minisign secret key file or the gpg key id
+    Key  string
+    path string
+}
+
+// ParseSigner parses a -sign value of the form minisign:<secret key file>
+// or gpg:<key id> and checks that the tool is installed
+func ParseSigner(value string) (*Signer, error) {
+    tool, key, ok := strings.Cut(value, ":")
+    tool = strings.ToLower(strings.TrimSpace(tool))
+    if !ok || strings.TrimSpace(key) == "" || (tool != SignMinisign && tool != SignGPG) {
+        return nil, fmt.Errorf("invalid signing key %q (expected %s:<secret key file> or %s:<key id>)", value, SignMinisign, SignGPG)
+    }
+    path, err := exec.LookPath(tool)
+    if err != nil {
+        return nil, fmt.Errorf("%s is needed for signing: %w", tool, err)
+    }
+    return &Signer{Tool: tool, Key: strings.TrimSpace(key), path: path}, nil
+}
+
+// signatureExtensions maps the signature file suffixes to their tools
+var signatureExtensions = map[string]string{
+    ".minisig": SignMinisign,
+    ".asc":     SignGPG,
+}
+
+// Sign writes a detached signature of filename and returns its name. A
+// minisign key protected by a password prompts for it on the terminal.
+func (s *Signer) Sign(filename string) (string, error) {
+    var signature string
+    var args []string
+    switch s.Tool {
+    case SignMinisign:
+        signature = filename + ".minisig"
+        args = []string{"-S", "-s", s.Key, "-x", signature, "-m", filename}
+    case SignGPG:
+        signature = filename + ".asc"
+        args = []string{"--batch", "--yes", "--local-user", s.Key, "--armor", "--output", signature, "--detach-sign", filename}
+    }
+    if _, err := runTool(s.path, args, os.Stdin); err != nil {
+        os.Remove(signature)
+        return "", fmt.Errorf("error signing %s with %s: %w", filename, s.Tool, err)
+    }
+    return signature, nil
+}
+
+// runTool runs an external tool and returns its output; failures include
+// its error output
+func runTool(path string, args []string, stdin io.Reader) (string, error) {
+    var stdout, stderr bytes.Buffer
+    cmd := exec.Command(path, args...)
+    cmd.Stdin = stdin
+    cmd.Stdout = &stdout
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+    }
+    return stdout.String(), nil
+}
+
+// fileSHA256 returns the hex SHA-256 digest of a file
+func fileSHA256(filename string) (string, error) {
+    file, err := os.Open(filename)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WriteChecksums writes the SHA-256 digests of the files to <base>.sha256
+// next to them, in the format of sha256sum, and returns its name
+func WriteChecksums(files []string, meta ExportMeta) (string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    var sums strings.Builder
+    for _, file := range files {
+        digest, err := fileSHA256(file)
+        if err != nil {
+            return "", fmt.Errorf("error hashing %s: %w", file, err)
+        }
+        fmt.Fprintf(&sums, "%s  %s\n", digest, filepath.Base(file))
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+".sha256")
+    if err := os.WriteFile(filename, []byte(sums.String()), 0644); err != nil {
+        return "", fmt.Errorf("error writing checksums: %w", err)
+    }
+    return filename, nil
+}
+
+// ChecksumResult is the outcome of checking one file of a sums file
+type ChecksumResult struct {
+    Name string
+    // Status is OK, FAILED or MISSING
+    Status string
+}
+
+// VerifyChecksums checks the files listed in a sums file, relative to its
+// directory. It returns ErrChecksumMismatch if any file differs or is gone.
+func VerifyChecksums(sumsFile string) ([]ChecksumResult, error) {
+    file, err := os.Open(sumsFile)
+    if err != nil {
+        return nil, fmt.Errorf("error reading checksums: %w", err)
+    }
+    defer file.Close()
+
+    dir := filepath.Dir(sumsFile)
+    var results []ChecksumResult
+    failed := 0
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" {
+            continue
+        }
+        digest, name, ok := strings.Cut(text, "  ")
+        if !ok || len(digest) != sha256.Size*2 || name == "" || filepath.Base(name) != name {
+            return nil, fmt.Errorf("invalid checksum line %d in %s", line, sumsFile)
+        }
+        result := ChecksumResult{Name: name, Status: "OK"}
+        actual, err := fileSHA256(filepath.Join(dir, name))
+        switch {
+        case os.IsNotExist(err):
+            result.Status = "MISSING"
+        case err != nil:
+            return nil, fmt.Errorf("error hashing %s: %w", name, err)
+        case !strings.EqualFold(actual, digest):
+            result.Status = "FAILED"
+        }
+        if result.Status != "OK" {
+            failed++
+        }
+        results = append(results, result)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("error reading checksums: %w", err)
+    }
+    if failed > 0 {
+        return results, fmt.Errorf("%w: %d of %d files", ErrChecksumMismatch, failed, len(results))
+    }
+    return results, nil
+}
+
+// VerifySignature checks the detached signature of a sums file, if there
+// is one. Minisign signatures need the public key file, gpg signatures the
+// fingerprint of the expected signer, as gpg alone accepts any key of the
+// keyring. It returns the tool that made the signature or "" when the file
+// is unsigned.
+func VerifySignature(sumsFile, publicKey, signer string) (string, error) {
+    for extension, tool := range signatureExtensions {
+        signature := sumsFile + extension
+        if _, err := os.Stat(signature); err != nil {
+            continue
+        }
+        path, err := exec.LookPath(tool)
+        if err != nil {
+            return tool, fmt.Errorf("%s is needed to verify %s: %w", tool, signature, err)
+        }
+        var args []string
+        switch tool {
+        case SignMinisign:
+            if publicKey == "" {
+                return tool, fmt.Errorf("verifying %s needs -pubkey", signature)
+            }
+            args = []string{"-V", "-q", "-p", publicKey, "-x", signature, "-m", sumsFile}
+        case SignGPG:
+            if signer == "" {
+                return tool, fmt.Errorf("verifying %s needs -signer", signature)
+            }
+            args = []string{"--batch", "--status-fd", "1", "--verify", signature, sumsFile}
+        }
+        status, err := runTool(path, args, nil)
+        if err != nil {
+            return tool, fmt.Errorf("invalid signature %s: %w", signature, err)
+        }
+        if tool == SignGPG && !gpgValidSigner(status, signer) {
+            return tool, fmt.Errorf("invalid signature %s: not made by %s", signature, signer)
+        }
+        return tool, nil
+    }
+    return "", nil
+}
+
+// gpgValidSigner reports whether the gpg --status-fd output has a VALIDSIG
+// line for the fingerprint, of the signing key or its primary key
+func gpgValidSigner(status, fingerprint string) bool {
+    fingerprint = strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+    for _, line := range strings.Split(status, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+            continue
+        }
+        // VALIDSIG <fingerprint> ... <primary key fingerprint>
+        if strings.EqualFold(fields[2], fingerprint) || (len(fields) >= 12 && strings.EqualFold(fields[11], fingerprint)) {
+            return true
+        }
+    }
+    return false
+}
+
+// runVerify implements the "verify" subcommand, which checks output files
+// against their sums file and its signature
+func runVerify(args []string) int {
+    flags := flag.NewFlagSet("verify", flag.ExitOnError)
+    publicKey := flags.String("pubkey", "", "minisign public key file for .minisig signatures (implies -require-signature)")
+    signer := flags.String("signer", "", "Fingerprint of the gpg key that must have made .asc signatures (implies -require-signature)")
+    requireSignature := flags.Bool("require-signature", false, "Fail when the sums file is not signed")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp verify [flags] <file.sha256>")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        flags.Usage()
+        return 1
+    }
+    sumsFile := flags.Arg(0)
+    // A named key means a signature is expected: removing it must not pass
+    if *publicKey != "" || *signer != "" {
+        *requireSignature = true
+    }
+
+    tool, err := VerifySignature(sumsFile, *publicKey, *signer)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    switch {
+    case tool != "":
+        fmt.Printf("Signature: OK (%s)\n", tool)
+    case *requireSignature:
+        fmt.Fprintf(os.Stderr, "Error: %s is not signed\n", sumsFile)
+        return 1
+    default:
+        fmt.Printf("Signature: none\n")
+    }
+
+    results, err := VerifyChecksums(sumsFile)
+    for _, result := range results {
+        fmt.Printf("%s: %s\n", result.Name, result.Status)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestGPGValidSigner(t *testing.T) {
+    // gpg --status-fd output of a signature by a subkey
+    status := "[GNUPG:] NEWSIG\n" +
+        "[GNUPG:] GOODSIG 1A2B3C4D5E6F7081 Report Signer <reports@uni.example>\n" +
+        "[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2024-01-02 1704153600 0 4 0 1 10 00 FEDCBA9876543210FEDCBA9876543210FEDCBA98\n"
+    tests := []struct {
+        fingerprint string
+        want        bool
+    }{
+        {"0123456789ABCDEF0123456789ABCDEF01234567", true},
+        {"fedc ba98 7654 3210 fedc ba98 7654 3210 fedc ba98", true},
+        {"1111111111111111111111111111111111111111", false},
+    }
+    for _, tt := range tests {
+        if got := gpgValidSigner(status, tt.fingerprint); got != tt.want {
+            t.Errorf("gpgValidSigner(%q) = %v, want %v", tt.fingerprint, got, tt.want)
+        }
+    }
+    if gpgValidSigner("[GNUPG:] BADSIG 1A2B3C4D5E6F7081 Report Signer\n", "1A2B3C4D5E6F7081") {
+        t.Error("bad signature accepted")
+    }
+}
+
+func TestVerifyPublicKeyRequiresSignature(t *testing.T) {
+    dir := t.TempDir()
+    output := filepath.Join(dir, "report.json")
+    if err := os.WriteFile(output, []byte("{}"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    digest := sha256.Sum256([]byte("{}"))
+    sums := filepath.Join(dir, "report.sha256")
+    if err := os.WriteFile(sums, []byte(hex.EncodeToString(digest[:])+"  report.json\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    if code := runVerify([]string{sums}); code != 0 {
+        t.Errorf("unsigned sums without a key: exit %d, want 0", code)
+    }
+    // A deleted signature must not pass when a key is named
+    for _, args := range [][]string{{"-pubkey", "key.pub", sums}, {"-signer", "0123456789ABCDEF", sums}} {
+        if code := runVerify(args); code == 0 {
+            t.Errorf("verify %v of unsigned sums passed", args)
+        }
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+)
+
+// DefaultChunkHitBudget is the largest number of probed hits that
+// PlanBatches puts into one query. It keeps the user buckets of a window
+// well below Quickwit's aggregation bucket limit.
+const DefaultChunkHitBudget = 50000
+
+// Job orders: the order in which the planned batches are dispatched
+const (
+    JobOrderNewest = "newest"
+    JobOrderOldest = "oldest"
+
+    // DefaultJobOrder fetches the most recent days first, so partial and
+    // cancelled runs hold the most relevant data
+    DefaultJobOrder = JobOrderNewest
+)
+
+// ParseJobOrder validates a -job-order value
+func ParseJobOrder(value string) (string, error) {
+    switch value {
+    case "":
+        return DefaultJobOrder, nil
+    case JobOrderNewest, JobOrderOldest:
+        return value, nil
+    }
+    return "", fmt.Errorf("invalid job order %q (available: %s, %s)", value, JobOrderNewest, JobOrderOldest)
+}
+
+// OrderBatches returns the batches of PlanBatches in dispatch order. The
+// days within a batch stay in ascending order, as range queries need them.
+func OrderBatches(batches [][]Job, order string) [][]Job {
+    if order == JobOrderOldest {
+        return batches
+    }
+    ordered := make([][]Job, len(batches))
+    for i, batch := range batches {
+        ordered[len(batches)-1-i] = batch
+    }
+    return ordered
+}
+
+// PlanBatches groups the jobs into the batches queried by BatchWorker.
+// Ranges of up to config.SingleQueryDays are one batch. With AutoChunk the
+// daily hit counts are probed first and every calendar month within the hit
+// budget becomes one batch; denser months are split into weeks, and weeks
+// over the budget into days. Otherwise every job is its own batch.
+func PlanBatches(ctx context.Context, config Config, client *HTTPClient, query map[string]interface{}, jobs []Job) [][]Job {
+    if config.SingleQueryDays > 0 && len(jobs) > 1 && len(jobs) <= config.SingleQueryDays {
+        return [][]Job{jobs}
+    }
+    if !config.AutoChunk || len(jobs) < 2 {
+        return dayBatches(jobs)
+    }
+
+    counts, err := ProbeDailyHits(ctx, client, query, jobs)
+    if err != nil {
+        log.Printf("Error probing hit counts, querying day by day: %v", err)
+        return dayBatches(jobs)
+    }
+
+    budget := config.ChunkHitBudget
+    if budget <= 0 {
+        budget = DefaultChunkHitBudget
+    }
+    var batches [][]Job
+    for _, month := range splitJobs(jobs, func(a, b Job) bool {
+        return a.Date.Year() == b.Date.Year() && a.Date.Month() == b.Date.Month()
+    }) {
+        if sumHits(month, counts) <= budget {
+            batches = append(batches, month)
+            continue
+        }
+        for len(month) > 0 {
+            week := month[:min(7, len(month))]
+            month = month[len(week):]
+            if sumHits(week, counts) <= budget {
+                batches = append(batches, week)
+            } else {
+                batches = append(batches, dayBatches(week)...)
+            }
+        }
+    }
+    return batches
+}
+
+// ProbeDailyHits counts the hits of every job with a single query that
+// aggregates nothing but a daily date_histogram
+func ProbeDailyHits(ctx context.Context, client *HTTPClient, query map[string]interface{}, jobs []Job) (map[time.Time]int64, error) {
+    first, last := jobs[0], jobs[len(jobs)-1]
+    offset := (first.StartTimestamp%86400 + 86400) % 86400
+    probe := map[string]interface{}{
+        "query":           query["query"],
+        "start_timestamp": first.StartTimestamp,
+        "end_timestamp":   last.EndTimestamp,
+        "max_hits":        0,
+        "aggs": map[string]interface{}{
+            "days": map[string]interface{}{
+                "date_histogram": map[string]interface{}{
+                    "field":          "timestamp",
+                    "fixed_interval": "86400s",
+                    "offset":         fmt.Sprintf("%ds", offset),
+                },
+            },
+        },
+    }
+
+    result, err := client.SendQuickwitRequest(ctx, probe)
+    if err != nil {
+        return nil, err
+    }
+    data, err := responseAggregation(result, "days")
+    if err != nil {
+        return nil, err
+    }
+    buckets, err := decodeBuckets[histogramBucket]("days", data)
+    if err != nil {
+        return nil, err
+    }
+
+    counts := make(map[time.Time]int64, len(jobs))
+    for _, bucket := range buckets {
+        key, err := bucket.Key.float()
+        if err != nil {
+            return nil, err
+        }
+        if job, ok := jobAt(jobs, int64(key/1000)); ok {
+            counts[job.Date] += int64(bucket.DocCount)
+        }
+    }
+    return counts, nil
+}
+
+// dayBatches returns one batch per job
+func dayBatches(jobs []Job) [][]Job {
+    batches := make([][]Job, len(jobs))
+    for i := range jobs {
+        batches[i] = jobs[i : i+1]
+    }
+    return batches
+}
+
+// splitJobs splits jobs into runs of consecutive jobs for which same holds
+func splitJobs(jobs []Job, same func(a, b Job) bool) [][]Job {
+    var runs [][]Job
+    start := 0
+    for i := 1; i <= len(jobs); i++ {
+        if i == len(jobs) || !same(jobs[start], jobs[i]) {
+            runs = append(runs, jobs[start:i])
+            start = i
+        }
+    }
+    return runs
+}
+
+// sumHits returns the probed hits of the jobs
+func sumHits(jobs []Job, counts map[time.Time]int64) int64 {
+    var total int64
+    for _, job := range jobs {
+        total += counts[job.Date]
+    }
+    return total
+}
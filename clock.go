@@ -0,0 +1,36 @@
+package main
+
+import (
+    "net/http"
+    "time"
+)
+
+// Clock tells the current time. Time-dependent code takes a Clock so that
+// it can be run, and tested, at a fixed point in time.
+type Clock interface {
+    Now() time.Time
+}
+
+// systemClock is the wall clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the Clock used when none is configured
+var SystemClock Clock = systemClock{}
+
+// FixedClock is a Clock that is stopped at the given time
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time { return time.Time(c) }
+
+// HTTPBackend sends the requests of an HTTPClient. *http.Client implements
+// it; tests substitute a fake Quickwit.
+type HTTPBackend interface {
+    Do(req *http.Request) (*http.Response, error)
+}
+
+// BackendFunc adapts a function to an HTTPBackend
+type BackendFunc func(req *http.Request) (*http.Response, error)
+
+func (f BackendFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
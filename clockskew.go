@@ -0,0 +1,53 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "time"
+)
+
+// DefaultMaxClockSkew is how far in the future event timestamps may lie
+// before they are treated as clock skew
+const DefaultMaxClockSkew = 5 * time.Minute
+
+// TimestampCutoff returns the latest trusted event timestamp for a maximum
+// clock skew, or 0 when maxSkew is negative and every timestamp is trusted
+func TimestampCutoff(now time.Time, maxSkew time.Duration) int64 {
+    if maxSkew < 0 {
+        return 0
+    }
+    return now.Add(maxSkew).Unix()
+}
+
+// ClampJobs ends the jobs at cutoff so that events stamped in the future by
+// a server with a bad clock are left out of the statistics. Jobs starting
+// after the cutoff are dropped. A zero cutoff returns the jobs unchanged.
+func ClampJobs(jobs []Job, cutoff int64) []Job {
+    if cutoff == 0 {
+        return jobs
+    }
+    clamped := make([]Job, 0, len(jobs))
+    for _, job := range jobs {
+        if job.StartTimestamp >= cutoff {
+            continue
+        }
+        job.EndTimestamp = min(job.EndTimestamp, cutoff)
+        clamped = append(clamped, job)
+    }
+    return clamped
+}
+
+// CountFutureEvents counts the events of the query between cutoff and the
+// end of the time range, i.e. those excluded by ClampJobs. Since the search
+// filters on the time range, events far outside it never reach the
+// statistics; the future ones are the skew that has to be excluded.
+func CountFutureEvents(ctx context.Context, client *HTTPClient, query map[string]interface{}, timeRange TimeRange, cutoff int64) (int64, error) {
+    if cutoff == 0 || cutoff >= timeRange.EndDate.Unix() {
+        return 0, nil
+    }
+    count, err := CountHits(ctx, client, query["query"], max(cutoff, timeRange.StartDate.Unix()), timeRange.EndDate.Unix())
+    if err != nil {
+        return 0, fmt.Errorf("error counting future events: %w", err)
+    }
+    return count, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/signal"
+    "sort"
+    "syscall"
+)
+
+// Command is a subcommand entry point. It receives the arguments following
+// the subcommand name and returns the process exit code.
+type Command struct {
+    Run         func(args []string) int
+    Description string
+}
+
+// Commands lists the available subcommands by name. Anything else on the
+// command line is treated as a domain for the default analysis.
+var Commands = map[string]Command{
+    "version": {Run: runVersion, Description: "Print version and build information"},
+    "report":  {Run: runReport, Description: "Build a report from the local store without querying Quickwit"},
+    "rollup":  {Run: runRollup, Description: "Build a trend report over the last periods from the local store"},
+    "realms":  {Run: runRealms, Description: "List realms seen in the index with their hit counts"},
+    "index":   {Run: runIndex, Description: "Create the Quickwit index with the expected doc mapping"},
+    "bursts":   {Run: runBursts, Description: "Report bursts of rejects for many usernames at one provider (password spraying)"},
+    "provider": {Run: runProvider, Description: "Show the daily activity of a single service provider"},
+    "backfill": {Run: runBackfill, Description: "Run the analyses listed in a manifest, resuming where a previous run stopped"},
+    "gaps":     {Run: runGaps, Description: "List days missing from the local store or with anomalously few hits, optionally backfilling them"},
+    "batch":    {Run: runBatch, Description: "Analyse a list of domains, resuming domain by domain where a previous run stopped"},
+    "runs":     {Run: runRuns, Description: "List past runs (runs list) or reprint the summary of one (runs show <id>)"},
+    "export":   {Run: runExport, Description: "Write the JSON output of an earlier run in other formats without querying Quickwit"},
+    "digest":   {Run: runDigest, Description: "Mail one summary of the runs of all domains in the last week"},
+    "verify":   {Run: runVerify, Description: "Check output files against their SHA-256 sums file and its signature"},
+    "serve":    {Run: runServe, Description: "Serve the gRPC API (Analyze, GetRun, ListRuns, StreamProgress) of api/eduroamidp.proto"},
+}
+
+// PrintCommands writes the list of subcommands to stdout
+func PrintCommands() {
+    names := make([]string, 0, len(Commands))
+    for name := range Commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    fmt.Println("Commands:")
+    for _, name := range names {
+        fmt.Printf("  %-10s %s\n", name, Commands[name].Description)
+    }
+}
+
+// commandContext returns a context cancelled on SIGINT/SIGTERM for subcommands
+func commandContext() (context.Context, context.CancelFunc) {
+    return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// LoadClient resolves and reads the properties file, with the settings of
+// profile and env if set, and returns a Quickwit client for it
+func LoadClient(configFile, profile, env string) (*HTTPClient, error) {
+    configPath, err := ResolveConfigPath(configFile)
+    if err != nil {
+        return nil, err
+    }
+    props, err := ReadProperties(configPath, profile, env)
+    if err != nil {
+        return nil, err
+    }
+    return NewHTTPClient(props), nil
+}
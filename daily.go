@@ -0,0 +1,198 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "os"
+    "path/filepath"
+    "slices"
+    "sort"
+    "strconv"
+)
+
+// DailyStat is one point of the per-day time series
+type DailyStat struct {
+    Date            string `json:"date"`
+    UniqueUsers     int    `json:"unique_users"`
+    UniqueProviders int    `json:"unique_providers"`
+    Hits            int64  `json:"hits"`
+}
+
+// dayVisitFormats are the formats that break the days down by user and
+// provider
+var dayVisitFormats = []string{"bigquery", "star", "nro", "html"}
+
+// NeedsDayVisits reports whether a run has to keep the per-day
+// user/provider pairs: the store, the impossible-travel check and the
+// multi-provider and provider-daily sections use them, as do some formats.
+// The daily series and the other sections only need the per-day users and
+// providers, which take far less memory on long ranges of large realms.
+func NeedsDayVisits(formats []string, fields FieldSet, pivot string, providerTimeseries, store, impossibleTravel bool) bool {
+    for _, format := range formats {
+        if slices.Contains(dayVisitFormats, format) {
+            return true
+        }
+    }
+    return store || impossibleTravel ||
+        fields.Has(FieldMultiProvider) && pivot != PivotSP ||
+        providerTimeseries && fields.Has(FieldProviderDaily)
+}
+
+// DailyStats returns the per-day time series of a result ordered by date
+func DailyStats(result *Result) []DailyStat {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Days) == 0 {
+        if result.Saved != nil {
+            return result.Saved.Daily
+        }
+        return nil
+    }
+    stats := make([]DailyStat, 0, len(result.Days))
+    for date, day := range result.Days {
+        stats = append(stats, DailyStat{
+            Date:            date,
+            UniqueUsers:     day.Users.Len(),
+            UniqueProviders: day.Providers.Len(),
+            Hits:            day.Hits,
+        })
+    }
+    sort.Slice(stats, func(i, j int) bool { return stats[i].Date < stats[j].Date })
+    return stats
+}
+
+// ExportDailyCSV writes the per-day time series next to the other CSV files.
+// It returns an empty filename when the result has no per-day data.
+func ExportDailyCSV(result *Result, meta ExportMeta) (string, error) {
+    stats := DailyStats(result)
+    if stats == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-daily.csv")
+    file, err := os.Create(filename)
+    if err != nil {
+        return "", fmt.Errorf("error creating daily CSV file: %w", err)
+    }
+    defer file.Close()
+
+    writer := csv.NewWriter(file)
+    if err := writer.Write([]string{"Date", "Unique Users", "Unique Providers", "Hits"}); err != nil {
+        return "", fmt.Errorf("error writing daily CSV header: %w", err)
+    }
+    for _, stat := range stats {
+        record := []string{
+            stat.Date,
+            strconv.Itoa(stat.UniqueUsers),
+            strconv.Itoa(stat.UniqueProviders),
+            strconv.FormatInt(stat.Hits, 10),
+        }
+        if err := writer.Write(record); err != nil {
+            return "", fmt.Errorf("error writing daily record: %w", err)
+        }
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return "", fmt.Errorf("error writing daily CSV file: %w", err)
+    }
+    return filename, nil
+}
+
+// ProviderDailyStat is one day of a provider's time series
+type ProviderDailyStat struct {
+    Date  string `json:"date"`
+    Users int    `json:"users"`
+    Hits  int64  `json:"hits"`
+}
+
+// ProviderDailyStats is the time series of a single provider
+type ProviderDailyStats struct {
+    Provider string              `json:"provider"`
+    Daily    []ProviderDailyStat `json:"daily"`
+}
+
+// ProviderDailySeries returns the per-day user and hit counts of every
+// provider, ordered by provider name and date. Days on which a provider was
+// not seen are omitted.
+func ProviderDailySeries(result *Result) []ProviderDailyStats {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Days) == 0 && result.Saved != nil {
+        return result.Saved.ProviderDaily
+    }
+    series := make(map[string]map[string]*ProviderDailyStat)
+    for date, day := range result.Days {
+        for _, providers := range day.Visits.ByUser() {
+            for _, provider := range providers {
+                if series[provider] == nil {
+                    series[provider] = make(map[string]*ProviderDailyStat)
+                }
+                stat := series[provider][date]
+                if stat == nil {
+                    stat = &ProviderDailyStat{Date: date, Hits: day.ProviderHits[provider]}
+                    series[provider][date] = stat
+                }
+                stat.Users++
+            }
+        }
+    }
+    if len(series) == 0 {
+        return nil
+    }
+
+    stats := make([]ProviderDailyStats, 0, len(series))
+    for provider, days := range series {
+        daily := make([]ProviderDailyStat, 0, len(days))
+        for _, stat := range days {
+            daily = append(daily, *stat)
+        }
+        sort.Slice(daily, func(i, j int) bool { return daily[i].Date < daily[j].Date })
+        stats = append(stats, ProviderDailyStats{Provider: provider, Daily: daily})
+    }
+    sort.Slice(stats, func(i, j int) bool { return stats[i].Provider < stats[j].Provider })
+    return stats
+}
+
+// ExportProviderDailyCSV writes the per-provider time series, one row per
+// provider and day. It returns an empty filename when there is no data.
+func ExportProviderDailyCSV(result *Result, meta ExportMeta) (string, error) {
+    stats := ProviderDailySeries(result)
+    if stats == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-provider-daily.csv")
+    file, err := os.Create(filename)
+    if err != nil {
+        return "", fmt.Errorf("error creating provider daily CSV file: %w", err)
+    }
+    defer file.Close()
+
+    writer := csv.NewWriter(file)
+    if err := writer.Write([]string{"Provider", "Date", "Users", "Hits"}); err != nil {
+        return "", fmt.Errorf("error writing provider daily CSV header: %w", err)
+    }
+    for _, provider := range stats {
+        for _, stat := range provider.Daily {
+            record := []string{provider.Provider, stat.Date, strconv.Itoa(stat.Users), strconv.FormatInt(stat.Hits, 10)}
+            if err := writer.Write(record); err != nil {
+                return "", fmt.Errorf("error writing provider daily record: %w", err)
+            }
+        }
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return "", fmt.Errorf("error writing provider daily CSV file: %w", err)
+    }
+    return filename, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// DefaultHTTPDebugMaxSize is the size in MB at which -debug-http rotates
+const DefaultHTTPDebugMaxSize = 10
+
+// httpDebugBackups is the number of rotated files (<file>.1 ...) kept
+const httpDebugBackups = 3
+
+// redacted replaces credentials in debug captures
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are replaced by redacted in debug captures
+var sensitiveHeaders = map[string]bool{
+    "Authorization":       true,
+    "Proxy-Authorization": true,
+    "Cookie":              true,
+    "Set-Cookie":          true,
+}
+
+// HTTPDebugLog dumps the requests and responses of failed Quickwit queries
+// to a file with credentials (authentication headers, URL user info) redacted, so a failure can be handed to the
+// Quickwit admins as is. The file is rotated when it exceeds maxSize.
+type HTTPDebugLog struct {
+    mu      sync.Mutex
+    path    string
+    maxSize int64
+    file    *os.File
+    size    int64
+}
+
+// OpenHTTPDebugLog opens (or creates) the capture file at path for
+// appending; maxSize is in bytes, 0 never rotates
+func OpenHTTPDebugLog(path string, maxSize int64) (*HTTPDebugLog, error) {
+    d := &HTTPDebugLog{path: path, maxSize: maxSize}
+    if err := d.open(); err != nil {
+        return nil, err
+    }
+    return d, nil
+}
+
+// open opens the capture file and records its current size
+func (d *HTTPDebugLog) open() error {
+    file, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return fmt.Errorf("error opening HTTP debug file: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return fmt.Errorf("error opening HTTP debug file: %w", err)
+    }
+    d.file = file
+    d.size = info.Size()
+    return nil
+}
+
+// rotate shifts <path> to <path>.1, <path>.1 to <path>.2 and so on,
+// dropping the oldest, and starts a new file
+func (d *HTTPDebugLog) rotate() error {
+    d.file.Close()
+    for i := httpDebugBackups - 1; i >= 1; i-- {
+        os.Rename(fmt.Sprintf("%s.%d", d.path, i), fmt.Sprintf("%s.%d", d.path, i+1))
+    }
+    if err := os.Rename(d.path, d.path+".1"); err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("error rotating HTTP debug file: %w", err)
+    }
+    return d.open()
+}
+
+// sanitizeURL redacts the user info of a URL
+func sanitizeURL(u *url.URL) string {
+    clean := *u
+    if clean.User != nil {
+        clean.User = url.User(redacted)
+    }
+    return clean.String()
+}
+
+// writeHeaders writes headers sorted by name, with sensitive values redacted
+func writeHeaders(b *strings.Builder, prefix string, headers http.Header) {
+    names := make([]string, 0, len(headers))
+    for name := range headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        for _, value := range headers[name] {
+            if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+                value = redacted
+            }
+            fmt.Fprintf(b, "%s%s: %s\n", prefix, name, value)
+        }
+    }
+}
+
+// Capture dumps a failed request and its response, which is nil when no
+// response was received. A nil HTTPDebugLog captures nothing.
+func (d *HTTPDebugLog) Capture(req *http.Request, requestBody []byte, resp *http.Response, responseBody []byte, duration time.Duration, queryErr error) {
+    if d == nil || req == nil {
+        return
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "=== %s %s %s (%v)\n", time.Now().Format(time.RFC3339), req.Method, sanitizeURL(req.URL), duration.Round(time.Millisecond))
+    fmt.Fprintf(&b, "> %s %s %s\n", req.Method, req.URL.RequestURI(), req.Proto)
+    fmt.Fprintf(&b, "> Host: %s\n", req.URL.Host)
+    writeHeaders(&b, "> ", req.Header)
+    fmt.Fprintf(&b, ">\n%s\n", requestBody)
+    if resp != nil {
+        fmt.Fprintf(&b, "< %s %s\n", resp.Proto, resp.Status)
+        writeHeaders(&b, "< ", resp.Header)
+        fmt.Fprintf(&b, "<\n%s\n", responseBody)
+    } else {
+        fmt.Fprintf(&b, "< (no response)\n")
+    }
+    if queryErr != nil {
+        fmt.Fprintf(&b, "error: %v\n", queryErr)
+    }
+    b.WriteString("\n")
+    entry := b.String()
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if d.maxSize > 0 && d.size > 0 && d.size+int64(len(entry)) > d.maxSize {
+        if err := d.rotate(); err != nil {
+            return
+        }
+    }
+    n, _ := d.file.WriteString(entry)
+    d.size += int64(n)
+}
+
+// Close closes the capture file
+func (d *HTTPDebugLog) Close() error {
+    if d == nil {
+        return nil
+    }
+    return d.file.Close()
+}
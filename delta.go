@@ -0,0 +1,153 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// outputFilePattern matches JSON outputs: <timestamp>-<range>[-partial].json
+var outputFilePattern = regexp.MustCompile(`^(\d{8}-\d{6})-(\d+d|y\d{4}|\d{8})(-partial)?\.json$`)
+
+// ChangesSinceLastRun compares a result with the previous output written
+// for the same domain and range type
+type ChangesSinceLastRun struct {
+    PreviousFile  string   `json:"previous_file"`
+    PreviousStart string   `json:"previous_start_date"`
+    PreviousEnd   string   `json:"previous_end_date"`
+    NewUsers      []string `json:"new_users,omitempty"`
+    LostUsers     []string `json:"lost_users,omitempty"`
+    NewProviders  []string `json:"new_providers,omitempty"`
+    LostProviders []string `json:"lost_providers,omitempty"`
+    HitsDelta     int64    `json:"hits_delta"`
+}
+
+// PreviousOutput is an earlier JSON output loaded for comparison
+type PreviousOutput struct {
+    Path string
+    Data SimplifiedOutputData
+}
+
+// rangeKind returns the part of an output file name that identifies the
+// range type: "7d" for relative ranges, "y" for years and "date" for dates
+func rangeKind(rangePart string) string {
+    switch {
+    case strings.HasSuffix(rangePart, "d"):
+        return rangePart
+    case strings.HasPrefix(rangePart, "y"):
+        return "y"
+    default:
+        return "date"
+    }
+}
+
+// FindPreviousOutput returns the most recent complete JSON output of the
+// same range type as meta in the domain's output directory, or nil if there
+// is none. It must be called before the current run's files are written.
+func FindPreviousOutput(meta ExportMeta) (*PreviousOutput, error) {
+    dir := OutputDirFor(meta.OutputDir, meta.Domain)
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error reading output directory: %w", err)
+    }
+
+    current := outputFilePattern.FindStringSubmatch(OutputBaseFilename(ExportMeta{TimeRange: meta.TimeRange}) + ".json")
+    if current == nil {
+        return nil, nil
+    }
+    kind := rangeKind(current[2])
+
+    var latest string
+    for _, entry := range entries {
+        match := outputFilePattern.FindStringSubmatch(entry.Name())
+        if match == nil || match[3] != "" || rangeKind(match[2]) != kind {
+            continue
+        }
+        // Timestamps sort lexically
+        if entry.Name() > latest {
+            latest = entry.Name()
+        }
+    }
+    if latest == "" {
+        return nil, nil
+    }
+
+    path := filepath.Join(dir, latest)
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("error reading previous output: %w", err)
+    }
+    previous := &PreviousOutput{Path: path}
+    if err := json.Unmarshal(data, &previous.Data); err != nil {
+        return nil, fmt.Errorf("error parsing previous output %s: %w", path, err)
+    }
+    if err := LoadUserChunks(dir, &previous.Data); err != nil {
+        return nil, err
+    }
+    return previous, nil
+}
+
+// CompareWithPrevious returns the changes of result against a previous
+// output. User and provider changes are only reported when the previous
+// output contains the respective lists, complete (not -max-output-size
+// truncated).
+func CompareWithPrevious(result *Result, previous *PreviousOutput) *ChangesSinceLastRun {
+    if previous == nil {
+        return nil
+    }
+
+    changes := &ChangesSinceLastRun{
+        PreviousFile:  filepath.Base(previous.Path),
+        PreviousStart: previous.Data.QueryInfo.StartDate,
+        PreviousEnd:   previous.Data.QueryInfo.EndDate,
+        HitsDelta:     result.TotalHits - previous.Data.QueryInfo.TotalHits,
+    }
+
+    if previous.Data.QueryInfo.Truncation != nil {
+        return changes
+    }
+
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if previous.Data.UserStats != nil {
+        before := make(map[string]bool, len(previous.Data.UserStats))
+        for _, user := range previous.Data.UserStats {
+            before[user.Username] = true
+        }
+        changes.NewUsers, changes.LostUsers = diffKeys(before, result.Users)
+    }
+    if previous.Data.ProviderStats != nil {
+        before := make(map[string]bool, len(previous.Data.ProviderStats))
+        for _, provider := range previous.Data.ProviderStats {
+            before[provider.Provider] = true
+        }
+        changes.NewProviders, changes.LostProviders = diffKeys(before, result.Providers)
+    }
+    return changes
+}
+
+// diffKeys returns the sorted keys of current missing from before (added)
+// and of before missing from current (removed)
+func diffKeys[V any](before map[string]bool, current map[string]V) (added, removed []string) {
+    for key := range current {
+        if !before[key] {
+            added = append(added, key)
+        }
+    }
+    for key := range before {
+        if _, ok := current[key]; !ok {
+            removed = append(removed, key)
+        }
+    }
+    sort.Strings(added)
+    sort.Strings(removed)
+    return added, removed
+}
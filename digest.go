@@ -0,0 +1,353 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "log"
+    "math"
+    "mime"
+    "net"
+    "net/smtp"
+    "os"
+    "sort"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultDigestDays is the period covered by the digest
+    DefaultDigestDays = 7
+
+    // DefaultDigestChange is the change in users or hits against the
+    // previous period (in percent) noted in the digest
+    DefaultDigestChange = 25.0
+)
+
+// DomainDigest summarises the runs of one domain in a digest period. The
+// figures are those of the latest run; the changes compare it with the
+// latest run of the previous period covering as many days.
+type DomainDigest struct {
+    Domain     string
+    Runs       int
+    Partial    int
+    Latest     *RunRecord
+    Previous   *RunRecord
+    UserChange float64
+    HitChange  float64
+    // Notes are the notable changes and anomalies of the domain
+    Notes []string
+}
+
+// Compared reports whether the domain has a previous run to compare with
+func (d DomainDigest) Compared() bool {
+    return d.Latest != nil && d.Previous != nil
+}
+
+// Digest summarises every domain analysed in a period, for one notification
+// instead of one per run
+type Digest struct {
+    From    time.Time
+    To      time.Time
+    Runs    int
+    Partial int
+    Users   int
+    Hits    int64
+    Domains []DomainDigest
+}
+
+// Notes returns the number of notes over all domains
+func (d *Digest) Notes() int {
+    notes := 0
+    for _, domain := range d.Domains {
+        notes += len(domain.Notes)
+    }
+    return notes
+}
+
+// runStarted returns the start time of a run, or the zero time if the
+// record has none
+func runStarted(record RunRecord) time.Time {
+    started, _ := time.Parse(time.RFC3339, record.Started)
+    return started
+}
+
+// percentChange returns the change from previous to current in percent
+func percentChange(previous, current int64) float64 {
+    if previous == 0 {
+        if current == 0 {
+            return 0
+        }
+        return math.Inf(1)
+    }
+    return float64(current-previous) / float64(previous) * 100
+}
+
+// BuildDigest summarises the runs started from from up to to. Runs of the
+// preceding period of the same length are the baseline of the changes and
+// list the domains that were not analysed in the period. Changes of at
+// least threshold percent are noted.
+func BuildDigest(runs []RunRecord, from, to time.Time, threshold float64) *Digest {
+    digest := &Digest{From: from, To: to}
+    previousFrom := from.Add(-to.Sub(from))
+
+    domains := make(map[string]*DomainDigest)
+    var previous []RunRecord
+    for i := range runs {
+        record := runs[i]
+        started := runStarted(record)
+        if started.Before(previousFrom) || !started.Before(to) {
+            continue
+        }
+        if started.Before(from) {
+            previous = append(previous, record)
+            continue
+        }
+        domain := domains[record.Domain]
+        if domain == nil {
+            domain = &DomainDigest{Domain: record.Domain}
+            domains[record.Domain] = domain
+        }
+        domain.Runs++
+        digest.Runs++
+        if record.Partial {
+            domain.Partial++
+            digest.Partial++
+        }
+        if domain.Latest == nil || !started.Before(runStarted(*domain.Latest)) {
+            domain.Latest = &record
+        }
+    }
+
+    // The baseline is the latest earlier run over as many days
+    lastSeen := make(map[string]RunRecord)
+    for i := range previous {
+        record := previous[i]
+        lastSeen[record.Domain] = record
+        domain := domains[record.Domain]
+        if domain == nil || domain.Latest.Days != record.Days {
+            continue
+        }
+        if domain.Previous == nil || !runStarted(record).Before(runStarted(*domain.Previous)) {
+            domain.Previous = &record
+        }
+    }
+
+    for _, domain := range domains {
+        latest := domain.Latest
+        digest.Users += latest.Users
+        digest.Hits += latest.TotalHits
+        if domain.Partial > 0 {
+            domain.Notes = append(domain.Notes, fmt.Sprintf("%d of %d runs partial", domain.Partial, domain.Runs))
+        }
+        if latest.Users == 0 {
+            domain.Notes = append(domain.Notes, "no users in the latest run")
+        }
+        if domain.Compared() {
+            domain.UserChange = percentChange(int64(domain.Previous.Users), int64(latest.Users))
+            domain.HitChange = percentChange(domain.Previous.TotalHits, latest.TotalHits)
+            if math.Abs(domain.UserChange) >= threshold {
+                domain.Notes = append(domain.Notes, fmt.Sprintf("users %s (%d to %d)", formatChange(domain.UserChange), domain.Previous.Users, latest.Users))
+            }
+            if math.Abs(domain.HitChange) >= threshold {
+                domain.Notes = append(domain.Notes, fmt.Sprintf("hits %s (%d to %d)", formatChange(domain.HitChange), domain.Previous.TotalHits, latest.TotalHits))
+            }
+        }
+    }
+    for name, record := range lastSeen {
+        if domains[name] == nil {
+            domains[name] = &DomainDigest{
+                Domain: name,
+                Notes:  []string{fmt.Sprintf("not analysed in this period (last run %s)", runStarted(record).Format(DateFormat))},
+            }
+        }
+    }
+
+    for _, domain := range domains {
+        digest.Domains = append(digest.Domains, *domain)
+    }
+    sort.Slice(digest.Domains, func(i, j int) bool { return digest.Domains[i].Domain < digest.Domains[j].Domain })
+    return digest
+}
+
+// formatChange formats a percent change with its sign
+func formatChange(change float64) string {
+    if math.IsInf(change, 1) {
+        return "new"
+    }
+    return fmt.Sprintf("%+.1f%%", change)
+}
+
+// Subject returns the subject line of the digest notification
+func (d *Digest) Subject() string {
+    return fmt.Sprintf("eduroam-idp digest %s to %s: %d domains, %d notes",
+        d.From.Format(DateFormat), d.To.Format(DateFormat), len(d.Domains), d.Notes())
+}
+
+// Text returns the plain text body of the digest notification
+func (d *Digest) Text() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "eduroam-idp digest from %s to %s\n", d.From.Format(DateFormat), d.To.Format(DateFormat))
+    fmt.Fprintf(&b, "%d domains, %d runs (%d partial)\n\n", len(d.Domains), d.Runs, d.Partial)
+    if len(d.Domains) == 0 {
+        b.WriteString("No runs recorded\n")
+        return b.String()
+    }
+
+    fmt.Fprintf(&b, "%-30s %5s %10s %8s %14s %8s\n", "Domain", "Runs", "Users", "Change", "Hits", "Change")
+    for _, domain := range d.Domains {
+        if domain.Latest == nil {
+            fmt.Fprintf(&b, "%-30s %5d %10s %8s %14s %8s\n", domain.Domain, 0, "-", "", "-", "")
+            continue
+        }
+        userChange, hitChange := "", ""
+        if domain.Compared() {
+            userChange, hitChange = formatChange(domain.UserChange), formatChange(domain.HitChange)
+        }
+        fmt.Fprintf(&b, "%-30s %5d %10d %8s %14d %8s\n", domain.Domain, domain.Runs,
+            domain.Latest.Users, userChange, domain.Latest.TotalHits, hitChange)
+    }
+    fmt.Fprintf(&b, "%-30s %5d %10d %8s %14d\n", "Total", d.Runs, d.Users, "", d.Hits)
+
+    if d.Notes() > 0 {
+        b.WriteString("\nNotes:\n")
+        for _, domain := range d.Domains {
+            for _, note := range domain.Notes {
+                fmt.Fprintf(&b, "  - %s: %s\n", domain.Domain, note)
+            }
+        }
+    }
+    return b.String()
+}
+
+// Mailer sends notifications through the SMTP server of the SMTP_HOST,
+// SMTP_USER, SMTP_PASS, MAIL_FROM and MAIL_TO properties
+type Mailer struct {
+    // Addr is the host:port of the server; port 587 is assumed if missing
+    Addr string
+    User string
+    Pass string
+    From string
+    To   []string
+}
+
+// NewMailer returns the mailer configured in props
+func NewMailer(props Properties) (*Mailer, error) {
+    if props.SMTPHost == "" {
+        return nil, fmt.Errorf("%w: SMTP_HOST", ErrMissingConfiguration)
+    }
+    if props.MailFrom == "" {
+        return nil, fmt.Errorf("%w: MAIL_FROM", ErrMissingConfiguration)
+    }
+    addr := props.SMTPHost
+    if _, _, err := net.SplitHostPort(addr); err != nil {
+        addr = net.JoinHostPort(addr, "587")
+    }
+    var to []string
+    for _, recipient := range strings.Split(props.MailTo, ",") {
+        if recipient = strings.TrimSpace(recipient); recipient != "" {
+            to = append(to, recipient)
+        }
+    }
+    if len(to) == 0 {
+        return nil, fmt.Errorf("%w: MAIL_TO", ErrMissingConfiguration)
+    }
+    return &Mailer{Addr: addr, User: props.SMTPUser, Pass: props.SMTPPass, From: props.MailFrom, To: to}, nil
+}
+
+// Message returns the plain text message with its headers
+func (m *Mailer) Message(subject, body string, date time.Time) []byte {
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "From: %s\r\n", m.From)
+    fmt.Fprintf(&b, "To: %s\r\n", strings.Join(m.To, ", "))
+    fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+    fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+    b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+    return b.Bytes()
+}
+
+// Send mails the message to every recipient, authenticating when a user
+// is configured. STARTTLS is used when the server offers it.
+func (m *Mailer) Send(subject, body string) error {
+    var auth smtp.Auth
+    if m.User != "" {
+        host, _, _ := net.SplitHostPort(m.Addr)
+        auth = smtp.PlainAuth("", m.User, m.Pass, host)
+    }
+    if err := smtp.SendMail(m.Addr, auth, m.From, m.To, m.Message(subject, body, time.Now())); err != nil {
+        return fmt.Errorf("error sending mail via %s: %w", m.Addr, err)
+    }
+    return nil
+}
+
+// runDigest implements the "digest" subcommand
+func runDigest(args []string) int {
+    flags := flag.NewFlagSet("digest", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file with the SMTP_* and MAIL_* settings")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* settings of the configuration file (default: DEFAULT_ENV)")
+    storeDir := flags.String("store-dir", "", "Directory of the local store holding the run history (default: the user data dir)")
+    days := flags.Int("days", DefaultDigestDays, "Number of days up to now covered by the digest")
+    change := flags.Float64("change", DefaultDigestChange, "Note changes in users or hits of at least this many percent against the previous period")
+    to := flags.String("to", "", "Comma-separated recipients (default: MAIL_TO of the configuration file)")
+    dryRun := flags.Bool("dry-run", false, "Print the digest instead of mailing it")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp digest [flags]")
+        fmt.Fprintln(flags.Output(), "Mails one summary of the runs of every domain in the last days, e.g. weekly from cron or a systemd timer.")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 0 || *days <= 0 || *change < 0 {
+        flags.Usage()
+        return 1
+    }
+
+    var mailer *Mailer
+    if !*dryRun {
+        configPath, err := ResolveConfigPath(*configFile)
+        if err != nil {
+            log.Printf("Error reading properties: %v", err)
+            return 1
+        }
+        props, err := ReadProperties(configPath, *profile, *env)
+        if err != nil {
+            log.Printf("Error reading properties: %v", err)
+            return 1
+        }
+        if *to != "" {
+            props.MailTo = *to
+        }
+        if mailer, err = NewMailer(props); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            return 1
+        }
+    }
+
+    history, err := OpenRunHistory(ResolveStoreDir(*storeDir))
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    runs, err := history.Runs("")
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    now := time.Now()
+    digest := BuildDigest(runs, now.AddDate(0, 0, -*days), now, *change)
+    if *dryRun {
+        fmt.Printf("Subject: %s\n\n%s", digest.Subject(), digest.Text())
+        return 0
+    }
+    if err := mailer.Send(digest.Subject(), digest.Text()); err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    fmt.Printf("Digest of %d domains sent to %s\n", len(digest.Domains), strings.Join(mailer.To, ", "))
+    return 0
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+    "errors"
+    "strings"
+    "testing"
+    "time"
+)
+
+func digestRun(domain string, started time.Time, days, users int, hits int64) RunRecord {
+    return RunRecord{
+        ID:        started.Format("20060102-150405"),
+        Domain:    domain,
+        Days:      days,
+        Started:   started.Format(time.RFC3339),
+        Users:     users,
+        TotalHits: hits,
+    }
+}
+
+func TestBuildDigest(t *testing.T) {
+    to := startOfDay(testNow)
+    from := to.AddDate(0, 0, -7)
+    lastWeek := from.AddDate(0, 0, -3)
+    thisWeek := from.AddDate(0, 0, 2)
+
+    partial := digestRun("b.example", thisWeek, 7, 0, 0)
+    partial.Partial = true
+    runs := []RunRecord{
+        digestRun("a.example", lastWeek, 7, 100, 1000),
+        digestRun("a.example", thisWeek, 7, 50, 1100),
+        digestRun("a.example", thisWeek.Add(time.Hour), 30, 400, 9000),
+        digestRun("a.example", thisWeek.Add(2*time.Hour), 7, 60, 1050),
+        partial,
+        digestRun("c.example", lastWeek, 7, 10, 100),
+        // Outside both periods
+        digestRun("d.example", from.AddDate(0, 0, -20), 7, 10, 100),
+        digestRun("a.example", to.Add(time.Hour), 7, 1, 1),
+    }
+
+    digest := BuildDigest(runs, from, to, DefaultDigestChange)
+    if digest.Runs != 4 || digest.Partial != 1 || digest.Users != 60 || digest.Hits != 1050 {
+        t.Errorf("totals: %+v", digest)
+    }
+    if len(digest.Domains) != 3 {
+        t.Fatalf("got %d domains, want 3", len(digest.Domains))
+    }
+
+    a := digest.Domains[0]
+    if a.Domain != "a.example" || a.Runs != 3 || !a.Compared() || a.Latest.Users != 60 || a.Previous.Users != 100 {
+        t.Errorf("a.example: %+v", a)
+    }
+    if len(a.Notes) != 1 || !strings.HasPrefix(a.Notes[0], "users -40.0%") {
+        t.Errorf("a.example notes: %q", a.Notes)
+    }
+
+    b := digest.Domains[1]
+    if b.Compared() || len(b.Notes) != 2 {
+        t.Errorf("b.example: %+v", b)
+    }
+
+    c := digest.Domains[2]
+    if c.Latest != nil || len(c.Notes) != 1 || !strings.Contains(c.Notes[0], "not analysed") {
+        t.Errorf("c.example: %+v", c)
+    }
+
+    text := digest.Text()
+    for _, want := range []string{"3 domains, 4 runs (1 partial)", "a.example", "-40.0%", "b.example: 1 of 1 runs partial"} {
+        if !strings.Contains(text, want) {
+            t.Errorf("digest text misses %q:\n%s", want, text)
+        }
+    }
+}
+
+func TestNewMailer(t *testing.T) {
+    if _, err := NewMailer(Properties{MailFrom: "idp@example.org", MailTo: "noc@example.org"}); !errors.Is(err, ErrMissingConfiguration) {
+        t.Errorf("missing SMTP_HOST: %v", err)
+    }
+
+    mailer, err := NewMailer(Properties{SMTPHost: "smtp.example.org", MailFrom: "idp@example.org", MailTo: "noc@example.org, ,stats@example.org"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if mailer.Addr != "smtp.example.org:587" || len(mailer.To) != 2 {
+        t.Errorf("mailer: %+v", mailer)
+    }
+
+    message := string(mailer.Message("Digest ก", "line 1\nline 2\n", testNow))
+    for _, want := range []string{"To: noc@example.org, stats@example.org\r\n", "Subject: =?utf-8?q?Digest_", "\r\n\r\nline 1\r\nline 2\r\n"} {
+        if !strings.Contains(message, want) {
+            t.Errorf("message misses %q:\n%s", want, message)
+        }
+    }
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// DefaultDomainSuggestions is the number of similar realms suggested for a
+// domain without hits
+const DefaultDomainSuggestions = 5
+
+// ErrInvalidDomain indicates a domain argument that cannot be a realm
+var ErrInvalidDomain = errors.New("invalid domain")
+
+// ValidateDomain checks the syntax of the realm a domain argument resolves
+// to. Wildcard domains ("*.ac.th") are checked without the "*.".
+func ValidateDomain(realm string) error {
+    name := strings.TrimPrefix(realm, "*.")
+    switch {
+    case strings.Contains(name, "://"):
+        return fmt.Errorf("%w %q: give the realm, not a URL", ErrInvalidDomain, realm)
+    case strings.Contains(name, "@"):
+        return fmt.Errorf("%w %q: give the realm only, without the username and \"@\"", ErrInvalidDomain, realm)
+    case strings.HasSuffix(name, "."):
+        return fmt.Errorf("%w %q: remove the trailing dot", ErrInvalidDomain, realm)
+    case !validRealm(RealmToASCII(name)):
+        return fmt.Errorf("%w %q: a realm is made of dot-separated labels of letters, digits and inner hyphens", ErrInvalidDomain, realm)
+    }
+    return nil
+}
+
+// SuggestRealms returns up to limit realms of the index that the user may
+// have meant by targets: look-alikes within a few edits, and realms that
+// differ from a target in the first label only (a missing, extra or other
+// prefix), ordered by hits
+func SuggestRealms(realms []RealmCount, targets []string, limit int) []RealmCount {
+    seen := make(map[string]bool)
+    var suggestions []RealmCount
+    for _, lookalike := range FindLookalikes(realms, targets, DefaultLookalikeDistance, nil) {
+        seen[lookalike.Realm] = true
+        suggestions = append(suggestions, RealmCount{Realm: lookalike.Realm, Hits: lookalike.Hits})
+    }
+    for _, realm := range realms {
+        if seen[realm.Realm] {
+            continue
+        }
+        for _, target := range targets {
+            if differByPrefix(strings.ToLower(realm.Realm), strings.ToLower(target)) {
+                seen[realm.Realm] = true
+                suggestions = append(suggestions, realm)
+                break
+            }
+        }
+    }
+    sort.SliceStable(suggestions, func(i, j int) bool { return suggestions[i].Hits > suggestions[j].Hits })
+    if len(suggestions) > limit {
+        suggestions = suggestions[:limit]
+    }
+    return suggestions
+}
+
+// differByPrefix reports whether realms a and b are the same below an added,
+// missing or replaced first label, leaving at least two labels in common
+func differByPrefix(a, b string) bool {
+    _, restA, _ := strings.Cut(a, ".")
+    _, restB, _ := strings.Cut(b, ".")
+    return strings.Contains(restA, ".") && (restA == b || restA == restB) ||
+        strings.Contains(restB, ".") && restB == a
+}
+
+// RealmKnown reports whether any of realms has hits among the discovered
+// realms, ignoring letter case if foldCase is set
+func RealmKnown(discovered []RealmCount, realms []string, foldCase bool) bool {
+    for _, known := range discovered {
+        for _, realm := range realms {
+            if known.Realm == realm || foldCase && strings.EqualFold(known.Realm, realm) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// printDomainSuggestions lists the realms similar to the realm domainName
+// that domain resolved to, with a hint when a flag would have matched
+func printDomainSuggestions(discovered []RealmCount, domain, domainName string) {
+    suggestions := SuggestRealms(discovered, []string{domainName, domain}, DefaultDomainSuggestions)
+    if len(suggestions) == 0 {
+        fmt.Printf("No similar realms have hits in this range\n")
+        return
+    }
+    fmt.Printf("Realms with hits that look similar:\n")
+    for _, suggestion := range suggestions {
+        hint := ""
+        switch {
+        case suggestion.Realm == domain && domainName != domain:
+            hint = "  (use -no-prefix)"
+        case suggestion.Realm == DomainPrefix+domain && domainName == domain:
+            hint = "  (without -no-prefix)"
+        case strings.EqualFold(suggestion.Realm, domainName):
+            hint = "  (use -realm-ci)"
+        }
+        fmt.Printf("  %-50s %12d%s\n", suggestion.Realm, suggestion.Hits, hint)
+    }
+}
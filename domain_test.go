@@ -0,0 +1,46 @@
+package main
+
+import (
+    "errors"
+    "reflect"
+    "testing"
+)
+
+func TestValidateDomain(t *testing.T) {
+    for _, realm := range []string{"eduroam.uni.ac.th", "*.ac.th", "xn--12c1fe0br.xn--o3cw4h", "มหาวิทยาลัย.ไทย"} {
+        if err := ValidateDomain(realm); err != nil {
+            t.Errorf("ValidateDomain(%q) = %v", realm, err)
+        }
+    }
+    for _, realm := range []string{"eduroam.user@uni.ac.th", "https://uni.ac.th", "uni.ac.th.", "uni..ac.th", "-uni.ac.th", "uni ac.th", ""} {
+        if err := ValidateDomain(realm); !errors.Is(err, ErrInvalidDomain) {
+            t.Errorf("ValidateDomain(%q) = %v, want ErrInvalidDomain", realm, err)
+        }
+    }
+}
+
+func TestSuggestRealms(t *testing.T) {
+    discovered := []RealmCount{
+        {Realm: "eduroam.uni.ac.th", Hits: 5000},
+        {Realm: "eduroam.other.ac.th", Hits: 900},
+        {Realm: "uni.ac.th", Hits: 80},
+        {Realm: "eduroam.unii.ac.th", Hits: 12},
+        {Realm: "ac.th", Hits: 3},
+    }
+    suggestions := SuggestRealms(discovered, []string{"eduroam.uni.ac.t", "uni.ac.t"}, 5)
+    want := []RealmCount{{Realm: "eduroam.uni.ac.th", Hits: 5000}, {Realm: "uni.ac.th", Hits: 80}, {Realm: "eduroam.unii.ac.th", Hits: 12}}
+    if !reflect.DeepEqual(suggestions, want) {
+        t.Errorf("look-alikes = %v, want %v", suggestions, want)
+    }
+
+    // A missing prefix, not a short suffix
+    suggestions = SuggestRealms(discovered, []string{"wifi.uni.ac.th"}, 5)
+    want = []RealmCount{{Realm: "eduroam.uni.ac.th", Hits: 5000}, {Realm: "uni.ac.th", Hits: 80}}
+    if !reflect.DeepEqual(suggestions, want) {
+        t.Errorf("prefix suggestions = %v, want %v", suggestions, want)
+    }
+
+    if !RealmKnown(discovered, []string{"EDUROAM.UNI.AC.TH"}, true) || RealmKnown(discovered, []string{"EDUROAM.UNI.AC.TH"}, false) {
+        t.Error("RealmKnown ignores the letter case setting")
+    }
+}
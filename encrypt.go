@@ -0,0 +1,97 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "os/exec"
+    "strings"
+)
+
+// Encryption tools usable with -encrypt
+const (
+    EncryptAge = "age"
+    EncryptGPG = "gpg"
+)
+
+// Encryption encrypts output files to recipients with an external tool
+type Encryption struct {
+    Tool       string
+    Recipients []string
+    path       string
+}
+
+// ParseEncryption parses an -encrypt value of the form tool:recipient[,...]
+// and checks that the tool is installed
+func ParseEncryption(value string) (*Encryption, error) {
+    tool, recipients, ok := strings.Cut(value, ":")
+    tool = strings.ToLower(strings.TrimSpace(tool))
+    if !ok || (tool != EncryptAge && tool != EncryptGPG) {
+        return nil, fmt.Errorf("invalid encryption %q (expected %s:<recipient> or %s:<recipient>)", value, EncryptAge, EncryptGPG)
+    }
+    e := &Encryption{Tool: tool}
+    for _, recipient := range strings.Split(recipients, ",") {
+        if recipient = strings.TrimSpace(recipient); recipient != "" {
+            e.Recipients = append(e.Recipients, recipient)
+        }
+    }
+    if len(e.Recipients) == 0 {
+        return nil, fmt.Errorf("no %s recipient given", tool)
+    }
+    path, err := exec.LookPath(tool)
+    if err != nil {
+        return nil, fmt.Errorf("%s is needed for encryption: %w", tool, err)
+    }
+    e.path = path
+    return e, nil
+}
+
+// Extension returns the suffix of the encrypted files
+func (e *Encryption) Extension() string {
+    return "." + e.Tool
+}
+
+// EncryptFile writes the encrypted copy of filename with Extension appended
+// and removes the plaintext
+func (e *Encryption) EncryptFile(filename string) (string, error) {
+    encrypted := filename + e.Extension()
+    var args []string
+    switch e.Tool {
+    case EncryptAge:
+        for _, recipient := range e.Recipients {
+            args = append(args, "-r", recipient)
+        }
+        args = append(args, "-o", encrypted, filename)
+    case EncryptGPG:
+        args = []string{"--batch", "--yes", "--trust-model", "always"}
+        for _, recipient := range e.Recipients {
+            args = append(args, "-r", recipient)
+        }
+        args = append(args, "-o", encrypted, "--encrypt", filename)
+    }
+
+    var stderr bytes.Buffer
+    cmd := exec.Command(e.path, args...)
+    cmd.Stderr = &stderr
+    if err := cmd.Run(); err != nil {
+        os.Remove(encrypted)
+        return "", fmt.Errorf("error encrypting %s with %s: %w: %s", filename, e.Tool, err, strings.TrimSpace(stderr.String()))
+    }
+    if err := os.Remove(filename); err != nil {
+        return encrypted, fmt.Errorf("error removing plaintext %s: %w", filename, err)
+    }
+    return encrypted, nil
+}
+
+// EncryptFiles encrypts every file and returns the encrypted names
+func (e *Encryption) EncryptFiles(files []string) ([]string, error) {
+    encrypted := make([]string, 0, len(files))
+    for _, file := range files {
+        name, err := e.EncryptFile(file)
+        if err != nil {
+            return encrypted, err
+        }
+        encrypted = append(encrypted, name)
+    }
+    return encrypted, nil
+}
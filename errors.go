@@ -0,0 +1,192 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "time"
+)
+
+// Exit statuses of the failure classes, for automation to react to (1 is
+// used for other errors, 2 for usage errors, ExitEmptyResult for empty
+// -fail-on-empty runs)
+const (
+    // ExitAuth is the exit status when Quickwit refused the credentials
+    ExitAuth = 4
+
+    // ExitIndexNotFound is the exit status when the index does not exist
+    ExitIndexNotFound = 5
+
+    // ExitTimeout is the exit status when queries timed out
+    ExitTimeout = 6
+
+    // ExitPartialRange is the exit status of runs that saved a partial
+    // result because they were interrupted
+    ExitPartialRange = 7
+
+    // ExitBucketTruncated is the exit status of runs whose output misses
+    // users beyond the bucket limit of some days
+    ExitBucketTruncated = 8
+
+    // ExitAuditLog is the exit status of runs that could not write all
+    // their audit records
+    ExitAuditLog = 9
+)
+
+var (
+    // ErrAuth indicates a query refused for its credentials (401 or 403)
+    ErrAuth = errors.New("quickwit authentication failed")
+
+    // ErrIndexNotFound indicates a query for an index Quickwit does not have
+    ErrIndexNotFound = errors.New("quickwit index not found")
+
+    // ErrBucketTruncated indicates a day with more users than the
+    // unique_users aggregation returns; the remaining users are missing
+    ErrBucketTruncated = errors.New("user buckets truncated")
+
+    // ErrTimeout indicates a query that did not complete in time; it is
+    // wrapped together with ErrSearcherUnavailable so the next searcher
+    // is tried
+    ErrTimeout = errors.New("quickwit query timed out")
+
+    // ErrPartialRange indicates an analysis interrupted before all days were
+    // processed; it wraps the context error, and the partial result is
+    // returned with it
+    ErrPartialRange = errors.New("partial range")
+
+    // ErrAuditLog indicates audit records that could not be written
+    ErrAuditLog = errors.New("audit log write failed")
+)
+
+// errorClasses maps the sentinel errors to their class names and exit
+// statuses, most specific first
+var errorClasses = []struct {
+    err   error
+    class string
+    exit  int
+}{
+    {ErrAuth, "auth", ExitAuth},
+    {ErrIndexNotFound, "index_not_found", ExitIndexNotFound},
+    {ErrTimeout, "timeout", ExitTimeout},
+    {ErrPartialRange, "partial_range", ExitPartialRange},
+    {ErrBucketTruncated, "bucket_truncated", ExitBucketTruncated},
+    {ErrAuditLog, "audit_log", ExitAuditLog},
+    {ErrSearcherUnavailable, "searcher_unavailable", 1},
+    {ErrUnexpectedResponse, "unexpected_response", 1},
+    {ErrNoAggregationsInResponse, "unexpected_response", 1},
+    {ErrMissingConfiguration, "configuration", 1},
+}
+
+// ErrorClass returns the class name of err for structured logs, "error"
+// for errors outside the taxonomy and "" for nil
+func ErrorClass(err error) string {
+    if err == nil {
+        return ""
+    }
+    for _, c := range errorClasses {
+        if errors.Is(err, c.err) {
+            return c.class
+        }
+    }
+    return "error"
+}
+
+// ExitCode returns the exit status for err: 0 for nil, the status of its
+// class, or 1
+func ExitCode(err error) int {
+    if err == nil {
+        return 0
+    }
+    for _, c := range errorClasses {
+        if errors.Is(err, c.err) {
+            return c.exit
+        }
+    }
+    return 1
+}
+
+// ErrorFields returns the structured log fields of err
+func ErrorFields(err error) string {
+    return fmt.Sprintf("error_class=%s exit_code=%d", ErrorClass(err), ExitCode(err))
+}
+
+// exitWithError logs err with its structured fields and exits with the
+// status of its class
+func exitWithError(message string, err error) {
+    log.Printf("%s: %v (%s)", message, err, ErrorFields(err))
+    exit(ExitCode(err))
+}
+
+// exitHooks run, latest first, when the process exits through exit
+var exitHooks []func(code int) int
+
+// atExit registers a hook that closes a resource before the process exits;
+// it returns the exit status, which it may change from 0 when the resource
+// failed
+func atExit(hook func(code int) int) {
+    exitHooks = append(exitHooks, hook)
+}
+
+// exit runs the exit hooks and exits with the resulting status. Code that
+// registered hooks exits through exit, fatalf or exitWithError rather than
+// os.Exit and log.Fatalf, which would skip them.
+func exit(code int) {
+    for i := len(exitHooks) - 1; i >= 0; i-- {
+        code = exitHooks[i](code)
+    }
+    os.Exit(code)
+}
+
+// fatalf is log.Fatalf through the exit hooks
+func fatalf(format string, args ...interface{}) {
+    log.Printf(format, args...)
+    exit(1)
+}
+
+// isTimeout reports whether err is a deadline or network timeout
+func isTimeout(err error) bool {
+    var netErr net.Error
+    return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// BucketTruncationError is the ErrBucketTruncated of one day
+type BucketTruncationError struct {
+    Date time.Time
+    // OtherHits is the sum_other_doc_count of the aggregation: the hits
+    // of the users beyond the bucket limit
+    OtherHits int64
+}
+
+func (e *BucketTruncationError) Error() string {
+    return fmt.Sprintf("%v on %s: %d hits of further users missing", ErrBucketTruncated, e.Date.Format(DateFormat), e.OtherHits)
+}
+
+func (e *BucketTruncationError) Unwrap() error {
+    return ErrBucketTruncated
+}
+
+// splitTruncations separates the day truncations in err, possibly joined,
+// from the errors that failed the query
+func splitTruncations(err error) ([]*BucketTruncationError, error) {
+    if err == nil {
+        return nil, nil
+    }
+    errs := []error{err}
+    if joined, ok := err.(interface{ Unwrap() []error }); ok {
+        errs = joined.Unwrap()
+    }
+    var truncations []*BucketTruncationError
+    var failures []error
+    for _, err := range errs {
+        var truncation *BucketTruncationError
+        if errors.As(err, &truncation) {
+            truncations = append(truncations, truncation)
+        } else {
+            failures = append(failures, err)
+        }
+    }
+    return truncations, errors.Join(failures...)
+}
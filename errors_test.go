@@ -0,0 +1,74 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestSearchErrorClasses(t *testing.T) {
+    tests := []struct {
+        status int
+        want   error
+        exit   int
+    }{
+        {http.StatusForbidden, ErrAuth, ExitAuth},
+        {http.StatusNotFound, ErrIndexNotFound, ExitIndexNotFound},
+        {http.StatusGatewayTimeout, ErrTimeout, ExitTimeout},
+        {http.StatusBadGateway, ErrSearcherUnavailable, 1},
+        {http.StatusBadRequest, nil, 1},
+    }
+    for _, test := range tests {
+        backend := BackendFunc(func(req *http.Request) (*http.Response, error) {
+            return response(test.status, map[string]string{"message": http.StatusText(test.status)}), nil
+        })
+        _, err := newTestClient(backend).SendQuickwitRequest(context.Background(), map[string]interface{}{"query": "*"})
+        if err == nil || (test.want != nil && !errors.Is(err, test.want)) {
+            t.Errorf("status %d: error %v, want %v", test.status, err, test.want)
+        }
+        if code := ExitCode(fmt.Errorf("worker 1 error: %w", err)); code != test.exit {
+            t.Errorf("status %d: exit code %d, want %d", test.status, code, test.exit)
+        }
+    }
+
+    timeout := BackendFunc(func(req *http.Request) (*http.Response, error) {
+        return nil, context.DeadlineExceeded
+    })
+    _, err := newTestClient(timeout).SendQuickwitRequest(context.Background(), map[string]interface{}{"query": "*"})
+    if !errors.Is(err, ErrTimeout) || !errors.Is(err, ErrSearcherUnavailable) || ErrorClass(err) != "timeout" {
+        t.Errorf("timed out request: error %v, class %q", err, ErrorClass(err))
+    }
+}
+
+func TestErrorClass(t *testing.T) {
+    partial := fmt.Errorf("%w (1 of 3 days unprocessed): %w", ErrPartialRange, context.Canceled)
+    if ErrorClass(partial) != "partial_range" || ExitCode(partial) != ExitPartialRange || !errors.Is(partial, context.Canceled) {
+        t.Errorf("partial range: class %q, exit code %d", ErrorClass(partial), ExitCode(partial))
+    }
+    if ErrorClass(nil) != "" || ExitCode(nil) != 0 || ErrorClass(errors.New("disk full")) != "error" {
+        t.Errorf("unclassified errors: %q %d %q", ErrorClass(nil), ExitCode(nil), ErrorClass(errors.New("disk full")))
+    }
+    if fields := ErrorFields(fmt.Errorf("%w: range", ErrAuth)); fields != "error_class=auth exit_code=4" {
+        t.Errorf("fields = %q", fields)
+    }
+}
+
+func TestSplitTruncations(t *testing.T) {
+    day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+    truncation := &BucketTruncationError{Date: day, OtherHits: 12}
+    failure := fmt.Errorf("%w: connection refused", ErrSearcherUnavailable)
+
+    truncations, err := splitTruncations(errors.Join(truncation, failure))
+    if len(truncations) != 1 || truncations[0].OtherHits != 12 || !errors.Is(err, ErrSearcherUnavailable) || errors.Is(err, ErrBucketTruncated) {
+        t.Errorf("joined errors split into %v and %v", truncations, err)
+    }
+    if truncations, err := splitTruncations(truncation); len(truncations) != 1 || err != nil {
+        t.Errorf("truncation split into %v and %v", truncations, err)
+    }
+    if ExitCode(truncation) != ExitBucketTruncated {
+        t.Errorf("truncation exit code %d", ExitCode(truncation))
+    }
+}
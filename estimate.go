@@ -0,0 +1,160 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultEstimateMinDays is the shortest range estimated before launch
+    DefaultEstimateMinDays = 31
+
+    // DefaultConfirmHits is the estimated hit count above which a run has
+    // to be confirmed (or started with -yes)
+    DefaultConfirmHits = 100000000
+
+    // CalibrationFile keeps the throughput measured by earlier runs
+    CalibrationFile = "calibration.json"
+
+    // calibrationWeight is the weight of the latest run in the averages
+    calibrationWeight = 0.3
+)
+
+// ErrNotConfirmed is returned when a large run is not confirmed
+var ErrNotConfirmed = errors.New("run not confirmed")
+
+// Calibration holds the throughput of earlier runs, averaged with more
+// weight on recent ones
+type Calibration struct {
+    Runs              int       `json:"runs"`
+    HitsPerSecond     float64   `json:"hits_per_second"`
+    OutputBytesPerHit float64   `json:"output_bytes_per_hit"`
+    Updated           time.Time `json:"updated"`
+}
+
+// RunEstimate is the expected cost of a run
+type RunEstimate struct {
+    Hits int64
+    // Duration and OutputBytes are only known when Calibrated
+    Duration    time.Duration
+    OutputBytes int64
+    Calibrated  bool
+}
+
+// CalibrationPath returns the calibration file in the user data dir
+func CalibrationPath() string {
+    dir, err := UserDataDir()
+    if err != nil {
+        return CalibrationFile
+    }
+    return filepath.Join(dir, AppDirName, CalibrationFile)
+}
+
+// LoadCalibration reads the calibration at path; a missing file is an
+// empty calibration
+func LoadCalibration(path string) (Calibration, error) {
+    var calibration Calibration
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return calibration, nil
+    }
+    if err != nil {
+        return calibration, err
+    }
+    if err := json.Unmarshal(data, &calibration); err != nil {
+        return Calibration{}, fmt.Errorf("error decoding calibration %s: %w", path, err)
+    }
+    return calibration, nil
+}
+
+// Save writes the calibration to path
+func (c Calibration) Save(path string) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return err
+    }
+    data, err := json.MarshalIndent(c, "", "  ")
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0600)
+}
+
+// Update adds a completed run of hits taking duration and writing
+// outputBytes
+func (c *Calibration) Update(hits int64, duration time.Duration, outputBytes int64, now time.Time) {
+    if hits <= 0 || duration <= 0 {
+        return
+    }
+    rate := float64(hits) / duration.Seconds()
+    perHit := float64(outputBytes) / float64(hits)
+    if c.Runs == 0 {
+        c.HitsPerSecond, c.OutputBytesPerHit = rate, perHit
+    } else {
+        c.HitsPerSecond += calibrationWeight * (rate - c.HitsPerSecond)
+        c.OutputBytesPerHit += calibrationWeight * (perHit - c.OutputBytesPerHit)
+    }
+    c.Runs++
+    c.Updated = now
+}
+
+// Estimate returns the expected cost of a run of hits
+func (c Calibration) Estimate(hits int64) RunEstimate {
+    estimate := RunEstimate{Hits: hits}
+    if c.Runs > 0 && c.HitsPerSecond > 0 {
+        estimate.Calibrated = true
+        estimate.Duration = time.Duration(float64(hits) / c.HitsPerSecond * float64(time.Second)).Round(time.Second)
+        estimate.OutputBytes = int64(float64(hits) * c.OutputBytesPerHit)
+    }
+    return estimate
+}
+
+// EstimateRun counts the hits of query over timeRange with a single
+// count-only query and estimates the run from calibration
+func EstimateRun(ctx context.Context, client *HTTPClient, query map[string]interface{}, timeRange TimeRange, calibration Calibration) (RunEstimate, error) {
+    hits, err := CountHits(ctx, client, query["query"], timeRange.StartDate.Unix(), timeRange.EndDate.Unix())
+    if err != nil {
+        return RunEstimate{}, fmt.Errorf("error estimating the run: %w", err)
+    }
+    return calibration.Estimate(hits), nil
+}
+
+// ConfirmRun asks on out whether to start the run and reads the answer from
+// in, which must be a terminal
+func ConfirmRun(in *os.File, out io.Writer, prompt string) error {
+    if info, err := in.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+        return fmt.Errorf("%w: %s; use -yes to run without confirmation", ErrNotConfirmed, prompt)
+    }
+    fmt.Fprintf(out, "%s. Continue? [y/N] ", prompt)
+    answer, err := bufio.NewReader(in).ReadString('\n')
+    if err != nil && answer == "" {
+        fmt.Fprintln(out)
+        return fmt.Errorf("%w: no answer; use -yes to run without confirmation", ErrNotConfirmed)
+    }
+    switch strings.ToLower(strings.TrimSpace(answer)) {
+    case "y", "yes":
+        return nil
+    }
+    return ErrNotConfirmed
+}
+
+// FormatBytes formats a size with a binary unit
+func FormatBytes(size int64) string {
+    const unit = 1024
+    if size < unit {
+        return fmt.Sprintf("%d B", size)
+    }
+    div, exp := int64(unit), 0
+    for n := size / unit; n >= unit; n /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestCalibration(t *testing.T) {
+    var calibration Calibration
+    if estimate := calibration.Estimate(1000); estimate.Calibrated || estimate.Hits != 1000 {
+        t.Errorf("uncalibrated estimate = %+v", estimate)
+    }
+
+    calibration.Update(1000, time.Second, 5000, testNow)
+    calibration.Update(4000, time.Second, 20000, testNow)
+    if calibration.Runs != 2 || calibration.HitsPerSecond != 1900 || calibration.OutputBytesPerHit != 5 {
+        t.Errorf("calibration = %+v", calibration)
+    }
+    estimate := calibration.Estimate(19000)
+    if !estimate.Calibrated || estimate.Duration != 10*time.Second || estimate.OutputBytes != 95000 {
+        t.Errorf("estimate = %+v", estimate)
+    }
+
+    // Empty runs do not count
+    calibration.Update(0, time.Second, 0, testNow)
+    if calibration.Runs != 2 {
+        t.Errorf("empty run counted: %+v", calibration)
+    }
+}
+
+func TestFormatBytes(t *testing.T) {
+    for size, want := range map[int64]string{512: "512 B", 1536: "1.5 KiB", 3 << 30: "3.0 GiB"} {
+        if got := FormatBytes(size); got != want {
+            t.Errorf("FormatBytes(%d) = %q, want %q", size, got, want)
+        }
+    }
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// DefaultExclusions apply when the properties file has no EXCLUDE lines:
+// local authentications at the home institution are not roaming
+var DefaultExclusions = []string{`service_provider:"client"`}
+
+// ExclusionRule drops events whose field equals Value or matches Regex.
+// Value rules become NOT clauses of the Quickwit query; regex rules are
+// applied to the aggregated users and providers, so hit totals still
+// include the events they match.
+type ExclusionRule struct {
+    Field string
+    Value string
+    Regex *regexp.Regexp
+}
+
+// ParseExclusionRule parses `field:value`, `field:"value"` or `field:/regex/`
+func ParseExclusionRule(value string) (ExclusionRule, error) {
+    field, pattern, ok := strings.Cut(strings.TrimSpace(value), ":")
+    field = strings.TrimSpace(field)
+    pattern = strings.TrimSpace(pattern)
+    if !ok || field == "" || pattern == "" {
+        return ExclusionRule{}, fmt.Errorf("invalid exclusion %q, expected field:value or field:/regex/", value)
+    }
+
+    if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+        if field != "username" && field != "service_provider" {
+            return ExclusionRule{}, fmt.Errorf("invalid exclusion %q: regex rules support username and service_provider only", value)
+        }
+        re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+        if err != nil {
+            return ExclusionRule{}, fmt.Errorf("invalid exclusion %q: %w", value, err)
+        }
+        return ExclusionRule{Field: field, Regex: re}, nil
+    }
+    return ExclusionRule{Field: field, Value: strings.Trim(pattern, `"`)}, nil
+}
+
+// ParseExclusions parses the EXCLUDE lines of the properties file. With no
+// lines DefaultExclusions apply; a single "none" disables all exclusions.
+func ParseExclusions(values []string) ([]ExclusionRule, error) {
+    if len(values) == 0 {
+        values = DefaultExclusions
+    }
+    if len(values) == 1 && strings.TrimSpace(values[0]) == "none" {
+        return nil, nil
+    }
+    rules := make([]ExclusionRule, 0, len(values))
+    for _, value := range values {
+        rule, err := ParseExclusionRule(value)
+        if err != nil {
+            return nil, err
+        }
+        rules = append(rules, rule)
+    }
+    return rules, nil
+}
+
+// String returns the rule in the form it was configured
+func (r ExclusionRule) String() string {
+    if r.Regex != nil {
+        return fmt.Sprintf("%s:/%s/", r.Field, r.Regex.String())
+    }
+    return fmt.Sprintf(`%s:"%s"`, r.Field, r.Value)
+}
+
+// exclusionClause returns the NOT clauses of the value rules, with a
+// leading space, for appending to a query string
+func exclusionClause(rules []ExclusionRule) string {
+    var clause strings.Builder
+    for _, rule := range rules {
+        if rule.Regex == nil {
+            fmt.Fprintf(&clause, ` NOT %s:"%s"`, rule.Field, rule.Value)
+        }
+    }
+    return clause.String()
+}
+
+// excludedEntry reports whether a regex rule matches the entry
+func excludedEntry(rules []ExclusionRule, entry LogEntry) bool {
+    for _, rule := range rules {
+        if rule.Regex == nil {
+            continue
+        }
+        switch rule.Field {
+        case "username":
+            if rule.Regex.MatchString(entry.Username) {
+                return true
+            }
+        case "service_provider":
+            if rule.Regex.MatchString(entry.ServiceProvider) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// exclusionStrings returns the rules in their configured form
+func exclusionStrings(rules []ExclusionRule) []string {
+    if len(rules) == 0 {
+        return nil
+    }
+    values := make([]string, len(rules))
+    for i, rule := range rules {
+        values[i] = rule.String()
+    }
+    return values
+}
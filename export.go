@@ -0,0 +1,263 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// ErrNotAnOutput indicates a file that is not a JSON output of this program
+var ErrNotAnOutput = errors.New("not a JSON output")
+
+// LoadOutput reads a JSON output written by the json format, with its
+// user list when that was split into files
+func LoadOutput(path string) (SimplifiedOutputData, error) {
+    var data SimplifiedOutputData
+    content, err := os.ReadFile(path)
+    if err != nil {
+        return data, fmt.Errorf("error reading %s: %w", path, err)
+    }
+    if err := json.Unmarshal(content, &data); err != nil {
+        return data, fmt.Errorf("%w: %s: %w", ErrNotAnOutput, path, err)
+    }
+    if data.QueryInfo.Domain == "" || data.QueryInfo.StartDate == "" {
+        return data, fmt.Errorf("%w: %s has no query_info", ErrNotAnOutput, path)
+    }
+    if err := LoadUserChunks(filepath.Dir(path), &data); err != nil {
+        return data, err
+    }
+    return data, nil
+}
+
+// OutputTimeRange returns the time range of a JSON output. The range type
+// (days, year or date) is taken from the output file name, if it has the
+// usual form.
+func OutputTimeRange(path string, data SimplifiedOutputData) (TimeRange, error) {
+    var timeRange TimeRange
+    var err error
+    if timeRange.StartDate, err = time.ParseInLocation(DateTimeFormat, data.QueryInfo.StartDate, time.Local); err != nil {
+        return timeRange, fmt.Errorf("%w: invalid start_date: %w", ErrNotAnOutput, err)
+    }
+    if timeRange.EndDate, err = time.ParseInLocation(DateTimeFormat, data.QueryInfo.EndDate, time.Local); err != nil {
+        return timeRange, fmt.Errorf("%w: invalid end_date: %w", ErrNotAnOutput, err)
+    }
+    timeRange.Days = data.QueryInfo.Days
+
+    if match := outputFilePattern.FindStringSubmatch(filepath.Base(path)); match != nil {
+        switch rangePart := match[2]; {
+        case rangePart[0] == 'y':
+            timeRange.SpecificYear = true
+            timeRange.Year, _ = strconv.Atoi(rangePart[1:])
+        case rangeKind(rangePart) == "date":
+            timeRange.SpecificDate = true
+        }
+    }
+    return timeRange, nil
+}
+
+// parseOutputDate parses a first_seen or last_seen value
+func parseOutputDate(value string) time.Time {
+    for _, layout := range []string{DateTimeFormat, DateFormat} {
+        if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// ResultFromOutput rebuilds a result from a JSON output. The users,
+// providers and totals are restored as far as the output lists them. The
+// per-day user detail is not part of the output: the daily, mobility,
+// multi-provider and outlier sections are carried over as saved, and the
+// formats built from the days themselves get no per-day rows.
+func ResultFromOutput(data SimplifiedOutputData, timeRange TimeRange) (*Result, error) {
+    exclusions, err := ParseExclusions(data.QueryInfo.Exclusions)
+    if err != nil {
+        return nil, err
+    }
+    if data.QueryInfo.Exclusions == nil {
+        exclusions = nil
+    }
+    result := &Result{
+        Users:           make(map[string]*UserStats, len(data.UserStats)),
+        Providers:       make(map[string]*ProviderStats, len(data.ProviderStats)),
+        StartDate:       timeRange.StartDate,
+        EndDate:         timeRange.EndDate,
+        TotalHits:       data.QueryInfo.TotalHits,
+        Partial:         data.QueryInfo.Partial,
+        UnprocessedDays: data.QueryInfo.UnprocessedDays,
+        TruncatedDays:   data.QueryInfo.TruncatedDays,
+        Exclusions:      exclusions,
+        Local:           data.LocalTraffic,
+        Verification:    data.Verification,
+        DataQuality:     data.DataQuality,
+        Forecast:        data.Forecast,
+        Security:        data.Security,
+        Samples:         data.Samples,
+        FutureEvents:    data.QueryInfo.FutureEvents,
+        Pivot:           data.QueryInfo.Pivot,
+        Saved:           &data,
+    }
+
+    for _, user := range data.UserStats {
+        result.Users[user.Username] = &UserStats{
+            Providers: NewNameSet(user.Providers...),
+            FirstSeen: parseOutputDate(user.FirstSeen),
+            LastSeen:  parseOutputDate(user.LastSeen),
+            Hits:      user.Hits,
+        }
+    }
+    for _, provider := range data.ProviderStats {
+        stats := &ProviderStats{
+            Users:     NewNameSet(provider.Users...),
+            FirstSeen: parseOutputDate(provider.FirstSeen),
+            LastSeen:  parseOutputDate(provider.LastSeen),
+            Hits:      provider.Hits,
+        }
+        // Provider lists without usernames still know their users
+        // through the user list
+        if len(provider.Users) == 0 {
+            for username, user := range result.Users {
+                if user.Providers.Has(provider.Provider) {
+                    stats.Users.Add(username)
+                }
+            }
+        }
+        result.Providers[provider.Provider] = stats
+    }
+    return result, nil
+}
+
+// runExport implements the "export" subcommand
+func runExport(args []string) int {
+    flags := flag.NewFlagSet("export", flag.ExitOnError)
+    from := flags.String("from", "", "JSON output of an earlier run to export again (required)")
+    outputFormat := flags.String("format", DefaultOutputFormat, "Comma-separated output formats (e.g. csv,nro)")
+    outputDir := flags.String("output-dir", "", "Base directory for output files")
+    outputFields := flags.String("fields", "all", "Comma-separated output sections")
+    sortUsers := flags.String("sort-users", DefaultUserSort, "Order of the user list: count (providers), name or first_seen")
+    sortProviders := flags.String("sort-providers", DefaultProviderSort, "Order of the provider list: count (users) or name")
+    configFile := flags.String("config", "", "Properties file with COUNTRY.<provider> settings (default: the usual search path, if any)")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    force := flags.Bool("force", false, "Overwrite existing output files")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp export -from <run.json> [flags]")
+        fmt.Fprintln(flags.Output(), "Writes the result of an earlier run in other formats without querying Quickwit.")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if *from == "" || flags.NArg() != 0 {
+        flags.Usage()
+        return 1
+    }
+    formats, err := ParseFormats(*outputFormat)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    fields, err := ParseFields(*outputFields)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    if _, err := ParseUserSort(*sortUsers); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    if _, err := ParseProviderSort(*sortProviders); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+
+    // The properties only add country overrides; none are needed
+    countries := make(CountryMap)
+    configPath, err := ResolveConfigPath(*configFile)
+    if err == nil {
+        var props Properties
+        if props, err = ReadProperties(configPath, *profile, ""); err == nil {
+            countries = props.Countries
+        }
+    }
+    if err != nil && *configFile != "" {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+
+    data, err := LoadOutput(*from)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    timeRange, err := OutputTimeRange(*from, data)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    result, err := ResultFromOutput(data, timeRange)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    domain := data.QueryInfo.Domain
+    fmt.Printf("Loaded %s (%s, %d days)\n", *from, domain, timeRange.Days)
+    fmt.Printf("Number of users: %d\n", len(result.Users))
+    fmt.Printf("Number of providers: %d\n", len(result.Providers))
+    fmt.Printf("Total hits: %d\n", result.TotalHits)
+    if data.UserStats == nil {
+        fmt.Println("The output has no user list; user-based sections will be empty")
+    }
+    homeCountry := ProviderCountry(domain)
+    population := 0
+    if data.Summary != nil && data.Summary.Roaming != nil {
+        homeCountry = data.Summary.Roaming.HomeCountry
+    }
+    if data.Summary != nil && data.Summary.Adoption != nil {
+        population = data.Summary.Adoption.Population
+    }
+    var baseline *BaselineComparison
+    if data.Summary != nil {
+        baseline = data.Summary.Baseline
+    }
+
+    filenames, err := RunExporters(formats, result, ExportMeta{
+        Domain:             domain,
+        TimeRange:          timeRange,
+        RunTime:            time.Now(),
+        OutputDir:          ResolveOutputDir(*outputDir),
+        Partial:            result.Partial,
+        ProviderTimeseries: data.ProviderDaily != nil,
+        MessageType:        data.QueryInfo.MessageType,
+        Fields:             fields,
+        SortUsers:          *sortUsers,
+        SortProviders:      *sortProviders,
+        HomeCountry:        homeCountry,
+        Countries:          countries,
+        OutlierFactor:      DefaultOutlierFactor,
+        MultiProviderMin:   DefaultMultiProviderMin,
+        Environment:        data.RunInfo.Environment,
+        Population:         population,
+        Baseline:           baseline,
+        LostProviders:      data.LostProviders,
+        Overwrite:          *force,
+    })
+    if errors.Is(err, ErrOutputExists) {
+        log.Printf("Error saving output: %v (use -force to overwrite)", err)
+        return 1
+    } else if err != nil {
+        log.Printf("Error saving output: %v", err)
+        return 1
+    }
+    fmt.Printf("Results have been saved to:\n")
+    for _, filename := range filenames {
+        fmt.Printf("  - %s\n", filename)
+    }
+    return 0
+}
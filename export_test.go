@@ -0,0 +1,72 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestResultFromOutput(t *testing.T) {
+    config := testConfig(t)
+    result, err := RunAnalysis(context.Background(), config, newTestClient(&fakeQuickwit{events: testEvents(t)}), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    meta := ExportMeta{
+        Domain:             config.Domain,
+        TimeRange:          config.TimeRange,
+        ProviderTimeseries: true,
+        OutlierFactor:      DefaultOutlierFactor,
+        MultiProviderMin:   1,
+        Deterministic:      true,
+    }
+    saved := CreateOutputData(result, meta)
+
+    // Decode as LoadOutput does
+    content, err := json.Marshal(saved)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var data SimplifiedOutputData
+    if err := json.Unmarshal(content, &data); err != nil {
+        t.Fatal(err)
+    }
+    timeRange, err := OutputTimeRange("report-3d.json", data)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !timeRange.StartDate.Equal(config.TimeRange.StartDate) || timeRange.Days != config.TimeRange.Days || timeRange.SpecificDate {
+        t.Errorf("time range = %+v, want %+v", timeRange, config.TimeRange)
+    }
+    rebuilt, err := ResultFromOutput(data, timeRange)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    exported := CreateOutputData(rebuilt, ExportMeta{
+        Domain:             meta.Domain,
+        TimeRange:          timeRange,
+        ProviderTimeseries: true,
+        OutlierFactor:      DefaultOutlierFactor,
+        MultiProviderMin:   1,
+        Deterministic:      true,
+    })
+    if !reflect.DeepEqual(exported, data) {
+        got, _ := json.MarshalIndent(exported, "", "  ")
+        want, _ := json.MarshalIndent(data, "", "  ")
+        t.Errorf("exported output differs:\n%s\nwant:\n%s", got, want)
+    }
+}
+
+func TestOutputBaseFilenameRunTime(t *testing.T) {
+    meta := ExportMeta{TimeRange: testRange(t, 3), RunTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}
+    if got := OutputBaseFilename(meta); got != "20240102-030405-3d" {
+        t.Errorf("OutputBaseFilename = %q", got)
+    }
+    meta.Partial = true
+    if got := OutputBaseFilename(meta); got != "20240102-030405-3d-partial" {
+        t.Errorf("partial OutputBaseFilename = %q", got)
+    }
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ExportMeta carries the run details exporters need besides the result
+type ExportMeta struct {
+    Domain             string
+    TimeRange          TimeRange
+    OutputDir          string
+    Partial            bool
+    // ProviderTimeseries adds the per-provider daily series to the output
+    ProviderTimeseries bool
+    // ProviderHistory is the first stored day of each provider, marking
+    // new providers in the timeline; nil without the store
+    ProviderHistory    *ProviderHistory
+    // MobilityFormula names the per-user mobility score (MobilityFormulas)
+    MobilityFormula    string
+    // OutlierFactor is the multiple of the median auths per user above
+    // which users are listed as outliers
+    OutlierFactor      float64
+    // MessageType is the RADIUS message type analysed; empty means
+    // DefaultMessageType
+    MessageType        string
+    // MultiProviderMin is the number of distinct providers a user must
+    // exceed on one day to be listed in the multi-provider section
+    MultiProviderMin   int
+    // Fields selects the output sections; nil writes all of them
+    Fields             FieldSet
+    // SortUsers and SortProviders order the user and provider lists; empty
+    // values use DefaultUserSort and DefaultProviderSort
+    SortUsers          string
+    SortProviders      string
+    // RunTime is the start of the run, the timestamp of the output file
+    // names; BaseName replaces it when set
+    RunTime            time.Time
+    BaseName           string
+    // Deterministic leaves the export time out of the file contents
+    Deterministic      bool
+    // Overwrite lets the exporters replace existing output files; without
+    // it RunExporters fails with ErrOutputExists
+    Overwrite          bool
+    // Previous is the earlier output the result is compared with, if any
+    Previous           *PreviousOutput
+    // Public strips usernames from the output; PublicCountries also
+    // aggregates the providers by country
+    Public             bool
+    PublicCountries    bool
+    // Pseudonyms replaces the usernames of a public output by stable
+    // identifiers (-stable-ids), keeping the user list; nil strips them
+    Pseudonyms         *Pseudonymizer
+    // HomeCountry is the country code of the institution, separating
+    // national from international roaming
+    HomeCountry        string
+    // Countries overrides the country of individual providers
+    Countries          CountryMap
+    // Locations positions providers for the geojson format
+    Locations          map[string]ProviderLocation
+    // BigQuery is the table of the bigquery format
+    BigQuery           *BigQueryTarget
+    // Environment is the Quickwit environment the result was queried from
+    Environment        string
+    // Baseline is the comparison with the window before the range
+    // (-baseline), nil without one
+    Baseline           *BaselineComparison
+    // LostProviders lists the providers of the previous period missing
+    // from the range (-baseline or -store), nil without a previous period
+    LostProviders      *LostProviderReport
+    // HeatStripProviders is the number of top providers with a heat strip
+    // in the html format (DefaultHeatStripProviders if 0)
+    HeatStripProviders int
+    // Population is the headcount the adoption percentage is based on (0
+    // for none)
+    Population         int
+    // MaxOutputSize is the largest JSON output in bytes before it is cut
+    // down to the summary and the top MaxOutputTop entries (0 for no limit)
+    MaxOutputSize      int64
+    MaxOutputTop       int
+    // UsersPerFile splits longer JSON user lists into numbered files of
+    // this many users (WriteUserChunks); 0 keeps them in the output
+    UsersPerFile       int
+}
+
+// Exporter writes a result in a single output format and returns the paths
+// of the files it created
+type Exporter interface {
+    Write(result *Result, meta ExportMeta) ([]string, error)
+}
+
+// ExporterFunc adapts an ordinary function to the Exporter interface
+type ExporterFunc func(result *Result, meta ExportMeta) ([]string, error)
+
+// Write calls f(result, meta)
+func (f ExporterFunc) Write(result *Result, meta ExportMeta) ([]string, error) {
+    return f(result, meta)
+}
+
+var (
+    exportersMu sync.RWMutex
+    exporters   = make(map[string]Exporter)
+)
+
+// RegisterExporter makes an exporter available under the given format name.
+// Registering the same name twice replaces the previous exporter.
+func RegisterExporter(name string, exporter Exporter) {
+    exportersMu.Lock()
+    defer exportersMu.Unlock()
+    exporters[strings.ToLower(name)] = exporter
+}
+
+// GetExporter returns the exporter registered for a format name
+func GetExporter(name string) (Exporter, bool) {
+    exportersMu.RLock()
+    defer exportersMu.RUnlock()
+    exporter, ok := exporters[strings.ToLower(name)]
+    return exporter, ok
+}
+
+// ExporterNames returns the sorted names of all registered exporters
+func ExporterNames() []string {
+    exportersMu.RLock()
+    defer exportersMu.RUnlock()
+    names := make([]string, 0, len(exporters))
+    for name := range exporters {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// ParseFormats splits a comma-separated format list (e.g. "json,csv") and
+// checks that every format has a registered exporter
+func ParseFormats(value string) ([]string, error) {
+    var formats []string
+    seen := make(map[string]bool)
+    for _, part := range strings.Split(value, ",") {
+        name := strings.ToLower(strings.TrimSpace(part))
+        if name == "" || seen[name] {
+            continue
+        }
+        if _, ok := GetExporter(name); !ok {
+            return nil, fmt.Errorf("%w: %q (available: %s)", ErrInvalidOutputFormat, name, strings.Join(ExporterNames(), ", "))
+        }
+        seen[name] = true
+        formats = append(formats, name)
+    }
+    if len(formats) == 0 {
+        return nil, fmt.Errorf("%w: no format given", ErrInvalidOutputFormat)
+    }
+    return formats, nil
+}
+
+// ErrOutputExists indicates output files of the run are already present
+var ErrOutputExists = errors.New("output already exists")
+
+// ExistingOutputs returns the files in the domain's output directory that
+// an export with meta would write. Outputs of the same base name with a
+// "-partial" or "-public" suffix belong to other runs and are ignored.
+func ExistingOutputs(meta ExportMeta) ([]string, error) {
+    dir := OutputDirFor(meta.OutputDir, meta.Domain)
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error reading output directory: %w", err)
+    }
+
+    base := OutputBaseFilename(meta)
+    var existing []string
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || !strings.HasPrefix(name, base) {
+            continue
+        }
+        rest := name[len(base):]
+        if strings.HasPrefix(rest, "-partial") || strings.HasPrefix(rest, "-public") {
+            continue
+        }
+        if strings.HasPrefix(rest, ".") || strings.HasPrefix(rest, "-") {
+            existing = append(existing, filepath.Join(dir, name))
+        }
+    }
+    return existing, nil
+}
+
+// RunExporters writes the result with each of the given formats in order.
+// Unless meta.Overwrite is set, nothing is written when any output of the
+// run already exists.
+func RunExporters(formats []string, result *Result, meta ExportMeta) ([]string, error) {
+    if !meta.Overwrite {
+        existing, err := ExistingOutputs(meta)
+        if err != nil {
+            return nil, err
+        }
+        if len(existing) > 0 {
+            return nil, fmt.Errorf("%w: %s", ErrOutputExists, existing[0])
+        }
+    }
+    if meta.Public && meta.Pseudonyms != nil {
+        result = PseudonymizeUsers(result, meta.Pseudonyms)
+    }
+    if meta.Public && meta.PublicCountries {
+        result = ProvidersByCountry(result, meta.Countries)
+    }
+    var files []string
+    for _, name := range formats {
+        exporter, ok := GetExporter(name)
+        if !ok {
+            return files, fmt.Errorf("%w: %q", ErrInvalidOutputFormat, name)
+        }
+        written, err := exporter.Write(result, meta)
+        if err != nil {
+            return files, fmt.Errorf("error exporting %s: %w", name, err)
+        }
+        files = append(files, written...)
+    }
+    return files, nil
+}
+
+func init() {
+    RegisterExporter("json", ExporterFunc(func(result *Result, meta ExportMeta) ([]string, error) {
+        outputData := CreateOutputData(result, meta)
+        chunks, err := WriteUserChunks(&outputData, meta)
+        if err != nil {
+            return nil, err
+        }
+        filename, err := SaveOutputToJSON(outputData, meta)
+        if err != nil {
+            return nil, err
+        }
+        return append([]string{filename}, chunks...), nil
+    }))
+    RegisterExporter("csv", ExporterFunc(ExportToCSV))
+}
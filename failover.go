@@ -0,0 +1,67 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// Searcher selection modes for several QW_URLs (QW_BALANCE)
+const (
+    BalanceFailover   = "failover"
+    BalanceRoundRobin = "round-robin"
+)
+
+// ErrSearcherUnavailable marks errors after which the next QW_URL is tried:
+// the searcher could not be reached or answered with a 5xx status
+var ErrSearcherUnavailable = errors.New("quickwit searcher unavailable")
+
+// ParseBalance validates a QW_BALANCE value
+func ParseBalance(value string) (string, error) {
+    switch value {
+    case "", BalanceFailover:
+        return BalanceFailover, nil
+    case BalanceRoundRobin:
+        return BalanceRoundRobin, nil
+    }
+    return "", fmt.Errorf("invalid QW_BALANCE %q (use %s or %s)", value, BalanceFailover, BalanceRoundRobin)
+}
+
+// URLs returns the configured Quickwit base URLs; QW_URL may list several
+// searchers separated by commas
+func (props Properties) URLs() []string {
+    var urls []string
+    for _, url := range strings.Split(props.QWURL, ",") {
+        if url = strings.TrimSuffix(strings.TrimSpace(url), "/"); url != "" {
+            urls = append(urls, url)
+        }
+    }
+    return urls
+}
+
+// searchOrder returns the URLs in the order they are tried for the next
+// request. Failover starts at the searcher that last answered; round-robin
+// starts at the next searcher on every request.
+func (c *HTTPClient) searchOrder(props Properties) []string {
+    urls := props.URLs()
+    if len(urls) < 2 {
+        return urls
+    }
+    var first int
+    if props.Balance == BalanceRoundRobin {
+        first = int(c.next.Add(1)-1) % len(urls)
+    } else {
+        first = int(c.preferred.Load()) % len(urls)
+    }
+    return append(urls[first:], urls[:first]...)
+}
+
+// markAnswered records the searcher that answered for failover
+func (c *HTTPClient) markAnswered(props Properties, url string) {
+    for i, u := range props.URLs() {
+        if u == url {
+            c.preferred.Store(int32(i))
+            return
+        }
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Output sections selectable with -fields
+const (
+    FieldSummary       = "summary"
+    FieldUsers         = "users"
+    FieldProviders     = "providers"
+    FieldDaily         = "daily"
+    FieldSubrealms     = "subrealms"
+    FieldProviderDaily = "provider_daily"
+    FieldMobility      = "mobility"
+    FieldAuths         = "auths"
+    FieldNAI           = "nai"
+    FieldVerification  = "verification"
+    FieldQuality       = "quality"
+    FieldForecast      = "forecast"
+    FieldOutliers      = "outliers"
+    FieldSecurity      = "security"
+    FieldMultiProvider = "multi_provider"
+    FieldSamples       = "samples"
+    FieldTimeline      = "provider_timeline"
+    FieldLost          = "lost_providers"
+)
+
+// OutputFields lists every selectable output section
+var OutputFields = []string{
+    FieldSummary, FieldUsers, FieldProviders, FieldDaily,
+    FieldSubrealms, FieldProviderDaily, FieldMobility, FieldAuths, FieldNAI,
+    FieldVerification, FieldQuality, FieldForecast, FieldOutliers,
+    FieldSecurity, FieldMultiProvider, FieldSamples, FieldTimeline,
+    FieldLost,
+}
+
+// FieldSet is a selection of output sections. A nil set selects everything.
+type FieldSet map[string]bool
+
+// Has reports whether the section is selected
+func (f FieldSet) Has(field string) bool {
+    return f == nil || f[field]
+}
+
+// ParseFields parses a comma-separated -fields value. An empty value or
+// "all" selects every section.
+func ParseFields(value string) (FieldSet, error) {
+    value = strings.TrimSpace(value)
+    if value == "" || value == "all" {
+        return nil, nil
+    }
+
+    known := make(map[string]bool, len(OutputFields))
+    for _, field := range OutputFields {
+        known[field] = true
+    }
+
+    fields := make(FieldSet)
+    for _, part := range strings.Split(value, ",") {
+        name := strings.ToLower(strings.TrimSpace(part))
+        if name == "" {
+            continue
+        }
+        if !known[name] {
+            names := append([]string(nil), OutputFields...)
+            sort.Strings(names)
+            return nil, fmt.Errorf("invalid output field %q (available: %s)", name, strings.Join(names, ", "))
+        }
+        fields[name] = true
+    }
+    if len(fields) == 0 {
+        return nil, fmt.Errorf("no output fields given")
+    }
+    return fields, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+    "math"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+const (
+    // DefaultForecastDays is the forecast horizon, about one quarter
+    DefaultForecastDays = 91
+
+    // forecastSeason is the length of the seasonal cycle in days (weekly)
+    forecastSeason = 7
+
+    // forecastZ scales the residual spread into an approximate 95% band
+    forecastZ = 1.96
+)
+
+// ForecastPoint is the projection of one future day with its band
+type ForecastPoint struct {
+    Date      string  `json:"date"`
+    Users     float64 `json:"unique_users"`
+    UsersLow  float64 `json:"unique_users_low"`
+    UsersHigh float64 `json:"unique_users_high"`
+    Hits      float64 `json:"hits"`
+    HitsLow   float64 `json:"hits_low"`
+    HitsHigh  float64 `json:"hits_high"`
+}
+
+// ForecastTotal is a projected figure over the whole horizon with its band
+type ForecastTotal struct {
+    Value float64 `json:"value"`
+    Low   float64 `json:"low"`
+    High  float64 `json:"high"`
+}
+
+// Forecast projects the daily unique users and hits over the next Days days
+type Forecast struct {
+    Method string `json:"method"`
+    Days   int    `json:"days"`
+    // MeanDailyUsers is the average projected daily unique users and
+    // TotalHits the projected hits summed over the horizon
+    MeanDailyUsers ForecastTotal   `json:"mean_daily_users"`
+    TotalHits      ForecastTotal   `json:"total_hits"`
+    Points         []ForecastPoint `json:"points"`
+}
+
+// holtWinters is a fitted additive Holt-Winters model
+type holtWinters struct {
+    level, trend float64
+    season       []float64
+    // sigma is the standard deviation of the one-step-ahead errors
+    sigma float64
+    sse   float64
+}
+
+// fitHoltWinters fits an additive model with the given smoothing factors;
+// a season length of 0 fits Holt's linear trend without seasonality
+func fitHoltWinters(series []float64, season int, alpha, beta, gamma float64) holtWinters {
+    m := holtWinters{level: series[0]}
+    start := 1
+    if season > 0 {
+        // Initial level and trend from the first two cycles, seasonal
+        // offsets from the first cycle
+        var first, second float64
+        for i := 0; i < season; i++ {
+            first += series[i]
+            second += series[season+i]
+        }
+        first /= float64(season)
+        second /= float64(season)
+        m.level = first
+        m.trend = (second - first) / float64(season)
+        m.season = make([]float64, season)
+        for i := 0; i < season; i++ {
+            m.season[i] = series[i] - first
+        }
+        start = season
+    } else if len(series) > 1 {
+        m.trend = series[1] - series[0]
+    }
+
+    var errors int
+    for t := start; t < len(series); t++ {
+        seasonal := 0.0
+        if season > 0 {
+            seasonal = m.season[t%season]
+        }
+        predicted := m.level + m.trend + seasonal
+        residual := series[t] - predicted
+        m.sse += residual * residual
+        errors++
+
+        level := alpha*(series[t]-seasonal) + (1-alpha)*(m.level+m.trend)
+        m.trend = beta*(level-m.level) + (1-beta)*m.trend
+        if season > 0 {
+            m.season[t%season] = gamma*(series[t]-level) + (1-gamma)*seasonal
+        }
+        m.level = level
+    }
+    if errors > 0 {
+        m.sigma = math.Sqrt(m.sse / float64(errors))
+    }
+    return m
+}
+
+// bestHoltWinters fits the smoothing factors on a coarse grid and keeps the
+// model with the smallest one-step-ahead error
+func bestHoltWinters(series []float64, season int) holtWinters {
+    grid := []float64{0.1, 0.3, 0.5, 0.7, 0.9}
+    gammas := grid
+    if season == 0 {
+        gammas = []float64{0}
+    }
+    var best holtWinters
+    found := false
+    for _, alpha := range grid {
+        for _, beta := range []float64{0.01, 0.05, 0.1, 0.3} {
+            for _, gamma := range gammas {
+                m := fitHoltWinters(series, season, alpha, beta, gamma)
+                if !found || m.sse < best.sse {
+                    best, found = m, true
+                }
+            }
+        }
+    }
+    return best
+}
+
+// predict returns the value h days (1-based) after the end of the series,
+// which had n points
+func (m holtWinters) predict(n, h int) float64 {
+    value := m.level + float64(h)*m.trend
+    if len(m.season) > 0 {
+        value += m.season[(n+h-1)%len(m.season)]
+    }
+    return value
+}
+
+// ForecastUsage projects the daily unique users and hits of result over the
+// next days with an additive Holt-Winters model with weekly seasonality
+// (Holt's linear trend for less than two weeks of data). The bands widen
+// with the square root of the horizon. An incomplete current day is left
+// out of the fit. It returns nil with less than a week of data.
+func ForecastUsage(result *Result, days int, now time.Time) *Forecast {
+    result.mu.RLock()
+    var users, hits []float64
+    var last time.Time
+    for _, job := range GenerateJobs(TimeRange{StartDate: result.StartDate, EndDate: result.EndDate}) {
+        if time.Unix(job.EndTimestamp, 0).After(now) {
+            break
+        }
+        day := result.Days[job.Date.Format(DateFormat)]
+        if day == nil {
+            users, hits = append(users, 0), append(hits, 0)
+        } else {
+            users, hits = append(users, float64(day.Users.Len())), append(hits, float64(day.Hits))
+        }
+        last = job.Date
+    }
+    result.mu.RUnlock()
+
+    if len(users) < forecastSeason || days < 1 {
+        return nil
+    }
+    season, method := forecastSeason, "holt-winters additive, weekly season"
+    if len(users) < 2*forecastSeason {
+        season, method = 0, "holt linear trend"
+    }
+    usersModel := bestHoltWinters(users, season)
+    hitsModel := bestHoltWinters(hits, season)
+
+    forecast := &Forecast{Method: method, Days: days, Points: make([]ForecastPoint, 0, days)}
+    var usersSum, hitsSum, usersVar, hitsVar float64
+    for h := 1; h <= days; h++ {
+        userValue := math.Max(0, usersModel.predict(len(users), h))
+        hitValue := math.Max(0, hitsModel.predict(len(hits), h))
+        userBand := forecastZ * usersModel.sigma * math.Sqrt(float64(h))
+        hitBand := forecastZ * hitsModel.sigma * math.Sqrt(float64(h))
+        forecast.Points = append(forecast.Points, ForecastPoint{
+            Date:      last.AddDate(0, 0, h).Format(DateFormat),
+            Users:     math.Round(userValue*10) / 10,
+            UsersLow:  math.Round(math.Max(0, userValue-userBand)*10) / 10,
+            UsersHigh: math.Round((userValue+userBand)*10) / 10,
+            Hits:      math.Round(hitValue),
+            HitsLow:   math.Round(math.Max(0, hitValue-hitBand)),
+            HitsHigh:  math.Round(hitValue + hitBand),
+        })
+        usersSum += userValue
+        hitsSum += hitValue
+        usersVar += usersModel.sigma * usersModel.sigma * float64(h)
+        hitsVar += hitsModel.sigma * hitsModel.sigma * float64(h)
+    }
+
+    // Summed errors are treated as independent, which understates the band
+    // of a trend error but keeps it comparable between runs
+    usersMean, usersBand := usersSum/float64(days), forecastZ*math.Sqrt(usersVar)/float64(days)
+    hitsBand := forecastZ * math.Sqrt(hitsVar)
+    forecast.MeanDailyUsers = ForecastTotal{
+        Value: math.Round(usersMean*10) / 10,
+        Low:   math.Round(math.Max(0, usersMean-usersBand)*10) / 10,
+        High:  math.Round((usersMean+usersBand)*10) / 10,
+    }
+    forecast.TotalHits = ForecastTotal{
+        Value: math.Round(hitsSum),
+        Low:   math.Round(math.Max(0, hitsSum-hitsBand)),
+        High:  math.Round(hitsSum + hitsBand),
+    }
+    return forecast
+}
+
+// ExportForecastCSV writes the projected days. It returns an empty filename
+// when no forecast was made.
+func ExportForecastCSV(result *Result, meta ExportMeta) (string, error) {
+    if result.Forecast == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    format := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+    records := [][]string{{"Date", "Unique Users", "Unique Users Low", "Unique Users High", "Hits", "Hits Low", "Hits High"}}
+    for _, point := range result.Forecast.Points {
+        records = append(records, []string{
+            point.Date,
+            format(point.Users), format(point.UsersLow), format(point.UsersHigh),
+            format(point.Hits), format(point.HitsLow), format(point.HitsHigh),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-forecast.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// DefaultGapLowFactor flags stored days with fewer hits than this fraction
+// of the median day as anomalously low
+const DefaultGapLowFactor = 0.2
+
+// Gap kinds
+const (
+    GapMissing = "missing"
+    GapLow     = "low"
+)
+
+// Gap is a run of consecutive days of one kind: not in the store at all, or
+// stored with anomalously few hits
+type Gap struct {
+    Start string
+    End   string
+    Days  int
+    Kind  string
+    // Hits is the total of the stored days of a low gap
+    Hits int64
+}
+
+// GapReport is the result of checking the store of a domain for gaps
+type GapReport struct {
+    From       string
+    To         string
+    Days       int
+    StoredDays int
+    // MedianHits is the median of the stored days the low threshold is
+    // derived from
+    MedianHits int64
+    Gaps       []Gap
+}
+
+// FindGaps checks every day from from to to against the stored records and
+// groups missing days and days below lowFactor times the median hits into
+// gaps. A lowFactor of 0 only reports missing days.
+func FindGaps(records []DayRecord, from, to time.Time, lowFactor float64) *GapReport {
+    stored := make(map[string]int64, len(records))
+    hits := make([]int64, 0, len(records))
+    for _, record := range records {
+        stored[record.Date] = record.Hits
+        hits = append(hits, record.Hits)
+    }
+    report := &GapReport{From: from.Format(DateFormat), To: to.Format(DateFormat)}
+    if len(hits) > 0 {
+        sort.Slice(hits, func(i, j int) bool { return hits[i] < hits[j] })
+        report.MedianHits = hits[len(hits)/2]
+    }
+    threshold := int64(float64(report.MedianHits) * lowFactor)
+
+    var current *Gap
+    for day := startOfDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+        date := day.Format(DateFormat)
+        report.Days++
+        dayHits, ok := stored[date]
+        kind := ""
+        switch {
+        case !ok:
+            kind = GapMissing
+        case dayHits < threshold:
+            kind = GapLow
+        }
+        if ok {
+            report.StoredDays++
+        }
+        if kind == "" {
+            current = nil
+            continue
+        }
+        if current == nil || current.Kind != kind {
+            report.Gaps = append(report.Gaps, Gap{Start: date, Kind: kind})
+            current = &report.Gaps[len(report.Gaps)-1]
+        }
+        current.End = date
+        current.Days++
+        current.Hits += dayHits
+    }
+    return report
+}
+
+// Dates returns every date of the gap
+func (g Gap) Dates() []time.Time {
+    start, _ := time.Parse(DateFormat, g.Start)
+    dates := make([]time.Time, g.Days)
+    for i := range dates {
+        dates[i] = start.AddDate(0, 0, i)
+    }
+    return dates
+}
+
+// GapBackfillManifest returns a backfill manifest that stores every day of
+// the gaps again, one single-day analysis per day
+func GapBackfillManifest(domain string, gaps []Gap, flags []string) BackfillManifest {
+    manifest := BackfillManifest{Parallel: DefaultBackfillParallel, Flags: flags}
+    for _, gap := range gaps {
+        for _, date := range gap.Dates() {
+            manifest.Entries = append(manifest.Entries, BackfillEntry{Domain: domain, Range: date.Format(SpecificDateFormat)})
+        }
+    }
+    return manifest
+}
+
+// runGaps implements the "gaps" subcommand
+func runGaps(args []string) int {
+    flags := flag.NewFlagSet("gaps", flag.ExitOnError)
+    storeDir := flags.String("store-dir", "", "Directory of the local store (default: the user data dir)")
+    lowFactor := flags.Float64("low-factor", DefaultGapLowFactor, "Report stored days with fewer hits than this fraction of the median day (0 disables)")
+    backfill := flags.Bool("backfill", false, "Run the analysis with -store for every day of the gaps (resumable, see the backfill command)")
+    analysisFlags := flags.String("analysis-flags", "", "Further flags of the -backfill analyses, e.g. \"-no-prefix -realm-ci\"")
+    parallel := flags.Int("parallel", DefaultBackfillParallel, "Number of days backfilled at once")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp gaps [flags] <domain> [days|Ny|yxxxx|DD-MM-YYYY]")
+        fmt.Fprintln(flags.Output(), "Without a range, the days from the first stored day to yesterday are checked.")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() < 1 || flags.NArg() > 2 {
+        flags.Usage()
+        return 1
+    }
+    domain := flags.Arg(0)
+
+    dir := ResolveStoreDir(*storeDir)
+    store, err := OpenStore(dir)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    // Today is still being filled and never a gap by default
+    yesterday := startOfDay(time.Now()).AddDate(0, 0, -1)
+    var from, to time.Time
+    if flags.NArg() == 2 {
+        timeRange, err := ResolveTimeRange(flags.Arg(1))
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+            return 1
+        }
+        from, to = timeRange.StartDate, timeRange.EndDate
+        if timeRange.SpecificDate {
+            to = from
+        }
+    } else {
+        to = yesterday
+    }
+
+    records, err := store.Load(domain, from, to)
+    if err != nil && !errors.Is(err, ErrEmptyStore) {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    if from.IsZero() {
+        if len(records) == 0 {
+            log.Printf("Error: %v for %s", ErrEmptyStore, domain)
+            return 1
+        }
+        from, _ = time.ParseInLocation(DateFormat, records[0].Date, time.Local)
+    }
+
+    report := FindGaps(records, from, to, *lowFactor)
+    fmt.Printf("Store of %s from %s to %s: %d of %d days stored, median %d hits per day\n",
+        domain, report.From, report.To, report.StoredDays, report.Days, report.MedianHits)
+    if len(report.Gaps) == 0 {
+        fmt.Println("No gaps")
+        return 0
+    }
+    for _, gap := range report.Gaps {
+        days := gap.Start
+        if gap.Days > 1 {
+            days += " to " + gap.End
+        }
+        detail := ""
+        if gap.Kind == GapLow {
+            detail = fmt.Sprintf(" (%d hits)", gap.Hits)
+        }
+        fmt.Printf("  %-26s %4d days  %s%s\n", days, gap.Days, gap.Kind, detail)
+    }
+
+    if !*backfill {
+        return 0
+    }
+    manifest := GapBackfillManifest(domain, report.Gaps, append([]string{"-store", "-store-dir", dir}, strings.Fields(*analysisFlags)...))
+    manifest.Parallel = max(1, *parallel)
+    base := filepath.Join(dir, strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(domain)+".gaps")
+    tracker, err := OpenBackfillTracker(base + ".status.json")
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    executable, err := os.Executable()
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    fmt.Printf("Backfilling %d days, %d at a time\n", len(manifest.Entries), manifest.Parallel)
+    failed, partial, err := RunBackfill(ctx, manifest, tracker, executable, base+".logs", manifest.Parallel)
+    if err != nil {
+        log.Printf("Backfill interrupted: %v", err)
+        return 1
+    }
+    if failed > 0 {
+        log.Printf("%d days failed; run gaps -backfill again to retry them", failed)
+        return 1
+    }
+    if partial > 0 {
+        log.Printf("%d days were only partly stored; run gaps -backfill again to complete them", partial)
+        return ExitPartialRange
+    }
+    fmt.Println("Backfill complete")
+    return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestFindGaps(t *testing.T) {
+    day := func(date string, hits int64) DayRecord { return DayRecord{Date: date, Hits: hits} }
+    records := []DayRecord{
+        day("2024-03-01", 1000),
+        day("2024-03-02", 900),
+        // 03 and 04 missing
+        day("2024-03-05", 50),
+        day("2024-03-06", 10),
+        day("2024-03-07", 1100),
+        // 08 missing
+    }
+    from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+    to := time.Date(2024, 3, 8, 0, 0, 0, 0, time.Local)
+
+    report := FindGaps(records, from, to, DefaultGapLowFactor)
+    want := []Gap{
+        {Start: "2024-03-03", End: "2024-03-04", Days: 2, Kind: GapMissing},
+        {Start: "2024-03-05", End: "2024-03-06", Days: 2, Kind: GapLow, Hits: 60},
+        {Start: "2024-03-08", End: "2024-03-08", Days: 1, Kind: GapMissing},
+    }
+    if !reflect.DeepEqual(report.Gaps, want) {
+        t.Errorf("gaps = %+v, want %+v", report.Gaps, want)
+    }
+    if report.Days != 8 || report.StoredDays != 5 || report.MedianHits != 900 {
+        t.Errorf("days %d, stored %d, median %d", report.Days, report.StoredDays, report.MedianHits)
+    }
+
+    if report := FindGaps(records, from, to, 0); len(report.Gaps) != 2 {
+        t.Errorf("without low days: %+v", report.Gaps)
+    }
+
+    manifest := GapBackfillManifest("uni", want[:1], []string{"-store"})
+    var ranges []string
+    for _, entry := range manifest.Entries {
+        ranges = append(ranges, entry.Range)
+    }
+    if !reflect.DeepEqual(ranges, []string{"03-03-2024", "04-03-2024"}) {
+        t.Errorf("backfill ranges = %v", ranges)
+    }
+}
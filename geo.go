@@ -0,0 +1,173 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// ProviderLocation is the position of a service provider
+type ProviderLocation struct {
+    Latitude  float64
+    Longitude float64
+}
+
+// ProviderMapping is the content of a -provider-locations file
+type ProviderMapping struct {
+    Countries CountryMap
+    Locations map[string]ProviderLocation
+}
+
+// ReadProviderMapping reads a CSV file with the columns provider, country,
+// latitude and longitude; a header row and empty country or coordinate
+// cells are allowed
+func ReadProviderMapping(path string) (ProviderMapping, error) {
+    mapping := ProviderMapping{Countries: make(CountryMap), Locations: make(map[string]ProviderLocation)}
+    file, err := os.Open(path)
+    if err != nil {
+        return mapping, fmt.Errorf("failed to open provider mapping: %w", err)
+    }
+    defer file.Close()
+
+    reader := csv.NewReader(file)
+    reader.FieldsPerRecord = -1
+    reader.Comment = '#'
+    for line := 1; ; line++ {
+        row, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            return mapping, nil
+        }
+        if err != nil {
+            return mapping, fmt.Errorf("error reading provider mapping: %w", err)
+        }
+        for len(row) < 4 {
+            row = append(row, "")
+        }
+        provider := strings.TrimSpace(row[0])
+        if provider == "" || (line == 1 && strings.EqualFold(provider, "provider")) {
+            continue
+        }
+        if country := strings.TrimSpace(row[1]); country != "" {
+            mapping.Countries[provider] = strings.ToUpper(country)
+        }
+        latitude, longitude := strings.TrimSpace(row[2]), strings.TrimSpace(row[3])
+        if latitude == "" && longitude == "" {
+            continue
+        }
+        var location ProviderLocation
+        location.Latitude, err = strconv.ParseFloat(latitude, 64)
+        if err == nil {
+            location.Longitude, err = strconv.ParseFloat(longitude, 64)
+        }
+        if err != nil || location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+            return mapping, fmt.Errorf("invalid coordinates for %s on line %d of %s", provider, line, path)
+        }
+        mapping.Locations[provider] = location
+    }
+}
+
+// geoFeature is a GeoJSON point feature of a visited provider
+type geoFeature struct {
+    Type     string `json:"type"`
+    Geometry struct {
+        Type        string     `json:"type"`
+        Coordinates [2]float64 `json:"coordinates"`
+    } `json:"geometry"`
+    Properties struct {
+        Provider string `json:"provider"`
+        Country  string `json:"country"`
+        Users    int    `json:"users"`
+        Hits     int64  `json:"hits"`
+    } `json:"properties"`
+}
+
+// ExportGeo writes the visited providers with a known location as a GeoJSON
+// FeatureCollection ("-providers.geojson") and as a CSV of latitude,
+// longitude and user count ("-providers-map.csv"). Providers without a
+// location in meta.Locations are left out.
+func ExportGeo(result *Result, meta ExportMeta) ([]string, error) {
+    result.mu.RLock()
+    providers := make([]string, 0, len(result.Providers))
+    for provider := range result.Providers {
+        if _, ok := meta.Locations[provider]; ok {
+            providers = append(providers, provider)
+        }
+    }
+    users := make(map[string]int, len(providers))
+    hits := make(map[string]int64, len(providers))
+    for _, provider := range providers {
+        users[provider] = result.Providers[provider].Users.Len()
+        hits[provider] = result.Providers[provider].Hits
+    }
+    unlocated := len(result.Providers) - len(providers)
+    result.mu.RUnlock()
+
+    sort.Slice(providers, func(i, j int) bool {
+        if users[providers[i]] != users[providers[j]] {
+            return users[providers[i]] > users[providers[j]]
+        }
+        return providers[i] < providers[j]
+    })
+
+    collection := struct {
+        Type     string       `json:"type"`
+        Features []geoFeature `json:"features"`
+    }{Type: "FeatureCollection", Features: make([]geoFeature, 0, len(providers))}
+    records := [][]string{{"Provider", "Country", "Latitude", "Longitude", "Users", "Hits"}}
+    for _, provider := range providers {
+        location := meta.Locations[provider]
+        var feature geoFeature
+        feature.Type = "Feature"
+        feature.Geometry.Type = "Point"
+        // GeoJSON positions are longitude first
+        feature.Geometry.Coordinates = [2]float64{location.Longitude, location.Latitude}
+        feature.Properties.Provider = provider
+        feature.Properties.Country = meta.Countries.Country(provider)
+        feature.Properties.Users = users[provider]
+        feature.Properties.Hits = hits[provider]
+        collection.Features = append(collection.Features, feature)
+
+        records = append(records, []string{
+            provider,
+            feature.Properties.Country,
+            strconv.FormatFloat(location.Latitude, 'f', -1, 64),
+            strconv.FormatFloat(location.Longitude, 'f', -1, 64),
+            strconv.Itoa(users[provider]),
+            strconv.FormatInt(hits[provider], 10),
+        })
+    }
+    if unlocated > 0 {
+        fmt.Printf("Map export: %d providers have no location and are left out\n", unlocated)
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+    baseFilename := filepath.Join(outputDir, OutputBaseFilename(meta))
+
+    data, err := json.MarshalIndent(collection, "", "  ")
+    if err != nil {
+        return nil, fmt.Errorf("error marshaling GeoJSON: %w", err)
+    }
+    geoFile := baseFilename + "-providers.geojson"
+    if err := os.WriteFile(geoFile, data, 0644); err != nil {
+        return nil, fmt.Errorf("error writing GeoJSON file: %w", err)
+    }
+    mapFile := baseFilename + "-providers-map.csv"
+    if err := writeCSVFile(mapFile, records); err != nil {
+        return nil, err
+    }
+    return []string{geoFile, mapFile}, nil
+}
+
+func init() {
+    RegisterExporter("geojson", ExporterFunc(ExportGeo))
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "net"
+    "strings"
+    "time"
+
+    "edutoam-idp/api"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+)
+
+const (
+    // DefaultGRPCAddr is the address the "serve" subcommand listens on
+    DefaultGRPCAddr = "localhost:9090"
+
+    // grpcShutdownTimeout bounds how long a stopping server waits for the
+    // running calls, e.g. analyses, before cancelling them
+    grpcShutdownTimeout = 30 * time.Second
+)
+
+// AnalysisServer implements the gRPC AnalysisService of api/eduroamidp.proto
+// over RunAnalysis, the run history and a progress broker
+type AnalysisServer struct {
+    api.UnimplementedAnalysisServiceServer
+
+    client    *HTTPClient
+    history   *RunHistory
+    broker    *ProgressBroker
+    outputDir string
+    workers   int
+}
+
+// NewAnalysisServer creates the service analysing with client. Analyses
+// write their output under outputDir, are recorded in history and publish
+// their progress to broker.
+func NewAnalysisServer(client *HTTPClient, history *RunHistory, broker *ProgressBroker, outputDir string, workers int) *AnalysisServer {
+    return &AnalysisServer{
+        client:    client,
+        history:   history,
+        broker:    broker,
+        outputDir: outputDir,
+        workers:   workers,
+    }
+}
+
+// Analyze runs the default analysis of a domain as the command line does
+// without flags besides those of the request, exports it and records it in
+// the run history
+func (s *AnalysisServer) Analyze(ctx context.Context, req *api.AnalyzeRequest) (*api.Run, error) {
+    if req.GetDomain() == "" {
+        return nil, status.Error(codes.InvalidArgument, "domain is required")
+    }
+    props := s.client.properties()
+    realm := GetDomain(req.GetDomain(), props.Aliases, req.GetNoPrefix())
+    if err := ValidateDomain(realm); err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    timeRange, err := ResolveTimeRange(req.GetRange())
+    if err != nil {
+        return nil, status.Errorf(codes.InvalidArgument, "invalid range: %v", err)
+    }
+    messageType, err := ParseMessageType(req.GetMessageType())
+    if err != nil {
+        return nil, status.Error(codes.InvalidArgument, err.Error())
+    }
+    formats := []string{DefaultOutputFormat}
+    if len(req.GetFormats()) > 0 {
+        if formats, err = ParseFormats(strings.Join(req.GetFormats(), ",")); err != nil {
+            return nil, status.Error(codes.InvalidArgument, err.Error())
+        }
+    }
+    exclusions, err := ParseExclusions(props.Exclusions)
+    if err != nil {
+        return nil, status.Errorf(codes.FailedPrecondition, "error reading properties: %v", err)
+    }
+
+    query := map[string]interface{}{
+        "query":           BuildRealmQuery(messageType, RealmVariants([]string{realm}), exclusions),
+        "start_timestamp": timeRange.StartDate.Unix(),
+        "end_timestamp":   timeRange.EndDate.Unix(),
+        "max_hits":        10000,
+    }
+    config := Config{
+        Domain:          req.GetDomain(),
+        OutputFormat:    strings.Join(formats, ","),
+        NumWorkers:      s.workers,
+        TimeRange:       timeRange,
+        Query:           QueryOptions{Pivot: PivotIdP, Exclusions: exclusions},
+        SingleQueryDays: DefaultSingleQueryDays,
+        MaxClockSkew:    DefaultMaxClockSkew,
+        JobOrder:        DefaultJobOrder,
+        Processors:      DefaultProcessors,
+        ResultBufferMax: DefaultResultBufferMax,
+        DayVisits:       NeedsDayVisits(formats, nil, PivotIdP, false, false, false),
+        Quiet:           true,
+    }
+
+    started := time.Now()
+    result, err := RunAnalysis(ctx, config, s.client, query, s.broker)
+    if err != nil {
+        return nil, grpcError(err)
+    }
+
+    meta := ExportMeta{
+        Domain:           req.GetDomain(),
+        TimeRange:        timeRange,
+        RunTime:          started,
+        OutputDir:        s.outputDir,
+        Partial:          result.Partial,
+        MessageType:      messageType,
+        OutlierFactor:    DefaultOutlierFactor,
+        MultiProviderMin: DefaultMultiProviderMin,
+        HomeCountry:      props.Countries.Country(realm),
+        Countries:        props.Countries,
+        Environment:      props.Env,
+        Population:       props.Headcount(req.GetDomain(), realm),
+    }
+    files, err := RunExporters(formats, result, meta)
+    if err != nil {
+        return nil, grpcError(err)
+    }
+    record := NewRunRecord(started.Format("20060102-150405"), req.GetRange(), result, meta, started, files)
+    if record.ID, err = s.history.Append(record); err != nil {
+        return nil, grpcError(err)
+    }
+    return runMessage(record), nil
+}
+
+// GetRun returns a run of the run history by ID
+func (s *AnalysisServer) GetRun(ctx context.Context, req *api.GetRunRequest) (*api.Run, error) {
+    runs, err := s.history.Runs("")
+    if err != nil {
+        return nil, grpcError(err)
+    }
+    for _, record := range runs {
+        if record.ID == req.GetId() {
+            return runMessage(record), nil
+        }
+    }
+    return nil, grpcError(fmt.Errorf("%w %q", ErrUnknownRun, req.GetId()))
+}
+
+// ListRuns returns the most recent runs, of one domain if requested, oldest
+// first as "runs list" prints them
+func (s *AnalysisServer) ListRuns(ctx context.Context, req *api.ListRunsRequest) (*api.ListRunsResponse, error) {
+    runs, err := s.history.Runs(req.GetDomain())
+    if err != nil {
+        return nil, grpcError(err)
+    }
+    limit := int(req.GetLimit())
+    if limit == 0 {
+        limit = DefaultRunsListLimit
+    }
+    if limit > 0 && len(runs) > limit {
+        runs = runs[len(runs)-limit:]
+    }
+    response := &api.ListRunsResponse{Runs: make([]*api.Run, 0, len(runs))}
+    for _, record := range runs {
+        response.Runs = append(response.Runs, runMessage(record))
+    }
+    return response, nil
+}
+
+// StreamProgress sends the progress events of the broker, of one domain if
+// requested, until the call ends or the broker is closed. Like the /events
+// stream, a slow client misses intermediate events.
+func (s *AnalysisServer) StreamProgress(req *api.StreamProgressRequest, stream grpc.ServerStreamingServer[api.ProgressEvent]) error {
+    events, unsubscribe := s.broker.Subscribe()
+    defer unsubscribe()
+
+    for {
+        select {
+        case event, ok := <-events:
+            if !ok {
+                return nil
+            }
+            if req.GetDomain() != "" && event.Domain != req.GetDomain() {
+                continue
+            }
+            if err := stream.Send(progressMessage(event)); err != nil {
+                return err
+            }
+        case <-stream.Context().Done():
+            return stream.Context().Err()
+        }
+    }
+}
+
+// runMessage converts a run record to its API message
+func runMessage(record RunRecord) *api.Run {
+    return &api.Run{
+        Id:           record.ID,
+        Domain:       record.Domain,
+        Range:        record.Range,
+        StartDate:    record.StartDate,
+        EndDate:      record.EndDate,
+        Days:         int32(record.Days),
+        Started:      record.Started,
+        DurationMs:   record.DurationMs,
+        TotalHits:    record.TotalHits,
+        Users:        int32(record.Users),
+        Providers:    int32(record.Providers),
+        Partial:      record.Partial,
+        Environment:  record.Environment,
+        PeakRssBytes: record.PeakRSSBytes,
+        Version:      record.Version,
+        Files:        record.Files,
+    }
+}
+
+// progressMessage converts a progress event to its API message
+func progressMessage(event ProgressEvent) *api.ProgressEvent {
+    return &api.ProgressEvent{
+        Type:          event.Type,
+        Domain:        event.Domain,
+        ProcessedDays: int32(event.ProcessedDays),
+        TotalDays:     int32(event.TotalDays),
+        TotalHits:     event.TotalHits,
+        InFlight:      int32(event.InFlight),
+        Percent:       event.Percent,
+        Elapsed:       event.Elapsed,
+        Message:       event.Message,
+        ErrorClass:    event.ErrorClass,
+        Time:          event.Time,
+    }
+}
+
+// grpcError returns err as a gRPC status, its code chosen by the class of
+// the error as ExitCode chooses the exit status
+func grpcError(err error) error {
+    code := codes.Internal
+    switch {
+    case errors.Is(err, context.Canceled), errors.Is(err, ErrPartialRange):
+        code = codes.Canceled
+    case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrTimeout):
+        code = codes.DeadlineExceeded
+    case errors.Is(err, ErrUnknownRun):
+        code = codes.NotFound
+    case errors.Is(err, ErrOutputExists):
+        code = codes.AlreadyExists
+    case errors.Is(err, ErrAuth), errors.Is(err, ErrIndexNotFound), errors.Is(err, ErrMissingConfiguration):
+        code = codes.FailedPrecondition
+    case errors.Is(err, ErrSearcherUnavailable):
+        code = codes.Unavailable
+    }
+    return status.Errorf(code, "%v (%s)", err, ErrorFields(err))
+}
+
+// ServeGRPC serves the AnalysisService on listener until ctx is cancelled.
+// The broker is closed first so that open progress streams end; running
+// analyses are given grpcShutdownTimeout to finish.
+func ServeGRPC(ctx context.Context, listener net.Listener, service *AnalysisServer) error {
+    server := grpc.NewServer()
+    api.RegisterAnalysisServiceServer(server, service)
+
+    // A failing listener stops the server like a cancelled ctx
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    stopped := make(chan struct{})
+    go func() {
+        defer close(stopped)
+        <-ctx.Done()
+        service.broker.Close()
+        graceful := make(chan struct{})
+        go func() {
+            server.GracefulStop()
+            close(graceful)
+        }()
+        select {
+        case <-graceful:
+        case <-time.After(grpcShutdownTimeout):
+            server.Stop()
+        }
+    }()
+
+    err := server.Serve(listener)
+    cancel()
+    <-stopped
+    return err
+}
+
+// runServe implements the "serve" subcommand
+func runServe(args []string) int {
+    flags := flag.NewFlagSet("serve", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* Quickwit environment of the configuration file (default: DEFAULT_ENV)")
+    listenAddr := flags.String("listen", DefaultGRPCAddr, "Address the gRPC service listens on")
+    outputDir := flags.String("output-dir", "", "Base directory for output files (default: ./"+OutputDirBase+" if it exists, else the user data dir)")
+    storeDir := flags.String("store-dir", "", "Directory of the local store holding the run history (default: the user data dir)")
+    numWorkers := flags.Int("workers", 0, "Number of worker goroutines per analysis (overrides environment variable)")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp serve [flags]")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 0 {
+        flags.Usage()
+        return 1
+    }
+
+    client, err := LoadClient(*configFile, *profile, *env)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    history, err := OpenRunHistory(ResolveStoreDir(*storeDir))
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    workers := GetNumWorkers()
+    if *numWorkers > 0 {
+        workers = *numWorkers
+    }
+
+    listener, err := net.Listen("tcp", *listenAddr)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    ctx, stop := commandContext()
+    defer stop()
+
+    fmt.Printf("Serving the gRPC API on %s\n", listener.Addr())
+    service := NewAnalysisServer(client, history, NewProgressBroker(), ResolveOutputDir(*outputDir), workers)
+    if err := ServeGRPC(ctx, listener, service); err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "os"
+    "testing"
+    "time"
+
+    "edutoam-idp/api"
+
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/credentials/insecure"
+    "google.golang.org/grpc/status"
+    "google.golang.org/grpc/test/bufconn"
+)
+
+func TestAnalysisServer(t *testing.T) {
+    history, err := OpenRunHistory(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    service := NewAnalysisServer(newTestClient(&fakeQuickwit{events: testEvents(t)}), history, NewProgressBroker(), t.TempDir(), 4)
+
+    listener := bufconn.Listen(1 << 20)
+    ctx, stop := context.WithCancel(context.Background())
+    defer stop()
+    served := make(chan error, 1)
+    go func() { served <- ServeGRPC(ctx, listener, service) }()
+
+    conn, err := grpc.NewClient("passthrough:///bufconn",
+        grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+        grpc.WithTransportCredentials(insecure.NewCredentials()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    client := api.NewAnalysisServiceClient(conn)
+    callCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+    defer cancel()
+
+    stream, err := client.StreamProgress(callCtx, &api.StreamProgressRequest{Domain: "uni.example"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    run, err := client.Analyze(callCtx, &api.AnalyzeRequest{Domain: "uni.example", NoPrefix: true, Range: "y2024"})
+    if err != nil {
+        t.Fatalf("Analyze: %v", err)
+    }
+    if run.TotalHits != 6 || run.Users != 3 || run.Providers != 3 || run.Range != "y2024" {
+        t.Errorf("Analyze = %+v, want the 6 hits of 3 users at 3 providers", run)
+    }
+    if len(run.Files) != 1 {
+        t.Fatalf("run files = %v, want one JSON output", run.Files)
+    }
+    if _, err := os.Stat(run.Files[0]); err != nil {
+        t.Errorf("output of the run: %v", err)
+    }
+
+    // The latest event is sent on subscription, so the stream ends on the
+    // done event of the run even if it subscribed late
+    for {
+        event, err := stream.Recv()
+        if err != nil {
+            t.Fatalf("StreamProgress: %v", err)
+        }
+        if event.Domain != "uni.example" {
+            t.Errorf("event of domain %q", event.Domain)
+        }
+        if event.Type == ProgressDone {
+            break
+        }
+    }
+
+    got, err := client.GetRun(callCtx, &api.GetRunRequest{Id: run.Id})
+    if err != nil || got.Id != run.Id || got.TotalHits != run.TotalHits {
+        t.Errorf("GetRun = %+v, %v, want run %s", got, err, run.Id)
+    }
+    if _, err := client.GetRun(callCtx, &api.GetRunRequest{Id: "19700101-000000"}); status.Code(err) != codes.NotFound {
+        t.Errorf("GetRun of an unknown run: %v, want NotFound", err)
+    }
+    if list, err := client.ListRuns(callCtx, &api.ListRunsRequest{}); err != nil || len(list.Runs) != 1 || list.Runs[0].Id != run.Id {
+        t.Errorf("ListRuns = %v, %v, want run %s", list, err, run.Id)
+    }
+    if list, err := client.ListRuns(callCtx, &api.ListRunsRequest{Domain: "other.example"}); err != nil || len(list.Runs) != 0 {
+        t.Errorf("ListRuns of another domain = %v, %v, want none", list, err)
+    }
+    for _, req := range []*api.AnalyzeRequest{
+        {},
+        {Domain: "uni.example", NoPrefix: true, Range: "someday"},
+        {Domain: "uni.example", NoPrefix: true, Formats: []string{"nope"}},
+    } {
+        if _, err := client.Analyze(callCtx, req); status.Code(err) != codes.InvalidArgument {
+            t.Errorf("Analyze(%v): %v, want InvalidArgument", req, err)
+        }
+    }
+
+    // Stopping the server ends open progress streams
+    open, err := client.StreamProgress(callCtx, &api.StreamProgressRequest{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := open.Recv(); err != nil {
+        t.Fatal(err)
+    }
+    stop()
+    if _, err := open.Recv(); !errors.Is(err, io.EOF) {
+        t.Errorf("stream after shutdown: %v, want EOF", err)
+    }
+    select {
+    case err := <-served:
+        if err != nil {
+            t.Errorf("ServeGRPC: %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("ServeGRPC did not return after the context was cancelled")
+    }
+}
+
+func TestGRPCErrorCodes(t *testing.T) {
+    tests := []struct {
+        err  error
+        code codes.Code
+    }{
+        {ErrAuth, codes.FailedPrecondition},
+        {ErrUnknownRun, codes.NotFound},
+        {ErrOutputExists, codes.AlreadyExists},
+        {context.Canceled, codes.Canceled},
+        {errors.Join(ErrSearcherUnavailable, ErrTimeout), codes.DeadlineExceeded},
+        {ErrSearcherUnavailable, codes.Unavailable},
+        {errors.New("boom"), codes.Internal},
+    }
+    for _, tt := range tests {
+        if code := status.Code(grpcError(tt.err)); code != tt.code {
+            t.Errorf("grpcError(%v) code = %v, want %v", tt.err, code, tt.code)
+        }
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "sync"
+    "time"
+)
+
+const (
+    // ReadinessCacheTTL is how long a readiness check result is reused
+    ReadinessCacheTTL = 10 * time.Second
+
+    // ReadinessTimeout bounds a single readiness check
+    ReadinessTimeout = 5 * time.Second
+)
+
+// ReadinessCheck reports whether the program can serve its purpose
+type ReadinessCheck func(ctx context.Context) error
+
+// Ping checks that the configuration is complete and that Quickwit answers
+// its readiness endpoint with the configured credentials. With several
+// QW_URLs one ready searcher is enough.
+func (c *HTTPClient) Ping(ctx context.Context) error {
+    props := c.properties()
+    urls := props.URLs()
+    if props.QWUser == "" || props.QWPass == "" || len(urls) == 0 {
+        return ErrMissingConfiguration
+    }
+
+    var err error
+    for _, url := range urls {
+        if err = c.ping(ctx, props, url); err == nil {
+            return nil
+        }
+    }
+    return err
+}
+
+// ping checks the readiness endpoint of the searcher at baseURL
+func (c *HTTPClient) ping(ctx context.Context, props Properties, baseURL string) error {
+    req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/health/readyz", nil)
+    if err != nil {
+        return fmt.Errorf("error creating request: %w", err)
+    }
+    req.SetBasicAuth(props.QWUser, props.QWPass)
+
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("quickwit %s unreachable: %w", baseURL, err)
+    }
+    defer resp.Body.Close()
+    io.Copy(io.Discard, resp.Body)
+
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("quickwit %s not ready (status %d)", baseURL, resp.StatusCode)
+    }
+    return nil
+}
+
+// CachedReadiness wraps check so that its result is reused for ttl, keeping
+// frequent probes from turning into a stream of Quickwit requests
+func CachedReadiness(check ReadinessCheck, ttl time.Duration) ReadinessCheck {
+    var (
+        mu      sync.Mutex
+        checked time.Time
+        last    error
+    )
+    return func(ctx context.Context) error {
+        mu.Lock()
+        defer mu.Unlock()
+        if !checked.IsZero() && time.Since(checked) < ttl {
+            return last
+        }
+        checkCtx, cancel := context.WithTimeout(ctx, ReadinessTimeout)
+        defer cancel()
+        last = check(checkCtx)
+        checked = time.Now()
+        return last
+    }
+}
+
+// serveHealthz answers liveness probes: the process is up and serving
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/plain")
+    fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler answers readiness probes with 200 when ready reports no
+// error and 503 with the reason otherwise
+func readyzHandler(ready ReadinessCheck) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/plain")
+        if ready != nil {
+            if err := ready(r.Context()); err != nil {
+                w.WriteHeader(http.StatusServiceUnavailable)
+                fmt.Fprintf(w, "not ready: %v\n", err)
+                return
+            }
+        }
+        fmt.Fprintln(w, "ok")
+    }
+}
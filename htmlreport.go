@@ -0,0 +1,234 @@
+package main
+
+import (
+    "fmt"
+    "html/template"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// DefaultHeatStripProviders is the number of providers, by users, with a
+// heat strip in the HTML report
+const DefaultHeatStripProviders = 10
+
+// heatCellSize is the pitch of the day cells of a heat strip in pixels
+const heatCellSize = 12
+
+// heatColors are the fills of the activity levels, from no users to most
+var heatColors = []string{"#ebedf0", "#c6e48b", "#7bc96f", "#239a3b", "#196127"}
+
+// heatCell is one day of a heat strip, positioned by week (column) and
+// weekday (row)
+type heatCell struct {
+    X     int
+    Y     int
+    Fill  string
+    Title string
+}
+
+// heatStrip is the per-day activity of one provider (or of all of them)
+type heatStrip struct {
+    Title      string
+    Users      int
+    ActiveDays int
+    Width      int
+    Height     int
+    Cells      []heatCell
+}
+
+// heatDay is the activity of one day
+type heatDay struct {
+    Users int
+    Hits  int64
+}
+
+// newHeatStrip lays out the days of timeRange as weeks of seven cells,
+// shading each by its users relative to the busiest day of the strip
+func newHeatStrip(title string, users int, timeRange TimeRange, days map[string]heatDay) heatStrip {
+    strip := heatStrip{Title: title, Users: users, Height: 7 * heatCellSize}
+    maxUsers := 0
+    for _, day := range days {
+        maxUsers = max(maxUsers, day.Users)
+    }
+
+    first := startOfDay(timeRange.StartDate)
+    last := startOfDay(timeRange.EndDate)
+    offset := int(first.Weekday())
+    i := 0
+    for date := first; !date.After(last); date = date.AddDate(0, 0, 1) {
+        key := date.Format(DateFormat)
+        day := days[key]
+        level := 0
+        if day.Users > 0 {
+            strip.ActiveDays++
+            level = (day.Users*(len(heatColors)-1) + maxUsers - 1) / maxUsers
+        }
+        slot := i + offset
+        strip.Cells = append(strip.Cells, heatCell{
+            X:     slot / 7 * heatCellSize,
+            Y:     slot % 7 * heatCellSize,
+            Fill:  heatColors[level],
+            Title: fmt.Sprintf("%s %s: %d users, %d hits", key, date.Weekday().String()[:3], day.Users, day.Hits),
+        })
+        i++
+    }
+    strip.Width = (i + offset + 6) / 7 * heatCellSize
+    return strip
+}
+
+// HeatStrips returns the strip of all providers followed by those of the
+// top providers by users. It returns nil without per-day data, and only
+// the first strip without per-provider days (an output saved without
+// -provider-timeseries).
+func HeatStrips(result *Result, timeRange TimeRange, top int) []heatStrip {
+    if top <= 0 {
+        top = DefaultHeatStripProviders
+    }
+    daily := DailyStats(result)
+    if len(daily) == 0 {
+        return nil
+    }
+    all := make(map[string]heatDay, len(daily))
+    for _, day := range daily {
+        all[day.Date] = heatDay{Users: day.UniqueUsers, Hits: day.Hits}
+    }
+
+    result.mu.RLock()
+    userCount := len(result.Users)
+    providers := make([]string, 0, len(result.Providers))
+    providerUsers := make(map[string]int, len(result.Providers))
+    for name, stats := range result.Providers {
+        providers = append(providers, name)
+        providerUsers[name] = stats.Users.Len()
+    }
+    result.mu.RUnlock()
+    sort.Slice(providers, func(i, j int) bool {
+        if providerUsers[providers[i]] != providerUsers[providers[j]] {
+            return providerUsers[providers[i]] > providerUsers[providers[j]]
+        }
+        return providers[i] < providers[j]
+    })
+    if len(providers) > top {
+        providers = providers[:top]
+    }
+
+    series := make(map[string]map[string]heatDay)
+    for _, provider := range ProviderDailySeries(result) {
+        days := make(map[string]heatDay, len(provider.Daily))
+        for _, day := range provider.Daily {
+            days[day.Date] = heatDay{Users: day.Users, Hits: day.Hits}
+        }
+        series[provider.Provider] = days
+    }
+
+    strips := []heatStrip{newHeatStrip("All providers", userCount, timeRange, all)}
+    if len(series) == 0 {
+        return strips
+    }
+    for _, provider := range providers {
+        strips = append(strips, newHeatStrip(provider, providerUsers[provider], timeRange, series[provider]))
+    }
+    return strips
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Domain}} {{.From}} to {{.To}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+h3 { font-size: 1em; margin: 1.2em 0 0.3em; }
+.legend rect, .strip rect { stroke: #fff; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1>{{.Domain}}: {{.From}} to {{.To}}</h1>
+<p>{{if .Generated}}Generated {{.Generated}} by {{else}}Generated by {{end}}eduroam-idp {{.Version}}{{if .Partial}}; partial result{{end}}.</p>
+<table>
+<tr><th>Days</th><th>Users</th><th>Providers</th><th>Hits</th></tr>
+<tr><td>{{.Days}}</td><td>{{.Users}}</td><td>{{.Providers}}</td><td>{{.Hits}}</td></tr>
+</table>
+<h2>Daily activity</h2>
+{{if .Strips}}<p>Each cell is one day, a column per week from Sunday to Saturday, shaded by users relative to the busiest day of the strip:
+<svg class="legend" width="{{.LegendWidth}}" height="12">{{range $i, $color := .Colors}}<rect x="{{$.LegendX $i}}" y="0" width="12" height="12" fill="{{$color}}"/>{{end}}</svg>
+less to more</p>
+{{range .Strips}}<h3>{{.Title}}: {{.Users}} users, active on {{.ActiveDays}} days</h3>
+<svg class="strip" width="{{.Width}}" height="{{.Height}}">
+{{range .Cells}}<rect x="{{.X}}" y="{{.Y}}" width="12" height="12" fill="{{.Fill}}"><title>{{.Title}}</title></rect>
+{{end}}</svg>
+{{end}}{{else}}<p>No per-day data in this result.</p>
+{{end}}</body>
+</html>
+`))
+
+// htmlReport is the data of htmlReportTemplate
+type htmlReport struct {
+    Domain    string
+    From      string
+    To        string
+    Generated string
+    Version   string
+    Partial   bool
+    Days      int
+    Users     int
+    Providers int
+    Hits      int64
+    Strips    []heatStrip
+    Colors    []string
+}
+
+// LegendWidth is the width of the color legend
+func (r htmlReport) LegendWidth() int {
+    return len(r.Colors) * heatCellSize
+}
+
+// LegendX is the position of the i-th legend color
+func (r htmlReport) LegendX(i int) int {
+    return i * heatCellSize
+}
+
+// ExportHTML writes a self-contained HTML report with the run totals and a
+// calendar heat strip per top provider, so gaps in service stand out
+func ExportHTML(result *Result, meta ExportMeta) ([]string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+    report := htmlReport{
+        Domain:  RealmToUnicode(meta.Domain),
+        From:    meta.TimeRange.StartDate.Format(DateFormat),
+        To:      meta.TimeRange.EndDate.Format(DateFormat),
+        Version: Version,
+        Partial: meta.Partial,
+        Days:    meta.TimeRange.Days,
+        Strips:  HeatStrips(result, meta.TimeRange, meta.HeatStripProviders),
+        Colors:  heatColors,
+    }
+    if !meta.Deterministic {
+        report.Generated = time.Now().Format(DateTimeFormat)
+    }
+    result.mu.RLock()
+    report.Users, report.Providers, report.Hits = len(result.Users), len(result.Providers), result.TotalHits
+    result.mu.RUnlock()
+
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+".html")
+    file, err := os.Create(filename)
+    if err != nil {
+        return nil, fmt.Errorf("error creating HTML file: %w", err)
+    }
+    defer file.Close()
+    if err := htmlReportTemplate.Execute(file, report); err != nil {
+        return nil, fmt.Errorf("error writing HTML file: %w", err)
+    }
+    return []string{filename}, nil
+}
+
+func init() {
+    RegisterExporter("html", ExporterFunc(ExportHTML))
+}
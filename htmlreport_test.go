@@ -0,0 +1,54 @@
+package main
+
+import (
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestNewHeatStrip(t *testing.T) {
+    // testRange(t, 3) runs from Wednesday 13 to Friday 15 March 2024
+    timeRange := testRange(t, 3)
+    strip := newHeatStrip("sp1.example.org", 4, timeRange, map[string]heatDay{
+        "2024-03-13": {Users: 4, Hits: 10},
+        "2024-03-15": {Users: 1, Hits: 1},
+    })
+
+    if len(strip.Cells) != 3 || strip.ActiveDays != 2 || strip.Width != heatCellSize {
+        t.Fatalf("strip: %+v", strip)
+    }
+    wednesday, thursday, friday := strip.Cells[0], strip.Cells[1], strip.Cells[2]
+    if wednesday.X != 0 || wednesday.Y != 3*heatCellSize || friday.Y != 5*heatCellSize {
+        t.Errorf("cell positions: %+v", strip.Cells)
+    }
+    if wednesday.Fill != heatColors[len(heatColors)-1] || thursday.Fill != heatColors[0] || friday.Fill != heatColors[1] {
+        t.Errorf("cell fills: %+v", strip.Cells)
+    }
+    if !strings.HasPrefix(thursday.Title, "2024-03-14 Thu: 0 users") {
+        t.Errorf("cell title %q", thursday.Title)
+    }
+}
+
+func TestExportHTML(t *testing.T) {
+    timeRange := testRange(t, 3)
+    result := baselineResult(timeRange.StartDate, 3, 30, "alice", "bob")
+    for _, day := range result.Days {
+        day.ProviderHits = map[string]int64{"sp1.example.org": 10}
+    }
+    result.Providers["sp1.example.org"].Users = NewNameSet("alice", "bob")
+
+    files, err := ExportHTML(result, ExportMeta{Domain: "uni.example", TimeRange: timeRange, OutputDir: t.TempDir(), Deterministic: true})
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := os.ReadFile(files[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    page := string(data)
+    for _, want := range []string{"<h3>All providers: 2 users, active on 3 days</h3>", "<h3>sp1.example.org: 2 users, active on 3 days</h3>", "Generated by eduroam-idp"} {
+        if !strings.Contains(page, want) {
+            t.Errorf("page misses %q", want)
+        }
+    }
+}
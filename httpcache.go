@@ -0,0 +1,131 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// HTTPCacheDirName is the directory of the response cache under the OS
+// cache dir
+const HTTPCacheDirName = "http"
+
+// ResponseCache keeps Quickwit search responses on disk, keyed by a hash of
+// the searchers, user, profile, environment, index and request body, so
+// that immediate re-runs do not query the cluster again. Entries older than
+// the TTL are ignored and overwritten, and removed when the cache is opened
+// as they hold raw responses including usernames.
+type ResponseCache struct {
+    dir string
+    ttl time.Duration
+}
+
+// DefaultHTTPCacheDir returns the response cache directory under the OS cache
+// dir ($XDG_CACHE_HOME or ~/.cache on Unix)
+func DefaultHTTPCacheDir() (string, error) {
+    dir, err := os.UserCacheDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(dir, AppDirName, HTTPCacheDirName), nil
+}
+
+// NewResponseCache creates the cache directory, removes expired entries and
+// returns a cache whose entries are valid for ttl
+func NewResponseCache(dir string, ttl time.Duration) (*ResponseCache, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("error creating HTTP cache directory: %w", err)
+    }
+    c := &ResponseCache{dir: dir, ttl: ttl}
+    if err := c.prune(); err != nil {
+        log.Printf("Error removing expired HTTP cache entries: %v", err)
+    }
+    return c, nil
+}
+
+// prune removes the entries, and files left by interrupted writes, that are
+// older than the TTL
+func (c *ResponseCache) prune() error {
+    entries, err := os.ReadDir(c.dir)
+    if err != nil {
+        return err
+    }
+    var errs []error
+    for _, entry := range entries {
+        name := entry.Name()
+        if entry.IsDir() || !(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.tmp")) {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil || time.Since(info.ModTime()) <= c.ttl {
+            continue
+        }
+        if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+            errs = append(errs, err)
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// path returns the cache file of a request. The searcher URLs are sorted, so
+// failover and round-robin order share the entries of one cluster.
+func (c *ResponseCache) path(props Properties, body []byte) string {
+    urls := props.URLs()
+    slices.Sort(urls)
+    hash := sha256.New()
+    for _, part := range []string{strings.Join(urls, ","), props.QWUser, props.Profile, props.Env, props.Index} {
+        hash.Write([]byte(part + "\n"))
+    }
+    hash.Write(body)
+    return filepath.Join(c.dir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// Get returns the cached response of a request, if fresh
+func (c *ResponseCache) Get(props Properties, body []byte) (*SearchResponse, bool) {
+    if c == nil {
+        return nil, false
+    }
+    path := c.path(props, body)
+    info, err := os.Stat(path)
+    if err != nil || time.Since(info.ModTime()) > c.ttl {
+        return nil, false
+    }
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, false
+    }
+    var result *SearchResponse
+    if err := json.Unmarshal(data, &result); err != nil || result == nil {
+        return nil, false
+    }
+    return result, true
+}
+
+// Put stores the response of a request. Failures only cost a later cache
+// miss, so they are returned for logging.
+func (c *ResponseCache) Put(props Properties, body []byte, result *SearchResponse) error {
+    if c == nil {
+        return nil
+    }
+    data, err := json.Marshal(result)
+    if err != nil {
+        return fmt.Errorf("error encoding cached response: %w", err)
+    }
+    path := c.path(props, body)
+    tmp := path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0600); err != nil {
+        return fmt.Errorf("error writing cached response: %w", err)
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        return fmt.Errorf("error writing cached response: %w", err)
+    }
+    return nil
+}
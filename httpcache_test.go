@@ -0,0 +1,67 @@
+package main
+
+import (
+    "os"
+    "testing"
+    "time"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+    cache, err := NewResponseCache(t.TempDir(), time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    props := Properties{QWURL: "http://a.example,http://b.example", QWUser: "user", Index: DefaultIndex}
+    body := []byte(`{"query":"*"}`)
+    if err := cache.Put(props, body, &SearchResponse{NumHits: 1}); err != nil {
+        t.Fatal(err)
+    }
+
+    reordered := props
+    reordered.QWURL = "http://b.example/, http://a.example"
+    if _, ok := cache.Get(reordered, body); !ok {
+        t.Error("reordered searchers missed the cache")
+    }
+
+    for name, change := range map[string]func(*Properties){
+        "url":     func(p *Properties) { p.QWURL = "http://c.example" },
+        "user":    func(p *Properties) { p.QWUser = "other" },
+        "profile": func(p *Properties) { p.Profile = "staff" },
+        "env":     func(p *Properties) { p.Env = "staging" },
+        "index":   func(p *Properties) { p.Index = "other-logs" },
+    } {
+        other := props
+        change(&other)
+        if _, ok := cache.Get(other, body); ok {
+            t.Errorf("different %s hit the cache", name)
+        }
+    }
+}
+
+func TestResponseCachePrunesExpired(t *testing.T) {
+    dir := t.TempDir()
+    cache, err := NewResponseCache(dir, time.Hour)
+    if err != nil {
+        t.Fatal(err)
+    }
+    props := Properties{QWURL: "http://a.example", QWUser: "user", Index: DefaultIndex}
+    for _, body := range []string{"old", "new"} {
+        if err := cache.Put(props, []byte(body), &SearchResponse{NumHits: 1}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    old := time.Now().Add(-2 * time.Hour)
+    if err := os.Chtimes(cache.path(props, []byte("old")), old, old); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := NewResponseCache(dir, time.Hour); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := os.Stat(cache.path(props, []byte("old"))); !os.IsNotExist(err) {
+        t.Errorf("expired entry kept: %v", err)
+    }
+    if _, ok := cache.Get(props, []byte("new")); !ok {
+        t.Error("fresh entry removed")
+    }
+}
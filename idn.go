@@ -0,0 +1,223 @@
+package main
+
+import (
+    "errors"
+    "strings"
+    "unicode/utf8"
+)
+
+// ACEPrefix marks a punycode-encoded domain label
+const ACEPrefix = "xn--"
+
+// ErrInvalidPunycode indicates a malformed "xn--" label
+var ErrInvalidPunycode = errors.New("invalid punycode")
+
+// Punycode parameters (RFC 3492)
+const (
+    punyBase        = 36
+    punyTMin        = 1
+    punyTMax        = 26
+    punySkew        = 38
+    punyDamp        = 700
+    punyInitialBias = 72
+    punyInitialN    = 128
+)
+
+// RealmToASCII returns the punycode (ACE) form of a realm; labels with
+// non-ASCII characters are lowercased and encoded, others are kept as is
+func RealmToASCII(realm string) string {
+    labels := strings.Split(realm, ".")
+    for i, label := range labels {
+        if !isASCII(label) {
+            labels[i] = ACEPrefix + punycodeEncode(strings.ToLower(label))
+        }
+    }
+    return strings.Join(labels, ".")
+}
+
+// RealmToUnicode returns the Unicode form of a realm, decoding its "xn--"
+// labels. Labels that fail to decode are kept as is.
+func RealmToUnicode(realm string) string {
+    labels := strings.Split(realm, ".")
+    for i, label := range labels {
+        if len(label) > len(ACEPrefix) && strings.EqualFold(label[:len(ACEPrefix)], ACEPrefix) {
+            if decoded, err := punycodeDecode(strings.ToLower(label[len(ACEPrefix):])); err == nil {
+                labels[i] = decoded
+            }
+        }
+    }
+    return strings.Join(labels, ".")
+}
+
+// RealmVariants returns the realms together with their punycode and Unicode
+// forms, without duplicates, so that a query matches however the realm was
+// logged
+func RealmVariants(realms []string) []string {
+    seen := make(map[string]bool)
+    var variants []string
+    for _, realm := range realms {
+        for _, variant := range []string{realm, RealmToASCII(realm), RealmToUnicode(realm)} {
+            if !seen[variant] {
+                seen[variant] = true
+                variants = append(variants, variant)
+            }
+        }
+    }
+    return variants
+}
+
+// UnicodeRealms returns the distinct Unicode forms of the realms
+func UnicodeRealms(realms []string) []string {
+    seen := make(map[string]bool)
+    var unique []string
+    for _, realm := range realms {
+        if realm = RealmToUnicode(realm); !seen[realm] {
+            seen[realm] = true
+            unique = append(unique, realm)
+        }
+    }
+    return unique
+}
+
+// isASCII reports whether s only holds ASCII characters
+func isASCII(s string) bool {
+    for i := 0; i < len(s); i++ {
+        if s[i] >= utf8.RuneSelf {
+            return false
+        }
+    }
+    return true
+}
+
+// punyAdapt is the bias adaptation function of RFC 3492
+func punyAdapt(delta, numPoints int, first bool) int {
+    if first {
+        delta /= punyDamp
+    } else {
+        delta /= 2
+    }
+    delta += delta / numPoints
+    k := 0
+    for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+        delta /= punyBase - punyTMin
+        k += punyBase
+    }
+    return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+// punyThreshold returns the threshold t for position k
+func punyThreshold(k, bias int) int {
+    switch {
+    case k <= bias:
+        return punyTMin
+    case k >= bias+punyTMax:
+        return punyTMax
+    }
+    return k - bias
+}
+
+// punyDigit encodes a digit value (0-35) as a-z0-9
+func punyDigit(d int) byte {
+    if d < 26 {
+        return byte('a' + d)
+    }
+    return byte('0' + d - 26)
+}
+
+// punycodeEncode encodes a label without the ACE prefix
+func punycodeEncode(label string) string {
+    input := []rune(label)
+    var output strings.Builder
+    for _, r := range input {
+        if r < utf8.RuneSelf {
+            output.WriteRune(r)
+        }
+    }
+    basic := output.Len()
+    handled := basic
+    if basic > 0 {
+        output.WriteByte('-')
+    }
+
+    n, delta, bias := punyInitialN, 0, punyInitialBias
+    for handled < len(input) {
+        m := int(^uint(0) >> 1)
+        for _, r := range input {
+            if int(r) >= n && int(r) < m {
+                m = int(r)
+            }
+        }
+        delta += (m - n) * (handled + 1)
+        n = m
+        for _, r := range input {
+            if int(r) < n {
+                delta++
+            }
+            if int(r) != n {
+                continue
+            }
+            q := delta
+            for k := punyBase; ; k += punyBase {
+                t := punyThreshold(k, bias)
+                if q < t {
+                    break
+                }
+                output.WriteByte(punyDigit(t + (q-t)%(punyBase-t)))
+                q = (q - t) / (punyBase - t)
+            }
+            output.WriteByte(punyDigit(q))
+            bias = punyAdapt(delta, handled+1, handled == basic)
+            delta = 0
+            handled++
+        }
+        delta++
+        n++
+    }
+    return output.String()
+}
+
+// punycodeDecode decodes a label without the ACE prefix
+func punycodeDecode(encoded string) (string, error) {
+    var output []rune
+    pos := 0
+    if b := strings.LastIndexByte(encoded, '-'); b >= 0 {
+        output = []rune(encoded[:b])
+        pos = b + 1
+    }
+
+    n, i, bias := punyInitialN, 0, punyInitialBias
+    for pos < len(encoded) {
+        oldI, w := i, 1
+        for k := punyBase; ; k += punyBase {
+            if pos >= len(encoded) {
+                return "", ErrInvalidPunycode
+            }
+            c := encoded[pos]
+            pos++
+            var digit int
+            switch {
+            case c >= 'a' && c <= 'z':
+                digit = int(c - 'a')
+            case c >= '0' && c <= '9':
+                digit = int(c-'0') + 26
+            default:
+                return "", ErrInvalidPunycode
+            }
+            i += digit * w
+            t := punyThreshold(k, bias)
+            if digit < t {
+                break
+            }
+            w *= punyBase - t
+        }
+        bias = punyAdapt(i-oldI, len(output)+1, oldI == 0)
+        n += i / (len(output) + 1)
+        i %= len(output) + 1
+        if n > utf8.MaxRune {
+            return "", ErrInvalidPunycode
+        }
+        output = append(output[:i], append([]rune{rune(n)}, output[i:]...)...)
+        i++
+    }
+    return string(output), nil
+}
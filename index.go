@@ -0,0 +1,128 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    _ "embed"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+)
+
+// IndexConfigTemplate is the Quickwit index config for the NRO logs, with
+// raw-tokenized fast fields for everything the analysis aggregates on
+//
+//go:embed nro-logs-index.json
+var IndexConfigTemplate []byte
+
+// IndexConfig returns IndexConfigTemplate with its index_id set to index
+func IndexConfig(index string) ([]byte, error) {
+    var config map[string]interface{}
+    if err := json.Unmarshal(IndexConfigTemplate, &config); err != nil {
+        return nil, fmt.Errorf("error decoding index template: %w", err)
+    }
+    config["index_id"] = index
+    return json.MarshalIndent(config, "", "  ")
+}
+
+// CreateIndex creates the configured index from IndexConfigTemplate on the
+// first searcher that accepts the request
+func (c *HTTPClient) CreateIndex(ctx context.Context, props Properties) error {
+    config, err := IndexConfig(props.Index)
+    if err != nil {
+        return err
+    }
+
+    urls := props.URLs()
+    for i, url := range urls {
+        err = c.createIndex(ctx, props, url, config)
+        if err == nil || !errors.Is(err, ErrSearcherUnavailable) || i == len(urls)-1 {
+            return err
+        }
+        log.Printf("Quickwit %s failed, trying %s: %v", url, urls[i+1], err)
+    }
+    return err
+}
+
+// createIndex posts an index config to the Quickwit node at baseURL
+func (c *HTTPClient) createIndex(ctx context.Context, props Properties, baseURL string, config []byte) error {
+    req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/v1/indexes", bytes.NewReader(config))
+    if err != nil {
+        return fmt.Errorf("error creating request: %w", err)
+    }
+    req.SetBasicAuth(props.QWUser, props.QWPass)
+    req.Header.Set("Content-Type", "application/json")
+
+    resp, err := c.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("%w: error sending request: %w", ErrSearcherUnavailable, err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(resp.Body)
+
+    if resp.StatusCode >= http.StatusInternalServerError {
+        return fmt.Errorf("%w: quickwit error (status %d): %s", ErrSearcherUnavailable, resp.StatusCode, string(body))
+    }
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("quickwit error (status %d): %s", resp.StatusCode, string(body))
+    }
+    return nil
+}
+
+// runIndex implements the "index" subcommand
+func runIndex(args []string) int {
+    flags := flag.NewFlagSet("index", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* Quickwit environment of the configuration file (default: DEFAULT_ENV)")
+    index := flags.String("index", "", "Index to create (default: QW_INDEX of the configuration file)")
+    printOnly := flags.Bool("print", false, "Print the index config instead of creating the index")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp index [flags] create")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 1 || flags.Arg(0) != "create" {
+        flags.Usage()
+        return 1
+    }
+
+    if *printOnly {
+        name := *index
+        if name == "" {
+            name = DefaultIndex
+        }
+        config, err := IndexConfig(name)
+        if err != nil {
+            log.Printf("Error: %v", err)
+            return 1
+        }
+        fmt.Println(string(config))
+        return 0
+    }
+
+    client, err := LoadClient(*configFile, *profile, *env)
+    if err != nil {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+    props := client.properties()
+    if *index != "" {
+        props.Index = *index
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    if err := client.CreateIndex(ctx, props); err != nil {
+        log.Printf("Error creating index %s: %v", props.Index, err)
+        return 1
+    }
+    fmt.Printf("Created index %s\n", props.Index)
+    return 0
+}
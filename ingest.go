@@ -0,0 +1,222 @@
+package main
+
+import (
+    "bufio"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// FieldMapping names the fields of a generic (ndjson or csv) log dump that
+// hold the values of a LogRecord
+type FieldMapping struct {
+    Username        string
+    ServiceProvider string
+    Realm           string
+    Timestamp       string
+    // Result is the field telling accepts from other events; events whose
+    // Result equals AcceptValue are accepts. With no Result field every
+    // event is an accept.
+    Result      string
+    AcceptValue string
+}
+
+// DefaultFieldMapping reads dumps that use the Quickwit field names
+var DefaultFieldMapping = FieldMapping{
+    Username:        "username",
+    ServiceProvider: "service_provider",
+    Realm:           "realm",
+    Timestamp:       "timestamp",
+    Result:          "message_type",
+    AcceptValue:     "Access-Accept",
+}
+
+// ParseFieldMapping applies a -input-map value such as
+// "username=user.name,timestamp=@timestamp,result=event:accept" to
+// DefaultFieldMapping. Nested JSON fields are addressed with dots.
+func ParseFieldMapping(value string) (FieldMapping, error) {
+    mapping := DefaultFieldMapping
+    for _, part := range strings.Split(value, ",") {
+        if part = strings.TrimSpace(part); part == "" {
+            continue
+        }
+        name, field, ok := strings.Cut(part, "=")
+        if !ok {
+            return mapping, fmt.Errorf("invalid field mapping %q, expected name=field", part)
+        }
+        field = strings.TrimSpace(field)
+        switch strings.TrimSpace(name) {
+        case "username":
+            mapping.Username = field
+        case "service_provider":
+            mapping.ServiceProvider = field
+        case "realm":
+            mapping.Realm = field
+        case "timestamp":
+            mapping.Timestamp = field
+        case "result":
+            mapping.Result, mapping.AcceptValue, _ = strings.Cut(field, ":")
+        default:
+            return mapping, fmt.Errorf("unknown mapped field %q (use username, service_provider, realm, timestamp or result)", name)
+        }
+    }
+    return mapping, nil
+}
+
+// NewLogParser returns the parser for an -input-format; the generic
+// formats read their fields through mapping
+func NewLogParser(format string, mapping FieldMapping) (LogParser, error) {
+    switch format {
+    case "ndjson":
+        return func(r io.Reader, emit func(LogRecord) error) error {
+            return parseNDJSON(r, mapping, emit)
+        }, nil
+    case "csv":
+        return func(r io.Reader, emit func(LogRecord) error) error {
+            return parseCSVLog(r, mapping, emit)
+        }, nil
+    }
+    if parse, ok := LogParsers[format]; ok {
+        return parse, nil
+    }
+    return nil, fmt.Errorf("unknown input format %q (available: %s)", format, strings.Join(LogFormatNames(), ", "))
+}
+
+// mappedRecord builds a record from the mapped field values
+func mappedRecord(mapping FieldMapping, get func(field string) (string, bool)) (LogRecord, bool) {
+    record := LogRecord{Accept: true}
+    record.Username, _ = get(mapping.Username)
+    record.ServiceProvider, _ = get(mapping.ServiceProvider)
+    record.Realm, _ = get(mapping.Realm)
+    if record.Realm == "" {
+        if _, realm, ok := strings.Cut(record.Username, "@"); ok {
+            record.Realm = realm
+        }
+    }
+    if mapping.Result != "" {
+        if result, ok := get(mapping.Result); ok {
+            record.Accept = result == mapping.AcceptValue
+        }
+    }
+    value, _ := get(mapping.Timestamp)
+    timestamp, ok := parseLogTimestamp(value)
+    record.Timestamp = timestamp
+    return record, ok
+}
+
+// parseLogTimestamp accepts Unix seconds or milliseconds, RFC 3339 and
+// DateTimeFormat in local time
+func parseLogTimestamp(value string) (time.Time, bool) {
+    value = strings.TrimSpace(value)
+    if number, err := strconv.ParseFloat(value, 64); err == nil {
+        if number > 1e12 {
+            return time.UnixMilli(int64(number)), true
+        }
+        return time.Unix(int64(number), 0), true
+    }
+    if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+        return t, true
+    }
+    if t, err := time.ParseInLocation(DateTimeFormat, value, time.Local); err == nil {
+        return t, true
+    }
+    return time.Time{}, false
+}
+
+// jsonField looks up a possibly nested ("a.b") field of a JSON object
+func jsonField(object map[string]interface{}, field string) (string, bool) {
+    value, ok := object[field]
+    for !ok {
+        head, rest, nested := strings.Cut(field, ".")
+        if !nested {
+            return "", false
+        }
+        child, isObject := object[head].(map[string]interface{})
+        if !isObject {
+            return "", false
+        }
+        object, field = child, rest
+        value, ok = object[field]
+    }
+    switch v := value.(type) {
+    case string:
+        return v, true
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64), true
+    case nil:
+        return "", false
+    default:
+        return fmt.Sprint(v), true
+    }
+}
+
+// parseNDJSON reads one JSON object per line; lines that are not objects
+// or have no valid timestamp are skipped
+func parseNDJSON(r io.Reader, mapping FieldMapping, emit func(LogRecord) error) error {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    for scanner.Scan() {
+        var object map[string]interface{}
+        if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+            continue
+        }
+        record, ok := mappedRecord(mapping, func(field string) (string, bool) {
+            return jsonField(object, field)
+        })
+        if !ok {
+            continue
+        }
+        if err := emit(record); err != nil {
+            return err
+        }
+    }
+    return scanner.Err()
+}
+
+// parseCSVLog reads a CSV dump whose first row names the columns
+func parseCSVLog(r io.Reader, mapping FieldMapping, emit func(LogRecord) error) error {
+    reader := csv.NewReader(r)
+    reader.FieldsPerRecord = -1
+    header, err := reader.Read()
+    if errors.Is(err, io.EOF) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    columns := make(map[string]int, len(header))
+    for i, name := range header {
+        columns[strings.TrimSpace(name)] = i
+    }
+    if _, ok := columns[mapping.Timestamp]; !ok {
+        return fmt.Errorf("no %q column for the timestamp", mapping.Timestamp)
+    }
+
+    for {
+        row, err := reader.Read()
+        if errors.Is(err, io.EOF) {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        record, ok := mappedRecord(mapping, func(field string) (string, bool) {
+            i, ok := columns[field]
+            if !ok || i >= len(row) {
+                return "", false
+            }
+            return row[i], true
+        })
+        if !ok {
+            continue
+        }
+        if err := emit(record); err != nil {
+            return err
+        }
+    }
+}
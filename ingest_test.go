@@ -0,0 +1,126 @@
+package main
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestParseFieldMapping(t *testing.T) {
+    tests := []struct {
+        value string
+        want  FieldMapping
+        err   bool
+    }{
+        {"", DefaultFieldMapping, false},
+        {"username=user.name, timestamp=@timestamp", FieldMapping{
+            Username: "user.name", ServiceProvider: "service_provider", Realm: "realm",
+            Timestamp: "@timestamp", Result: "message_type", AcceptValue: "Access-Accept",
+        }, false},
+        {"result=event:accept,service_provider=sp,realm=", FieldMapping{
+            Username: "username", ServiceProvider: "sp", Realm: "",
+            Timestamp: "timestamp", Result: "event", AcceptValue: "accept",
+        }, false},
+        {"username", FieldMapping{}, true},
+        {"user=name", FieldMapping{}, true},
+    }
+    for _, tt := range tests {
+        got, err := ParseFieldMapping(tt.value)
+        if (err != nil) != tt.err {
+            t.Errorf("ParseFieldMapping(%q) error = %v", tt.value, err)
+            continue
+        }
+        if !tt.err && got != tt.want {
+            t.Errorf("ParseFieldMapping(%q) = %+v, want %+v", tt.value, got, tt.want)
+        }
+    }
+}
+
+func TestParseLogTimestamp(t *testing.T) {
+    want := time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC)
+    tests := []struct {
+        value string
+        want  time.Time
+        ok    bool
+    }{
+        {"1709296215", want, true},
+        {"1709296215000", want, true},
+        {"1709296215.5", want, true},
+        {"2024-03-01T12:30:15Z", want, true},
+        {"2024-03-01T19:30:15+07:00", want, true},
+        {"2024-03-01T12:30:15.250Z", want.Add(250 * time.Millisecond), true},
+        {" 2024-03-01 12:30:15 ", time.Date(2024, 3, 1, 12, 30, 15, 0, time.Local), true},
+        {"01/03/2024", time.Time{}, false},
+        {"", time.Time{}, false},
+    }
+    for _, tt := range tests {
+        got, ok := parseLogTimestamp(tt.value)
+        if ok != tt.ok || !got.Equal(tt.want) {
+            t.Errorf("parseLogTimestamp(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+        }
+    }
+}
+
+func TestParseNDJSONNested(t *testing.T) {
+    mapping, err := ParseFieldMapping("username=user.name,service_provider=radius.nas.id,timestamp=@timestamp,result=event.outcome:success,realm=")
+    if err != nil {
+        t.Fatal(err)
+    }
+    parse, err := NewLogParser("ndjson", mapping)
+    if err != nil {
+        t.Fatal(err)
+    }
+    input := `{"@timestamp":"2024-03-01T12:30:15Z","user":{"name":"alice@uni.example"},"radius":{"nas":{"id":"sp.example"}},"event":{"outcome":"success"}}
+not json
+{"@timestamp":"2024-03-01T12:31:00Z","user":{"name":"bob@uni.example"},"radius":{"nas":{"id":"sp.example"}},"event":{"outcome":"failure"}}
+{"user":{"name":"carol@uni.example"}}
+{"@timestamp":1709296300,"user.name":"dave@other.example","radius":{"nas":{"id":"sp2.example"}}}
+`
+    records, err := parseAll(t, parse, input)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []LogRecord{
+        {Username: "alice@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC), Accept: true},
+        {Username: "bob@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Date(2024, 3, 1, 12, 31, 0, 0, time.UTC), Accept: false},
+        // A dotted key is read as is before being treated as a path
+        {Username: "dave@other.example", ServiceProvider: "sp2.example", Realm: "other.example", Timestamp: time.Unix(1709296300, 0), Accept: true},
+    }
+    checkRecords(t, records, want)
+}
+
+func TestParseCSVLog(t *testing.T) {
+    mapping, err := ParseFieldMapping("username=User,service_provider=Provider,timestamp=Time,result=Type:Access-Accept")
+    if err != nil {
+        t.Fatal(err)
+    }
+    parse, err := NewLogParser("csv", mapping)
+    if err != nil {
+        t.Fatal(err)
+    }
+    input := "Time, Provider ,User,Type\n" +
+        "2024-03-01T12:30:15Z,sp.example,alice@uni.example,Access-Accept\n" +
+        "2024-03-01T12:31:15Z,sp.example,bob@uni.example,Access-Reject\n" +
+        "yesterday,sp.example,carol@uni.example,Access-Accept\n" +
+        "2024-03-01T12:32:15Z,sp.example\n"
+    records, err := parseAll(t, parse, input)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 3 {
+        t.Fatalf("got %d records, want 3: %+v", len(records), records)
+    }
+    if r := records[0]; r.Username != "alice@uni.example" || r.ServiceProvider != "sp.example" || r.Realm != "uni.example" || !r.Accept {
+        t.Errorf("first record = %+v", r)
+    }
+    if records[1].Accept {
+        t.Errorf("reject read as accept: %+v", records[1])
+    }
+    if r := records[2]; r.Username != "" || r.ServiceProvider != "sp.example" {
+        t.Errorf("short row = %+v", r)
+    }
+
+    if err := parse(strings.NewReader("User,Provider\nalice,sp\n"), func(LogRecord) error { return nil }); err == nil {
+        t.Error("CSV without a timestamp column accepted")
+    }
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+)
+
+// testRange returns the normalized range of days days ending at testNow
+func testRange(t *testing.T, days int) TimeRange {
+    t.Helper()
+    timeRange, err := ResolveTimeRangeAt(fmt.Sprint(days), testNow)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return timeRange
+}
+
+func TestGenerateJobs(t *testing.T) {
+    timeRange := testRange(t, 3)
+    jobs := GenerateJobs(timeRange)
+    if len(jobs) != 3 {
+        t.Fatalf("GenerateJobs returned %d jobs, want 3", len(jobs))
+    }
+    for i, job := range jobs {
+        want := timeRange.StartDate.AddDate(0, 0, i)
+        if !job.Date.Equal(want) || job.StartTimestamp != want.Unix() {
+            t.Errorf("job %d starts at %v, want %v", i, job.Date, want)
+        }
+        if i > 0 && job.StartTimestamp != jobs[i-1].EndTimestamp {
+            t.Errorf("job %d starts at %d, previous ends at %d", i, job.StartTimestamp, jobs[i-1].EndTimestamp)
+        }
+    }
+    // The last job ends with the range, one nanosecond before midnight
+    if last := jobs[2]; last.EndTimestamp != timeRange.EndDate.Unix() {
+        t.Errorf("last job ends at %d, want %d", last.EndTimestamp, timeRange.EndDate.Unix())
+    }
+
+    if jobs := GenerateJobs(TimeRange{StartDate: testNow, EndDate: testNow}); len(jobs) != 0 {
+        t.Errorf("GenerateJobs of an empty range returned %d jobs", len(jobs))
+    }
+}
+
+func TestTimestampCutoff(t *testing.T) {
+    if cutoff := TimestampCutoff(testNow, 5*time.Minute); cutoff != testNow.Unix()+300 {
+        t.Errorf("TimestampCutoff = %d, want %d", cutoff, testNow.Unix()+300)
+    }
+    if cutoff := TimestampCutoff(testNow, -1); cutoff != 0 {
+        t.Errorf("TimestampCutoff with a negative skew = %d, want 0", cutoff)
+    }
+}
+
+func TestClampJobs(t *testing.T) {
+    jobs := GenerateJobs(testRange(t, 3))
+    if clamped := ClampJobs(jobs, 0); len(clamped) != 3 {
+        t.Errorf("ClampJobs without cutoff kept %d of 3 jobs", len(clamped))
+    }
+
+    cutoff := jobs[1].StartTimestamp + 3600
+    clamped := ClampJobs(jobs, cutoff)
+    if len(clamped) != 2 {
+        t.Fatalf("ClampJobs kept %d jobs, want 2", len(clamped))
+    }
+    if clamped[0] != jobs[0] {
+        t.Errorf("ClampJobs changed a job before the cutoff: %+v", clamped[0])
+    }
+    if clamped[1].EndTimestamp != cutoff {
+        t.Errorf("ClampJobs ended the job at %d, want %d", clamped[1].EndTimestamp, cutoff)
+    }
+    if jobs[1].EndTimestamp == cutoff {
+        t.Errorf("ClampJobs modified its input")
+    }
+}
+
+func TestPlanBatches(t *testing.T) {
+    ctx := context.Background()
+    jobs := GenerateJobs(testRange(t, 10))
+
+    tests := []struct {
+        name    string
+        config  Config
+        jobs    []Job
+        batches int
+    }{
+        {name: "single query", config: Config{SingleQueryDays: 10}, jobs: jobs, batches: 1},
+        {name: "too long for one query", config: Config{SingleQueryDays: 7}, jobs: jobs, batches: 10},
+        {name: "per day", config: Config{}, jobs: jobs, batches: 10},
+        {name: "one day", config: Config{SingleQueryDays: 7, AutoChunk: true}, jobs: jobs[:1], batches: 1},
+    }
+    for _, tt := range tests {
+        batches := PlanBatches(ctx, tt.config, nil, nil, tt.jobs)
+        if len(batches) != tt.batches {
+            t.Errorf("%s: %d batches, want %d", tt.name, len(batches), tt.batches)
+        }
+        var planned int
+        for _, batch := range batches {
+            planned += len(batch)
+        }
+        if planned != len(tt.jobs) {
+            t.Errorf("%s: %d jobs planned, want %d", tt.name, planned, len(tt.jobs))
+        }
+    }
+}
+
+func TestBuildRangeQuery(t *testing.T) {
+    jobs := GenerateJobs(testRange(t, 3))
+    query := BuildRangeQuery(map[string]interface{}{"query": "q"}, jobs, QueryOptions{})
+    if query["start_timestamp"] != jobs[0].StartTimestamp || query["end_timestamp"] != jobs[2].EndTimestamp {
+        t.Errorf("range query covers %v - %v, want %d - %d", query["start_timestamp"], query["end_timestamp"],
+            jobs[0].StartTimestamp, jobs[2].EndTimestamp)
+    }
+    days := query["aggs"].(map[string]interface{})["days"].(map[string]interface{})
+    histogram := days["date_histogram"].(map[string]interface{})
+    offset := (jobs[0].StartTimestamp%86400 + 86400) % 86400
+    if histogram["offset"] != fmt.Sprintf("%ds", offset) {
+        t.Errorf("histogram offset = %v, want %ds", histogram["offset"], offset)
+    }
+    if _, ok := days["aggs"].(map[string]interface{})["unique_users"]; !ok {
+        t.Errorf("range query lacks the per-day user aggregation")
+    }
+}
+
+func TestJobAt(t *testing.T) {
+    jobs := GenerateJobs(testRange(t, 3))
+    if job, ok := jobAt(jobs, jobs[1].StartTimestamp+60); !ok || job != jobs[1] {
+        t.Errorf("jobAt returned %+v, %v, want job 1", job, ok)
+    }
+    if _, ok := jobAt(jobs, jobs[2].EndTimestamp); ok {
+        t.Errorf("jobAt found a job after the range")
+    }
+}
+
+func TestOrderBatches(t *testing.T) {
+    jobs := GenerateJobs(testRange(t, 5))
+    batches := [][]Job{jobs[:2], jobs[2:3], jobs[3:]}
+
+    newest := OrderBatches(batches, JobOrderNewest)
+    if len(newest) != 3 || newest[0][0] != jobs[3] || newest[2][0] != jobs[0] {
+        t.Errorf("newest first: %v", newest)
+    }
+    if newest[0][1] != jobs[4] {
+        t.Errorf("days within a batch were reordered: %v", newest[0])
+    }
+    if batches[0][0] != jobs[0] {
+        t.Errorf("OrderBatches modified its input")
+    }
+    if oldest := OrderBatches(batches, JobOrderOldest); oldest[0][0] != jobs[0] {
+        t.Errorf("oldest first: %v", oldest)
+    }
+
+    for value, want := range map[string]string{"": JobOrderNewest, "newest": JobOrderNewest, "oldest": JobOrderOldest} {
+        if order, err := ParseJobOrder(value); err != nil || order != want {
+            t.Errorf("ParseJobOrder(%q) = %q, %v, want %q", value, order, err, want)
+        }
+    }
+    if _, err := ParseJobOrder("random"); err == nil {
+        t.Errorf("ParseJobOrder accepted an unknown order")
+    }
+}
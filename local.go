@@ -0,0 +1,50 @@
+package main
+
+import (
+    "context"
+    "fmt"
+)
+
+// LocalTraffic summarises the events at the home institution itself
+// (service_provider "client"), which the roaming analysis excludes
+type LocalTraffic struct {
+    Hits int64 `json:"hits"`
+    // UniqueUsers is estimated by Quickwit's cardinality aggregation
+    UniqueUsers int64 `json:"unique_users_approx"`
+    // RoamingShare is the fraction of all events that were roaming rather
+    // than local
+    RoamingShare float64 `json:"roaming_share"`
+}
+
+// CountLocalTraffic queries the hits and approximate unique users of the
+// local events of messageType of the realms over the time range
+func CountLocalTraffic(ctx context.Context, client *HTTPClient, messageType string, realms []string, timeRange TimeRange, roamingHits int64) (*LocalTraffic, error) {
+    query := map[string]interface{}{
+        "query":           BuildLocalQuery(messageType, realms),
+        "start_timestamp": timeRange.StartDate.Unix(),
+        "end_timestamp":   timeRange.EndDate.Unix(),
+        "max_hits":        0,
+        "aggs": map[string]interface{}{
+            "users": map[string]interface{}{
+                "cardinality": map[string]interface{}{
+                    "field": "username",
+                },
+            },
+        },
+    }
+
+    result, err := client.SendQuickwitRequest(ctx, query)
+    if err != nil {
+        return nil, fmt.Errorf("error counting local traffic: %w", err)
+    }
+
+    var users valueMetric
+    if err := decodeAggregation(result, "users", &users); err != nil {
+        return nil, fmt.Errorf("error counting local traffic: %w", err)
+    }
+    local := &LocalTraffic{Hits: int64(result.NumHits), UniqueUsers: int64(users.Value)}
+    if total := local.Hits + roamingHits; total > 0 {
+        local.RoamingShare = float64(roamingHits) / float64(total)
+    }
+    return local, nil
+}
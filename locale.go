@@ -0,0 +1,134 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Locale describes how numbers and dates are presented in human-facing
+// output (console summaries and templates). Machine formats such as JSON and
+// CSV always use plain numbers and ISO dates regardless of the locale.
+type Locale struct {
+    Name         string
+    ThousandsSep string
+    DecimalSep   string
+    DateLayout   string
+}
+
+// DefaultLocale keeps numbers unseparated and dates in ISO order
+var DefaultLocale = Locale{Name: "iso", ThousandsSep: "", DecimalSep: ".", DateLayout: DateFormat}
+
+// Locales lists the supported locale settings by name
+var Locales = map[string]Locale{
+    "iso":   DefaultLocale,
+    "en":    {Name: "en", ThousandsSep: ",", DecimalSep: ".", DateLayout: "01/02/2006"},
+    "en-gb": {Name: "en-gb", ThousandsSep: ",", DecimalSep: ".", DateLayout: "02/01/2006"},
+    "th":    {Name: "th", ThousandsSep: ",", DecimalSep: ".", DateLayout: "02/01/2006"},
+    "de":    {Name: "de", ThousandsSep: ".", DecimalSep: ",", DateLayout: "02.01.2006"},
+    "fr":    {Name: "fr", ThousandsSep: " ", DecimalSep: ",", DateLayout: "02/01/2006"},
+}
+
+// GetLocale returns the locale registered under name
+func GetLocale(name string) (Locale, error) {
+    if name == "" {
+        return DefaultLocale, nil
+    }
+    if locale, ok := Locales[strings.ToLower(name)]; ok {
+        return locale, nil
+    }
+    names := make([]string, 0, len(Locales))
+    for n := range Locales {
+        names = append(names, n)
+    }
+    sort.Strings(names)
+    return Locale{}, fmt.Errorf("unknown locale %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// FormatInt formats an integer with the locale's thousands separator
+func (l Locale) FormatInt(n int64) string {
+    digits := strconv.FormatInt(n, 10)
+    sign := ""
+    if n < 0 {
+        sign, digits = "-", digits[1:]
+    }
+    if l.ThousandsSep == "" || len(digits) <= 3 {
+        return sign + digits
+    }
+
+    var b strings.Builder
+    lead := len(digits) % 3
+    if lead > 0 {
+        b.WriteString(digits[:lead])
+    }
+    for i := lead; i < len(digits); i += 3 {
+        if b.Len() > 0 {
+            b.WriteString(l.ThousandsSep)
+        }
+        b.WriteString(digits[i : i+3])
+    }
+    return sign + b.String()
+}
+
+// FormatFloat formats a number with prec decimals using the locale separators
+func (l Locale) FormatFloat(f float64, prec int) string {
+    s := strconv.FormatFloat(f, 'f', prec, 64)
+    intPart, fracPart, hasFrac := strings.Cut(s, ".")
+    n, err := strconv.ParseInt(intPart, 10, 64)
+    if err != nil {
+        return s
+    }
+    out := l.FormatInt(n)
+    if n == 0 && strings.HasPrefix(intPart, "-") {
+        out = "-" + out
+    }
+    if hasFrac {
+        out += l.DecimalSep + fracPart
+    }
+    return out
+}
+
+// FormatDate formats a date using the locale's date ordering
+func (l Locale) FormatDate(t time.Time) string {
+    return t.Format(l.DateLayout)
+}
+
+// TemplateFuncs returns template helpers bound to this locale:
+// number (integers), decimal (floats with precision) and date (time values
+// or strings in the output's ISO formats)
+func (l Locale) TemplateFuncs() map[string]interface{} {
+    return map[string]interface{}{
+        "number": func(v interface{}) string {
+            switch n := v.(type) {
+            case int:
+                return l.FormatInt(int64(n))
+            case int64:
+                return l.FormatInt(n)
+            case int32:
+                return l.FormatInt(int64(n))
+            case float64:
+                return l.FormatFloat(n, 0)
+            }
+            return fmt.Sprint(v)
+        },
+        "decimal": func(f float64, prec int) string {
+            return l.FormatFloat(f, prec)
+        },
+        "date": func(v interface{}) string {
+            switch d := v.(type) {
+            case time.Time:
+                return l.FormatDate(d)
+            case string:
+                for _, layout := range []string{DateTimeFormat, DateFormat} {
+                    if t, err := time.Parse(layout, d); err == nil {
+                        return l.FormatDate(t)
+                    }
+                }
+                return d
+            }
+            return fmt.Sprint(v)
+        },
+    }
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// DefaultInputFormat is the log format read by -input
+const DefaultInputFormat = "detail"
+
+// LogRecord is one authentication event read from a log file
+type LogRecord struct {
+    Username        string
+    ServiceProvider string
+    Realm           string
+    Timestamp       time.Time
+    // Accept is false for events other than Access-Accept
+    Accept bool
+}
+
+// LogParser reads the records of a log and passes each to emit
+type LogParser func(r io.Reader, emit func(LogRecord) error) error
+
+// SkippedLinesError is returned by a LogParser that read its input but
+// skipped events it could not parse, such as lines with an unknown
+// timestamp format. The other records were emitted.
+type SkippedLinesError struct {
+    Lines int
+    // First is the line number of the first skipped event
+    First int
+}
+
+func (e *SkippedLinesError) Error() string {
+    return fmt.Sprintf("skipped %d unreadable events, the first at line %d", e.Lines, e.First)
+}
+
+// skip counts an unreadable event at line
+func (e *SkippedLinesError) skip(line int) {
+    if e.Lines == 0 {
+        e.First = line
+    }
+    e.Lines++
+}
+
+// err returns e if events were skipped
+func (e *SkippedLinesError) err() error {
+    if e.Lines == 0 {
+        return nil
+    }
+    return e
+}
+
+// LogParsers are the fixed log formats understood by -input-format (see
+// NewLogParser for the generic ones)
+var LogParsers = map[string]LogParser{
+    "detail":      ParseDetailLog,
+    "radsecproxy": ParseRadsecproxyLog,
+}
+
+// LogFormatNames returns the sorted names of the log formats, including the
+// generic ndjson and csv formats read through a FieldMapping
+func LogFormatNames() []string {
+    names := []string{"csv", "ndjson"}
+    for name := range LogParsers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// ExpandInputPaths splits a comma-separated -input value and expands glob
+// patterns, so that rotated logs can be given as "detail-*"
+func ExpandInputPaths(value string) ([]string, error) {
+    var paths []string
+    for _, pattern := range strings.Split(value, ",") {
+        if pattern = strings.TrimSpace(pattern); pattern == "" {
+            continue
+        }
+        matches, err := filepath.Glob(pattern)
+        if err != nil {
+            return nil, fmt.Errorf("invalid input pattern %q: %w", pattern, err)
+        }
+        if len(matches) == 0 {
+            return nil, fmt.Errorf("no input files match %q", pattern)
+        }
+        paths = append(paths, matches...)
+    }
+    if len(paths) == 0 {
+        return nil, fmt.Errorf("no input files given")
+    }
+    return paths, nil
+}
+
+// LogFilter selects the records a log-file report is made of, mirroring the
+// Quickwit query: accepted events of the realm (or at the provider with the
+// sp pivot) in the time range, without excluded events
+type LogFilter struct {
+    // MatchRealm reports whether a realm belongs to the report
+    MatchRealm func(realm string) bool
+    // Provider selects the service provider instead with the sp pivot
+    Provider   string
+    Exclusions []ExclusionRule
+    Start, End time.Time
+}
+
+// Match reports whether a record belongs to the report
+func (f LogFilter) Match(record LogRecord) bool {
+    if !record.Accept || record.Timestamp.Before(f.Start) || record.Timestamp.After(f.End) {
+        return false
+    }
+    if f.Provider != "" {
+        if !strings.EqualFold(record.ServiceProvider, f.Provider) {
+            return false
+        }
+    } else if f.MatchRealm != nil && !f.MatchRealm(record.Realm) {
+        return false
+    }
+    for _, rule := range f.Exclusions {
+        if rule.Regex != nil {
+            continue
+        }
+        var value string
+        switch rule.Field {
+        case "username":
+            value = record.Username
+        case "service_provider":
+            value = record.ServiceProvider
+        case "realm":
+            value = record.Realm
+        default:
+            continue
+        }
+        if value == rule.Value {
+            return false
+        }
+    }
+    return true
+}
+
+// AnalyzeLogFiles builds a result from log files instead of Quickwit. The
+// records selected by filter go through the same aggregation as query
+// results, so every exporter works unchanged.
+func AnalyzeLogFiles(ctx context.Context, paths []string, parse LogParser, filter LogFilter, config Config) (*Result, error) {
+    StartNameScope()
+    result := &Result{
+        Users:         make(map[string]*UserStats),
+        Providers:     make(map[string]*ProviderStats),
+        StartDate:     config.TimeRange.StartDate,
+        EndDate:       config.TimeRange.EndDate,
+        Pivot:         config.Query.Pivot,
+        Exclusions:    config.Query.Exclusions,
+        FoldRealmCase: config.Query.FoldRealmCase,
+        Days:          make(map[string]*DayStats),
+        DayVisits:     config.DayVisits,
+    }
+
+    queue := NewResultQueue(ResultChanBuffer, config.ResultBufferMax)
+    resultChan := queue.In()
+    config.Monitor.Watch("results", 2*ResultChanBuffer, queue.Buffered)
+    config.Monitor.Watch("results_overflow", maxEntries(config.ResultBufferMax), queue.Overflow)
+    processDone := make(chan struct{})
+    go func() {
+        ProcessResultsSharded(ctx, queue.Out(), result, config.Processors)
+        close(processDone)
+    }()
+
+    dayHits := make(map[string]int64)
+    var readErr error
+    for _, path := range paths {
+        readErr = readLogFile(ctx, path, parse, func(record LogRecord) error {
+            if !filter.Match(record) {
+                return nil
+            }
+            entry := LogEntry{
+                Username:        record.Username,
+                ServiceProvider: record.ServiceProvider,
+                Timestamp:       record.Timestamp,
+                Hits:            1,
+            }
+            if config.Query.Pivot == PivotSP {
+                entry.ServiceProvider = record.Realm
+            } else if config.Query.RealmBreakdown {
+                entry.Realm = record.Realm
+            }
+            resultChan <- entry
+            dayHits[record.Timestamp.Format(DateFormat)]++
+            result.TotalHits++
+            return nil
+        })
+        if readErr != nil {
+            break
+        }
+    }
+    queue.Close()
+    <-processDone
+    result.Backpressure = queue.Stats()
+
+    for day, hits := range dayHits {
+        if result.Days[day] == nil {
+            result.Days[day] = &DayStats{}
+        }
+        result.Days[day].Hits = hits
+    }
+
+    if readErr != nil && ctx.Err() != nil {
+        result.Partial = true
+        return result, ctx.Err()
+    }
+    if readErr != nil {
+        return nil, readErr
+    }
+    return result, nil
+}
+
+// readLogFile parses one log file, stopping when ctx is cancelled. Skipped
+// events are logged; a file none of whose events could be read fails.
+func readLogFile(ctx context.Context, path string, parse LogParser, emit func(LogRecord) error) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return fmt.Errorf("error opening input: %w", err)
+    }
+    defer file.Close()
+
+    var records int
+    err = parse(file, func(record LogRecord) error {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        records++
+        return emit(record)
+    })
+    var skipped *SkippedLinesError
+    if errors.As(err, &skipped) && records > 0 {
+        log.Printf("Warning: %s: %v", path, err)
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("error reading %s: %w", path, err)
+    }
+    return nil
+}
+
+// ParseDetailLog reads FreeRADIUS detail files: blank-line separated
+// records of "Attribute = value" lines after a date line. The service
+// provider is taken from Operator-Name (without the "1" of the REALM
+// namespace), falling back to NAS-Identifier and Client-IP-Address; the
+// realm from Stripped-User-Domain, Realm or the User-Name. Records with a
+// Packet-Type other than Access-Accept are not accepts. Records without a
+// Timestamp attribute or readable date line are skipped.
+func ParseDetailLog(r io.Reader, emit func(LogRecord) error) error {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+    attrs := make(map[string]string)
+    var header string
+    var lineNo, start int
+    var skipped SkippedLinesError
+    flush := func() error {
+        if len(attrs) == 0 {
+            return nil
+        }
+        defer clear(attrs)
+        record := LogRecord{Accept: true}
+        if packetType, ok := attrs["Packet-Type"]; ok {
+            record.Accept = packetType == "Access-Accept"
+        }
+        record.Username = attrs["User-Name"]
+        record.ServiceProvider = firstOf(attrs, "Operator-Name", "NAS-Identifier", "Client-IP-Address")
+        if strings.HasPrefix(attrs["Operator-Name"], "1") {
+            record.ServiceProvider = attrs["Operator-Name"][1:]
+        }
+        record.Realm = firstOf(attrs, "Stripped-User-Domain", "Realm")
+        if record.Realm == "" {
+            if _, realm, ok := strings.Cut(record.Username, "@"); ok {
+                record.Realm = realm
+            }
+        }
+        if seconds, err := strconv.ParseInt(attrs["Timestamp"], 10, 64); err == nil {
+            record.Timestamp = time.Unix(seconds, 0)
+        } else if t, err := time.ParseInLocation(time.ANSIC, header, time.Local); err == nil {
+            record.Timestamp = t
+        } else {
+            skipped.skip(start)
+            return nil
+        }
+        return emit(record)
+    }
+
+    for scanner.Scan() {
+        lineNo++
+        line := scanner.Text()
+        if strings.TrimSpace(line) == "" {
+            if err := flush(); err != nil {
+                return err
+            }
+            continue
+        }
+        if line[0] != ' ' && line[0] != '\t' {
+            if err := flush(); err != nil {
+                return err
+            }
+            header, start = strings.TrimSpace(line), lineNo
+            continue
+        }
+        if len(attrs) == 0 && start == 0 {
+            start = lineNo
+        }
+        name, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+        if !ok {
+            continue
+        }
+        attrs[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"`)
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+    if err := flush(); err != nil {
+        return err
+    }
+    return skipped.err()
+}
+
+// firstOf returns the first non-empty attribute of names
+func firstOf(attrs map[string]string, names ...string) string {
+    for _, name := range names {
+        if value := attrs[name]; value != "" {
+            return value
+        }
+    }
+    return ""
+}
+
+// ParseRadsecproxyLog reads radsecproxy log lines of the form
+// "<time>: Access-Accept for user <name> [stationid <id>] from <server> to
+// <client> (<address>)", written to its own log file ("Mon Jan _2 15:04:05
+// 2006: ") or through syslog ("Jan _2 15:04:05 host radsecproxy[pid]: ",
+// or with an RFC 3339 timestamp as written by current rsyslog and
+// journald). The client the reply is sent to is the service provider.
+// Access-Reject lines are read as non-accepts; event lines with an
+// unknown timestamp format are skipped.
+func ParseRadsecproxyLog(r io.Reader, emit func(LogRecord) error) error {
+    scanner := bufio.NewScanner(r)
+    now := time.Now()
+    var skipped SkippedLinesError
+    for lineNo := 1; scanner.Scan(); lineNo++ {
+        line := scanner.Text()
+        index := strings.Index(line, "Access-Accept for user ")
+        accept := index >= 0
+        if !accept {
+            if index = strings.Index(line, "Access-Reject for user "); index < 0 {
+                continue
+            }
+        }
+
+        timestamp, ok := parseRadsecproxyTime(line[:index], now)
+        fields := strings.Fields(line[index+len("Access-Accept for user "):])
+        if !ok || len(fields) == 0 {
+            skipped.skip(lineNo)
+            continue
+        }
+        record := LogRecord{Username: fields[0], Timestamp: timestamp, Accept: accept}
+        if _, realm, ok := strings.Cut(record.Username, "@"); ok {
+            record.Realm = realm
+        }
+        for i := 1; i < len(fields)-1; i++ {
+            if fields[i] == "to" {
+                record.ServiceProvider = fields[i+1]
+                break
+            }
+        }
+        if err := emit(record); err != nil {
+            return err
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return err
+    }
+    return skipped.err()
+}
+
+// rfc3339Layouts are the RFC 3339 timestamps of syslog lines; journald
+// writes the offset without a colon
+var rfc3339Layouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999-0700"}
+
+// parseRadsecproxyTime parses the time prefix of a radsecproxy log line.
+// Traditional syslog timestamps have no year; the latest year not in the
+// future is used.
+func parseRadsecproxyTime(prefix string, now time.Time) (time.Time, bool) {
+    prefix = strings.TrimSpace(prefix)
+    if field, _, _ := strings.Cut(prefix, " "); len(field) >= 20 && field[4] == '-' {
+        for _, layout := range rfc3339Layouts {
+            if t, err := time.Parse(layout, strings.TrimSuffix(field, ":")); err == nil {
+                return t, true
+            }
+        }
+    }
+    if len(prefix) >= 24 {
+        if t, err := time.ParseInLocation(time.ANSIC, prefix[:24], time.Local); err == nil {
+            return t, true
+        }
+    }
+    if len(prefix) >= 15 {
+        if t, err := time.ParseInLocation(time.Stamp, prefix[:15], time.Local); err == nil {
+            t = t.AddDate(now.Year(), 0, 0)
+            if t.After(now.Add(24 * time.Hour)) {
+                t = t.AddDate(-1, 0, 0)
+            }
+            return t, true
+        }
+    }
+    return time.Time{}, false
+}
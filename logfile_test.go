@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// detailSample is a FreeRADIUS detail file of an eduroam IdP
+const detailSample = `Fri Mar  1 12:30:15 2024
+	Packet-Type = Access-Accept
+	User-Name = "alice@uni.example"
+	Stripped-User-Domain = "uni.example"
+	Operator-Name = "1sp.example"
+	NAS-Identifier = "ap-17"
+	Client-IP-Address = 192.0.2.10
+	Timestamp = 1709296215
+
+Fri Mar  1 12:31:00 2024
+	Packet-Type = Access-Reject
+	User-Name = "bob@uni.example"
+	Operator-Name = "1sp.example"
+	Timestamp = 1709296260
+
+Fri Mar  1 12:32:00 2024
+	User-Name = "carol@Uni.Example"
+	Operator-Name = "4TADIG"
+	NAS-Identifier = "ap-18"
+
+Fri Mar  1 12:33:00 2024
+	Packet-Type = Access-Accept
+	User-Name = "dave@other.example"
+	Realm = "other.example"
+	Client-IP-Address = 192.0.2.20
+	Timestamp = 1709296380
+`
+
+// radsecproxySample has lines of the radsecproxy log file, traditional and
+// RFC 3339 syslog
+const radsecproxySample = `Fri Mar  1 12:30:15 2024: Access-Accept for user alice@uni.example stationid 02-00-00-00-00-01 from idp.uni.example to sp.example (192.0.2.10)
+Fri Mar  1 12:30:16 2024: Access-Request (id 13) from sp.example (192.0.2.10)
+Mar  1 12:31:00 nro radsecproxy[812]: Access-Reject for user bob@uni.example from idp.uni.example to sp.example (192.0.2.10)
+2024-03-01T12:32:00.123456+07:00 nro radsecproxy[812]: Access-Accept for user carol@uni.example from idp.uni.example to sp2.example (192.0.2.11)
+2024-03-01T12:33:00+0700 nro radsecproxy[812]: Access-Accept for user dave@uni.example from idp.uni.example to sp3.example (192.0.2.12)
+`
+
+// parseAll runs a parser over input and returns the records it emitted
+func parseAll(t *testing.T, parse LogParser, input string) ([]LogRecord, error) {
+    t.Helper()
+    var records []LogRecord
+    err := parse(strings.NewReader(input), func(record LogRecord) error {
+        records = append(records, record)
+        return nil
+    })
+    return records, err
+}
+
+func TestParseDetailLog(t *testing.T) {
+    records, err := parseAll(t, ParseDetailLog, detailSample)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []LogRecord{
+        {Username: "alice@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Unix(1709296215, 0), Accept: true},
+        {Username: "bob@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Unix(1709296260, 0), Accept: false},
+        // No Timestamp attribute: the date line; other namespaces are kept
+        {Username: "carol@Uni.Example", ServiceProvider: "4TADIG", Realm: "Uni.Example", Timestamp: time.Date(2024, 3, 1, 12, 32, 0, 0, time.Local), Accept: true},
+        {Username: "dave@other.example", ServiceProvider: "192.0.2.20", Realm: "other.example", Timestamp: time.Unix(1709296380, 0), Accept: true},
+    }
+    checkRecords(t, records, want)
+}
+
+func TestParseRadsecproxyLog(t *testing.T) {
+    records, err := parseAll(t, ParseRadsecproxyLog, radsecproxySample)
+    if err != nil {
+        t.Fatal(err)
+    }
+    plus7 := time.FixedZone("", 7*3600)
+    want := []LogRecord{
+        {Username: "alice@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Date(2024, 3, 1, 12, 30, 15, 0, time.Local), Accept: true},
+        {Username: "bob@uni.example", ServiceProvider: "sp.example", Realm: "uni.example", Timestamp: time.Date(time.Now().Year(), 3, 1, 12, 31, 0, 0, time.Local), Accept: false},
+        {Username: "carol@uni.example", ServiceProvider: "sp2.example", Realm: "uni.example", Timestamp: time.Date(2024, 3, 1, 12, 32, 0, 123456000, plus7), Accept: true},
+        {Username: "dave@uni.example", ServiceProvider: "sp3.example", Realm: "uni.example", Timestamp: time.Date(2024, 3, 1, 12, 33, 0, 0, plus7), Accept: true},
+    }
+    // The yearless syslog line is dated in the latest year not in the future
+    if want[1].Timestamp.After(time.Now().Add(24 * time.Hour)) {
+        want[1].Timestamp = want[1].Timestamp.AddDate(-1, 0, 0)
+    }
+    checkRecords(t, records, want)
+}
+
+func TestParseLogSkippedLines(t *testing.T) {
+    tests := []struct {
+        name    string
+        parse   LogParser
+        input   string
+        records int
+        skipped SkippedLinesError
+    }{
+        {"radsecproxy", ParseRadsecproxyLog, "01/03/2024 12:30:15: Access-Accept for user alice@uni.example from idp to sp.example (192.0.2.10)\n" +
+            "Fri Mar  1 12:30:15 2024: Access-Accept for user bob@uni.example from idp to sp.example (192.0.2.10)\n" +
+            "Fri Mar  1 12:30:16 2024: Access-Accept for user \n", 1, SkippedLinesError{Lines: 2, First: 1}},
+        {"detail", ParseDetailLog, "Fri Mar  1 12:30:15 2024\n\tUser-Name = \"alice@uni.example\"\n\tTimestamp = 1709296215\n\n" +
+            "2024-03-01 12:31\n\tUser-Name = \"bob@uni.example\"\n", 1, SkippedLinesError{Lines: 1, First: 5}},
+    }
+    for _, tt := range tests {
+        records, err := parseAll(t, tt.parse, tt.input)
+        var skipped *SkippedLinesError
+        if !errors.As(err, &skipped) || *skipped != tt.skipped || len(records) != tt.records {
+            t.Errorf("%s: %d records, %v; want %d records, %+v", tt.name, len(records), err, tt.records, tt.skipped)
+        }
+    }
+}
+
+func TestReadLogFileSkippedLines(t *testing.T) {
+    dir := t.TempDir()
+    partly := filepath.Join(dir, "partly.log")
+    unreadable := filepath.Join(dir, "unreadable.log")
+    line := ": Access-Accept for user alice@uni.example from idp to sp.example (192.0.2.10)\n"
+    os.WriteFile(partly, []byte("Fri Mar  1 12:30:15 2024"+line+"1709296215"+line), 0600)
+    os.WriteFile(unreadable, []byte("1709296215"+line), 0600)
+
+    var records int
+    emit := func(LogRecord) error { records++; return nil }
+    if err := readLogFile(context.Background(), partly, ParseRadsecproxyLog, emit); err != nil || records != 1 {
+        t.Errorf("partly readable file: %d records, %v", records, err)
+    }
+    if err := readLogFile(context.Background(), unreadable, ParseRadsecproxyLog, emit); err == nil {
+        t.Error("a file without readable events was accepted")
+    }
+}
+
+// checkRecords compares parsed records, timestamps by instant
+func checkRecords(t *testing.T, records, want []LogRecord) {
+    t.Helper()
+    if len(records) != len(want) {
+        t.Fatalf("got %d records, want %d: %+v", len(records), len(want), records)
+    }
+    for i := range want {
+        if !records[i].Timestamp.Equal(want[i].Timestamp) {
+            t.Errorf("record %d timestamp = %v, want %v", i, records[i].Timestamp, want[i].Timestamp)
+        }
+        records[i].Timestamp = want[i].Timestamp
+        if records[i] != want[i] {
+            t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+        }
+    }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "sort"
+    "strings"
+)
+
+const (
+    // DefaultLookalikeDistance is the maximum edit distance for a realm to be
+    // reported as a look-alike
+    DefaultLookalikeDistance = 2
+)
+
+// Lookalike is a realm that differs from the expected one by a small edit
+// distance, typically a typo in a supplicant profile
+type Lookalike struct {
+    Realm    string
+    Hits     int64
+    Distance int
+}
+
+// FindLookalikes returns the realms within maxDistance edits of any of the
+// targets, ignoring case, ordered by hits. Realms equal to a target (with
+// identical case) and those listed in exclude are skipped; case-only
+// variants are reported with distance 0.
+func FindLookalikes(realms []RealmCount, targets []string, maxDistance int, exclude []string) []Lookalike {
+    skip := make(map[string]bool)
+    for _, realm := range append(append([]string(nil), targets...), exclude...) {
+        skip[realm] = true
+    }
+
+    var lookalikes []Lookalike
+    for _, realm := range realms {
+        if skip[realm.Realm] {
+            continue
+        }
+        best := -1
+        for _, target := range targets {
+            d := editDistance(strings.ToLower(realm.Realm), strings.ToLower(target), maxDistance)
+            if d <= maxDistance && (best < 0 || d < best) {
+                best = d
+            }
+        }
+        if best >= 0 {
+            lookalikes = append(lookalikes, Lookalike{Realm: realm.Realm, Hits: realm.Hits, Distance: best})
+        }
+    }
+    sort.Slice(lookalikes, func(i, j int) bool {
+        if lookalikes[i].Hits != lookalikes[j].Hits {
+            return lookalikes[i].Hits > lookalikes[j].Hits
+        }
+        return lookalikes[i].Realm < lookalikes[j].Realm
+    })
+    return lookalikes
+}
+
+// editDistance returns the Levenshtein distance between a and b, or
+// limit+1 as soon as it is known to exceed limit
+func editDistance(a, b string, limit int) int {
+    ra, rb := []rune(a), []rune(b)
+    if diff := len(ra) - len(rb); diff > limit || -diff > limit {
+        return limit + 1
+    }
+
+    prev := make([]int, len(rb)+1)
+    curr := make([]int, len(rb)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(ra); i++ {
+        curr[0] = i
+        rowMin := curr[0]
+        for j := 1; j <= len(rb); j++ {
+            cost := 1
+            if ra[i-1] == rb[j-1] {
+                cost = 0
+            }
+            curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+            rowMin = min(rowMin, curr[j])
+        }
+        if rowMin > limit {
+            return limit + 1
+        }
+        prev, curr = curr, prev
+    }
+    return prev[len(rb)]
+}
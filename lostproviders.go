@@ -0,0 +1,132 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// Sources of the previous period of a lost-provider report
+const (
+    LostSourceBaseline = "baseline"
+    LostSourceStore    = "store"
+)
+
+// LostProvider is a provider of the previous period missing from the range
+type LostProvider struct {
+    Provider string `json:"provider"`
+    LastSeen string `json:"last_seen"`
+    Users    int    `json:"users"`
+    Hits     int64  `json:"hits"`
+}
+
+// LostProviderReport lists the providers seen in the period before the
+// range but not in it, often a broken RADIUS peering. StoredDays is the
+// number of days of the previous period found in the store.
+type LostProviderReport struct {
+    Source     string         `json:"source"`
+    StartDate  string         `json:"start_date"`
+    EndDate    string         `json:"end_date"`
+    StoredDays int            `json:"stored_days,omitempty"`
+    Providers  []LostProvider `json:"providers"`
+}
+
+// FindLostProviders returns the providers of previous, covering
+// previousRange, that are missing from result, most users first
+func FindLostProviders(result, previous *Result, previousRange TimeRange, source string) *LostProviderReport {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+    previous.mu.RLock()
+    defer previous.mu.RUnlock()
+
+    report := &LostProviderReport{
+        Source:    source,
+        StartDate: previousRange.StartDate.Format(DateTimeFormat),
+        EndDate:   previousRange.EndDate.Format(DateTimeFormat),
+        Providers: []LostProvider{},
+    }
+    for name, stats := range previous.Providers {
+        if _, ok := result.Providers[name]; ok {
+            continue
+        }
+        report.Providers = append(report.Providers, LostProvider{
+            Provider: name,
+            LastSeen: stats.LastSeen.Format(DateFormat),
+            Users:    stats.Users.Len(),
+            Hits:     stats.Hits,
+        })
+    }
+    sort.Slice(report.Providers, func(i, j int) bool {
+        a, b := report.Providers[i], report.Providers[j]
+        if a.Users != b.Users {
+            return a.Users > b.Users
+        }
+        return a.Provider < b.Provider
+    })
+    return report
+}
+
+// FindLostProvidersInStore compares result with the stored days of the
+// period of equal length before timeRange. It returns nil when none of
+// those days is stored.
+func FindLostProvidersInStore(store *Store, domain string, result *Result, timeRange TimeRange) (*LostProviderReport, error) {
+    previousRange := BaselineRange(timeRange, timeRange.Days)
+    records, err := store.Load(domain, previousRange.StartDate, previousRange.EndDate)
+    if errors.Is(err, ErrEmptyStore) || err == nil && len(records) == 0 {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    previous, err := BuildResultFromDays(records, previousRange)
+    if err != nil {
+        return nil, err
+    }
+    report := FindLostProviders(result, previous, previousRange, LostSourceStore)
+    report.StoredDays = len(records)
+    return report, nil
+}
+
+// printLostProviders warns about the providers lost since the previous
+// period
+func printLostProviders(report *LostProviderReport) {
+    if len(report.Providers) == 0 {
+        fmt.Printf("No providers lost since the previous period (%s to %s)\n", report.StartDate[:10], report.EndDate[:10])
+        return
+    }
+    fmt.Printf("Warning: %d providers of the previous period (%s to %s) are missing:\n",
+        len(report.Providers), report.StartDate[:10], report.EndDate[:10])
+    for _, provider := range report.Providers {
+        fmt.Printf("  %-40s last seen %s, %d users, %d hits\n", provider.Provider, provider.LastSeen, provider.Users, provider.Hits)
+    }
+}
+
+// ExportLostProvidersCSV writes the lost providers. It returns an empty
+// filename when no provider was lost.
+func ExportLostProvidersCSV(result *Result, meta ExportMeta) (string, error) {
+    report := meta.LostProviders
+    if report == nil || len(report.Providers) == 0 {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Provider", "Last Seen", "Users", "Hits"}}
+    for _, provider := range report.Providers {
+        records = append(records, []string{
+            provider.Provider,
+            provider.LastSeen,
+            strconv.Itoa(provider.Users),
+            strconv.FormatInt(provider.Hits, 10),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-lost-providers.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
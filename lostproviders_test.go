@@ -0,0 +1,46 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestFindLostProvidersInStore(t *testing.T) {
+    timeRange := testRange(t, 3)
+    store, err := OpenStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report, err := FindLostProvidersInStore(store, "uni.example", &Result{}, timeRange); err != nil || report != nil {
+        t.Fatalf("empty store: %+v, %v", report, err)
+    }
+
+    previousDay := timeRange.StartDate.AddDate(0, 0, -2).Format(DateFormat)
+    if _, _, err := store.Append("uni.example", map[string]*DayStats{
+        previousDay: testDay(4, map[string][]string{
+            "alice": {"sp1.example.org", "sp2.example.org"},
+            "bob":   {"sp2.example.org"},
+            "carol": {"sp3.example.org"},
+        }),
+        // Before the previous period
+        timeRange.StartDate.AddDate(0, 0, -4).Format(DateFormat): testDay(1, map[string][]string{
+            "dave": {"sp4.example.org"},
+        }),
+    }, "run-1"); err != nil {
+        t.Fatal(err)
+    }
+
+    result := &Result{Providers: map[string]*ProviderStats{"sp1.example.org": {}}}
+    report, err := FindLostProvidersInStore(store, "uni.example", result, timeRange)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if report == nil || report.Source != LostSourceStore || report.StoredDays != 1 || len(report.Providers) != 2 {
+        t.Fatalf("report: %+v", report)
+    }
+    if lost := report.Providers[0]; lost.Provider != "sp2.example.org" || lost.Users != 2 || lost.LastSeen != previousDay {
+        t.Errorf("first lost provider: %+v", lost)
+    }
+    if lost := report.Providers[1]; lost.Provider != "sp3.example.org" || lost.Users != 1 {
+        t.Errorf("second lost provider: %+v", lost)
+    }
+}
@@ -1,6 +1,6 @@
 /*
 Program: eduroam-idp (Identity Provider Accept Analysis)
-Version: 2.2.0.2
+Version: 2.3.0.0
 Description: This program aggregates Access-Accept events for users from a specified domain
              using the Quickwit search engine's aggregation capabilities. It collects data 
              over a specified time range, processes the results, and outputs the aggregated 
@@ -18,10 +18,112 @@ Features:
 - Optimized concurrent processing with worker pools
 - Flexible time range specification: days, years, specific year, or specific date
 - Real-time progress reporting with accurate hit counts
-- Multiple output formats (JSON, CSV)
+- Multiple output formats (JSON, CSV) through pluggable exporters
 - Streamlined output format focusing on essential information
 - Enhanced performance through code optimization
 
+Changes in version 2.3.0.0:
+- Added HTTP status listener (-listen) streaming live progress as Server-Sent Events
+- Added the serve command, a gRPC API (Analyze, GetRun, ListRuns, StreamProgress) over the analysis, the run history and the progress events, published in api/eduroamidp.proto
+- Added pluggable exporters; -format accepts several formats (e.g. json,csv)
+- Added -template to render custom reports with Go text/template
+- Added -locale for thousand separators and date ordering in human-facing output
+- Properties file is also looked up in the user config dir; outputs default to the user data dir
+- Added "version" subcommand and run_info output section with build provenance
+- Added -benchmark to measure query latency per worker count and recommend NUM_WORKERS
+- Added -pprof, -cpuprofile and -memprofile for profiling
+- SIGUSR1 logs a status snapshot (progress, in-flight queries, memory) without stopping the run
+- SIGINT/SIGTERM now save a partial output listing the unprocessed days
+- Added -audit-log to keep an append-only record of every Quickwit query; a run that could not write all its records exits with status 9
+- Added -store to accumulate per-day aggregates locally and "report -from-store" to query them
+- Overlapping days in the store are deduplicated (latest run wins) instead of double counted
+- Added "realms" subcommand to discover realms with data and their hit counts
+- Added -include-subrealms and "*.suffix" domains to aggregate several realms with a per-realm breakdown
+- Added -realm-alias to report several realms as one institution
+- Domain shortcuts are read from ALIAS.<name> lines in the properties file; added -no-prefix
+- Added -pivot sp to report the users and realms visiting a service provider
+- Added a per-day time series ("daily" in JSON, -daily.csv) of unique users, providers and hits; the per-day user/provider pairs are only kept when -store, -impossible-travel, a section or a format needs them
+- Added -provider-timeseries for daily user and hit counts per provider
+- Added a mobility view: users by number of distinct providers and the most common provider pairs
+- Added a per-user mobility score (-mobility-formula) with its distribution and the top roamers
+- User and provider statistics include hit counts, not only membership
+- Added auths-per-user statistics (mean, median, p95) and histogram
+- Added -fields to select the output sections written
+- Added -sort-users and -sort-providers; CSV lists follow the same order as JSON
+- Outputs include changes_since_last_run against the previous output of the same range type
+- Added -watch to refresh the current day on an interval and rewrite a rolling output
+- Watch mode supports systemd Type=notify, the systemd watchdog and SIGHUP configuration reload
+- The -listen status server exposes /healthz and /readyz (Quickwit reachability and configuration)
+- Provider statistics include auths per day and per hour over the queried range
+- Added "realms -similar-to" to flag look-alike (typo) realms; sub-realm runs warn about them too
+- Added NAI syntax validation (RFC 7542) of usernames with counts per issue
+- Added -count-local to summarise the excluded service_provider "client" traffic
+- Exclusions are configured with EXCLUDE lines (field:value or field:/regex/) and listed in query_info
+- Added -profile to select PROFILE.<name>.* settings (credentials, QW_INDEX, EXCLUDE, OUTPUT_DIR, WATCH)
+- QW_URL accepts several comma-separated searchers with failover or round-robin (QW_BALANCE)
+- Added -http-cache-ttl to answer repeated Quickwit queries from an on-disk cache (keyed by cluster, user, profile, environment, index and query)
+- Ranges of up to -single-query-days days (default 7) are fetched with a single query
+- Added -auto-chunk to probe daily hit counts and fetch sparse weeks or months with one query each
+- Added -verify to compare the aggregated hits per day with count-only queries
+- Added -data-quality to report missing usernames and service providers and future timestamps per day
+- Events stamped beyond -max-clock-skew in the future are excluded from the statistics and counted
+- Internationalized realms are queried in both their punycode and Unicode forms and reported in Unicode
+- Added -realm-ci to match every case variant of the realm
+- Added -public (and -public-countries) for a publishable output without usernames
+- Added the "nro" output format with the monthly national/international roaming statistics
+- Added -input to build reports from FreeRADIUS detail or radsecproxy log files without Quickwit (radsecproxy lines with traditional or RFC 3339 syslog timestamps; unreadable events are counted and reported, and a file without a readable event fails the run)
+- -input also reads ndjson and csv dumps, with field names set by -input-map
+- Added the index create command to set up the Quickwit index with fast fields for the analysed fields
+- Added the backfill command to run the (domain, range) entries of a manifest with bounded parallelism and resume (absolute ranges only, so a resumed entry covers the same days; cancelling interrupts the runs so they save their partial results)
+- Added the provider command with daily hits, unique users and first/last seen of one service provider
+- The summary splits users, providers and hits into national and international roaming; COUNTRY.<provider> properties override the TLD-based country
+- Added the geojson format with the visited providers located by -provider-locations, weighted by users
+- Added the rollup command for a monthly, quarterly or yearly trend report (CSV and HTML charts) from the local store
+- Added -forecast to project daily unique users and hits for the next quarter (Holt-Winters) with confidence bands
+- Added the outliers section listing users with more than -outlier-factor times the median auths
+- Added the bursts command reporting Access-Reject bursts against many usernames from one provider (there is no reject mode for the main analysis yet)
+- Added -impossible-travel, a security section flagging users seen at distant providers faster than -travel-speed allows
+- Added the multi_provider section listing users seen at more than -multi-provider-min providers on the same day
+- Added -deterministic for outputs committed to version control: fixed name order, no export time and stable, overwritten file names
+- Added -force and -no-clobber; existing output files of a run are no longer silently replaced
+- Added -bundle to pack the output files of a run with a manifest.json into one .tar.gz
+- Added -encrypt age:<recipient> or gpg:<key id> to encrypt the written reports with the installed age or gpg
+- Added -checksums and -sign (minisign or gpg) for a signed SHA-256 sums file of the outputs, and the verify command to check them (-pubkey or -signer, the expected gpg fingerprint, require a signature)
+- Added -upload to copy the output files to an SFTP or WebDAV UPLOAD_URL configured in the properties file
+- Added the bigquery format streaming per-day user/provider rows into the BQ_PROJECT/BQ_DATASET/BQ_TABLE table (insert IDs derived from domain, day, user and provider; the token is refreshed on 401)
+- Added -publish sending a run summary and per-provider events to a NATS subject or a Kafka topic (REST Proxy)
+- Added the star format: fact_activity, dim_user, dim_provider and dim_date CSVs for Power BI and Tableau
+- Added -message-type (accept, reject, challenge or accounting) to run the analysis on other RADIUS message types
+- Added -sample N, a samples debug section with the most recent raw events (timestamps, station ids) of every provider
+- Added -timing, a breakdown of Quickwit latency, processing and idle time, the slowest days and per-worker throughput, also in run_info
+- Added -debug-http to dump the sanitized requests and responses of failed Quickwit queries, rotated by size
+- Made the clock (Config.Clock) and the HTTP backend (HTTPClient.SetBackend) injectable, with unit tests of range parsing, job generation and the worker pipeline
+- Stored the users of a provider, the providers of a user and the per-day user/provider pairs as compact sets of IDs interned per run (NameSet, PairSet) instead of maps, cutting the memory of large realms
+- Added -processors: results are aggregated by one processor per CPU core, each owning a share of the users, and merged at the end
+- Decoded every Quickwit search response once into typed aggregations and buckets: malformed buckets are skipped with a warning and unexpected shapes are errors instead of panics
+- Added -job-order: days are now fetched newest first by default, so interrupted runs keep the most recent days
+- Added the gaps command listing days missing from the local store or with anomalously few hits, with -backfill to fetch them again
+- Added the batch command analysing a list of domains with a per-domain status file, so interrupted runs resume with the unfinished domains, and a final status report
+- Runs of 31 days or more are estimated with a count query first (hits, and run time and output size calibrated on earlier runs); runs above -confirm-hits ask for confirmation unless started with -yes
+- Added -env and ENV.<name>.* settings selecting one of several Quickwit clusters in the configuration file (DEFAULT_ENV when -env is not given; required once environments are defined); the environment is recorded in run_info
+- Every run is recorded in a run history next to the local store; "runs list" shows past runs with their range, totals and duration, "runs show <id>" reprints the summary and output paths of one
+- Added the export command writing the JSON output of an earlier run in other formats (export -from run.json -format csv) without querying Quickwit again
+- The domain argument is checked for realm syntax, runs without hits list similar realms of the index, and -check-domain verifies the realm has hits before running
+- Added -fail-on-empty, exiting with status 3 when a run finds no users, and -skip-empty-output to write no output files for such runs
+- Added the adoption percentage (unique users of the population) to the summary for domains with a HEADCOUNT.<domain> setting or -headcount
+- Added the digest command, mailing one summary of the runs of all domains in the last week (latest figures, changes against the previous week, partial and empty runs, domains no longer analysed) through the SMTP_HOST, SMTP_USER, SMTP_PASS, MAIL_FROM and MAIL_TO settings
+- Added -baseline, also querying the window right before the range (e.g. -baseline 30d) and adding the percentage changes of users, providers, hits, hits per day and mean daily users to the summary
+- Added the provider_timeline section listing providers by first appearance in the range; with -store and in report -from-store, providers never seen before the range are marked new
+- Added the lost_providers section listing providers of the previous period (the -baseline window, or with -store the stored days of the period before the range) that are missing from the range, a hint of broken RADIUS peerings
+- Added the html format, a self-contained report with the run totals and a calendar heat strip of the daily users of all providers and of the top -heat-providers providers, so gaps in service stand out
+- Added -pack and PACK.<name>.<flag>=<value> lines defining named report packs (formats, sections, filters, publishing) in the configuration file
+- Added -stable-ids: public outputs keep the user list with usernames replaced by HMAC-SHA256 identifiers under a private key (PSEUDONYM_KEY, created on first use), so users can be joined across runs; query_info.user_ids names the key fingerprint
+- Added -max-output-size (e.g. 100MB): larger JSON outputs are cut down to the summary and the top -max-output-top users and providers, recorded in query_info.truncated
+- Added -users-per-file: long user lists of the JSON output are written to numbered <name>-users-NNNN.json files listed in a <name>-users-index.json (user_stats_index in the output); the export command and the previous-run comparison read them back
+- The end of a run prints the peak RSS, peak heap, GC cycles and pauses and the high-water mark of the result channel backlog; they are recorded in run_info.resources and the run history
+- Results the processors cannot take yet are queued in memory up to -result-buffer-max entries (default 50,000, about 5 MB) instead of stalling the workers; a full queue, or processing lagging the queries for 30 seconds, is logged, and the queue statistics are in run_info.backpressure
+- Failures are classified (authentication, missing index, timeout, partial range, truncated user buckets) with exit statuses 4 to 8 and an error_class field in the log, the audit log and progress events; days with users beyond the bucket limit are listed in query_info.bucket_truncated_days; batch, backfill and gaps -backfill record runs exiting 7 as partial (retried on resume) and 8 as truncated (kept) instead of failed
+
 Changes in version 2.2.0.2:
 - Added support for yxxxx parameter to specify a specific year (e.g., y2024)
 - Added CSV export option with -format flag
@@ -48,6 +150,7 @@ package main
 
 import (
     "bufio"
+    "bytes"
     "context"
     "encoding/csv"
     "encoding/json"
@@ -60,7 +163,7 @@ import (
     "os"
     "os/signal"
     "path/filepath"
-    "sort"
+    "slices"
     "strconv"
     "strings"
     "sync"
@@ -102,8 +205,25 @@ const (
     
     // DefaultOutputFormat is the default output file format
     DefaultOutputFormat = "json"
+    
+    // DomainPrefix is prepended to domains that are not aliases
+    DomainPrefix = "eduroam."
+
+    // DefaultIndex is the Quickwit index searched unless QW_INDEX is set
+    DefaultIndex = "nro-logs"
+
+    // ExitEmptyResult is the exit status of -fail-on-empty runs that found
+    // no users (1 is used for other errors, 2 for usage errors; the failure
+    // classes of ExitCode use 4 and up)
+    ExitEmptyResult = 3
 )
 
+// DefaultDomainAliases are the built-in domain shortcuts
+var DefaultDomainAliases = map[string]string{
+    "etlr1": "etlr1.eduroam.org",
+    "etlr2": "etlr2.eduroam.org",
+}
+
 var (
     // ErrMissingConfiguration indicates missing required configuration
     ErrMissingConfiguration = errors.New("missing required configuration")
@@ -116,6 +236,13 @@ var (
     
     // ErrInvalidOutputFormat indicates an invalid output format was specified
     ErrInvalidOutputFormat = errors.New("invalid output format")
+
+    // ErrUnknownProfile indicates a -profile with no lines in the properties file
+    ErrUnknownProfile = errors.New("unknown profile")
+
+    // ErrUnknownEnvironment indicates an -env with no ENV.<name> lines, or
+    // a missing environment when the properties file defines several
+    ErrUnknownEnvironment = errors.New("unknown environment")
 )
 
 // Properties represents the authentication properties for Quickwit API
@@ -123,6 +250,56 @@ type Properties struct {
     QWUser string
     QWPass string
     QWURL  string
+    // Aliases maps domain shortcuts to realms (ALIAS.<name>=<realm> lines);
+    // configured entries override DefaultDomainAliases
+    Aliases map[string]string
+    // Exclusions holds the raw EXCLUDE lines (see ParseExclusions)
+    Exclusions []string
+    // Index is the Quickwit index searched (QW_INDEX, default DefaultIndex)
+    Index string
+    // Balance selects how several comma-separated QW_URLs are used
+    // (BalanceFailover or BalanceRoundRobin)
+    Balance string
+    // OutputDir and Watch are defaults for -output-dir and -watch
+    OutputDir string
+    Watch     time.Duration
+    // Countries maps providers to country codes (COUNTRY.<provider>=<CC>
+    // lines), overriding the TLD heuristic of ProviderCountry
+    Countries CountryMap
+    // UploadURL is the sftp:// or WebDAV http(s):// target of -upload,
+    // with UploadUser, UploadPass (WebDAV) and UploadKey (sftp identity)
+    UploadURL  string
+    UploadUser string
+    UploadPass string
+    UploadKey  string
+    // BQProject, BQDataset and BQTable name the table of the bigquery
+    // format, BQCredentials is a service account key file; BQEndpoint
+    // overrides the API root
+    BQProject     string
+    BQDataset     string
+    BQTable       string
+    BQCredentials string
+    BQEndpoint    string
+    // PublishURL is the NATS subject or Kafka REST Proxy topic of -publish
+    PublishURL string
+    // SMTPHost (host:port), SMTPUser and SMTPPass are the mail server of
+    // the digest, sent from MailFrom to the comma-separated MailTo
+    SMTPHost string
+    SMTPUser string
+    SMTPPass string
+    MailFrom string
+    MailTo   string
+    // Headcounts maps domains to the size of their population
+    // (HEADCOUNT.<domain>=<n> lines), the base of the adoption percentage
+    Headcounts map[string]int
+    // PseudonymKey is the key file of -stable-ids (PSEUDONYM_KEY, default
+    // PseudonymKeyFile in the user config dir)
+    PseudonymKey string
+    // Env is the environment (cluster) whose ENV.<name>.* lines were
+    // applied, empty when the file defines none
+    Env string
+    // Profile is the -profile whose PROFILE.<name>.* lines were applied
+    Profile string
 }
 
 // LogEntry represents a single log entry from Quickwit search results
@@ -130,20 +307,74 @@ type LogEntry struct {
     Username        string    `json:"username"`
     ServiceProvider string    `json:"service_provider"`
     Timestamp       time.Time `json:"timestamp"`
+    Realm           string    `json:"realm,omitempty"`
+    // Hits is the number of events the entry stands for; when a bucket is
+    // emitted as several entries only the first one carries the count
+    Hits            int64     `json:"hits,omitempty"`
 }
 
 // UserStats contains statistics for a user
 type UserStats struct {
-    Providers map[string]bool
+    Providers NameSet
     FirstSeen time.Time
     LastSeen  time.Time
+    Hits      int64
 }
 
 // ProviderStats contains statistics for a service provider
 type ProviderStats struct {
-    Users     map[string]bool
+    Users     NameSet
     FirstSeen time.Time
     LastSeen  time.Time
+    Hits      int64
+}
+
+// DayStats contains the activity of a single day: the users and providers
+// seen, which user was at which provider, and the day's hit count
+type DayStats struct {
+    Users        NameSet
+    Providers    NameSet
+    // Visits are the user/provider pairs of the day
+    Visits       PairSet
+    Hits         int64
+    // ProviderHits is the day's hit count per provider
+    ProviderHits map[string]int64
+}
+
+// Add records that username was seen at provider on the day
+func (d *DayStats) Add(username, provider string) {
+    d.addActivity(username, provider, true)
+}
+
+// addActivity records username and provider on the day, and their pair
+// when visits is set
+func (d *DayStats) addActivity(username, provider string, visits bool) {
+    d.Users.Add(username)
+    d.Providers.Add(provider)
+    if visits {
+        d.Visits.Add(username, provider)
+    }
+}
+
+// Merge adds the activity of other to the day
+func (d *DayStats) Merge(other *DayStats) {
+    d.Users.Merge(other.Users)
+    d.Providers.Merge(other.Providers)
+    d.Visits.Merge(other.Visits)
+    if len(other.ProviderHits) > 0 && d.ProviderHits == nil {
+        d.ProviderHits = make(map[string]int64, len(other.ProviderHits))
+    }
+    for provider, hits := range other.ProviderHits {
+        d.ProviderHits[provider] += hits
+    }
+    d.Hits += other.Hits
+}
+
+// RealmStats contains statistics for a realm when results are broken down
+// by sub-realm
+type RealmStats struct {
+    Users NameSet
+    Hits  int64
 }
 
 // Result holds the aggregated results
@@ -153,7 +384,53 @@ type Result struct {
     StartDate time.Time
     EndDate   time.Time
     TotalHits int64
-    mu        sync.RWMutex
+    // Partial is set when the run was interrupted before all days were
+    // processed; UnprocessedDays then lists the missing dates
+    Partial         bool
+    UnprocessedDays []string
+    // TruncatedDays lists the dates whose users exceeded the bucket limit
+    // of the query, so that some users of those days are missing
+    TruncatedDays   []string
+    // Days holds per-day activity keyed by date (DateFormat)
+    Days            map[string]*DayStats
+    // DayVisits keeps the user/provider pairs of the days (DayStats.Visits)
+    // while processing; without it the days hold their users and providers
+    DayVisits       bool
+    // Realms holds the per-realm breakdown when the query spans several realms
+    Realms          map[string]*RealmStats
+    // Exclusions are the rules the result was filtered with
+    Exclusions      []ExclusionRule
+    // FoldRealmCase merges case variants in the realm breakdown
+    FoldRealmCase   bool
+    // Local is the excluded local traffic, when it was counted
+    Local           *LocalTraffic
+    // Verification compares the hits with count-only queries (-verify)
+    Verification    *VerificationReport
+    // DataQuality counts problem records per day (-data-quality)
+    DataQuality     *DataQualityReport
+    // Forecast projects the daily series ahead (-forecast)
+    Forecast        *Forecast
+    // Security holds the impossible-travel incidents (-impossible-travel)
+    Security        *SecurityReport
+    // Samples are raw events per provider (-sample)
+    Samples         []ProviderSample
+    // Timing is the query latency breakdown of the run (-timing)
+    Timing          *TimingReport
+    // Resources is the memory use of the run up to the export
+    Resources       *ResourceReport
+    // Backpressure is the instrumentation of the result queue
+    Backpressure    *BackpressureStats
+    // TimestampCutoff is the latest event timestamp included (0 for none)
+    // and FutureEvents the number of later events excluded as clock skew
+    TimestampCutoff int64
+    FutureEvents    int64
+    // Pivot is PivotSP when Providers holds the realms of visitors to a
+    // service provider rather than service providers
+    Pivot           string
+    // Saved is the JSON output a result was rebuilt from (export -from);
+    // with no Days, the sections derived from them are taken from it
+    Saved           *SimplifiedOutputData
+    mu              sync.RWMutex
 }
 
 // SimplifiedOutputData represents the output JSON structure
@@ -164,25 +441,70 @@ type SimplifiedOutputData struct {
         StartDate string `json:"start_date"`
         EndDate   string `json:"end_date"`
         TotalHits int64  `json:"total_hits"`
+        Partial         bool     `json:"partial,omitempty"`
+        UnprocessedDays []string `json:"unprocessed_days,omitempty"`
+        // TruncatedDays are days with users beyond the bucket limit
+        TruncatedDays   []string `json:"bucket_truncated_days,omitempty"`
+        Pivot           string   `json:"pivot,omitempty"`
+        Exclusions      []string `json:"exclusions,omitempty"`
+        // FutureEvents were excluded for timestamps after the clock skew cutoff
+        FutureEvents    int64    `json:"excluded_future_events,omitempty"`
+        MessageType     string   `json:"message_type"`
+        // UserIDs names the identifier scheme and key fingerprint of the
+        // usernames in a -stable-ids output
+        UserIDs         string   `json:"user_ids,omitempty"`
+        // Truncation is set when the output exceeded -max-output-size
+        Truncation      *OutputTruncation `json:"truncated,omitempty"`
     } `json:"query_info"`
+    RunInfo       RunInfo `json:"run_info"`
     Description   string `json:"description"`
-    Summary       struct {
-        TotalUsers     int `json:"total_users"`
-        TotalProviders int `json:"total_providers"`
-    } `json:"summary"`
+    Summary       *OutputSummary `json:"summary,omitempty"`
     ProviderStats []struct {
-        Provider  string   `json:"provider"`
-        UserCount int      `json:"user_count"`
-        Users     []string `json:"users"`
-        FirstSeen string   `json:"first_seen,omitempty"`
-        LastSeen  string   `json:"last_seen,omitempty"`
-    } `json:"provider_stats"`
+        Provider     string   `json:"provider"`
+        UserCount    int      `json:"user_count"`
+        Hits         int64    `json:"hits"`
+        AuthsPerDay  float64  `json:"auths_per_day"`
+        AuthsPerHour float64  `json:"auths_per_hour"`
+        Users        []string `json:"users,omitempty"`
+        FirstSeen    string   `json:"first_seen,omitempty"`
+        LastSeen     string   `json:"last_seen,omitempty"`
+    } `json:"provider_stats,omitempty"`
     UserStats []struct {
         Username  string   `json:"username"`
         Providers []string `json:"providers"`
+        Hits      int64    `json:"hits"`
         FirstSeen string   `json:"first_seen,omitempty"`
         LastSeen  string   `json:"last_seen,omitempty"`
-    } `json:"user_stats"`
+    } `json:"user_stats,omitempty"`
+    // UserStatsIndex names the index file of a user list split into
+    // numbered files by -users-per-file
+    UserStatsIndex string `json:"user_stats_index,omitempty"`
+    Subrealms     []SubrealmStat       `json:"subrealms,omitempty"`
+    Daily         []DailyStat          `json:"daily,omitempty"`
+    ProviderDaily []ProviderDailyStats `json:"provider_daily,omitempty"`
+    Mobility      *MobilityStats       `json:"mobility,omitempty"`
+    AuthsPerUser  *AuthDistribution    `json:"auths_per_user,omitempty"`
+    Changes       *ChangesSinceLastRun `json:"changes_since_last_run,omitempty"`
+    NAIValidation *NAIReport           `json:"nai_validation,omitempty"`
+    LocalTraffic  *LocalTraffic        `json:"local_traffic,omitempty"`
+    Verification  *VerificationReport  `json:"verification,omitempty"`
+    DataQuality   *DataQualityReport   `json:"data_quality,omitempty"`
+    Forecast      *Forecast            `json:"forecast,omitempty"`
+    Outliers      *OutlierReport       `json:"outliers,omitempty"`
+    Security      *SecurityReport      `json:"security,omitempty"`
+    Samples       []ProviderSample     `json:"samples,omitempty"`
+    MultiProvider *MultiProviderReport `json:"multi_provider,omitempty"`
+    Timeline      *ProviderTimeline    `json:"provider_timeline,omitempty"`
+    LostProviders *LostProviderReport  `json:"lost_providers,omitempty"`
+}
+
+// OutputSummary holds the totals of the output JSON
+type OutputSummary struct {
+    TotalUsers     int                 `json:"total_users"`
+    TotalProviders int                 `json:"total_providers"`
+    Roaming        *RoamingSplit       `json:"roaming_split,omitempty"`
+    Adoption       *Adoption           `json:"adoption,omitempty"`
+    Baseline       *BaselineComparison `json:"baseline,omitempty"`
 }
 
 // TimeRange represents the time range specification
@@ -206,6 +528,7 @@ type Job struct {
 type QueryStats struct {
     ProcessedDays atomic.Int32
     TotalHits     atomic.Int64
+    InFlight      atomic.Int32
 }
 
 // Config holds the configuration for the program
@@ -216,12 +539,73 @@ type Config struct {
     LogFile      string
     NumWorkers   int
     TimeRange    TimeRange
+    Query        QueryOptions
+    // SingleQueryDays is the longest range fetched with one query
+    // (RangeWorker) instead of one job per day; 0 always queries per day
+    SingleQueryDays int
+    // AutoChunk probes the daily hit counts of longer ranges and fetches
+    // sparse weeks or months with one query each, within ChunkHitBudget
+    AutoChunk      bool
+    ChunkHitBudget int64
+    // MaxClockSkew is how far in the future event timestamps are trusted;
+    // later events are excluded and counted. Negative trusts every timestamp.
+    MaxClockSkew time.Duration
+    // Timing records the latency of every batch into Result.Timing
+    Timing bool
+    // Clock tells the time of the run (nil: SystemClock)
+    Clock Clock
+    // JobOrder is the order the days are fetched in (JobOrderNewest when
+    // empty)
+    JobOrder string
+    // Processors is the number of goroutines aggregating the results,
+    // each one owning a share of the users; below 2 a single one
+    Processors int
+    // Monitor samples the backlog of the result channel (nil: not sampled)
+    Monitor *ResourceMonitor
+    // ResultBufferMax is the number of results queued beyond the channel
+    // buffer while the processors lag, before the queries wait for them
+    ResultBufferMax int
+    // DayVisits keeps the per-day user/provider pairs (NeedsDayVisits)
+    DayVisits bool
+    // Quiet leaves out the progress line on stdout, e.g. for the analyses
+    // of the gRPC server, whose progress is streamed instead
+    Quiet bool
+}
+
+// now returns the current time of the configured clock
+func (config Config) now() time.Time {
+    if config.Clock == nil {
+        return SystemClock.Now()
+    }
+    return config.Clock.Now()
+}
+
+// QueryOptions selects optional parts of the per-day aggregation query
+type QueryOptions struct {
+    // RealmBreakdown adds a realm sub-aggregation per user so results can be
+    // broken down by (sub-)realm
+    RealmBreakdown bool
+    // Pivot selects what is aggregated under each user (PivotIdP or PivotSP)
+    Pivot          string
+    // Exclusions are the active exclusion rules; value rules must already be
+    // part of the query, regex rules are applied to the results
+    Exclusions     []ExclusionRule
+    // FoldRealmCase reports case variants of a realm as one realm
+    FoldRealmCase  bool
 }
 
 // HTTPClient is a wrapper around the standard http.Client with authentication
 type HTTPClient struct {
-    client *http.Client
-    props  Properties
+    client  HTTPBackend
+    propsMu sync.RWMutex
+    props   Properties
+    audit   *AuditLog
+    debug   *HTTPDebugLog
+    cache   *ResponseCache
+    // preferred is the index of the searcher that last answered (failover),
+    // next the round-robin counter
+    preferred atomic.Int32
+    next      atomic.Uint32
 }
 
 // NewHTTPClient creates a new HTTP client with the given properties
@@ -243,8 +627,51 @@ func NewHTTPClient(props Properties) *HTTPClient {
     }
 }
 
+// SetBackend sends subsequent requests through backend instead of the
+// default *http.Client
+func (c *HTTPClient) SetBackend(backend HTTPBackend) {
+    c.client = backend
+}
+
+// SetProperties replaces the connection properties used by subsequent
+// queries, e.g. after the properties file was reloaded
+func (c *HTTPClient) SetProperties(props Properties) {
+    c.propsMu.Lock()
+    defer c.propsMu.Unlock()
+    c.props = props
+}
+
+// properties returns the current connection properties
+func (c *HTTPClient) properties() Properties {
+    c.propsMu.RLock()
+    defer c.propsMu.RUnlock()
+    return c.props
+}
+
+// SetResponseCache answers repeated queries from the given cache
+func (c *HTTPClient) SetResponseCache(cache *ResponseCache) {
+    c.cache = cache
+}
+
+// SetAuditLog records every subsequent query in the given audit log
+func (c *HTTPClient) SetAuditLog(audit *AuditLog) {
+    c.audit = audit
+}
+
+// SetHTTPDebugLog dumps the requests and responses of failed queries to
+// the given capture file
+func (c *HTTPClient) SetHTTPDebugLog(debug *HTTPDebugLog) {
+    c.debug = debug
+}
+
 // SendQuickwitRequest handles HTTP communication with Quickwit
-func (c *HTTPClient) SendQuickwitRequest(ctx context.Context, query map[string]interface{}) (map[string]interface{}, error) {
+func (c *HTTPClient) SendQuickwitRequest(ctx context.Context, query map[string]interface{}) (result *SearchResponse, err error) {
+    start := time.Now()
+    props := c.properties()
+    defer func() {
+        c.audit.Record(props.QWUser, query, result, time.Since(start), err)
+    }()
+
     jsonQuery, err := json.Marshal(query)
     if err != nil {
         return nil, fmt.Errorf("error marshaling query: %w", err)
@@ -255,51 +682,111 @@ func (c *HTTPClient) SendQuickwitRequest(ctx context.Context, query map[string]i
         log.Printf("Query: %s", string(jsonQuery))
     }
 
-    req, err := http.NewRequestWithContext(ctx, "POST", c.props.QWURL+"/api/v1/nro-logs/search", strings.NewReader(string(jsonQuery)))
+    if cached, ok := c.cache.Get(props, jsonQuery); ok {
+        return cached, nil
+    }
+
+    urls := c.searchOrder(props)
+    if len(urls) == 0 {
+        return nil, ErrMissingConfiguration
+    }
+    for i, url := range urls {
+        result, err = c.search(ctx, props, url, jsonQuery)
+        if err == nil {
+            c.markAnswered(props, url)
+            if err := c.cache.Put(props, jsonQuery, result); err != nil {
+                log.Printf("Error caching response: %v", err)
+            }
+            return result, nil
+        }
+        if !errors.Is(err, ErrSearcherUnavailable) || ctx.Err() != nil || i == len(urls)-1 {
+            return nil, err
+        }
+        log.Printf("Quickwit searcher %s failed, trying %s: %v", url, urls[i+1], err)
+    }
+    return nil, err
+}
+
+// search sends one search request to the searcher at baseURL
+func (c *HTTPClient) search(ctx context.Context, props Properties, baseURL string, jsonQuery []byte) (result *SearchResponse, err error) {
+    req, err := http.NewRequestWithContext(ctx, "POST", baseURL+"/api/v1/"+props.Index+"/search", bytes.NewReader(jsonQuery))
     if err != nil {
         return nil, fmt.Errorf("error creating request: %w", err)
     }
 
-    req.SetBasicAuth(c.props.QWUser, c.props.QWPass)
+    req.SetBasicAuth(props.QWUser, props.QWPass)
     req.Header.Set("Content-Type", "application/json")
     req.Header.Set("Accept", "application/json")
 
-    resp, err := c.client.Do(req)
+    start := time.Now()
+    var resp *http.Response
+    var bodyBytes []byte
+    defer func() {
+        if err != nil {
+            c.debug.Capture(req, jsonQuery, resp, bodyBytes, time.Since(start), err)
+        }
+    }()
+
+    resp, err = c.client.Do(req)
     if err != nil {
-        return nil, fmt.Errorf("error sending request: %w", err)
+        if isTimeout(err) {
+            return nil, fmt.Errorf("%w: %w: error sending request: %w", ErrSearcherUnavailable, ErrTimeout, err)
+        }
+        return nil, fmt.Errorf("%w: error sending request: %w", ErrSearcherUnavailable, err)
     }
     defer resp.Body.Close()
 
-    bodyBytes, err := io.ReadAll(resp.Body)
+    bodyBytes, err = io.ReadAll(resp.Body)
     if err != nil {
-        return nil, fmt.Errorf("error reading response: %w", err)
+        if isTimeout(err) {
+            return nil, fmt.Errorf("%w: %w: error reading response: %w", ErrSearcherUnavailable, ErrTimeout, err)
+        }
+        return nil, fmt.Errorf("%w: error reading response: %w", ErrSearcherUnavailable, err)
     }
 
+    switch {
+    case resp.StatusCode == http.StatusGatewayTimeout:
+        return nil, fmt.Errorf("%w: %w: quickwit error (status %d): %s", ErrSearcherUnavailable, ErrTimeout, resp.StatusCode, string(bodyBytes))
+    case resp.StatusCode >= http.StatusInternalServerError:
+        return nil, fmt.Errorf("%w: quickwit error (status %d): %s", ErrSearcherUnavailable, resp.StatusCode, string(bodyBytes))
+    case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+        return nil, fmt.Errorf("%w (status %d): %s", ErrAuth, resp.StatusCode, string(bodyBytes))
+    case resp.StatusCode == http.StatusNotFound:
+        return nil, fmt.Errorf("%w: %s (status %d): %s", ErrIndexNotFound, props.Index, resp.StatusCode, string(bodyBytes))
+    }
     if resp.StatusCode != http.StatusOK {
         return nil, fmt.Errorf("quickwit error (status %d): %s", resp.StatusCode, string(bodyBytes))
     }
 
-    var result map[string]interface{}
     if err := json.Unmarshal(bodyBytes, &result); err != nil {
-        return nil, fmt.Errorf("error decoding response: %w", err)
+        return nil, fmt.Errorf("%w: error decoding response: %w", ErrUnexpectedResponse, err)
+    }
+    if result == nil {
+        return nil, fmt.Errorf("%w: empty response", ErrUnexpectedResponse)
     }
 
-    if errorMsg, hasError := result["error"].(string); hasError {
-        return nil, fmt.Errorf("quickwit error: %s", errorMsg)
+    if result.Error != "" {
+        return nil, fmt.Errorf("quickwit error: %s", result.Error)
     }
 
     return result, nil
 }
 
-// ReadProperties reads the authentication properties from a file
-func ReadProperties(filePath string) (Properties, error) {
+// ReadProperties reads the authentication properties from a file. When
+// profile is set, its PROFILE.<profile>.<KEY> lines override the top-level
+// keys; EXCLUDE lines of the profile replace the top-level ones. When the
+// file defines environments (ENV.<name>.<KEY> lines, typically QW_URL and
+// credentials per cluster), one must be selected by env or DEFAULT_ENV; its
+// lines override the profile.
+func ReadProperties(filePath, profile, env string) (Properties, error) {
     file, err := os.Open(filePath)
     if err != nil {
         return Properties{}, fmt.Errorf("failed to open properties file: %w", err)
     }
     defer file.Close()
 
-    props := Properties{}
+    var base, overrides [][2]string
+    envs := make(map[string][][2]string)
     scanner := bufio.NewScanner(file)
     for scanner.Scan() {
         line := scanner.Text()
@@ -308,14 +795,22 @@ func ReadProperties(filePath string) (Properties, error) {
             if len(parts) == 2 {
                 key := strings.TrimSpace(parts[0])
                 value := strings.TrimSpace(parts[1])
-                switch key {
-                case "QW_USER":
-                    props.QWUser = value
-                case "QW_PASS":
-                    props.QWPass = value
-                case "QW_URL":
-                    props.QWURL = strings.TrimPrefix(value, "=")
+                if rest, ok := strings.CutPrefix(key, "PROFILE."); ok {
+                    if name, key, ok := strings.Cut(rest, "."); ok && name == profile && profile != "" {
+                        overrides = append(overrides, [2]string{key, value})
+                    }
+                    continue
+                }
+                if rest, ok := strings.CutPrefix(key, "ENV."); ok {
+                    if name, key, ok := strings.Cut(rest, "."); ok && name != "" {
+                        envs[name] = append(envs[name], [2]string{key, value})
+                    }
+                    continue
                 }
+                if key == "DEFAULT_ENV" && env == "" {
+                    env = value
+                }
+                base = append(base, [2]string{key, value})
             }
         }
     }
@@ -323,6 +818,45 @@ func ReadProperties(filePath string) (Properties, error) {
     if err := scanner.Err(); err != nil {
         return Properties{}, fmt.Errorf("error reading properties file: %w", err)
     }
+    if profile != "" && len(overrides) == 0 {
+        return Properties{}, fmt.Errorf("%w: %q", ErrUnknownProfile, profile)
+    }
+    envOverrides, ok := envs[env]
+    if !ok && (env != "" || len(envs) > 0) {
+        names := make([]string, 0, len(envs))
+        for name := range envs {
+            names = append(names, name)
+        }
+        slices.Sort(names)
+        if env == "" {
+            return Properties{}, fmt.Errorf("%w: select one with -env or DEFAULT_ENV (available: %s)", ErrUnknownEnvironment, strings.Join(names, ", "))
+        }
+        return Properties{}, fmt.Errorf("%w: %q (available: %s)", ErrUnknownEnvironment, env, strings.Join(names, ", "))
+    }
+
+    props := Properties{Index: DefaultIndex, Balance: BalanceFailover, Aliases: make(map[string]string), Countries: make(CountryMap)}
+    for _, kv := range base {
+        if err := props.set(kv[0], kv[1]); err != nil {
+            return Properties{}, err
+        }
+    }
+    for _, layer := range [][][2]string{overrides, envOverrides} {
+        for _, kv := range layer {
+            if kv[0] == "EXCLUDE" {
+                props.Exclusions = nil
+                break
+            }
+        }
+        for _, kv := range layer {
+            if err := props.set(kv[0], kv[1]); err != nil {
+                return Properties{}, err
+            }
+        }
+    }
+    if envOverrides != nil {
+        props.Env = env
+    }
+    props.Profile = profile
     
     // Validate required properties
     if props.QWUser == "" || props.QWPass == "" || props.QWURL == "" {
@@ -332,28 +866,105 @@ func ReadProperties(filePath string) (Properties, error) {
     return props, nil
 }
 
-// GetDomain returns the full domain name based on the input
-func GetDomain(input string) string {
-    switch input {
-    case "etlr1":
-        return "etlr1.eduroam.org"
-    case "etlr2":
-        return "etlr2.eduroam.org"
+// set applies one properties line
+func (props *Properties) set(key, value string) error {
+    switch key {
+    case "QW_USER":
+        props.QWUser = value
+    case "QW_PASS":
+        props.QWPass = value
+    case "QW_URL":
+        props.QWURL = strings.TrimPrefix(value, "=")
+    case "QW_INDEX":
+        props.Index = value
+    case "QW_BALANCE":
+        balance, err := ParseBalance(value)
+        if err != nil {
+            return err
+        }
+        props.Balance = balance
+    case "EXCLUDE":
+        props.Exclusions = append(props.Exclusions, value)
+    case "OUTPUT_DIR":
+        props.OutputDir = value
+    case "UPLOAD_URL":
+        props.UploadURL = value
+    case "UPLOAD_USER":
+        props.UploadUser = value
+    case "UPLOAD_PASS":
+        props.UploadPass = value
+    case "UPLOAD_KEY":
+        props.UploadKey = value
+    case "BQ_PROJECT":
+        props.BQProject = value
+    case "BQ_DATASET":
+        props.BQDataset = value
+    case "BQ_TABLE":
+        props.BQTable = value
+    case "BQ_CREDENTIALS":
+        props.BQCredentials = value
+    case "BQ_ENDPOINT":
+        props.BQEndpoint = value
+    case "PUBLISH_URL":
+        props.PublishURL = value
+    case "SMTP_HOST":
+        props.SMTPHost = value
+    case "SMTP_USER":
+        props.SMTPUser = value
+    case "SMTP_PASS":
+        props.SMTPPass = value
+    case "MAIL_FROM":
+        props.MailFrom = value
+    case "MAIL_TO":
+        props.MailTo = value
+    case "PSEUDONYM_KEY":
+        props.PseudonymKey = value
+    case "WATCH":
+        interval, err := time.ParseDuration(value)
+        if err != nil || interval <= 0 {
+            return fmt.Errorf("invalid WATCH interval %q", value)
+        }
+        props.Watch = interval
     default:
-        return fmt.Sprintf("eduroam.%s", input)
+        if name, ok := strings.CutPrefix(key, "ALIAS."); ok && name != "" {
+            props.Aliases[name] = value
+        }
+        if provider, ok := strings.CutPrefix(key, "COUNTRY."); ok && provider != "" {
+            props.Countries[provider] = strings.ToUpper(value)
+        }
+        if domain, ok := strings.CutPrefix(key, "HEADCOUNT."); ok && domain != "" {
+            population, err := strconv.Atoi(value)
+            if err != nil || population <= 0 {
+                return fmt.Errorf("invalid HEADCOUNT for %s: %q", domain, value)
+            }
+            if props.Headcounts == nil {
+                props.Headcounts = make(map[string]int)
+            }
+            props.Headcounts[domain] = population
+        }
     }
+    return nil
 }
 
-// Worker processes a single job
-func Worker(ctx context.Context, job Job, resultChan chan<- LogEntry, query map[string]interface{}, client *HTTPClient) (int64, error) {
-    // Check for cancellation
-    select {
-    case <-ctx.Done():
-        return 0, ctx.Err()
-    default:
+// GetDomain returns the full domain name based on the input. Shortcuts are
+// looked up in aliases, then in DefaultDomainAliases; anything else gets the
+// "eduroam." prefix unless noPrefix is set.
+func GetDomain(input string, aliases map[string]string, noPrefix bool) string {
+    if realm, ok := aliases[input]; ok {
+        return realm
+    }
+    if realm, ok := DefaultDomainAliases[input]; ok {
+        return realm
     }
+    if noPrefix {
+        return input
+    }
+    return fmt.Sprintf("%s%s", DomainPrefix, input)
+}
 
-    currentQuery := map[string]interface{}{
+// BuildJobQuery builds the aggregation query for a single job from the base query
+func BuildJobQuery(query map[string]interface{}, job Job, options QueryOptions) map[string]interface{} {
+    jobQuery := map[string]interface{}{
         "query":           query["query"],
         "start_timestamp": job.StartTimestamp,
         "end_timestamp":   job.EndTimestamp,
@@ -367,7 +978,7 @@ func Worker(ctx context.Context, job Job, resultChan chan<- LogEntry, query map[
                 "aggs": map[string]interface{}{
                     "providers": map[string]interface{}{
                         "terms": map[string]interface{}{
-                            "field": "service_provider",
+                            "field": pivotField(options.Pivot),
                             "size":  1000,
                         },
                     },
@@ -382,16 +993,47 @@ func Worker(ctx context.Context, job Job, resultChan chan<- LogEntry, query map[
         },
     }
 
+    if options.RealmBreakdown {
+        userAggs := jobQuery["aggs"].(map[string]interface{})["unique_users"].(map[string]interface{})["aggs"].(map[string]interface{})
+        userAggs["realms"] = map[string]interface{}{
+            "terms": map[string]interface{}{
+                "field": "realm",
+                "size":  10,
+            },
+        }
+    }
+
+    return jobQuery
+}
+
+// Worker processes a single job, adding the time spent to timing (may be nil)
+func Worker(ctx context.Context, job Job, resultChan chan<- LogEntry, query map[string]interface{}, options QueryOptions, client *HTTPClient, timing *BatchTiming) (int64, error) {
+    // Check for cancellation
+    select {
+    case <-ctx.Done():
+        return 0, ctx.Err()
+    default:
+    }
+
+    currentQuery := BuildJobQuery(query, job, options)
+
+    queryStart := time.Now()
     result, err := client.SendQuickwitRequest(ctx, currentQuery)
+    queryTime := time.Since(queryStart)
     if err != nil {
+        timing.record(queryTime, 0)
         return 0, err
     }
+    processStart := time.Now()
+    defer func() { timing.record(queryTime, time.Since(processStart)) }()
 
-    return ProcessAggregations(ctx, result, resultChan, job.Date)
+    // Once a day has been fetched it is processed completely, even if the
+    // run is cancelled meanwhile, so partial results never contain half days
+    return ProcessAggregations(context.WithoutCancel(ctx), result, resultChan, job.Date)
 }
 
 // ProcessAggregations processes the aggregation results
-func ProcessAggregations(ctx context.Context, result map[string]interface{}, resultChan chan<- LogEntry, jobDate time.Time) (int64, error) {
+func ProcessAggregations(ctx context.Context, result *SearchResponse, resultChan chan<- LogEntry, jobDate time.Time) (int64, error) {
     // Check for context cancellation
     select {
     case <-ctx.Done():
@@ -399,23 +1041,24 @@ func ProcessAggregations(ctx context.Context, result map[string]interface{}, res
     default:
     }
 
-    aggs, ok := result["aggregations"].(map[string]interface{})
-    if !ok {
-        return 0, ErrNoAggregationsInResponse
-    }
-
-    uniqueUsers, ok := aggs["unique_users"].(map[string]interface{})
-    if !ok {
-        return 0, fmt.Errorf("no unique_users aggregation")
+    data, err := responseAggregation(result, "unique_users")
+    if err != nil {
+        return 0, err
     }
+    return processUserAggregation(ctx, data, resultChan, jobDate)
+}
 
-    buckets, ok := uniqueUsers["buckets"].([]interface{})
-    if !ok {
-        return 0, fmt.Errorf("no buckets in unique_users aggregation")
+// processUserAggregation emits the entries of a unique_users aggregation
+// and returns its hits. When the aggregation left users out for its size,
+// the hits of the users returned come with a *BucketTruncationError.
+func processUserAggregation(ctx context.Context, data json.RawMessage, resultChan chan<- LogEntry, jobDate time.Time) (int64, error) {
+    buckets, err := decodeBuckets[userBucket]("unique_users", data)
+    if err != nil {
+        return 0, err
     }
 
     var totalHits int64
-    for _, bucketInterface := range buckets {
+    for _, bucket := range buckets {
         // Check for context cancellation periodically
         select {
         case <-ctx.Done():
@@ -423,23 +1066,18 @@ func ProcessAggregations(ctx context.Context, result map[string]interface{}, res
         default:
         }
 
-        bucket, ok := bucketInterface.(map[string]interface{})
-        if !ok {
-            continue
-        }
-
-        username := bucket["key"].(string)
-        docCount := int64(bucket["doc_count"].(float64))
-        totalHits += docCount
-
-        ProcessUserBucket(ctx, bucket, username, resultChan, jobDate)
+        totalHits += int64(bucket.DocCount)
+        ProcessUserBucket(ctx, bucket, resultChan, jobDate)
     }
 
+    if other := otherDocCount(data); other > 0 {
+        return totalHits, &BucketTruncationError{Date: jobDate, OtherHits: other}
+    }
     return totalHits, nil
 }
 
 // ProcessUserBucket processes a single user bucket from aggregations
-func ProcessUserBucket(ctx context.Context, bucket map[string]interface{}, username string, resultChan chan<- LogEntry, jobDate time.Time) {
+func ProcessUserBucket(ctx context.Context, bucket userBucket, resultChan chan<- LogEntry, jobDate time.Time) {
     // Check for context cancellation
     select {
     case <-ctx.Done():
@@ -447,22 +1085,26 @@ func ProcessUserBucket(ctx context.Context, bucket map[string]interface{}, usern
     default:
     }
 
-    if providersAgg, ok := bucket["providers"].(map[string]interface{}); ok {
-        if providerBuckets, ok := providersAgg["buckets"].([]interface{}); ok {
-            for _, providerBucketInterface := range providerBuckets {
-                providerBucket, ok := providerBucketInterface.(map[string]interface{})
-                if !ok {
-                    continue
-                }
-                provider := providerBucket["key"].(string)
-                ProcessUserProviderDaily(ctx, bucket, username, provider, resultChan, jobDate)
+    // With a realm breakdown, attribute the user to their busiest realm
+    realm := ""
+    if bucket.Realms != nil {
+        var best docCount
+        for _, realmBucket := range bucket.Realms.Buckets {
+            if realm == "" || realmBucket.DocCount > best {
+                realm, best = string(realmBucket.Key), realmBucket.DocCount
             }
         }
     }
+
+    for _, providerBucket := range bucket.Providers.Buckets {
+        ProcessUserProviderDaily(ctx, bucket, string(bucket.Key), string(providerBucket.Key), realm, int64(providerBucket.DocCount), resultChan, jobDate)
+    }
 }
 
-// ProcessUserProviderDaily processes daily activities for a user and provider
-func ProcessUserProviderDaily(ctx context.Context, bucket map[string]interface{}, username, provider string, resultChan chan<- LogEntry, jobDate time.Time) {
+// ProcessUserProviderDaily processes daily activities for a user and provider.
+// hits is the user's event count at the provider for the job; it is carried
+// by the first emitted entry only so that it is counted once.
+func ProcessUserProviderDaily(ctx context.Context, bucket userBucket, username, provider, realm string, hits int64, resultChan chan<- LogEntry, jobDate time.Time) {
     // Check for context cancellation
     select {
     case <-ctx.Done():
@@ -470,94 +1112,150 @@ func ProcessUserProviderDaily(ctx context.Context, bucket map[string]interface{}
     default:
     }
 
-    if dailyAgg, ok := bucket["daily"].(map[string]interface{}); ok {
-        if dailyBuckets, ok := dailyAgg["buckets"].([]interface{}); ok {
-            for _, dailyBucketInterface := range dailyBuckets {
-                dailyBucket, ok := dailyBucketInterface.(map[string]interface{})
-                if !ok || dailyBucket["doc_count"].(float64) == 0 {
-                    continue
-                }
+    for _, dailyBucket := range bucket.Daily.Buckets {
+        if dailyBucket.DocCount == 0 {
+            continue
+        }
+        key, err := dailyBucket.Key.float()
+        if err != nil {
+            continue
+        }
 
-                timestamp := time.Unix(int64(dailyBucket["key"].(float64)/1000), 0)
-                
-                // If jobDate is provided, use it to ensure consistent date
-                if !jobDate.IsZero() {
-                    timestamp = time.Date(
-                        jobDate.Year(), jobDate.Month(), jobDate.Day(),
-                        timestamp.Hour(), timestamp.Minute(), timestamp.Second(),
-                        0, timestamp.Location(),
-                    )
-                }
-                
-                select {
-                case resultChan <- LogEntry{
-                    Username:        username,
-                    ServiceProvider: provider,
-                    Timestamp:       timestamp,
-                }:
-                case <-ctx.Done():
-                    return
-                }
-            }
+        timestamp := time.Unix(int64(key/1000), 0)
+
+        // If jobDate is provided, use it to ensure consistent date
+        if !jobDate.IsZero() {
+            timestamp = time.Date(
+                jobDate.Year(), jobDate.Month(), jobDate.Day(),
+                timestamp.Hour(), timestamp.Minute(), timestamp.Second(),
+                0, timestamp.Location(),
+            )
+        }
+
+        select {
+        case resultChan <- LogEntry{
+            Username:        username,
+            ServiceProvider: provider,
+            Timestamp:       timestamp,
+            Realm:           realm,
+            Hits:            hits,
+        }:
+        case <-ctx.Done():
+            return
         }
+        hits = 0
     }
 }
 
 // ProcessResults processes the search results and updates the result struct
 func ProcessResults(ctx context.Context, resultChan <-chan LogEntry, result *Result) {
-    userMap := make(map[string]map[string]bool)
+    userMap := make(map[string]*NameSet)
     userFirstSeen := make(map[string]time.Time)
     userLastSeen := make(map[string]time.Time)
     providerFirstSeen := make(map[string]time.Time)
     providerLastSeen := make(map[string]time.Time)
+    userHits := make(map[string]int64)
+    providerHits := make(map[string]int64)
+    trackDays := result.Days != nil
+    dayMap := make(map[string]*DayStats)
+    realmMap := make(map[string]*RealmStats)
     
-    for {
-        select {
-        case entry, ok := <-resultChan:
-            if !ok {
-                // Channel closed, finalize results
-                FinalizeResults(userMap, userFirstSeen, userLastSeen, providerFirstSeen, providerLastSeen, result)
-                return
-            }
-            
-            if _, exists := userMap[entry.Username]; !exists {
-                userMap[entry.Username] = make(map[string]bool)
-                userFirstSeen[entry.Username] = entry.Timestamp
-                userLastSeen[entry.Username] = entry.Timestamp
+    // The caller closes resultChan once every worker has stopped, so the
+    // channel is drained completely even after cancellation. Days that were
+    // fetched before an interrupt are therefore kept in a partial result.
+    for entry := range resultChan {
+        if excludedEntry(result.Exclusions, entry) {
+            continue
+        }
+        if _, exists := userMap[entry.Username]; !exists {
+            userMap[entry.Username] = &NameSet{}
+            userFirstSeen[entry.Username] = entry.Timestamp
+            userLastSeen[entry.Username] = entry.Timestamp
+        }
+        userMap[entry.Username].Add(entry.ServiceProvider)
+        userHits[entry.Username] += entry.Hits
+        providerHits[entry.ServiceProvider] += entry.Hits
+        
+        // Update user's first/last seen
+        if entry.Timestamp.Before(userFirstSeen[entry.Username]) {
+            userFirstSeen[entry.Username] = entry.Timestamp
+        }
+        if entry.Timestamp.After(userLastSeen[entry.Username]) {
+            userLastSeen[entry.Username] = entry.Timestamp
+        }
+        
+        // Update provider's first/last seen
+        if firstSeen, exists := providerFirstSeen[entry.ServiceProvider]; !exists || entry.Timestamp.Before(firstSeen) {
+            providerFirstSeen[entry.ServiceProvider] = entry.Timestamp
+        }
+        if lastSeen, exists := providerLastSeen[entry.ServiceProvider]; !exists || entry.Timestamp.After(lastSeen) {
+            providerLastSeen[entry.ServiceProvider] = entry.Timestamp
+        }
+
+        if trackDays {
+            date := entry.Timestamp.Format(DateFormat)
+            day := dayMap[date]
+            if day == nil {
+                day = &DayStats{}
+                dayMap[date] = day
             }
-            userMap[entry.Username][entry.ServiceProvider] = true
-            
-            // Update user's first/last seen
-            if entry.Timestamp.Before(userFirstSeen[entry.Username]) {
-                userFirstSeen[entry.Username] = entry.Timestamp
+            day.addActivity(entry.Username, entry.ServiceProvider, result.DayVisits)
+            if entry.Hits > 0 {
+                if day.ProviderHits == nil {
+                    day.ProviderHits = make(map[string]int64)
+                }
+                day.ProviderHits[entry.ServiceProvider] += entry.Hits
             }
-            if entry.Timestamp.After(userLastSeen[entry.Username]) {
-                userLastSeen[entry.Username] = entry.Timestamp
+        }
+
+        if entry.Realm != "" {
+            // Punycode and Unicode forms of a realm are reported together
+            name := RealmToUnicode(entry.Realm)
+            if result.FoldRealmCase {
+                name = strings.ToLower(name)
             }
-            
-            // Update provider's first/last seen
-            if firstSeen, exists := providerFirstSeen[entry.ServiceProvider]; !exists || entry.Timestamp.Before(firstSeen) {
-                providerFirstSeen[entry.ServiceProvider] = entry.Timestamp
+            realm := realmMap[name]
+            if realm == nil {
+                realm = &RealmStats{}
+                realmMap[name] = realm
             }
-            if lastSeen, exists := providerLastSeen[entry.ServiceProvider]; !exists || entry.Timestamp.After(lastSeen) {
-                providerLastSeen[entry.ServiceProvider] = entry.Timestamp
+            realm.Users.Add(entry.Username)
+            realm.Hits += entry.Hits
+        }
+    }
+
+    FinalizeResults(userMap, userFirstSeen, userLastSeen, providerFirstSeen, providerLastSeen, userHits, providerHits, result)
+
+    if len(realmMap) > 0 {
+        result.mu.Lock()
+        result.Realms = realmMap
+        result.mu.Unlock()
+    }
+
+    if trackDays {
+        result.mu.Lock()
+        for date, day := range dayMap {
+            if result.Days[date] == nil {
+                result.Days[date] = &DayStats{}
             }
-            
-        case <-ctx.Done():
-            // Context cancelled, finalize what we have
-            FinalizeResults(userMap, userFirstSeen, userLastSeen, providerFirstSeen, providerLastSeen, result)
-            return
+            result.Days[date].Users = day.Users
+            result.Days[date].Providers = day.Providers
+            result.Days[date].Visits = day.Visits
+            result.Days[date].ProviderHits = day.ProviderHits
         }
+        result.mu.Unlock()
     }
 }
 
 // FinalizeResults updates the final result structure from the working maps
 func FinalizeResults(
-    userMap map[string]map[string]bool,
+    userMap map[string]*NameSet,
     userFirstSeen map[string]time.Time,
     userLastSeen map[string]time.Time,
     providerFirstSeen map[string]time.Time,
     providerLastSeen map[string]time.Time,
+    userHits map[string]int64,
+    providerHits map[string]int64,
     result *Result) {
     
     result.mu.Lock()
@@ -566,7 +1264,6 @@ func FinalizeResults(
     for username, providers := range userMap {
         if _, exists := result.Users[username]; !exists {
             result.Users[username] = &UserStats{
-                Providers: make(map[string]bool),
                 FirstSeen: userFirstSeen[username],
                 LastSeen:  userLastSeen[username],
             }
@@ -579,13 +1276,13 @@ func FinalizeResults(
                 result.Users[username].LastSeen = userLastSeen[username]
             }
         }
+        result.Users[username].Hits += userHits[username]
 
-        for provider := range providers {
-            result.Users[username].Providers[provider] = true
+        result.Users[username].Providers.Merge(*providers)
+        for provider := range providers.All() {
             
             if _, exists := result.Providers[provider]; !exists {
                 result.Providers[provider] = &ProviderStats{
-                    Users:     make(map[string]bool),
                     FirstSeen: providerFirstSeen[provider],
                     LastSeen:  providerLastSeen[provider],
                 }
@@ -598,101 +1295,220 @@ func FinalizeResults(
                     result.Providers[provider].LastSeen = providerLastSeen[provider]
                 }
             }
-            result.Providers[provider].Users[username] = true
+            result.Providers[provider].Users.Add(username)
+        }
+    }
+
+    for provider, hits := range providerHits {
+        if stats, exists := result.Providers[provider]; exists {
+            stats.Hits += hits
         }
     }
 }
 
 // CreateOutputData creates the output JSON structure
-func CreateOutputData(result *Result, domain string, timeRange TimeRange) SimplifiedOutputData {
+func CreateOutputData(result *Result, meta ExportMeta) SimplifiedOutputData {
+    domain, timeRange := meta.Domain, meta.TimeRange
     output := SimplifiedOutputData{}
-    output.QueryInfo.Domain = domain
+    output.QueryInfo.Domain = RealmToUnicode(domain)
     output.QueryInfo.Days = timeRange.Days
     output.QueryInfo.StartDate = timeRange.StartDate.Format(DateTimeFormat)
     output.QueryInfo.EndDate = timeRange.EndDate.Format(DateTimeFormat)
     output.QueryInfo.TotalHits = result.TotalHits
-    output.Description = "Aggregated Access-Accept events for the specified domain and time range."
+    output.QueryInfo.Partial = result.Partial
+    output.QueryInfo.UnprocessedDays = result.UnprocessedDays
+    output.QueryInfo.TruncatedDays = result.TruncatedDays
+    output.QueryInfo.Exclusions = exclusionStrings(result.Exclusions)
+    output.QueryInfo.FutureEvents = result.FutureEvents
+    output.RunInfo = GetRunInfo()
+    output.RunInfo.Environment = meta.Environment
+    if !meta.Deterministic {
+        output.RunInfo.Timing = result.Timing
+        output.RunInfo.Resources = result.Resources
+        output.RunInfo.Backpressure = result.Backpressure
+    }
+    messageType := meta.MessageType
+    if messageType == "" {
+        messageType = DefaultMessageType
+    }
+    output.QueryInfo.MessageType = messageType
+    output.Description = fmt.Sprintf("Aggregated %s events for the specified domain and time range.", messageType)
+    if result.Pivot == PivotSP {
+        output.QueryInfo.Pivot = PivotSP
+        output.Description = fmt.Sprintf("Aggregated %s events at the specified service provider and time range; provider_stats lists the visitors' realms.", messageType)
+    }
+    fields := meta.Fields
+    if fields.Has(FieldSubrealms) {
+        output.Subrealms = SubrealmStats(result)
+    }
+    if fields.Has(FieldDaily) {
+        output.Daily = DailyStats(result)
+    }
+    if fields.Has(FieldMobility) {
+        output.Mobility = ComputeMobility(result, meta.MobilityFormula)
+    }
+    if fields.Has(FieldAuths) {
+        output.AuthsPerUser = ComputeAuthDistribution(result)
+    }
+    if fields.Has(FieldNAI) {
+        output.NAIValidation = ValidateUsernames(result)
+    }
+    if fields.Has(FieldOutliers) {
+        output.Outliers = FindOutliers(result, meta.OutlierFactor)
+    }
+    if fields.Has(FieldMultiProvider) && result.Pivot != PivotSP {
+        output.MultiProvider = FindMultiProviderDays(result, meta.MultiProviderMin)
+    }
+    if fields.Has(FieldLost) {
+        output.LostProviders = meta.LostProviders
+    }
+    if fields.Has(FieldTimeline) {
+        output.Timeline = BuildProviderTimeline(result, meta.ProviderHistory, meta.TimeRange)
+    }
+    if meta.ProviderTimeseries && fields.Has(FieldProviderDaily) {
+        output.ProviderDaily = ProviderDailySeries(result)
+    }
+    output.Changes = CompareWithPrevious(result, meta.Previous)
+    output.LocalTraffic = result.Local
+    if fields.Has(FieldVerification) {
+        output.Verification = result.Verification
+    }
+    if fields.Has(FieldQuality) {
+        output.DataQuality = result.DataQuality
+    }
+    if fields.Has(FieldForecast) {
+        output.Forecast = result.Forecast
+    }
+    if fields.Has(FieldSecurity) {
+        output.Security = result.Security
+    }
+    if fields.Has(FieldSamples) {
+        output.Samples = result.Samples
+    }
+
+    var roaming *RoamingSplit
+    var adoption *Adoption
+    if fields.Has(FieldSummary) {
+        roaming = ComputeRoamingSplit(result, meta.HomeCountry, meta.Countries)
+        adoption = ComputeAdoption(result, meta.Population)
+    }
 
     result.mu.RLock()
     defer result.mu.RUnlock()
 
-    output.Summary.TotalUsers = len(result.Users)
-    output.Summary.TotalProviders = len(result.Providers)
+    if fields.Has(FieldSummary) {
+        output.Summary = &OutputSummary{
+            TotalUsers:     len(result.Users),
+            TotalProviders: len(result.Providers),
+            Roaming:        roaming,
+            Adoption:       adoption,
+            Baseline:       meta.Baseline,
+        }
+    }
+
+    if fields.Has(FieldProviders) {
+        addProviderStats(&output, result, meta.SortProviders, timeRange.Days)
+    }
+    if fields.Has(FieldUsers) {
+        addUserStats(&output, result, meta.SortUsers)
+    }
+    if meta.Public {
+        output.stripUsernames(meta.Pseudonyms != nil)
+        if meta.Pseudonyms != nil {
+            output.QueryInfo.UserIDs = meta.Pseudonyms.Scheme()
+        }
+    }
+
+    return output
+}
 
-    // Process provider stats
+// addProviderStats fills the provider list of the output in sortOrder, with
+// auth rates over a range of days; the caller holds the result's read lock
+func addProviderStats(output *SimplifiedOutputData, result *Result, sortOrder string, days int) {
     output.ProviderStats = make([]struct {
-        Provider  string   `json:"provider"`
-        UserCount int      `json:"user_count"`
-        Users     []string `json:"users"`
-        FirstSeen string   `json:"first_seen,omitempty"`
-        LastSeen  string   `json:"last_seen,omitempty"`
+        Provider     string   `json:"provider"`
+        UserCount    int      `json:"user_count"`
+        Hits         int64    `json:"hits"`
+        AuthsPerDay  float64  `json:"auths_per_day"`
+        AuthsPerHour float64  `json:"auths_per_hour"`
+        Users        []string `json:"users,omitempty"`
+        FirstSeen    string   `json:"first_seen,omitempty"`
+        LastSeen     string   `json:"last_seen,omitempty"`
     }, 0, len(result.Providers))
 
-    for provider, stats := range result.Providers {
-        users := make([]string, 0, len(stats.Users))
-        for user := range stats.Users {
-            users = append(users, user)
-        }
-        sort.Strings(users)
+    for _, provider := range SortedProviders(result.Providers, sortOrder) {
+        stats := result.Providers[provider]
+        users := stats.Users.Sorted()
         
         output.ProviderStats = append(output.ProviderStats, struct {
-            Provider  string   `json:"provider"`
-            UserCount int      `json:"user_count"`
-            Users     []string `json:"users"`
-            FirstSeen string   `json:"first_seen,omitempty"`
-            LastSeen  string   `json:"last_seen,omitempty"`
+            Provider     string   `json:"provider"`
+            UserCount    int      `json:"user_count"`
+            Hits         int64    `json:"hits"`
+            AuthsPerDay  float64  `json:"auths_per_day"`
+            AuthsPerHour float64  `json:"auths_per_hour"`
+            Users        []string `json:"users,omitempty"`
+            FirstSeen    string   `json:"first_seen,omitempty"`
+            LastSeen     string   `json:"last_seen,omitempty"`
         }{
-            Provider:  provider,
-            UserCount: len(users),
-            Users:     users,
-            FirstSeen: stats.FirstSeen.Format(DateFormat),
-            LastSeen:  stats.LastSeen.Format(DateFormat),
+            Provider:     provider,
+            UserCount:    len(users),
+            Hits:         stats.Hits,
+            AuthsPerDay:  perDay(stats.Hits, days),
+            AuthsPerHour: perDay(stats.Hits, days) / 24,
+            Users:        users,
+            FirstSeen:    stats.FirstSeen.Format(DateFormat),
+            LastSeen:     stats.LastSeen.Format(DateFormat),
         })
     }
+}
 
-    // Sort provider stats by number of users
-    sort.Slice(output.ProviderStats, func(i, j int) bool {
-        return output.ProviderStats[i].UserCount > output.ProviderStats[j].UserCount
-    })
+// perDay returns the average number of hits per day over a range of days
+func perDay(hits int64, days int) float64 {
+    if days <= 0 {
+        return 0
+    }
+    return float64(hits) / float64(days)
+}
 
-    // Process user stats
+// addUserStats fills the user list of the output in sortOrder; the caller
+// holds the result's read lock
+func addUserStats(output *SimplifiedOutputData, result *Result, sortOrder string) {
     output.UserStats = make([]struct {
         Username  string   `json:"username"`
         Providers []string `json:"providers"`
+        Hits      int64    `json:"hits"`
         FirstSeen string   `json:"first_seen,omitempty"`
         LastSeen  string   `json:"last_seen,omitempty"`
     }, 0, len(result.Users))
 
-    for username, stats := range result.Users {
-        providers := make([]string, 0, len(stats.Providers))
-        for provider := range stats.Providers {
-            providers = append(providers, provider)
-        }
-        sort.Strings(providers)
+    for _, username := range SortedUsernames(result.Users, sortOrder) {
+        stats := result.Users[username]
+        providers := stats.Providers.Sorted()
         
         output.UserStats = append(output.UserStats, struct {
             Username  string   `json:"username"`
             Providers []string `json:"providers"`
+            Hits      int64    `json:"hits"`
             FirstSeen string   `json:"first_seen,omitempty"`
             LastSeen  string   `json:"last_seen,omitempty"`
         }{
             Username:  username,
             Providers: providers,
+            Hits:      stats.Hits,
             FirstSeen: stats.FirstSeen.Format(DateFormat),
             LastSeen:  stats.LastSeen.Format(DateFormat),
         })
     }
-
-    // Sort user stats by username
-    sort.Slice(output.UserStats, func(i, j int) bool {
-        return output.UserStats[i].Username < output.UserStats[j].Username
-    })
-
-    return output
 }
 
 // ParseTimeRange parses the command line parameter into a TimeRange struct
 func ParseTimeRange(param string) (TimeRange, error) {
+    return ParseTimeRangeAt(param, SystemClock.Now())
+}
+
+// ParseTimeRangeAt parses the command line parameter into a TimeRange
+// struct; relative ranges end at now
+func ParseTimeRangeAt(param string, now time.Time) (TimeRange, error) {
     var timeRange TimeRange
     
     // Check for year format (yxxxx)
@@ -724,7 +1540,7 @@ func ParseTimeRange(param string) (TimeRange, error) {
         if years, err := strconv.Atoi(yearStr); err == nil {
             if years >= 1 && years <= MaxYearsRange {
                 timeRange.Days = years * 365
-                timeRange.EndDate = time.Now()
+                timeRange.EndDate = now
                 timeRange.StartDate = timeRange.EndDate.AddDate(-years, 0, 0)
                 return timeRange, nil
             }
@@ -737,7 +1553,7 @@ func ParseTimeRange(param string) (TimeRange, error) {
     if d, err := strconv.Atoi(param); err == nil {
         if d >= 1 && d <= MaxDaysRange {
             timeRange.Days = d
-            timeRange.EndDate = time.Now()
+            timeRange.EndDate = now
             timeRange.StartDate = timeRange.EndDate.AddDate(0, 0, -d+1)
             return timeRange, nil
         }
@@ -757,6 +1573,35 @@ func ParseTimeRange(param string) (TimeRange, error) {
     return timeRange, nil
 }
 
+// ResolveTimeRange parses the optional time range parameter (defaulting to
+// one day) and normalizes it to the beginning and end of the covered days
+func ResolveTimeRange(param string) (TimeRange, error) {
+    return ResolveTimeRangeAt(param, SystemClock.Now())
+}
+
+// ResolveTimeRangeAt is ResolveTimeRange with relative ranges ending at now
+func ResolveTimeRangeAt(param string, now time.Time) (TimeRange, error) {
+    var timeRange TimeRange
+    if param != "" {
+        var err error
+        timeRange, err = ParseTimeRangeAt(param, now)
+        if err != nil {
+            return timeRange, err
+        }
+    } else {
+        // Default: 1 day
+        timeRange.Days = 1
+        timeRange.EndDate = now
+        timeRange.StartDate = timeRange.EndDate.AddDate(0, 0, -1)
+    }
+
+    // Normalize date times to beginning/end of day
+    timeRange.StartDate = time.Date(timeRange.StartDate.Year(), timeRange.StartDate.Month(), timeRange.StartDate.Day(), 0, 0, 0, 0, timeRange.StartDate.Location())
+    timeRange.EndDate = time.Date(timeRange.EndDate.Year(), timeRange.EndDate.Month(), timeRange.EndDate.Day(), 23, 59, 59, 999999999, timeRange.EndDate.Location())
+
+    return timeRange, nil
+}
+
 // isLeapYear checks if a year is a leap year
 func isLeapYear(year int) bool {
     return year%4 == 0 && (year%100 != 0 || year%400 == 0)
@@ -772,28 +1617,63 @@ func GetNumWorkers() int {
     return DefaultNumWorkers
 }
 
-// SaveOutputToJSON saves the output data to a JSON file
-func SaveOutputToJSON(outputData SimplifiedOutputData, domain string, timeRange TimeRange) (string, error) {
-    outputDir := filepath.Join(OutputDirBase, domain)
+// OutputDirFor returns the output directory for a domain. The "*" of a
+// wildcard domain is replaced so the name is valid everywhere.
+func OutputDirFor(baseDir, domain string) string {
+    return filepath.Join(baseDir, strings.ReplaceAll(domain, "*", "_"))
+}
+
+// PrepareOutputDir creates and returns the output directory for a domain
+func PrepareOutputDir(baseDir, domain string) (string, error) {
+    outputDir := OutputDirFor(baseDir, domain)
     if err := os.MkdirAll(outputDir, 0755); err != nil {
         return "", fmt.Errorf("error creating output directory: %w", err)
     }
+    return outputDir, nil
+}
 
-    currentTime := time.Now().Format("20060102-150405")
-    var filename string
+// OutputBaseFilename returns the file name prefix for an export, stamped
+// with meta.RunTime so that every file of a run shares it; meta.BaseName
+// replaces the timestamp. Partial results get a "-partial" suffix.
+func OutputBaseFilename(meta ExportMeta) string {
+    currentTime := meta.RunTime.Format("20060102-150405")
+    if meta.BaseName != "" {
+        currentTime = meta.BaseName
+    }
+    timeRange := meta.TimeRange
     
+    var name string
     if timeRange.SpecificDate {
-        filename = fmt.Sprintf("%s/%s-%s.json", outputDir, currentTime, timeRange.StartDate.Format("20060102"))
+        name = fmt.Sprintf("%s-%s", currentTime, timeRange.StartDate.Format("20060102"))
     } else if timeRange.SpecificYear {
-        filename = fmt.Sprintf("%s/%s-y%d.json", outputDir, currentTime, timeRange.Year)
+        name = fmt.Sprintf("%s-y%d", currentTime, timeRange.Year)
     } else {
-        filename = fmt.Sprintf("%s/%s-%dd.json", outputDir, currentTime, timeRange.Days)
+        name = fmt.Sprintf("%s-%dd", currentTime, timeRange.Days)
     }
+    if meta.Partial {
+        name += "-partial"
+    }
+    if meta.Public {
+        name += "-public"
+    }
+    return name
+}
 
-    jsonData, err := json.MarshalIndent(outputData, "", "  ")
+// SaveOutputToJSON saves the output data to a JSON file
+func SaveOutputToJSON(outputData SimplifiedOutputData, meta ExportMeta) (string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+".json")
+
+    jsonData, err := MarshalOutput(outputData, meta.MaxOutputSize, meta.MaxOutputTop)
     if err != nil {
         return "", fmt.Errorf("error marshaling JSON: %w", err)
     }
+    if meta.MaxOutputSize > 0 && int64(len(jsonData)) > meta.MaxOutputSize {
+        log.Printf("Warning: %s exceeds -max-output-size even with the summary only", filepath.Base(filename))
+    }
 
     if err := os.WriteFile(filename, jsonData, 0644); err != nil {
         return "", fmt.Errorf("error writing file: %w", err)
@@ -803,147 +1683,745 @@ func SaveOutputToJSON(outputData SimplifiedOutputData, domain string, timeRange
 }
 
 // ExportToCSV exports the results to CSV files
-func ExportToCSV(result *Result, domain string, timeRange TimeRange) ([]string, error) {
-    outputDir := filepath.Join(OutputDirBase, domain)
-    if err := os.MkdirAll(outputDir, 0755); err != nil {
-        return nil, fmt.Errorf("error creating output directory: %w", err)
+func ExportToCSV(result *Result, meta ExportMeta) ([]string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
     }
+    baseFilename := filepath.Join(outputDir, OutputBaseFilename(meta))
 
-    currentTime := time.Now().Format("20060102-150405")
-    var baseFilename string
-    
-    if timeRange.SpecificDate {
-        baseFilename = fmt.Sprintf("%s-%s", currentTime, timeRange.StartDate.Format("20060102"))
-    } else if timeRange.SpecificYear {
-        baseFilename = fmt.Sprintf("%s-y%d", currentTime, timeRange.Year)
-    } else {
-        baseFilename = fmt.Sprintf("%s-%dd", currentTime, timeRange.Days)
+    var filenames []string
+    if meta.Fields.Has(FieldUsers) {
+        filename := baseFilename + "-users.csv"
+        if err := writeUsersCSV(result, meta.SortUsers, filename); err != nil {
+            return nil, err
+        }
+        filenames = append(filenames, filename)
     }
-    
-    // Create users CSV file
-    usersFilename := filepath.Join(outputDir, baseFilename+"-users.csv")
-    usersFile, err := os.Create(usersFilename)
-    if err != nil {
-        return nil, fmt.Errorf("error creating users CSV file: %w", err)
+    if meta.Fields.Has(FieldProviders) {
+        filename := baseFilename + "-providers.csv"
+        if err := writeProvidersCSV(result, meta.SortProviders, meta.TimeRange.Days, filename); err != nil {
+            return nil, err
+        }
+        filenames = append(filenames, filename)
     }
-    defer usersFile.Close()
+    if meta.Fields.Has(FieldSummary) {
+        filename := baseFilename + "-summary.csv"
+        if err := writeSummaryCSV(result, meta, filename); err != nil {
+            return nil, err
+        }
+        filenames = append(filenames, filename)
+    }
+
+    // Optional sections, each written to its own file when it has data
+    sections := []struct {
+        field  string
+        export func(*Result, ExportMeta) (string, error)
+    }{
+        {FieldMobility, ExportMobilityCSV},
+        {FieldDaily, ExportDailyCSV},
+        {FieldSubrealms, ExportSubrealmsCSV},
+        {FieldProviderDaily, ExportProviderDailyCSV},
+        {FieldNAI, ExportNAICSV},
+        {FieldVerification, ExportVerificationCSV},
+        {FieldQuality, ExportDataQualityCSV},
+        {FieldForecast, ExportForecastCSV},
+        {FieldOutliers, ExportOutliersCSV},
+        {FieldSecurity, ExportSecurityCSV},
+        {FieldSamples, ExportSamplesCSV},
+        {FieldMultiProvider, ExportMultiProviderCSV},
+        {FieldTimeline, ExportProviderTimelineCSV},
+        {FieldLost, ExportLostProvidersCSV},
+    }
+    for _, section := range sections {
+        if !meta.Fields.Has(section.field) {
+            continue
+        }
+        if section.field == FieldProviderDaily && !meta.ProviderTimeseries {
+            continue
+        }
+        filename, err := section.export(result, meta)
+        if err != nil {
+            return nil, err
+        }
+        if filename != "" {
+            filenames = append(filenames, filename)
+        }
+    }
+    return filenames, nil
+}
 
-    usersWriter := csv.NewWriter(usersFile)
-    defer usersWriter.Flush()
+// writeCSVFile writes records to a new CSV file
+func writeCSVFile(filename string, records [][]string) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return fmt.Errorf("error creating CSV file: %w", err)
+    }
+    defer file.Close()
 
-    // Write users CSV header
-    if err := usersWriter.Write([]string{"Username", "Providers Count", "Providers", "First Seen", "Last Seen"}); err != nil {
-        return nil, fmt.Errorf("error writing users CSV header: %w", err)
+    if err := csv.NewWriter(file).WriteAll(records); err != nil {
+        return fmt.Errorf("error writing CSV file: %w", err)
     }
+    return nil
+}
+
+// writeUsersCSV writes one row per user in sortOrder
+func writeUsersCSV(result *Result, sortOrder, filename string) error {
+    records := [][]string{{"Username", "Providers Count", "Providers", "Hits", "First Seen", "Last Seen"}}
 
-    // Write users data
     result.mu.RLock()
-    for username, stats := range result.Users {
-        providers := make([]string, 0, len(stats.Providers))
-        for provider := range stats.Providers {
-            providers = append(providers, provider)
-        }
-        sort.Strings(providers)
+    for _, username := range SortedUsernames(result.Users, sortOrder) {
+        stats := result.Users[username]
+        providers := stats.Providers.Sorted()
         
-        record := []string{
+        records = append(records, []string{
             username,
             strconv.Itoa(len(providers)),
             strings.Join(providers, "; "),
+            strconv.FormatInt(stats.Hits, 10),
             stats.FirstSeen.Format(DateFormat),
             stats.LastSeen.Format(DateFormat),
-        }
-        if err := usersWriter.Write(record); err != nil {
-            result.mu.RUnlock()
-            return nil, fmt.Errorf("error writing user record: %w", err)
-        }
-    }
-    
-    // Create providers CSV file
-    providersFilename := filepath.Join(outputDir, baseFilename+"-providers.csv")
-    providersFile, err := os.Create(providersFilename)
-    if err != nil {
-        result.mu.RUnlock()
-        return nil, fmt.Errorf("error creating providers CSV file: %w", err)
+        })
     }
-    defer providersFile.Close()
+    result.mu.RUnlock()
 
-    providersWriter := csv.NewWriter(providersFile)
-    defer providersWriter.Flush()
+    return writeCSVFile(filename, records)
+}
 
-    // Write providers CSV header
-    if err := providersWriter.Write([]string{"Provider", "Users Count", "First Seen", "Last Seen"}); err != nil {
-        result.mu.RUnlock()
-        return nil, fmt.Errorf("error writing providers CSV header: %w", err)
-    }
+// writeProvidersCSV writes one row per provider in sortOrder, with auth
+// rates over a range of days
+func writeProvidersCSV(result *Result, sortOrder string, days int, filename string) error {
+    records := [][]string{{"Provider", "Users Count", "Hits", "Auths Per Day", "Auths Per Hour", "First Seen", "Last Seen"}}
 
-    // Write providers data
-    for provider, stats := range result.Providers {
-        record := []string{
+    result.mu.RLock()
+    for _, provider := range SortedProviders(result.Providers, sortOrder) {
+        stats := result.Providers[provider]
+        records = append(records, []string{
             provider,
-            strconv.Itoa(len(stats.Users)),
+            strconv.Itoa(stats.Users.Len()),
+            strconv.FormatInt(stats.Hits, 10),
+            strconv.FormatFloat(perDay(stats.Hits, days), 'f', 2, 64),
+            strconv.FormatFloat(perDay(stats.Hits, days)/24, 'f', 2, 64),
             stats.FirstSeen.Format(DateFormat),
             stats.LastSeen.Format(DateFormat),
-        }
-        if err := providersWriter.Write(record); err != nil {
-            result.mu.RUnlock()
-            return nil, fmt.Errorf("error writing provider record: %w", err)
-        }
+        })
     }
     result.mu.RUnlock()
-    
-    // Create summary CSV file
-    summaryFilename := filepath.Join(outputDir, baseFilename+"-summary.csv")
-    summaryFile, err := os.Create(summaryFilename)
-    if err != nil {
-        return nil, fmt.Errorf("error creating summary CSV file: %w", err)
-    }
-    defer summaryFile.Close()
 
-    summaryWriter := csv.NewWriter(summaryFile)
-    defer summaryWriter.Flush()
+    return writeCSVFile(filename, records)
+}
 
-    // Write summary CSV header and data
-    if err := summaryWriter.Write([]string{"Parameter", "Value"}); err != nil {
-        return nil, fmt.Errorf("error writing summary CSV header: %w", err)
-    }
-    
+// writeSummaryCSV writes the run parameters and totals as name/value rows
+func writeSummaryCSV(result *Result, meta ExportMeta, filename string) error {
+    timeRange := meta.TimeRange
     summaryData := [][]string{
-        {"Domain", domain},
+        {"Parameter", "Value"},
+        {"Domain", meta.Domain},
         {"Start Date", timeRange.StartDate.Format(DateTimeFormat)},
         {"End Date", timeRange.EndDate.Format(DateTimeFormat)},
         {"Total Days", strconv.Itoa(timeRange.Days)},
         {"Total Users", strconv.Itoa(len(result.Users))},
         {"Total Providers", strconv.Itoa(len(result.Providers))},
         {"Total Hits", strconv.FormatInt(result.TotalHits, 10)},
-        {"Exported At", time.Now().Format(DateTimeFormat)},
     }
-    
-    for _, record := range summaryData {
-        if err := summaryWriter.Write(record); err != nil {
-            return nil, fmt.Errorf("error writing summary record: %w", err)
+    if !meta.Deterministic {
+        summaryData = append(summaryData, []string{"Exported At", time.Now().Format(DateTimeFormat)})
+    }
+    summaryData = append(summaryData, []string{"Version", Version})
+    if meta.MessageType != "" && meta.MessageType != DefaultMessageType {
+        summaryData = append(summaryData, []string{"Message Type", meta.MessageType})
+    }
+    if result.Pivot == PivotSP {
+        summaryData = append(summaryData, []string{"Pivot", PivotSP})
+    }
+    if len(result.Exclusions) > 0 {
+        summaryData = append(summaryData, []string{"Exclusions", strings.Join(exclusionStrings(result.Exclusions), " ")})
+    }
+    if meta.Fields.Has(FieldAuths) {
+        if dist := ComputeAuthDistribution(result); dist != nil {
+            summaryData = append(summaryData,
+                []string{"Auths Per User Mean", strconv.FormatFloat(dist.Mean, 'f', 2, 64)},
+                []string{"Auths Per User Median", strconv.FormatInt(dist.Median, 10)},
+                []string{"Auths Per User P95", strconv.FormatInt(dist.P95, 10)},
+                []string{"Auths Per User Max", strconv.FormatInt(dist.Max, 10)},
+            )
+            for _, bucket := range dist.Histogram {
+                summaryData = append(summaryData, []string{"Users With " + bucket.Range + " Auths", strconv.Itoa(bucket.Users)})
+            }
+        }
+    }
+    if adoption := ComputeAdoption(result, meta.Population); adoption != nil {
+        summaryData = append(summaryData,
+            []string{"Population", strconv.Itoa(adoption.Population)},
+            []string{"Adoption Percent", strconv.FormatFloat(adoption.Percent, 'f', 2, 64)},
+        )
+    }
+    if comparison := meta.Baseline; comparison != nil {
+        summaryData = append(summaryData,
+            []string{"Baseline Start Date", comparison.StartDate},
+            []string{"Baseline End Date", comparison.EndDate},
+            []string{"Baseline Users", strconv.Itoa(comparison.Users)},
+            []string{"Baseline Providers", strconv.Itoa(comparison.Providers)},
+            []string{"Baseline Hits", strconv.FormatInt(comparison.Hits, 10)},
+            []string{"Users Change Percent", csvChangePercent(comparison.UsersChange)},
+            []string{"Providers Change Percent", csvChangePercent(comparison.ProvidersChange)},
+            []string{"Hits Change Percent", csvChangePercent(comparison.HitsChange)},
+            []string{"Hits Per Day Change Percent", csvChangePercent(comparison.HitsPerDayChange)},
+            []string{"Mean Daily Users Change Percent", csvChangePercent(comparison.MeanDailyUsersChange)},
+        )
+    }
+    if split := ComputeRoamingSplit(result, meta.HomeCountry, meta.Countries); split != nil {
+        summaryData = append(summaryData,
+            []string{"Home Country", split.HomeCountry},
+            []string{"National Users", strconv.Itoa(split.National.Users)},
+            []string{"National Providers", strconv.Itoa(split.National.Providers)},
+            []string{"National Hits", strconv.FormatInt(split.National.Hits, 10)},
+            []string{"International Users", strconv.Itoa(split.International.Users)},
+            []string{"International Providers", strconv.Itoa(split.International.Providers)},
+            []string{"International Hits", strconv.FormatInt(split.International.Hits, 10)},
+            []string{"Users In Both", strconv.Itoa(split.BothUsers)},
+        )
+    }
+    if result.FutureEvents > 0 {
+        summaryData = append(summaryData, []string{"Excluded Future Events", strconv.FormatInt(result.FutureEvents, 10)})
+    }
+    if result.Verification != nil {
+        summaryData = append(summaryData, []string{"Verification Mismatches", strconv.Itoa(result.Verification.Mismatches)})
+    }
+    if result.DataQuality != nil {
+        total := result.DataQuality.Total
+        summaryData = append(summaryData,
+            []string{"Missing Username", strconv.FormatInt(total.MissingUsername, 10)},
+            []string{"Empty Username", strconv.FormatInt(total.EmptyUsername, 10)},
+            []string{"Missing Service Provider", strconv.FormatInt(total.MissingProvider, 10)},
+            []string{"Future Timestamps", strconv.FormatInt(total.FutureTimestamps, 10)},
+        )
+    }
+    if meta.Fields.Has(FieldOutliers) {
+        if report := FindOutliers(result, meta.OutlierFactor); report != nil {
+            summaryData = append(summaryData,
+                []string{"Outlier Threshold", strconv.FormatFloat(report.Threshold, 'f', -1, 64)},
+                []string{"Outlier Users", strconv.Itoa(len(report.Users))},
+                []string{"Outlier Hits", strconv.FormatInt(report.OutlierHits, 10)},
+            )
         }
     }
+    if meta.Fields.Has(FieldMultiProvider) && result.Pivot != PivotSP {
+        if report := FindMultiProviderDays(result, meta.MultiProviderMin); report != nil {
+            summaryData = append(summaryData,
+                []string{"Multi-Provider Users", strconv.Itoa(report.Users)},
+                []string{"Multi-Provider User Days", strconv.Itoa(len(report.Days))},
+            )
+        }
+    }
+    if report := meta.LostProviders; report != nil && meta.Fields.Has(FieldLost) {
+        summaryData = append(summaryData,
+            []string{"Lost Providers", strconv.Itoa(len(report.Providers))},
+            []string{"Lost Providers Since", report.StartDate},
+        )
+    }
+    if meta.Fields.Has(FieldTimeline) {
+        if timeline := BuildProviderTimeline(result, meta.ProviderHistory, meta.TimeRange); timeline.StoreFrom != "" {
+            summaryData = append(summaryData,
+                []string{"Store From", timeline.StoreFrom},
+                []string{"New Providers", strconv.Itoa(timeline.New)},
+            )
+        }
+    }
+    if result.Security != nil && meta.Fields.Has(FieldSecurity) {
+        users := make(map[string]bool)
+        for _, incident := range result.Security.ImpossibleTravel {
+            users[incident.Username] = true
+        }
+        summaryData = append(summaryData,
+            []string{"Impossible Travel Incidents", strconv.Itoa(len(result.Security.ImpossibleTravel))},
+            []string{"Impossible Travel Users", strconv.Itoa(len(users))},
+        )
+    }
+    if result.Forecast != nil && meta.Fields.Has(FieldForecast) {
+        forecast := result.Forecast
+        summaryData = append(summaryData,
+            []string{"Forecast Days", strconv.Itoa(forecast.Days)},
+            []string{"Forecast Mean Daily Users", strconv.FormatFloat(forecast.MeanDailyUsers.Value, 'f', -1, 64)},
+            []string{"Forecast Mean Daily Users Range", strconv.FormatFloat(forecast.MeanDailyUsers.Low, 'f', -1, 64) + " - " + strconv.FormatFloat(forecast.MeanDailyUsers.High, 'f', -1, 64)},
+            []string{"Forecast Total Hits", strconv.FormatFloat(forecast.TotalHits.Value, 'f', -1, 64)},
+            []string{"Forecast Total Hits Range", strconv.FormatFloat(forecast.TotalHits.Low, 'f', -1, 64) + " - " + strconv.FormatFloat(forecast.TotalHits.High, 'f', -1, 64)},
+        )
+    }
+    if result.Local != nil {
+        summaryData = append(summaryData,
+            []string{"Local Hits", strconv.FormatInt(result.Local.Hits, 10)},
+            []string{"Local Unique Users (approx)", strconv.FormatInt(result.Local.UniqueUsers, 10)},
+            []string{"Roaming Share", strconv.FormatFloat(result.Local.RoamingShare, 'f', 4, 64)},
+        )
+    }
+    if changes := CompareWithPrevious(result, meta.Previous); changes != nil {
+        summaryData = append(summaryData,
+            []string{"Previous Run", changes.PreviousFile},
+            []string{"New Users", strconv.Itoa(len(changes.NewUsers))},
+            []string{"Lost Users", strconv.Itoa(len(changes.LostUsers))},
+            []string{"New Providers", strconv.Itoa(len(changes.NewProviders))},
+            []string{"Lost Providers", strconv.Itoa(len(changes.LostProviders))},
+            []string{"Hits Delta", strconv.FormatInt(changes.HitsDelta, 10)},
+        )
+    }
+    if result.Partial {
+        summaryData = append(summaryData,
+            []string{"Partial", "true"},
+            []string{"Unprocessed Days", strings.Join(result.UnprocessedDays, "; ")},
+        )
+    }
+    if len(result.TruncatedDays) > 0 {
+        summaryData = append(summaryData, []string{"Bucket Truncated Days", strings.Join(result.TruncatedDays, "; ")})
+    }
+
+    return writeCSVFile(filename, summaryData)
+}
+
+// GenerateJobs splits a time range into one job per day
+func GenerateJobs(timeRange TimeRange) []Job {
+    var jobs []Job
+    currentDate := timeRange.StartDate
+    for currentDate.Before(timeRange.EndDate) {
+        nextDate := currentDate.Add(24 * time.Hour)
+        if nextDate.After(timeRange.EndDate) {
+            nextDate = timeRange.EndDate
+        }
+        jobs = append(jobs, Job{
+            StartTimestamp: currentDate.Unix(),
+            EndTimestamp:   nextDate.Unix(),
+            Date:           currentDate,
+        })
+        currentDate = nextDate
+    }
+    return jobs
+}
+
+// RunAnalysis queues one job per day of the configured time range, runs them
+// on a pool of workers and aggregates the results. Days are fetched in the
+// batches planned by PlanBatches. Progress is published to
+// broker, which may be nil. When ctx is cancelled the days processed so far
+// are returned as a partial result together with the context error.
+func RunAnalysis(ctx context.Context, config Config, client *HTTPClient, query map[string]interface{}, broker *ProgressBroker) (*Result, error) {
+    timeRange := config.TimeRange
+    StartNameScope()
+
+    queue := NewResultQueue(ResultChanBuffer, config.ResultBufferMax)
+    resultChan := queue.In()
+    config.Monitor.Watch("results", 2*ResultChanBuffer, queue.Buffered)
+    config.Monitor.Watch("results_overflow", maxEntries(config.ResultBufferMax), queue.Overflow)
+    errChan := make(chan error, 1)
+    
+    stats := &QueryStats{}
+    stats.ProcessedDays.Store(0)
+    stats.TotalHits.Store(0)
     
-    return []string{usersFilename, providersFilename, summaryFilename}, nil
+    var wg sync.WaitGroup
+
+    batches := make(chan []Job, timeRange.Days)
+
+    var timing *TimingRecorder
+    if config.Timing {
+        timing = NewTimingRecorder()
+    }
+
+    var completedMu sync.Mutex
+    completed := make(map[time.Time]int64)
+    var truncations []*BucketTruncationError
+
+    queryStart := time.Now()
+    publish := func(eventType string, err error) {
+        processed := int(stats.ProcessedDays.Load())
+        event := ProgressEvent{
+            Type:          eventType,
+            Domain:        config.Domain,
+            ProcessedDays: processed,
+            TotalDays:     timeRange.Days,
+            TotalHits:     stats.TotalHits.Load(),
+            InFlight:      int(stats.InFlight.Load()),
+            Elapsed:       time.Since(queryStart).Round(time.Second).String(),
+        }
+        if err != nil {
+            event.Message, event.ErrorClass = err.Error(), ErrorClass(err)
+        }
+        if timeRange.Days > 0 {
+            event.Percent = float64(processed) * 100 / float64(timeRange.Days)
+        }
+        broker.Publish(event)
+    }
+    publish(ProgressStart, nil)
+
+    // Create result storage
+    result := &Result{
+        Users:     make(map[string]*UserStats),
+        Providers: make(map[string]*ProviderStats),
+        StartDate: timeRange.StartDate,
+        EndDate:   timeRange.EndDate,
+        Pivot:      config.Query.Pivot,
+        Exclusions: config.Query.Exclusions,
+        FoldRealmCase: config.Query.FoldRealmCase,
+        Days:       make(map[string]*DayStats),
+        DayVisits:  config.DayVisits,
+        TimestampCutoff: TimestampCutoff(config.now(), config.MaxClockSkew),
+    }
+
+    // Start result processors
+    processDone := make(chan struct{})
+    go func() {
+        ProcessResultsSharded(ctx, queue.Out(), result, config.Processors)
+        close(processDone)
+    }()
+
+    // Days are fetched in batches: one day per query, or several days per
+    // query for short ranges and, with AutoChunk, sparse stretches
+    allJobs := ClampJobs(GenerateJobs(timeRange), result.TimestampCutoff)
+    plan := OrderBatches(PlanBatches(ctx, config, client, query, allJobs), config.JobOrder)
+
+    // Start workers
+    for w := 1; w <= config.NumWorkers; w++ {
+        wg.Add(1)
+        go func(workerId int) {
+            defer wg.Done()
+            for batch := range batches {
+                select {
+                case <-ctx.Done():
+                    return
+                default:
+                }
+                
+                stats.InFlight.Add(1)
+                var batchTiming BatchTiming
+                dayHits, err := BatchWorker(ctx, batch, resultChan, query, config.Query, client, &batchTiming)
+                stats.InFlight.Add(-1)
+                // Truncated days are kept and reported, only other errors
+                // stop the worker
+                truncated, err := splitTruncations(err)
+
+                // Days fetched before an error are kept for partial results
+                var batchHits int64
+                completedMu.Lock()
+                for date, hits := range dayHits {
+                    completed[date] += hits
+                    stats.TotalHits.Add(hits)
+                    batchHits += hits
+                }
+                truncations = append(truncations, truncated...)
+                completedMu.Unlock()
+                timing.Record(workerId, batch, batchTiming, batchHits)
+                current := stats.ProcessedDays.Add(int32(len(dayHits)))
+
+                if err != nil {
+                    select {
+                    case errChan <- fmt.Errorf("worker %d error: %w", workerId, err):
+                    default:
+                    }
+                    return
+                }
+                
+                if !config.Quiet {
+                    fmt.Printf("\rProgress: %d/%d days processed, Progress hits: %d", 
+                        current, timeRange.Days, stats.TotalHits.Load())
+                }
+                publish(ProgressDay, nil)
+            }
+        }(w)
+    }
+
+    // Queue batches
+    for _, batch := range plan {
+        select {
+        case batches <- batch:
+        case <-ctx.Done():
+            break
+        }
+    }
+    close(batches)
+
+    // Wait for workers to finish
+    wg.Wait()
+    queue.Close()
+
+    // Wait for processor to finish
+    <-processDone
+    result.Timing = timing.Report(config.NumWorkers)
+    result.Backpressure = queue.Stats()
+
+    // Record every completed day, including days without activity
+    if result.Days != nil {
+        for date, hits := range completed {
+            day := date.Format(DateFormat)
+            if result.Days[day] == nil {
+                result.Days[day] = &DayStats{}
+            }
+            result.Days[day].Hits = hits
+        }
+    }
+
+    // Days with more users than the bucket limit miss the remaining users
+    slices.SortFunc(truncations, func(a, b *BucketTruncationError) int { return a.Date.Compare(b.Date) })
+    for _, truncation := range truncations {
+        log.Printf("Warning: %v (%s)", truncation, ErrorFields(truncation))
+        result.TruncatedDays = append(result.TruncatedDays, truncation.Date.Format(DateFormat))
+    }
+
+    // On cancellation return what was processed so far, marked as partial
+    if ctx.Err() != nil {
+        result.TotalHits = stats.TotalHits.Load()
+        result.Partial = true
+        for _, job := range allJobs {
+            if _, done := completed[job.Date]; !done {
+                result.UnprocessedDays = append(result.UnprocessedDays, job.Date.Format(DateFormat))
+            }
+        }
+        err := fmt.Errorf("%w (%d of %d days unprocessed): %w", ErrPartialRange, len(result.UnprocessedDays), len(allJobs), ctx.Err())
+        publish(ProgressError, err)
+        return result, err
+    }
+
+    // Check for errors
+    select {
+    case err := <-errChan:
+        if err != nil {
+            publish(ProgressError, err)
+            return nil, err
+        }
+    default:
+    }
+
+    // Events stamped after the cutoff were left out; count them
+    future, err := CountFutureEvents(ctx, client, query, timeRange, result.TimestampCutoff)
+    if err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    result.FutureEvents = future
+
+    // Store final total hits
+    result.TotalHits = stats.TotalHits.Load()
+    publish(ProgressDone, nil)
+
+    return result, nil
 }
 
 func main() {
+    // Dispatch subcommands before parsing the analysis flags
+    if len(os.Args) > 1 {
+        if command, ok := Commands[os.Args[1]]; ok {
+            os.Exit(command.Run(os.Args[2:]))
+        }
+    }
+
     // Define command line flags
-    outputFormat := flag.String("format", DefaultOutputFormat, "Output format (json or csv)")
-    configFile := flag.String("config", PropertiesFile, "Path to configuration file")
+    outputFormat := flag.String("format", DefaultOutputFormat, "Comma-separated output formats (e.g. json,csv)")
+    configFile := flag.String("config", "", "Path to configuration file (default: ./"+PropertiesFile+", then the user config dir under "+AppDirName+"/)")
+    profile := flag.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file (credentials, index, exclusions, output dir, watch interval)")
+    env := flag.String("env", "", "Use the Quickwit environment (e.g. prod, staging) defined by the ENV.<name>.* settings of the configuration file (default: DEFAULT_ENV)")
+    outputDir := flag.String("output-dir", "", "Base directory for output files (default: ./"+OutputDirBase+" if it exists, else the user data dir)")
     // Defined but not implemented yet in this version - ignoring in code to avoid compile errors
     _ = flag.String("log-level", "info", "Log level (error, warn, info, debug)")
     _ = flag.String("log-file", "", "Path to log file")
     numWorkers := flag.Int("workers", 0, "Number of worker goroutines (overrides environment variable)")
+    localeName := flag.String("locale", "", "Locale for human-facing numbers and dates (iso, en, en-gb, th, de, fr)")
+    templateFile := flag.String("template", "", "Path to a Go text/template rendered as an additional report")
+    benchmark := flag.Bool("benchmark", false, "Measure query latency at several worker counts and recommend one, instead of running a report")
+    benchmarkLevels := flag.String("benchmark-levels", DefaultBenchmarkLevels, "Comma-separated worker counts measured by -benchmark")
+    pprofAddr := flag.String("pprof", "", "Address to serve net/http/pprof endpoints on (e.g. localhost:6060)")
+    cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+    memProfile := flag.String("memprofile", "", "Write a heap profile to this file at the end of the run")
+    auditLogFile := flag.String("audit-log", "", "Append a record of every Quickwit query to this file")
+    debugHTTPFile := flag.String("debug-http", "", "Dump the requests and responses of failed Quickwit queries to this file, credentials redacted")
+    debugHTTPMaxSize := flag.Int64("debug-http-max-size", DefaultHTTPDebugMaxSize, "Size in MB at which the -debug-http file is rotated (keeping "+strconv.Itoa(httpDebugBackups)+" old files)")
+    autoChunk := flag.Bool("auto-chunk", false, "Probe daily hit counts first and fetch sparse weeks or months with one query each")
+    chunkHitBudget := flag.Int64("chunk-hit-budget", DefaultChunkHitBudget, "Maximum probed hits fetched with one query by -auto-chunk")
+    singleQueryDays := flag.Int("single-query-days", DefaultSingleQueryDays, "Fetch ranges of up to this many days with one query instead of one query per day (0 disables)")
+    httpCacheTTL := flag.Duration("http-cache-ttl", 0, "Reuse identical Quickwit responses cached on disk for this long (e.g. 1h); 0 disables the cache, -benchmark ignores it")
+    httpCacheDir := flag.String("http-cache-dir", "", "Directory of the HTTP response cache (default: the user cache dir)")
+    storeResults := flag.Bool("store", false, "Append the per-day aggregates of this run to the local store")
+    storeDir := flag.String("store-dir", "", "Directory of the local store (default: the user data dir)")
+    listenAddr := flag.String("listen", "", "Address for the HTTP status listener serving live progress events (e.g. :8080)")
+    realmAlias := flag.String("realm-alias", "", "Treat several realms as one institution, e.g. \"uni=eduroam.uni.ac.th,wifi.uni.ac.th\"; the domain argument is then the alias name")
+    outputFields := flag.String("fields", "all", "Comma-separated output sections ("+strings.Join(OutputFields, ",")+")")
+    maxClockSkew := flag.Duration("max-clock-skew", DefaultMaxClockSkew, "Exclude (and count) events stamped further than this in the future; negative keeps all events")
+    dataQuality := flag.Bool("data-quality", false, "Count records with a missing or empty username, a missing service provider or a future timestamp, per day")
+    inputFiles := flag.String("input", "", "Analyse these log files (comma-separated, globs allowed) instead of querying Quickwit")
+    inputFormat := flag.String("input-format", DefaultInputFormat, "Format of the -input files: "+strings.Join(LogFormatNames(), " or "))
+    inputMap := flag.String("input-map", "", "Field names of ndjson/csv input, e.g. \"username=user.name,timestamp=@timestamp,result=event:accept\" (default: the Quickwit field names)")
+    providerLocations := flag.String("provider-locations", "", "CSV file of provider,country,latitude,longitude used by the geojson format and the roaming split")
+    homeCountry := flag.String("home-country", "", "Country code separating national from international roaming (default: the realm's top-level domain)")
+    public := flag.Bool("public", false, "Write a publishable output without usernames or user lists")
+    publicCountries := flag.Bool("public-countries", false, "With -public, aggregate providers by country (top-level domain); implies -public")
+    maxOutputSize := flag.String("max-output-size", "", "Cut the JSON output down to the summary and the top -max-output-top users and providers when it would exceed this size (e.g. 100MB), noted in query_info")
+    maxOutputTop := flag.Int("max-output-top", DefaultMaxOutputTop, "Number of users and providers kept in a JSON output cut down by -max-output-size")
+    usersPerFile := flag.Int("users-per-file", 0, "Write the user list of the JSON output into numbered files of this many users with an index file when it is longer (0 keeps it in the output)")
+    stableIDs := flag.Bool("stable-ids", false, "With -public, keep the user list with usernames replaced by keyed HMAC identifiers that are the same in every run (key: PSEUDONYM_KEY or "+PseudonymKeyFile+" in the user config dir)")
+    realmCI := flag.Bool("realm-ci", false, "Match the realm case-insensitively, querying every case variant seen in the time range")
+    forecast := flag.Bool("forecast", false, "Project daily unique users and hits over the next -forecast-days with confidence bands")
+    forecastDays := flag.Int("forecast-days", DefaultForecastDays, "Forecast horizon in days for -forecast")
+    impossibleTravel := flag.Bool("impossible-travel", false, "Flag users seen at distant providers (-provider-locations) within an implausibly short time")
+    travelSpeed := flag.Float64("travel-speed", DefaultTravelSpeed, "Travel speed in km/h above which -impossible-travel flags a user")
+    travelDistance := flag.Float64("travel-distance", DefaultTravelDistance, "Minimum distance in km between providers checked by -impossible-travel")
+    jobOrder := flag.String("job-order", DefaultJobOrder, "Order the days are fetched in: newest (first, so interrupted runs keep the latest days) or oldest")
+    resultBufferMax := flag.Int("result-buffer-max", DefaultResultBufferMax, "Number of results queued in memory while the result processors lag the queries, before the queries wait for them (about 100 bytes each; raise it to let the queries run further ahead)")
+    processors := flag.Int("processors", DefaultProcessors, "Number of goroutines aggregating the results, each owning a share of the users (1 disables sharding)")
+    timingReport := flag.Bool("timing", false, "Print per-worker throughput, query latency and the slowest days at the end and add them to run_info")
+    assumeYes := flag.Bool("yes", false, "Start large runs without asking for confirmation")
+    confirmHits := flag.Int64("confirm-hits", DefaultConfirmHits, "Ask for confirmation when a run of "+strconv.Itoa(DefaultEstimateMinDays)+" days or more is estimated at more hits than this (0 never asks)")
+    sample := flag.Int("sample", 0, "Fetch this many recent raw events per provider into the samples debug section (one query per provider)")
+    verify := flag.Bool("verify", false, "Re-count every day with count-only queries and flag days whose aggregated hits differ")
+    countLocal := flag.Bool("count-local", false, "Also count the local (service_provider \"client\") traffic excluded from the roaming analysis")
+    watchInterval := flag.Duration("watch", 0, "Keep running and re-query the current day at this interval (e.g. 15m), rewriting the \"watch\" output files")
+    sortUsers := flag.String("sort-users", DefaultUserSort, "Order of the user list: count (providers), name or first_seen")
+    sortProviders := flag.String("sort-providers", DefaultProviderSort, "Order of the provider list: count (users) or name")
+    bundle := flag.Bool("bundle", false, "Pack the output files of the run and a manifest into a single <name>.tar.gz")
+    encrypt := flag.String("encrypt", "", "Encrypt the output files with age or gpg and remove the plaintext: age:<recipient>[,...] or gpg:<key id>[,...]")
+    checksums := flag.Bool("checksums", false, "Write the SHA-256 sums of the output files to <name>.sha256 (check with the verify command)")
+    sign := flag.String("sign", "", "Sign the sums file (implies -checksums): minisign:<secret key file> or gpg:<key id>")
+    publish := flag.Bool("publish", false, "Publish a run summary and per-provider events to the NATS or Kafka PUBLISH_URL of the configuration file")
+    upload := flag.Bool("upload", false, "Upload the output files to the SFTP or WebDAV UPLOAD_URL of the configuration file")
+    force := flag.Bool("force", false, "Overwrite existing output files of the run")
+    noClobber := flag.Bool("no-clobber", false, "Skip the export, successfully, when output files of the run already exist (default with neither flag: -deterministic and -watch overwrite, other existing outputs are an error)")
+    deterministic := flag.Bool("deterministic", false, "Write diffable outputs: name order, no export time or previous-run comparison, and stable \"report\" file names that are overwritten")
+    outlierFactor := flag.Float64("outlier-factor", DefaultOutlierFactor, "List users with more auths than this multiple of the median as outliers (0 disables)")
+    multiProviderMin := flag.Int("multi-provider-min", DefaultMultiProviderMin, "List users seen at more than this many distinct providers on one day (0 disables)")
+    mobilityFormula := flag.String("mobility-formula", DefaultMobilityFormula, "Per-user mobility score: product (providers x active days), sum, providers or days")
+    providerTimeseries := flag.Bool("provider-timeseries", false, "Include daily user and hit counts for every provider in the output")
+    messageTypeName := flag.String("message-type", "accept", "RADIUS message type analysed: accept, reject, challenge or accounting")
+    pivotName := flag.String("pivot", PivotIdP, "Report perspective: \"idp\" (users of a realm) or \"sp\" (visitors of the service provider given as domain)")
+    noPrefix := flag.Bool("no-prefix", false, "Use the domain as the realm as-is instead of prefixing it with \""+DomainPrefix+"\"")
+    headcount := flag.Int("headcount", 0, "Population (headcount or roster size) of the institution for the adoption percentage (default: HEADCOUNT.<domain> of the configuration file)")
+    heatProviders := flag.Int("heat-providers", DefaultHeatStripProviders, "Number of top providers with a daily heat strip in the html format")
+    baselineParam := flag.String("baseline", "", "Also query the window of this many days (e.g. 30d) right before the range and add the changes against it to the summary")
+    failOnEmpty := flag.Bool("fail-on-empty", false, "Exit with status "+strconv.Itoa(ExitEmptyResult)+" when the result has no users")
+    skipEmptyOutput := flag.Bool("skip-empty-output", false, "Do not write output files when the result has no users")
+    checkDomain := flag.Bool("check-domain", false, "Check that the realm has hits in the range before running, suggesting similar realms if not")
+    includeSubrealms := flag.Bool("include-subrealms", false, "Also match realms below the domain and report a per-realm breakdown (implied by a '*.suffix' domain)")
     
+    packName := flag.String("pack", "", "Apply the flags of the PACK.<name>.* report pack of the configuration file; flags given on the command line win")
+
     // Parse flags
     flag.Parse()
+
+    if *packName != "" {
+        configPath, err := ResolveConfigPath(*configFile)
+        if err == nil {
+            var settings [][2]string
+            if settings, err = ReadPack(configPath, *packName); err == nil {
+                err = ApplyPack(flag.CommandLine, settings)
+            }
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        fmt.Printf("Using report pack %s\n", *packName)
+    }
     
-    // Validate output format
-    if *outputFormat != "json" && *outputFormat != "csv" {
-        fmt.Fprintf(os.Stderr, "Error: Invalid output format. Must be 'json' or 'csv'.\n")
+    // Validate output formats
+    formats, err := ParseFormats(*outputFormat)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
         os.Exit(1)
     }
+    fields, err := ParseFields(*outputFields)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    var providerMapping ProviderMapping
+    if *providerLocations != "" {
+        if providerMapping, err = ReadProviderMapping(*providerLocations); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+    } else if slices.Contains(formats, "geojson") || *impossibleTravel {
+        fmt.Fprintf(os.Stderr, "Error: the geojson format and -impossible-travel need -provider-locations\n")
+        os.Exit(1)
+    }
+    var encryption *Encryption
+    if *encrypt != "" {
+        if encryption, err = ParseEncryption(*encrypt); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+    }
+    var signer *Signer
+    if *sign != "" {
+        if signer, err = ParseSigner(*sign); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+    }
+    if *force && *noClobber {
+        fmt.Fprintf(os.Stderr, "Error: -force and -no-clobber cannot be combined\n")
+        os.Exit(1)
+    }
+    messageType, err := ParseMessageType(*messageTypeName)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    if messageType != MessageAccept && (*inputFiles != "" || *storeResults) {
+        fmt.Fprintf(os.Stderr, "Error: -message-type %s cannot be combined with -input or -store, which only handle accepts\n", *messageTypeName)
+        os.Exit(1)
+    }
+    maxOutputBytes, err := ParseByteSize(*maxOutputSize)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: -max-output-size: %v\n", err)
+        os.Exit(1)
+    }
+    baselineDays := 0
+    if *baselineParam != "" {
+        if baselineDays, err = ParseBaselineDays(*baselineParam); err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        if *inputFiles != "" {
+            fmt.Fprintf(os.Stderr, "Error: -baseline cannot be combined with -input\n")
+            os.Exit(1)
+        }
+    }
+    if _, err := ParseJobOrder(*jobOrder); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    if _, err := ParseUserSort(*sortUsers); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    if _, err := ParseProviderSort(*sortProviders); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    if _, err := ParseMobilityFormula(*mobilityFormula); err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    locale, err := GetLocale(*localeName)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        os.Exit(1)
+    }
+    if *templateFile != "" {
+        templateExporter, err := NewTemplateExporter(*templateFile, locale)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+            os.Exit(1)
+        }
+        RegisterExporter("template", templateExporter)
+        formats = append(formats, "template")
+    }
+
+    if *pprofAddr != "" {
+        StartPprofServer(*pprofAddr)
+    }
+    stopProfiling, err := StartProfiling(*cpuProfile, *memProfile)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        exit(1)
+    }
+    atExit(func(code int) int {
+        stopProfiling()
+        return code
+    })
     
     // Setup signal handling for graceful shutdown
     ctx, cancel := context.WithCancel(context.Background())
@@ -953,80 +2431,270 @@ func main() {
     signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
     go func() {
         <-signalChan
-        log.Println("Received termination signal, shutting down gracefully...")
+        log.Println("Received termination signal, saving partial results (signal again to abort)...")
         cancel()
+        <-signalChan
+        log.Println("Received second termination signal, aborting")
+        exit(1)
     }()
 
     // Check remaining arguments
     args := flag.Args()
     if len(args) < 1 || len(args) > 2 {
         fmt.Println("Usage: ./eduroam-idp [flags] <domain> [days|Ny|yxxxx|DD-MM-YYYY]")
+        fmt.Println("       ./eduroam-idp <command> [args]")
         fmt.Println("  <domain>: domain to search for (e.g., 'example.ac.th', 'etlr1')")
         fmt.Println("  [days]: number of days (1-3650)")
         fmt.Println("  [Ny]: number of years (1y-10y)")
         fmt.Println("  [yxxxx]: specific year (e.g., y2024)")
         fmt.Println("  [DD-MM-YYYY]: specific date")
         fmt.Println()
+        PrintCommands()
+        fmt.Println()
         fmt.Println("Flags:")
         flag.PrintDefaults()
-        os.Exit(1)
+        exit(1)
     }
 
     domain := args[0]
     var timeRange TimeRange
 
+    rangeParam := ""
     if len(args) == 2 {
-        var err error
-        timeRange, err = ParseTimeRange(args[1])
+        rangeParam = args[1]
+    }
+    timeRange, err = ResolveTimeRange(rangeParam)
+    if err != nil {
+        fatalf("Error parsing time range parameter: %v", err)
+    }
+
+    // Log files are analysed without Quickwit, so the properties file is
+    // only required when one was given explicitly
+    var inputPaths []string
+    var logParser LogParser
+    if *inputFiles != "" {
+        if *watchInterval > 0 || *benchmark || *countLocal || *verify || *dataQuality || *impossibleTravel || *sample > 0 {
+            fatalf("Error: -input cannot be combined with -watch, -benchmark, -count-local, -verify, -data-quality, -impossible-travel or -sample")
+        }
+        if inputPaths, err = ExpandInputPaths(*inputFiles); err != nil {
+            fatalf("Error: %v", err)
+        }
+        mapping, err := ParseFieldMapping(*inputMap)
         if err != nil {
-            log.Fatalf("Error parsing time range parameter: %v", err)
+            fatalf("Error: %v", err)
+        }
+        if logParser, err = NewLogParser(*inputFormat, mapping); err != nil {
+            fatalf("Error: %v", err)
         }
-    } else {
-        // Default: 1 day
-        timeRange.Days = 1
-        timeRange.EndDate = time.Now()
-        timeRange.StartDate = timeRange.EndDate.AddDate(0, 0, -1)
     }
-
-    // Normalize date times to beginning/end of day
-    timeRange.StartDate = time.Date(timeRange.StartDate.Year(), timeRange.StartDate.Month(), timeRange.StartDate.Day(), 0, 0, 0, 0, timeRange.StartDate.Location())
-    timeRange.EndDate = time.Date(timeRange.EndDate.Year(), timeRange.EndDate.Month(), timeRange.EndDate.Day(), 23, 59, 59, 999999999, timeRange.EndDate.Location())
-
-    props, err := ReadProperties(*configFile)
-    if err != nil {
-        log.Fatalf("Error reading properties: %v", err)
+    configPath, err := ResolveConfigPath(*configFile)
+    var props Properties
+    if err == nil {
+        props, err = ReadProperties(configPath, *profile, *env)
+    }
+    if err != nil && (inputPaths == nil || *configFile != "") {
+        fatalf("Error reading properties: %v", err)
+    }
+    if props.Aliases == nil {
+        props = Properties{Index: DefaultIndex, Balance: BalanceFailover, Aliases: make(map[string]string), Countries: make(CountryMap)}
+    }
+    if props.Env != "" && inputPaths == nil {
+        fmt.Printf("Using Quickwit environment %s (%s)\n", props.Env, props.QWURL)
+    }
+    if *outputDir == "" {
+        *outputDir = props.OutputDir
+    }
+    if *watchInterval == 0 {
+        *watchInterval = props.Watch
+    }
+    var bigQuery *BigQueryTarget
+    if slices.Contains(formats, "bigquery") {
+        if bigQuery, err = NewBigQueryTarget(props); err != nil {
+            fatalf("Error: %v", err)
+        }
+    }
+    var publisher *Publisher
+    if *publish {
+        if publisher, err = NewPublisher(props); err != nil {
+            fatalf("Error: %v", err)
+        }
+    }
+    var uploadTarget *UploadTarget
+    if *upload {
+        if uploadTarget, err = NewUploadTarget(props); err != nil {
+            fatalf("Error: %v", err)
+        }
     }
 
     httpClient := NewHTTPClient(props)
+    if *auditLogFile != "" {
+        auditLog, err := OpenAuditLog(*auditLogFile, domain)
+        if err != nil {
+            fatalf("Error: %v", err)
+        }
+        // The audit log is evidence of the queries made: a run that lost
+        // records fails even if the analysis succeeded
+        atExit(func(code int) int {
+            if err := auditLog.Close(); err != nil {
+                log.Printf("Error: %v (%s)", err, ErrorFields(err))
+                if code == 0 {
+                    return ExitCode(err)
+                }
+            }
+            return code
+        })
+        httpClient.SetAuditLog(auditLog)
+    }
+    if *debugHTTPFile != "" {
+        debugLog, err := OpenHTTPDebugLog(*debugHTTPFile, *debugHTTPMaxSize<<20)
+        if err != nil {
+            fatalf("Error: %v", err)
+        }
+        defer debugLog.Close()
+        httpClient.SetHTTPDebugLog(debugLog)
+    }
+    if *httpCacheTTL > 0 && !*benchmark {
+        dir := *httpCacheDir
+        if dir == "" {
+            if dir, err = DefaultHTTPCacheDir(); err != nil {
+                fatalf("Error: %v", err)
+            }
+        }
+        cache, err := NewResponseCache(dir, *httpCacheTTL)
+        if err != nil {
+            fatalf("Error: %v", err)
+        }
+        httpClient.SetResponseCache(cache)
+    }
 
     // Display query parameters
     if timeRange.SpecificDate {
-        fmt.Printf("Searching for date: %s\n", timeRange.StartDate.Format(DateFormat))
+        fmt.Printf("Searching for date: %s\n", locale.FormatDate(timeRange.StartDate))
     } else if timeRange.SpecificYear {
         fmt.Printf("Searching for year: %d\n", timeRange.Year)
     } else {
         fmt.Printf("Searching from %s to %s (%d days)\n", 
-            timeRange.StartDate.Format(DateFormat), 
-            timeRange.EndDate.Format(DateFormat),
+            locale.FormatDate(timeRange.StartDate), 
+            locale.FormatDate(timeRange.EndDate),
             timeRange.Days)
     }
 
-    domainName := GetDomain(domain)
+    pivot, err := ParsePivot(*pivotName)
+    if err != nil {
+        fatalf("Error: %v", err)
+    }
+
+    domainName := GetDomain(domain, props.Aliases, *noPrefix)
+    if pivot == PivotIdP && *realmAlias == "" {
+        realm := domainName
+        if IsWildcardDomain(domain) {
+            realm = domain
+        }
+        if err := ValidateDomain(realm); err != nil {
+            fatalf("Error: %v", err)
+        }
+    }
+    realms := []string{domainName}
+    var discovered []RealmCount
+    subrealms := *includeSubrealms || IsWildcardDomain(domain)
+    if pivot == PivotSP && (*realmAlias != "" || subrealms || *storeResults || *countLocal || *realmCI || *impossibleTravel) {
+        fatalf("Error: -pivot %s cannot be combined with -realm-alias, sub-realm matching, -store, -count-local, -realm-ci or -impossible-travel", PivotSP)
+    }
+    if pivot == PivotSP {
+        domainName = domain
+        fmt.Printf("Reporting visitors of service provider %s\n", domain)
+    } else if *realmAlias != "" {
+        aliasName, aliasRealms, err := ParseRealmAlias(*realmAlias)
+        if err != nil {
+            fatalf("Error: %v", err)
+        }
+        if aliasName != domain {
+            fatalf("Error: domain %q does not match realm alias %q", domain, aliasName)
+        }
+        for _, realm := range aliasRealms {
+            if err := ValidateDomain(realm); err != nil {
+                fatalf("Error: %v", err)
+            }
+        }
+        if subrealms {
+            fatalf("Error: -realm-alias cannot be combined with sub-realm matching")
+        }
+        realms = aliasRealms
+        domainName = domain
+        fmt.Printf("Alias %s covers realms: %s\n", domain, strings.Join(realms, ", "))
+    } else if subrealms && inputPaths == nil {
+        realms, discovered, err = ResolveSubrealms(ctx, httpClient, domain, domainName, timeRange)
+        if err != nil {
+            exitWithError("Error", err)
+        }
+        if !IsWildcardDomain(domain) {
+            lookalikes := FindLookalikes(discovered, []string{domainName, domain}, DefaultLookalikeDistance, realms)
+            if len(lookalikes) > 0 {
+                printLookalikes(lookalikes, domainName)
+            }
+        }
+        domainName = domain
+        fmt.Printf("Matched %d realms: %s\n", len(realms), strings.Join(realms, ", "))
+    }
+    exclusions, err := ParseExclusions(props.Exclusions)
+    if err != nil {
+        fatalf("Error reading properties: %v", err)
+    }
+    // Realms with non-ASCII labels may be logged in either form, and with
+    // -realm-ci in any letter case
+    reportRealms := UnicodeRealms(realms)
+    if pivot == PivotIdP {
+        realms = RealmVariants(realms)
+        if *realmCI && inputPaths == nil {
+            if discovered == nil {
+                if discovered, err = DiscoverRealms(ctx, httpClient, timeRange, DefaultRealmDiscoverySize); err != nil {
+                    exitWithError("Error discovering realms", err)
+                }
+            }
+            realms = CaseVariants(realms, discovered)
+            fmt.Printf("Matching realm case variants: %s\n", strings.Join(realms, ", "))
+        }
+        if *checkDomain && inputPaths == nil && !subrealms {
+            if discovered == nil {
+                if discovered, err = DiscoverRealms(ctx, httpClient, timeRange, DefaultRealmDiscoverySize); err != nil {
+                    exitWithError("Error discovering realms", err)
+                }
+            }
+            if !RealmKnown(discovered, realms, *realmCI) {
+                fmt.Printf("No hits for %s in this range\n", strings.Join(reportRealms, ", "))
+                printDomainSuggestions(discovered, domain, domainName)
+                exit(1)
+            }
+        }
+    }
+    queryString := BuildRealmQuery(messageType, realms, exclusions)
+    if pivot == PivotSP {
+        queryString = BuildServiceProviderQuery(messageType, domain, exclusions)
+    }
     query := map[string]interface{}{
-        "query":           fmt.Sprintf(`message_type:"Access-Accept" AND realm:"%s" NOT service_provider:"client"`, domainName),
+        "query":           queryString,
         "start_timestamp": timeRange.StartDate.Unix(),
         "end_timestamp":   timeRange.EndDate.Unix(),
         "max_hits":        10000,
     }
 
-    resultChan := make(chan LogEntry, ResultChanBuffer)
-    errChan := make(chan error, 1)
-    
-    stats := &QueryStats{}
-    stats.ProcessedDays.Store(0)
-    stats.TotalHits.Store(0)
-    
-    var wg sync.WaitGroup
+    if *benchmark {
+        levels, err := ParseBenchmarkLevels(*benchmarkLevels)
+        if err != nil {
+            fatalf("Error: %v", err)
+        }
+        benchRange := TimeRange{Days: DefaultBenchmarkDays, EndDate: timeRange.EndDate}
+        benchRange.StartDate = benchRange.EndDate.AddDate(0, 0, -DefaultBenchmarkDays+1)
+        benchRange.StartDate = time.Date(benchRange.StartDate.Year(), benchRange.StartDate.Month(), benchRange.StartDate.Day(), 0, 0, 0, 0, benchRange.StartDate.Location())
+
+        fmt.Printf("Benchmarking %d daily queries for %s at worker counts %v\n", DefaultBenchmarkDays, domainName, levels)
+        results, err := RunBenchmark(ctx, httpClient, query, GenerateJobs(benchRange), levels)
+        if err != nil {
+            exitWithError("Benchmark failed", err)
+        }
+        fmt.Printf("Recommended NUM_WORKERS: %d\n", RecommendWorkers(results))
+        exit(0)
+    }
 
     // Determine workers count
     workersCount := GetNumWorkers()
@@ -1034,129 +2702,530 @@ func main() {
         workersCount = *numWorkers
     }
 
-    jobs := make(chan Job, timeRange.Days)
+    monitor := StartResourceMonitor()
+    defer monitor.Stop()
+
+    config := Config{
+        Domain:       domain,
+        OutputFormat: *outputFormat,
+        NumWorkers:   workersCount,
+        TimeRange:    timeRange,
+        Query:        QueryOptions{RealmBreakdown: (len(reportRealms) > 1 || subrealms && inputPaths != nil) && pivot == PivotIdP, Pivot: pivot, Exclusions: exclusions, FoldRealmCase: *realmCI},
+        SingleQueryDays: *singleQueryDays,
+        AutoChunk:       *autoChunk,
+        ChunkHitBudget:  *chunkHitBudget,
+        MaxClockSkew:    *maxClockSkew,
+        Timing:          *timingReport,
+        Processors:      *processors,
+        JobOrder:        *jobOrder,
+        Monitor:         monitor,
+        ResultBufferMax: *resultBufferMax,
+        DayVisits:       NeedsDayVisits(formats, fields, pivot, *providerTimeseries, *storeResults, *impossibleTravel),
+    }
+
+    broker := NewProgressBroker()
+    WatchStatusSignal(broker)
+    if *listenAddr != "" {
+        StartStatusServer(ctx, *listenAddr, broker, CachedReadiness(httpClient.Ping, ReadinessCacheTTL))
+        fmt.Printf("Progress events available at http://%s/events\n", *listenAddr)
+    }
+
+    meta := ExportMeta{
+        Domain:             domain,
+        TimeRange:          timeRange,
+        OutputDir:          ResolveOutputDir(*outputDir),
+        ProviderTimeseries: *providerTimeseries,
+        MobilityFormula:    *mobilityFormula,
+        OutlierFactor:      *outlierFactor,
+        MultiProviderMin:   *multiProviderMin,
+        MessageType:        messageType,
+        Fields:             fields,
+        SortUsers:          *sortUsers,
+        SortProviders:      *sortProviders,
+        Public:             *public || *publicCountries,
+        PublicCountries:    *publicCountries,
+        HomeCountry:        strings.ToUpper(*homeCountry),
+        Countries:          props.Countries,
+        Locations:          providerMapping.Locations,
+        BigQuery:           bigQuery,
+        Environment:        props.Env,
+        HeatStripProviders: *heatProviders,
+        MaxOutputSize:      maxOutputBytes,
+        MaxOutputTop:       *maxOutputTop,
+        UsersPerFile:       *usersPerFile,
+    }
+    if pivot == PivotIdP {
+        meta.Population = props.Headcount(domain, domainName)
+        if *headcount > 0 {
+            meta.Population = *headcount
+        }
+    }
+    // COUNTRY properties take precedence over the -provider-locations file
+    for provider, country := range providerMapping.Countries {
+        if _, ok := props.Countries[provider]; !ok {
+            props.Countries[provider] = country
+        }
+    }
+    if meta.HomeCountry == "" {
+        meta.HomeCountry = props.Countries.Country(domainName)
+    }
+    if *stableIDs {
+        if !meta.Public {
+            fatalf("Error: -stable-ids needs -public or -public-countries")
+        }
+        keyPath := props.PseudonymKey
+        if keyPath == "" {
+            if keyPath, err = DefaultPseudonymKeyPath(); err != nil {
+                fatalf("Error: %v", err)
+            }
+        }
+        if meta.Pseudonyms, err = LoadPseudonymKey(keyPath); err != nil {
+            fatalf("Error: %v", err)
+        }
+        fmt.Printf("Replacing usernames by stable identifiers (key %s)\n", meta.Pseudonyms.Fingerprint())
+    }
+    if meta.Public {
+        meta.Fields = PublicFields(meta.Fields, meta.Pseudonyms != nil)
+    }
+    if *deterministic {
+        // Name order keeps list positions stable when counts change
+        meta.Deterministic = true
+        meta.SortUsers, meta.SortProviders = SortByName, SortByName
+        meta.BaseName = "report"
+    }
+    // Stable file names exist to be rewritten
+    meta.Overwrite = *force || (!*noClobber && (*deterministic || *watchInterval > 0))
+
+    // Long runs are estimated with a count-only query first
+    calibrationPath := CalibrationPath()
+    calibration, err := LoadCalibration(calibrationPath)
+    if err != nil {
+        log.Printf("Warning: %v", err)
+    }
+    if inputPaths == nil && *watchInterval == 0 && timeRange.Days >= DefaultEstimateMinDays {
+        estimate, err := EstimateRun(ctx, httpClient, query, timeRange, calibration)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+        } else {
+            summary := fmt.Sprintf("Estimated %s hits", locale.FormatInt(estimate.Hits))
+            if estimate.Calibrated {
+                summary += fmt.Sprintf(", about %v and %s of output", estimate.Duration, FormatBytes(estimate.OutputBytes))
+            }
+            fmt.Println(summary)
+            if *confirmHits > 0 && estimate.Hits > *confirmHits && !*assumeYes {
+                if err := ConfirmRun(os.Stdin, os.Stdout, summary); err != nil {
+                    fatalf("Error: %v", err)
+                }
+            }
+        }
+    }
 
     queryStart := time.Now()
+    runID := queryStart.Format("20060102-150405")
+    meta.RunTime = queryStart
     fmt.Printf("Using %d workers\n", workersCount)
 
-    // Create result storage
-    result := &Result{
-        Users:     make(map[string]*UserStats),
-        Providers: make(map[string]*ProviderStats),
-        StartDate: timeRange.StartDate,
-        EndDate:   timeRange.EndDate,
-    }
+    if *watchInterval > 0 {
+        if *storeResults {
+            fatalf("Error: -watch cannot be combined with -store")
+        }
+        if *failOnEmpty || *skipEmptyOutput {
+            fatalf("Error: -watch cannot be combined with -fail-on-empty or -skip-empty-output")
+        }
+        if baselineDays > 0 {
+            fatalf("Error: -watch cannot be combined with -baseline")
+        }
+        if *noClobber || *bundle || encryption != nil || *checksums || signer != nil || uploadTarget != nil || publisher != nil {
+            fatalf("Error: -watch rewrites its output and cannot be combined with -no-clobber, -bundle, -encrypt, -checksums, -sign, -upload or -publish")
+        }
+        // Watch mode rewrites the same files on every update
+        meta.BaseName = "watch"
+        fmt.Printf("Watching %s, refreshing today every %v\n", domain, *watchInterval)
+
+        // SIGHUP re-reads the properties file; the realm query is kept
+        WatchReloadSignal(func() {
+            props, err := ReadProperties(configPath, *profile, *env)
+            if err != nil {
+                log.Printf("Reload failed, keeping the current configuration: %v", err)
+                return
+            }
+            httpClient.SetProperties(props)
+            log.Printf("Reloaded configuration from %s", configPath)
+        })
 
-    // Start workers
-    for w := 1; w <= workersCount; w++ {
-        wg.Add(1)
-        go func(workerId int) {
-            defer wg.Done()
-            for job := range jobs {
-                select {
-                case <-ctx.Done():
-                    return
-                default:
+        // The watchdog is only fed while updates keep arriving
+        var lastUpdate atomic.Int64
+        stopWatchdog := make(chan struct{})
+        defer close(stopWatchdog)
+        StartWatchdog(stopWatchdog, func() bool {
+            last := lastUpdate.Load()
+            return last == 0 || time.Since(time.Unix(0, last)) < 3*(*watchInterval)+DefaultHTTPTimeout
+        })
+
+        err := RunWatch(ctx, config, httpClient, query, broker, *watchInterval, func(result *Result, watchRange TimeRange) error {
+            if lastUpdate.Swap(time.Now().UnixNano()) == 0 {
+                if err := SdNotify("READY=1"); err != nil {
+                    log.Printf("Error notifying service manager: %v", err)
                 }
-                
-                hits, err := Worker(ctx, job, resultChan, query, httpClient)
-                if err != nil {
-                    select {
-                    case errChan <- fmt.Errorf("worker %d error: %w", workerId, err):
-                    default:
+            }
+            meta.TimeRange = watchRange
+            meta.Partial = result.Partial
+            filenames, err := RunExporters(formats, result, meta)
+            if err != nil {
+                return err
+            }
+            status := fmt.Sprintf("%s users, %s providers, %s hits",
+                locale.FormatInt(int64(len(result.Users))), locale.FormatInt(int64(len(result.Providers))),
+                locale.FormatInt(result.TotalHits))
+            fmt.Printf("\n%s: %s; updated %d files\n", time.Now().Format(DateTimeFormat), status, len(filenames))
+            SdNotify("STATUS=" + status)
+            return nil
+        })
+        SdNotify("STOPPING=1")
+        if err != nil && !errors.Is(err, context.Canceled) {
+            exitWithError("Error occurred", err)
+        }
+        exit(0)
+    }
+
+    var result *Result
+    if inputPaths != nil {
+        // Realms are matched case-insensitively, and below the domain
+        // when sub-realms are included
+        filter := LogFilter{
+            Exclusions: exclusions,
+            Start:      timeRange.StartDate,
+            End:        timeRange.EndDate,
+            MatchRealm: func(realm string) bool {
+                for _, r := range realms {
+                    if strings.EqualFold(RealmToUnicode(realm), RealmToUnicode(r)) {
+                        return true
                     }
-                    return
                 }
-                
-                stats.TotalHits.Add(hits)
-                current := stats.ProcessedDays.Add(1)
-                
-                fmt.Printf("\rProgress: %d/%d days processed, Progress hits: %d", 
-                    current, timeRange.Days, stats.TotalHits.Load())
+                return subrealms && len(MatchSubrealms([]RealmCount{{Realm: realm}}, domain, domainName)) > 0
+            },
+        }
+        if pivot == PivotSP {
+            filter.Provider = domain
+        }
+        fmt.Printf("Reading %d %s log files\n", len(inputPaths), *inputFormat)
+        result, err = AnalyzeLogFiles(ctx, inputPaths, logParser, filter, config)
+    } else {
+        result, err = RunAnalysis(ctx, config, httpClient, query, broker)
+    }
+    // The calibration rates the main analysis only, not the baseline and
+    // check queries below
+    queryDuration := time.Since(queryStart)
+    if err != nil && !(errors.Is(err, context.Canceled) && result != nil) {
+        exitWithError("Error occurred", err)
+    }
+    if result.Partial {
+        fmt.Printf("\nOperation cancelled. Saving partial results (%d of %d days unprocessed).\n",
+            len(result.UnprocessedDays), timeRange.Days)
+    } else if result.TotalHits == 0 {
+        // An empty report usually means a mistyped domain
+        fmt.Printf("\nWarning: no hits for %s in this range\n", domain)
+        if pivot == PivotIdP && inputPaths == nil && !IsWildcardDomain(domain) {
+            var err error
+            if discovered == nil {
+                discovered, err = DiscoverRealms(ctx, httpClient, timeRange, DefaultRealmDiscoverySize)
             }
-        }(w)
+            if err != nil {
+                log.Printf("Warning: error discovering realms: %v", err)
+            } else {
+                printDomainSuggestions(discovered, domain, domainName)
+            }
+        }
     }
 
-    // Start result processor
-    processDone := make(chan struct{})
-    go func() {
-        ProcessResults(ctx, resultChan, result)
-        close(processDone)
-    }()
+    if *countLocal && !result.Partial {
+        local, err := CountLocalTraffic(ctx, httpClient, messageType, realms, timeRange, result.TotalHits)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+        } else {
+            result.Local = local
+        }
+    }
 
-    // Queue jobs
-    currentDate := timeRange.StartDate
-    for currentDate.Before(timeRange.EndDate) {
-        nextDate := currentDate.Add(24 * time.Hour)
-        if nextDate.After(timeRange.EndDate) {
-            nextDate = timeRange.EndDate
+    if *dataQuality && !result.Partial {
+        report, err := CheckDataQuality(ctx, httpClient, query, timeRange)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+        } else {
+            result.DataQuality = report
         }
-        select {
-        case jobs <- Job{
-            StartTimestamp: currentDate.Unix(),
-            EndTimestamp:   nextDate.Unix(),
-            Date:           currentDate,
-        }:
-        case <-ctx.Done():
-            break
+    }
+    if *verify && !result.Partial {
+        report, err := VerifyResult(ctx, httpClient, query, result, timeRange)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+        } else {
+            result.Verification = report
         }
-        currentDate = nextDate
     }
-    close(jobs)
-
-    // Wait for workers to finish
-    wg.Wait()
-    close(resultChan)
-
-    // Wait for processor to finish
-    select {
-    case <-processDone:
-    case <-ctx.Done():
-        fmt.Println("\nOperation cancelled.")
-        os.Exit(1)
+    if *impossibleTravel && !result.Partial {
+        report, err := CheckImpossibleTravel(ctx, httpClient, query, result, providerMapping.Locations, *travelSpeed, *travelDistance)
+        if err != nil {
+            log.Printf("Warning: %v", err)
+        } else {
+            result.Security = report
+        }
     }
-
-    // Check for errors
-    select {
-    case err := <-errChan:
+    if *sample > 0 && !result.Partial {
+        samples, err := FetchProviderSamples(ctx, httpClient, query, result, *sample)
         if err != nil {
-            log.Fatalf("Error occurred: %v", err)
+            log.Printf("Warning: %v", err)
+        } else {
+            result.Samples = samples
+        }
+    }
+    if *forecast && !result.Partial {
+        if result.Forecast = ForecastUsage(result, *forecastDays, time.Now()); result.Forecast == nil {
+            log.Printf("Warning: a forecast needs at least %d complete days", forecastSeason)
+        }
+    }
+    if baselineDays > 0 && !result.Partial {
+        baselineRange := BaselineRange(timeRange, baselineDays)
+        fmt.Printf("\nQuerying the baseline from %s to %s\n", baselineRange.StartDate.Format(DateFormat), baselineRange.EndDate.Format(DateFormat))
+        baselineConfig := config
+        baselineConfig.TimeRange = baselineRange
+        baseline, err := RunAnalysis(ctx, baselineConfig, httpClient, query, broker)
+        if err != nil && !(errors.Is(err, context.Canceled) && baseline != nil) {
+            log.Printf("Warning: not comparing with the baseline: %v", err)
+        } else {
+            meta.Baseline = CompareBaseline(result, timeRange, baseline, baselineRange)
+            meta.LostProviders = FindLostProviders(result, baseline, baselineRange, LostSourceBaseline)
+        }
+    }
+    if *storeResults && meta.LostProviders == nil && !result.Partial {
+        store, err := OpenStore(ResolveStoreDir(*storeDir))
+        if err == nil {
+            meta.LostProviders, err = FindLostProvidersInStore(store, domain, result, timeRange)
+        }
+        if err != nil {
+            log.Printf("Warning: not checking for lost providers: %v", err)
         }
-    default:
     }
 
-    // Store final total hits
-    result.TotalHits = stats.TotalHits.Load()
-
-    queryDuration := time.Since(queryStart)
+    result.Resources = monitor.Report()
 
     fmt.Printf("\n")
-    fmt.Printf("Number of users: %d\n", len(result.Users))
-    fmt.Printf("Number of providers: %d\n", len(result.Providers))
-    fmt.Printf("Total hits: %d\n", result.TotalHits)
+    fmt.Printf("Number of users: %s\n", locale.FormatInt(int64(len(result.Users))))
+    if pivot == PivotSP {
+        fmt.Printf("Number of realms: %s\n", locale.FormatInt(int64(len(result.Providers))))
+    } else {
+        fmt.Printf("Number of providers: %s\n", locale.FormatInt(int64(len(result.Providers))))
+    }
+    fmt.Printf("Total hits: %s\n", locale.FormatInt(result.TotalHits))
+    for _, stat := range SubrealmStats(result) {
+        fmt.Printf("  %s: %s users, %s hits\n", stat.Realm,
+            locale.FormatInt(int64(stat.UserCount)), locale.FormatInt(stat.Hits))
+    }
+    if result.Local != nil {
+        fmt.Printf("Local hits: %s (about %s users), roaming share %.1f%%\n",
+            locale.FormatInt(result.Local.Hits), locale.FormatInt(result.Local.UniqueUsers),
+            result.Local.RoamingShare*100)
+    }
+    if adoption := ComputeAdoption(result, meta.Population); adoption != nil {
+        fmt.Printf("Adoption: %.1f%% of a population of %s\n", adoption.Percent, locale.FormatInt(int64(adoption.Population)))
+    }
+    if comparison := meta.Baseline; comparison != nil {
+        partial := ""
+        if comparison.Partial {
+            partial = ", partial"
+        }
+        fmt.Printf("Versus the previous %d days (%s to %s%s): users %s, providers %s, hits %s, hits per day %s, mean daily users %s\n",
+            comparison.Days, comparison.StartDate[:10], comparison.EndDate[:10], partial,
+            formatChangePercent(comparison.UsersChange), formatChangePercent(comparison.ProvidersChange),
+            formatChangePercent(comparison.HitsChange), formatChangePercent(comparison.HitsPerDayChange),
+            formatChangePercent(comparison.MeanDailyUsersChange))
+    }
+    if meta.LostProviders != nil && meta.Fields.Has(FieldLost) {
+        printLostProviders(meta.LostProviders)
+    }
+    if split := ComputeRoamingSplit(result, meta.HomeCountry, meta.Countries); split != nil {
+        fmt.Printf("National roaming (%s): %s users, %s providers, %s hits\n", split.HomeCountry,
+            locale.FormatInt(int64(split.National.Users)), locale.FormatInt(int64(split.National.Providers)), locale.FormatInt(split.National.Hits))
+        fmt.Printf("International roaming: %s users, %s providers, %s hits (%s users in both)\n",
+            locale.FormatInt(int64(split.International.Users)), locale.FormatInt(int64(split.International.Providers)),
+            locale.FormatInt(split.International.Hits), locale.FormatInt(int64(split.BothUsers)))
+    }
+    if result.FutureEvents > 0 {
+        fmt.Printf("Excluded %s events with timestamps in the future (clock skew)\n", locale.FormatInt(result.FutureEvents))
+    }
+    if result.DataQuality != nil {
+        total := result.DataQuality.Total
+        fmt.Printf("Data quality: %s missing usernames, %s empty usernames, %s missing service providers, %s future timestamps\n",
+            locale.FormatInt(total.MissingUsername), locale.FormatInt(total.EmptyUsername),
+            locale.FormatInt(total.MissingProvider), locale.FormatInt(total.FutureTimestamps))
+    }
+    if meta.Fields.Has(FieldOutliers) {
+        if report := FindOutliers(result, meta.OutlierFactor); report != nil && len(report.Users) > 0 {
+            fmt.Printf("Outliers: %s users above %.0f auths (%gx the median of %s), %.1f%% of all hits\n",
+                locale.FormatInt(int64(len(report.Users))), report.Threshold, report.Factor,
+                locale.FormatInt(report.Median), report.OutlierShare*100)
+        }
+    }
+    if meta.Fields.Has(FieldMultiProvider) && pivot != PivotSP {
+        if report := FindMultiProviderDays(result, meta.MultiProviderMin); report != nil && len(report.Days) > 0 {
+            fmt.Printf("Multi-provider: %s users at more than %d providers on %s user-days\n",
+                locale.FormatInt(int64(report.Users)), report.Min, locale.FormatInt(int64(len(report.Days))))
+        }
+    }
+    if result.Security != nil {
+        fmt.Printf("Impossible travel: %s incidents\n", locale.FormatInt(int64(len(result.Security.ImpossibleTravel))))
+        for _, incident := range result.Security.ImpossibleTravel {
+            fmt.Printf("  %s on %s: %s at %s, %s at %s (%.0f km, %.0f km/h)\n", incident.Username, incident.Date,
+                incident.From, incident.FromTime[11:], incident.To, incident.ToTime[11:], incident.DistanceKm, incident.SpeedKmh)
+        }
+    }
+    if result.Samples != nil {
+        sampled := 0
+        for _, sample := range result.Samples {
+            sampled += len(sample.Hits)
+        }
+        fmt.Printf("Samples: %s raw events from %s providers\n", locale.FormatInt(int64(sampled)), locale.FormatInt(int64(len(result.Samples))))
+    }
+    if result.Timing != nil {
+        PrintTimingReport(result.Timing)
+    }
+    PrintResourceReport(result.Resources)
+    if stats := result.Backpressure; stats != nil && stats.Overflowed > 0 {
+        fmt.Printf("Result backlog: %s entries queued beyond the channel buffer (high water %s of %s), queries waited %d times for %v\n",
+            locale.FormatInt(stats.Overflowed), locale.FormatInt(int64(stats.OverflowHighWater)), locale.FormatInt(int64(stats.OverflowMax)),
+            stats.Stalls, (time.Duration(stats.StallMs*1000) * time.Microsecond).Round(time.Millisecond))
+    }
+    if result.Forecast != nil {
+        fmt.Printf("Forecast for the next %d days (%s): %.1f daily users (%.1f - %.1f), %s hits (%s - %s)\n",
+            result.Forecast.Days, result.Forecast.Method, result.Forecast.MeanDailyUsers.Value,
+            result.Forecast.MeanDailyUsers.Low, result.Forecast.MeanDailyUsers.High,
+            locale.FormatInt(int64(result.Forecast.TotalHits.Value)), locale.FormatInt(int64(result.Forecast.TotalHits.Low)),
+            locale.FormatInt(int64(result.Forecast.TotalHits.High)))
+    }
+    if result.Verification != nil {
+        if result.Verification.Mismatches == 0 {
+            fmt.Printf("Verification: all days match the count-only queries\n")
+        }
+        for _, day := range result.Verification.Days {
+            if day.Missing != 0 {
+                fmt.Printf("Verification mismatch on %s: counted %s hits, aggregated %s\n", day.Date,
+                    locale.FormatInt(day.CountedHits), locale.FormatInt(day.AggregatedHits))
+            }
+        }
+    }
+
+    if *storeResults {
+        store, err := OpenStore(ResolveStoreDir(*storeDir))
+        if err != nil {
+            fatalf("Error opening store: %v", err)
+        }
+        if meta.ProviderHistory, err = store.ProviderFirstDates(domain); err != nil {
+            log.Printf("Warning: not marking new providers: %v", err)
+        }
+        stored, overlaps, err := store.Append(domain, result.Days, runID)
+        if err != nil {
+            fatalf("Error appending to store: %v", err)
+        }
+        fmt.Printf("Stored %d days in the local store\n", stored)
+        if len(overlaps) > 0 {
+            fmt.Printf("Replaced %d previously stored days (%s to %s)\n",
+                len(overlaps), overlaps[0], overlaps[len(overlaps)-1])
+        }
+        if meta.Fields.Has(FieldTimeline) {
+            printNewProviders(BuildProviderTimeline(result, meta.ProviderHistory, timeRange))
+        }
+    }
 
-    // Export according to format
+    // Export with every requested format
     exportStart := time.Now()
-    if *outputFormat == "csv" {
-        filenames, err := ExportToCSV(result, domain, timeRange)
+    meta.Partial = result.Partial
+    empty := len(result.Users) == 0 && !result.Partial
+    if empty && *skipEmptyOutput {
+        fmt.Printf("No users found, not writing output files\n")
+        formats = nil
+    }
+    // Version control shows the changes between deterministic runs
+    if !meta.Deterministic {
+        meta.Previous, err = FindPreviousOutput(meta)
         if err != nil {
-            log.Fatalf("Error exporting to CSV: %v", err)
+            log.Printf("Warning: not comparing with the previous run: %v", err)
+        } else if meta.Previous != nil {
+            fmt.Printf("Comparing with previous run %s\n", filepath.Base(meta.Previous.Path))
         }
+    }
+    filenames, err := RunExporters(formats, result, meta)
+    if errors.Is(err, ErrOutputExists) && *noClobber {
+        fmt.Printf("Not exporting, %v\n", err)
+    } else if errors.Is(err, ErrOutputExists) {
+        fatalf("Error saving output: %v (use -force to overwrite or -no-clobber to skip)", err)
+    } else if err != nil {
+        fatalf("Error saving output: %v", err)
+    }
+    if *bundle && len(filenames) > 0 {
+        archive, err := BundleOutputs(filenames, meta)
+        if err != nil {
+            fatalf("Error bundling output: %v", err)
+        }
+        filenames = []string{archive}
+    }
+    if encryption != nil && len(filenames) > 0 {
+        if filenames, err = encryption.EncryptFiles(filenames); err != nil {
+            fatalf("Error encrypting output: %v", err)
+        }
+    }
+    if (*checksums || signer != nil) && len(filenames) > 0 {
+        sumsFile, err := WriteChecksums(filenames, meta)
+        if err != nil {
+            fatalf("Error saving output: %v", err)
+        }
+        filenames = append(filenames, sumsFile)
+        if signer != nil {
+            signature, err := signer.Sign(sumsFile)
+            if err != nil {
+                fatalf("Error saving output: %v", err)
+            }
+            filenames = append(filenames, signature)
+        }
+    }
+    if uploadTarget != nil && len(filenames) > 0 {
+        if err := uploadTarget.Upload(ctx, filenames, filepath.Base(OutputDirFor("", domain))); err != nil {
+            fatalf("Error uploading output: %v", err)
+        }
+        fmt.Printf("Uploaded %d files to %s\n", len(filenames), uploadTarget)
+    }
+    if publisher != nil {
+        summary, providers := RunEvents(result, meta, filenames)
+        if err := publisher.Publish(ctx, summary, providers); err != nil {
+            fatalf("Error publishing events: %v", err)
+        }
+        fmt.Printf("Published the run summary and %d provider events to %s\n", len(providers), publisher)
+    }
+    if len(filenames) > 0 {
         fmt.Printf("Results have been saved to:\n")
+    }
+    for _, filename := range filenames {
+        fmt.Printf("  - %s\n", filename)
+    }
+
+    // Cached responses would overstate the throughput
+    if inputPaths == nil && !result.Partial && *httpCacheTTL == 0 {
+        var outputBytes int64
         for _, filename := range filenames {
-            fmt.Printf("  - %s\n", filename)
+            if info, err := os.Stat(filename); err == nil {
+                outputBytes += info.Size()
+            }
         }
-    } else {
-        // Create output
-        outputData := CreateOutputData(result, domain, timeRange)
-        
-        // Save output
-        filename, err := SaveOutputToJSON(outputData, domain, timeRange)
-        if err != nil {
-            log.Fatalf("Error saving output: %v", err)
+        calibration.Update(result.TotalHits, queryDuration, outputBytes, time.Now())
+        if err := calibration.Save(calibrationPath); err != nil {
+            log.Printf("Warning: error saving calibration: %v", err)
         }
-        
-        fmt.Printf("Results have been saved to %s\n", filename)
+    }
+    if history, err := OpenRunHistory(ResolveStoreDir(*storeDir)); err != nil {
+        log.Printf("Warning: %v", err)
+    } else if id, err := history.Append(NewRunRecord(runID, rangeParam, result, meta, queryStart, filenames)); err != nil {
+        log.Printf("Warning: %v", err)
+    } else {
+        fmt.Printf("Recorded as run %s\n", id)
     }
     
     exportDuration := time.Since(exportStart)
@@ -1165,4 +3234,18 @@ func main() {
     fmt.Printf("  Quickwit query: %v\n", queryDuration)
     fmt.Printf("  Export processing: %v\n", exportDuration)
     fmt.Printf("  Overall: %v\n", time.Since(queryStart))
+
+    if result.Partial {
+        log.Printf("Error: partial result saved (%s)", ErrorFields(ErrPartialRange))
+        exit(ExitPartialRange)
+    }
+    if len(result.TruncatedDays) > 0 {
+        log.Printf("Error: users beyond the bucket limit missing on %d days (%s)", len(result.TruncatedDays), ErrorFields(ErrBucketTruncated))
+        exit(ExitBucketTruncated)
+    }
+    if empty && *failOnEmpty {
+        log.Printf("Error: no users found for %s", domain)
+        exit(ExitEmptyResult)
+    }
+    exit(0)
 }
\ No newline at end of file
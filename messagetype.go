@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// RADIUS message types selectable with -message-type
+const (
+    MessageAccept     = "Access-Accept"
+    MessageReject     = "Access-Reject"
+    MessageChallenge  = "Access-Challenge"
+    MessageAccounting = "Accounting-Request"
+
+    // DefaultMessageType is the message type analysed without a flag
+    DefaultMessageType = MessageAccept
+)
+
+// messageTypeNames maps the accepted spellings of -message-type values to
+// message types
+var messageTypeNames = map[string]string{
+    "accept":             MessageAccept,
+    "access-accept":      MessageAccept,
+    "reject":             MessageReject,
+    "access-reject":      MessageReject,
+    "challenge":          MessageChallenge,
+    "access-challenge":   MessageChallenge,
+    "accounting":         MessageAccounting,
+    "accounting-request": MessageAccounting,
+}
+
+// ParseMessageType validates a -message-type value given as a short name
+// (accept, reject, challenge, accounting) or the full message type, in any
+// letter case
+func ParseMessageType(value string) (string, error) {
+    if value == "" {
+        return DefaultMessageType, nil
+    }
+    if messageType, ok := messageTypeNames[strings.ToLower(strings.TrimSpace(value))]; ok {
+        return messageType, nil
+    }
+    return "", fmt.Errorf("invalid message type %q (available: accept, reject, challenge, accounting)", value)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    // TopProviderPairs is the number of provider pairs listed in the mobility view
+    TopProviderPairs = 20
+
+    // TopRoamers is the number of users listed by mobility score
+    TopRoamers = 20
+
+    // DefaultMobilityFormula is the mobility score used when none is given
+    DefaultMobilityFormula = "product"
+)
+
+// MobilityFormulas maps formula names to the mobility score they compute
+// from a user's distinct providers and distinct active days
+var MobilityFormulas = map[string]func(providers, days int) float64{
+    "product":   func(providers, days int) float64 { return float64(providers * days) },
+    "sum":       func(providers, days int) float64 { return float64(providers + days) },
+    "providers": func(providers, days int) float64 { return float64(providers) },
+    "days":      func(providers, days int) float64 { return float64(days) },
+}
+
+// ParseMobilityFormula validates a -mobility-formula value
+func ParseMobilityFormula(value string) (string, error) {
+    if value == "" {
+        return DefaultMobilityFormula, nil
+    }
+    if _, ok := MobilityFormulas[value]; !ok {
+        names := make([]string, 0, len(MobilityFormulas))
+        for name := range MobilityFormulas {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        return "", fmt.Errorf("invalid mobility formula %q (available: %s)", value, strings.Join(names, ", "))
+    }
+    return value, nil
+}
+
+// ProviderCountBucket is the number of users who used a given number of
+// distinct providers; the last bucket ("3+") collects everything above
+type ProviderCountBucket struct {
+    Providers string `json:"providers"`
+    Users     int    `json:"users"`
+}
+
+// ProviderPair is a pair of providers together with the number of users who
+// used both
+type ProviderPair struct {
+    Providers [2]string `json:"providers"`
+    Users     int       `json:"users"`
+}
+
+// RoamerScore is the mobility score of a single user
+type RoamerScore struct {
+    Username  string  `json:"username"`
+    Providers int     `json:"providers"`
+    Days      int     `json:"days"`
+    Score     float64 `json:"score"`
+}
+
+// ScoreDistribution describes the spread of mobility scores over all users
+type ScoreDistribution struct {
+    Min float64 `json:"min"`
+    P50 float64 `json:"p50"`
+    P90 float64 `json:"p90"`
+    P99 float64 `json:"p99"`
+    Max float64 `json:"max"`
+}
+
+// MobilityStats summarises how many providers users roam to
+type MobilityStats struct {
+    Distribution      []ProviderCountBucket `json:"distribution"`
+    TopPairs          []ProviderPair        `json:"top_pairs"`
+    Formula           string                `json:"score_formula"`
+    ScoreDistribution ScoreDistribution     `json:"score_distribution"`
+    TopRoamers        []RoamerScore         `json:"top_roamers"`
+}
+
+// ComputeMobility returns the distribution of distinct providers per user,
+// the most common provider pairs and the users with the highest mobility
+// score under formula (a MobilityFormulas name)
+func ComputeMobility(result *Result, formula string) *MobilityStats {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Users) == 0 {
+        return nil
+    }
+    if len(result.Days) == 0 && result.Saved != nil {
+        return result.Saved.Mobility
+    }
+
+    if _, ok := MobilityFormulas[formula]; !ok {
+        formula = DefaultMobilityFormula
+    }
+    score := MobilityFormulas[formula]
+
+    activeDays := make(map[string]int)
+    for _, day := range result.Days {
+        for username := range day.Users.All() {
+            activeDays[username]++
+        }
+    }
+
+    counts := make([]int, 3)
+    pairs := make(map[[2]string]int)
+    roamers := make([]RoamerScore, 0, len(result.Users))
+    for username, stats := range result.Users {
+        providers := make([]string, 0, stats.Providers.Len())
+        for provider := range stats.Providers.All() {
+            providers = append(providers, provider)
+        }
+        switch n := len(providers); {
+        case n >= 3:
+            counts[2]++
+        case n > 0:
+            counts[n-1]++
+        }
+
+        roamers = append(roamers, RoamerScore{
+            Username:  username,
+            Providers: len(providers),
+            Days:      activeDays[username],
+            Score:     score(len(providers), activeDays[username]),
+        })
+
+        sort.Strings(providers)
+        for i := 0; i < len(providers); i++ {
+            for j := i + 1; j < len(providers); j++ {
+                pairs[[2]string{providers[i], providers[j]}]++
+            }
+        }
+    }
+
+    mobility := &MobilityStats{
+        Distribution: []ProviderCountBucket{
+            {Providers: "1", Users: counts[0]},
+            {Providers: "2", Users: counts[1]},
+            {Providers: "3+", Users: counts[2]},
+        },
+        TopPairs: make([]ProviderPair, 0, len(pairs)),
+        Formula:  formula,
+    }
+    for pair, users := range pairs {
+        mobility.TopPairs = append(mobility.TopPairs, ProviderPair{Providers: pair, Users: users})
+    }
+    sort.Slice(mobility.TopPairs, func(i, j int) bool {
+        a, b := mobility.TopPairs[i], mobility.TopPairs[j]
+        if a.Users != b.Users {
+            return a.Users > b.Users
+        }
+        if a.Providers[0] != b.Providers[0] {
+            return a.Providers[0] < b.Providers[0]
+        }
+        return a.Providers[1] < b.Providers[1]
+    })
+    if len(mobility.TopPairs) > TopProviderPairs {
+        mobility.TopPairs = mobility.TopPairs[:TopProviderPairs]
+    }
+
+    sort.Slice(roamers, func(i, j int) bool {
+        if roamers[i].Score != roamers[j].Score {
+            return roamers[i].Score > roamers[j].Score
+        }
+        return roamers[i].Username < roamers[j].Username
+    })
+    mobility.ScoreDistribution = ScoreDistribution{
+        Min: roamers[len(roamers)-1].Score,
+        P50: percentileScore(roamers, 50),
+        P90: percentileScore(roamers, 90),
+        P99: percentileScore(roamers, 99),
+        Max: roamers[0].Score,
+    }
+    if len(roamers) > TopRoamers {
+        roamers = roamers[:TopRoamers]
+    }
+    mobility.TopRoamers = roamers
+    return mobility
+}
+
+// percentileScore returns the p-th percentile score of roamers sorted by
+// descending score
+func percentileScore(roamers []RoamerScore, p float64) float64 {
+    index := int(p/100*float64(len(roamers))+0.5) - 1
+    if index < 0 {
+        index = 0
+    }
+    if index >= len(roamers) {
+        index = len(roamers) - 1
+    }
+    return roamers[len(roamers)-1-index].Score
+}
+
+// ExportMobilityCSV writes the mobility view: the provider count
+// distribution and the top provider pairs, followed by the top roamers unless
+// the output is public. It returns an empty filename when the result has no
+// users.
+func ExportMobilityCSV(result *Result, meta ExportMeta) (string, error) {
+    mobility := ComputeMobility(result, meta.MobilityFormula)
+    if mobility == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-mobility.csv")
+    file, err := os.Create(filename)
+    if err != nil {
+        return "", fmt.Errorf("error creating mobility CSV file: %w", err)
+    }
+    defer file.Close()
+
+    writer := csv.NewWriter(file)
+    records := [][]string{{"Section", "Providers", "Users"}}
+    for _, bucket := range mobility.Distribution {
+        records = append(records, []string{"distribution", bucket.Providers, strconv.Itoa(bucket.Users)})
+    }
+    for _, pair := range mobility.TopPairs {
+        records = append(records, []string{"pair", pair.Providers[0] + "; " + pair.Providers[1], strconv.Itoa(pair.Users)})
+    }
+    if meta.Public {
+        mobility.TopRoamers = nil
+    } else {
+        records = append(records, []string{})
+        records = append(records, []string{"Username", "Providers", "Active Days", "Score (" + mobility.Formula + ")"})
+    }
+    for _, roamer := range mobility.TopRoamers {
+        records = append(records, []string{
+            roamer.Username,
+            strconv.Itoa(roamer.Providers),
+            strconv.Itoa(roamer.Days),
+            strconv.FormatFloat(roamer.Score, 'f', -1, 64),
+        })
+    }
+    if err := writer.WriteAll(records); err != nil {
+        return "", fmt.Errorf("error writing mobility CSV file: %w", err)
+    }
+    return filename, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// DefaultMultiProviderMin is the number of distinct providers a user must
+// exceed on one day to be listed in the multi-provider report
+const DefaultMultiProviderMin = 2
+
+// MultiProviderDay is a user seen at several providers on the same day
+type MultiProviderDay struct {
+    Date      string   `json:"date"`
+    Username  string   `json:"username"`
+    Providers []string `json:"providers"`
+}
+
+// MultiProviderReport lists the days on which users were seen at more than
+// Min distinct providers, whether multi-campus use or shared credentials
+type MultiProviderReport struct {
+    Min   int                `json:"min_providers_exceeded"`
+    Users int                `json:"users"`
+    Days  []MultiProviderDay `json:"days"`
+}
+
+// FindMultiProviderDays returns the user-days with more than min distinct
+// providers ordered by date, number of providers (descending) and username.
+// It returns nil for a result without per-day data or a min below 1.
+func FindMultiProviderDays(result *Result, min int) *MultiProviderReport {
+    if min < 1 {
+        return nil
+    }
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Days) == 0 {
+        if result.Saved != nil {
+            return result.Saved.MultiProvider
+        }
+        return nil
+    }
+    report := &MultiProviderReport{Min: min, Days: []MultiProviderDay{}}
+    users := make(map[string]bool)
+    for date, day := range result.Days {
+        for username, providers := range day.Visits.ByUser() {
+            if len(providers) <= min {
+                continue
+            }
+            entry := MultiProviderDay{Date: date, Username: username, Providers: providers}
+            sort.Strings(entry.Providers)
+            report.Days = append(report.Days, entry)
+            users[username] = true
+        }
+    }
+    report.Users = len(users)
+    sort.Slice(report.Days, func(i, j int) bool {
+        a, b := report.Days[i], report.Days[j]
+        if a.Date != b.Date {
+            return a.Date < b.Date
+        }
+        if len(a.Providers) != len(b.Providers) {
+            return len(a.Providers) > len(b.Providers)
+        }
+        return a.Username < b.Username
+    })
+    return report
+}
+
+// ExportMultiProviderCSV writes the multi-provider user-days. It returns an
+// empty filename when there are none.
+func ExportMultiProviderCSV(result *Result, meta ExportMeta) (string, error) {
+    report := FindMultiProviderDays(result, meta.MultiProviderMin)
+    if report == nil || len(report.Days) == 0 {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Date", "Username", "Providers", "Provider List"}}
+    for _, day := range report.Days {
+        records = append(records, []string{
+            day.Date,
+            day.Username,
+            strconv.Itoa(len(day.Providers)),
+            strings.Join(day.Providers, "; "),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-multi-provider.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "unicode"
+)
+
+// NAI validation issues
+const (
+    NAIWhitespace       = "whitespace"
+    NAIMissingRealm     = "missing realm"
+    NAIMultipleAt       = "multiple @"
+    NAIIllegalCharacter = "illegal character"
+    NAIInvalidDots      = "empty username label"
+    NAIInvalidRealm     = "invalid realm"
+)
+
+const (
+    // NAIExamplesPerIssue is the number of example identities kept per issue
+    NAIExamplesPerIssue = 5
+)
+
+// NAIIssue counts the identities failing validation for one reason
+type NAIIssue struct {
+    Issue    string   `json:"issue"`
+    Count    int      `json:"count"`
+    Examples []string `json:"examples"`
+}
+
+// NAIReport summarises NAI syntax validation of the usernames in a result
+type NAIReport struct {
+    Checked   int        `json:"checked"`
+    Malformed int        `json:"malformed"`
+    Issues    []NAIIssue `json:"issues,omitempty"`
+}
+
+// isUsernameChar reports whether r is allowed in the username part of an
+// NAI (utf8-atext in RFC 7542)
+func isUsernameChar(r rune) bool {
+    if r > unicode.MaxASCII {
+        return unicode.IsPrint(r)
+    }
+    return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", r)
+}
+
+// validRealm reports whether realm is a dot-separated list of labels made of
+// letters, digits and inner hyphens (utf8-realm in RFC 7542)
+func validRealm(realm string) bool {
+    if realm == "" {
+        return false
+    }
+    for _, label := range strings.Split(realm, ".") {
+        if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+            return false
+        }
+        for _, r := range label {
+            if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+// ValidateNAI checks an identity against the RFC 7542 NAI syntax (which
+// obsoletes RFC 4282) and returns the first issue found, or "" if it is
+// valid. Roaming requires a realm, so identities without one are reported.
+func ValidateNAI(nai string) string {
+    if strings.IndexFunc(nai, unicode.IsSpace) >= 0 {
+        return NAIWhitespace
+    }
+    username, realm, found := strings.Cut(nai, "@")
+    if !found {
+        return NAIMissingRealm
+    }
+    if strings.Contains(realm, "@") {
+        return NAIMultipleAt
+    }
+    if username != "" {
+        for _, label := range strings.Split(username, ".") {
+            if label == "" {
+                return NAIInvalidDots
+            }
+        }
+        if strings.IndexFunc(username, func(r rune) bool { return r != '.' && !isUsernameChar(r) }) >= 0 {
+            return NAIIllegalCharacter
+        }
+    }
+    if !validRealm(realm) {
+        return NAIInvalidRealm
+    }
+    return ""
+}
+
+// malformedUsernames returns the malformed usernames of a result with their
+// issue, ordered by username
+func malformedUsernames(result *Result) (int, [][2]string) {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    var malformed [][2]string
+    for username := range result.Users {
+        if issue := ValidateNAI(username); issue != "" {
+            malformed = append(malformed, [2]string{username, issue})
+        }
+    }
+    sort.Slice(malformed, func(i, j int) bool { return malformed[i][0] < malformed[j][0] })
+    return len(result.Users), malformed
+}
+
+// ValidateUsernames reports the usernames of a result that are not valid NAIs
+func ValidateUsernames(result *Result) *NAIReport {
+    checked, malformed := malformedUsernames(result)
+    if checked == 0 {
+        return nil
+    }
+
+    report := &NAIReport{Checked: checked, Malformed: len(malformed)}
+    byIssue := make(map[string]*NAIIssue)
+    for _, entry := range malformed {
+        issue := byIssue[entry[1]]
+        if issue == nil {
+            issue = &NAIIssue{Issue: entry[1], Examples: []string{}}
+            byIssue[entry[1]] = issue
+        }
+        issue.Count++
+        if len(issue.Examples) < NAIExamplesPerIssue {
+            issue.Examples = append(issue.Examples, entry[0])
+        }
+    }
+    for _, issue := range byIssue {
+        report.Issues = append(report.Issues, *issue)
+    }
+    sort.Slice(report.Issues, func(i, j int) bool {
+        if report.Issues[i].Count != report.Issues[j].Count {
+            return report.Issues[i].Count > report.Issues[j].Count
+        }
+        return report.Issues[i].Issue < report.Issues[j].Issue
+    })
+    return report
+}
+
+// ExportNAICSV writes every malformed username with its issue. It returns an
+// empty filename when all usernames are valid.
+func ExportNAICSV(result *Result, meta ExportMeta) (string, error) {
+    _, malformed := malformedUsernames(result)
+    if len(malformed) == 0 {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-malformed-nai.csv")
+    file, err := os.Create(filename)
+    if err != nil {
+        return "", fmt.Errorf("error creating NAI CSV file: %w", err)
+    }
+    defer file.Close()
+
+    records := [][]string{{"Username", "Issue"}}
+    for _, entry := range malformed {
+        records = append(records, []string{entry[0], entry[1]})
+    }
+    if err := csv.NewWriter(file).WriteAll(records); err != nil {
+        return "", fmt.Errorf("error writing NAI CSV file: %w", err)
+    }
+    return filename, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+    "iter"
+    "math/bits"
+    "slices"
+    "sort"
+    "sync"
+    "sync/atomic"
+)
+
+// nameInterner assigns every distinct name (username, provider, realm) a
+// small integer ID, so sets of names store 4-byte IDs instead of strings and
+// every name is kept in memory once
+type nameInterner struct {
+    mu    sync.RWMutex
+    ids   map[string]uint32
+    names []string
+}
+
+// newNameInterner returns an empty interner
+func newNameInterner() *nameInterner {
+    return &nameInterner{ids: make(map[string]uint32)}
+}
+
+// runNames is the interner that empty sets adopt on their first Add
+var runNames atomic.Pointer[nameInterner]
+
+// currentNames returns the interner of the current run
+func currentNames() *nameInterner {
+    if in := runNames.Load(); in != nil {
+        return in
+    }
+    runNames.CompareAndSwap(nil, newNameInterner())
+    return runNames.Load()
+}
+
+// StartNameScope gives the sets filled from now on a new interner. Every
+// set keeps the interner it was filled with, so the names of an earlier
+// run are released together with its last result instead of accumulating
+// in long-running processes (-watch, serve).
+func StartNameScope() {
+    runNames.Store(newNameInterner())
+}
+
+// id returns the ID of name, assigning the next one to new names
+func (in *nameInterner) id(name string) uint32 {
+    in.mu.RLock()
+    id, ok := in.ids[name]
+    in.mu.RUnlock()
+    if ok {
+        return id
+    }
+
+    in.mu.Lock()
+    defer in.mu.Unlock()
+    if id, ok := in.ids[name]; ok {
+        return id
+    }
+    id = uint32(len(in.names))
+    in.ids[name] = id
+    in.names = append(in.names, name)
+    return id
+}
+
+// lookup returns the ID of name without assigning one
+func (in *nameInterner) lookup(name string) (uint32, bool) {
+    in.mu.RLock()
+    defer in.mu.RUnlock()
+    id, ok := in.ids[name]
+    return id, ok
+}
+
+// table returns the names indexed by ID. Names are only appended, so the
+// returned slice stays valid for the IDs assigned so far.
+func (in *nameInterner) table() []string {
+    in.mu.RLock()
+    defer in.mu.RUnlock()
+    return in.names
+}
+
+// NameSet is a compact set of names. Small sets are a sorted slice of
+// interned IDs; once a bitset over the ID range is smaller, the set
+// switches to it. The zero value is an empty set, which adopts the
+// interner of the current run on its first Add. Like a slice, a copied
+// NameSet shares its storage, so copy with Merge into an empty set; it is
+// not safe for concurrent modification.
+type NameSet struct {
+    in   *nameInterner
+    ids  []uint32
+    bits []uint64
+    n    int
+}
+
+// NewNameSet returns a set of the given names
+func NewNameSet(members ...string) NameSet {
+    var set NameSet
+    for _, name := range members {
+        set.Add(name)
+    }
+    return set
+}
+
+// Add inserts name into the set
+func (s *NameSet) Add(name string) {
+    if s.in == nil {
+        s.in = currentNames()
+    }
+    s.addID(s.in.id(name))
+}
+
+// addID inserts an interned ID into the set
+func (s *NameSet) addID(id uint32) {
+    if s.bits != nil {
+        word := int(id / 64)
+        if word >= len(s.bits) {
+            s.bits = append(s.bits, make([]uint64, word+1-len(s.bits))...)
+        }
+        if s.bits[word]&(1<<(id%64)) == 0 {
+            s.bits[word] |= 1 << (id % 64)
+            s.n++
+        }
+        return
+    }
+
+    i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+    if i < len(s.ids) && s.ids[i] == id {
+        return
+    }
+    s.ids = append(s.ids, 0)
+    copy(s.ids[i+1:], s.ids[i:])
+    s.ids[i] = id
+    s.n++
+
+    // A bitset costs 8 bytes per 64 IDs up to the largest, the slice 4
+    // bytes per member
+    if words := int(s.ids[len(s.ids)-1]/64) + 1; len(s.ids) >= 64 && words*8 < len(s.ids)*4 {
+        s.bits = make([]uint64, words)
+        for _, id := range s.ids {
+            s.bits[id/64] |= 1 << (id % 64)
+        }
+        s.ids = nil
+    }
+}
+
+// Has reports whether name is in the set
+func (s NameSet) Has(name string) bool {
+    if s.in == nil {
+        return false
+    }
+    id, ok := s.in.lookup(name)
+    if !ok {
+        return false
+    }
+    if s.bits != nil {
+        word := int(id / 64)
+        return word < len(s.bits) && s.bits[word]&(1<<(id%64)) != 0
+    }
+    i := sort.Search(len(s.ids), func(i int) bool { return s.ids[i] >= id })
+    return i < len(s.ids) && s.ids[i] == id
+}
+
+// Len returns the number of names in the set
+func (s NameSet) Len() int {
+    return s.n
+}
+
+// All iterates over the names of the set in the order they were first
+// seen by the run
+func (s NameSet) All() iter.Seq[string] {
+    return func(yield func(string) bool) {
+        if s.in == nil {
+            return
+        }
+        table := s.in.table()
+        if s.bits == nil {
+            for _, id := range s.ids {
+                if !yield(table[id]) {
+                    return
+                }
+            }
+            return
+        }
+        for word, w := range s.bits {
+            for w != 0 {
+                bit := bits.TrailingZeros64(w)
+                if !yield(table[word*64+bit]) {
+                    return
+                }
+                w &= w - 1
+            }
+        }
+    }
+}
+
+// Sorted returns the names of the set in lexical order
+func (s NameSet) Sorted() []string {
+    sorted := make([]string, 0, s.n)
+    for name := range s.All() {
+        sorted = append(sorted, name)
+    }
+    sort.Strings(sorted)
+    return sorted
+}
+
+// Merge adds the names of other to the set. Sets of the same interner are
+// merged by ID, others by name.
+func (s *NameSet) Merge(other NameSet) {
+    if other.n == 0 {
+        return
+    }
+    if s.in == nil {
+        s.in = other.in
+    }
+    if s.in != other.in {
+        for name := range other.All() {
+            s.Add(name)
+        }
+        return
+    }
+    if other.bits == nil {
+        for _, id := range other.ids {
+            s.addID(id)
+        }
+        return
+    }
+    for word, w := range other.bits {
+        for w != 0 {
+            bit := bits.TrailingZeros64(w)
+            s.addID(uint32(word*64 + bit))
+            w &= w - 1
+        }
+    }
+}
+
+// PairSet is a set of (username, provider) pairs, stored as one 8-byte key
+// of the two interned IDs per pair instead of a map of maps. The zero value
+// is an empty set; it is not safe for concurrent modification.
+type PairSet struct {
+    in    *nameInterner
+    pairs map[uint64]struct{}
+}
+
+// Add inserts the pair of username and provider
+func (p *PairSet) Add(username, provider string) {
+    if p.in == nil {
+        p.in = currentNames()
+    }
+    if p.pairs == nil {
+        p.pairs = make(map[uint64]struct{})
+    }
+    p.pairs[uint64(p.in.id(username))<<32|uint64(p.in.id(provider))] = struct{}{}
+}
+
+// Len returns the number of pairs in the set
+func (p PairSet) Len() int {
+    return len(p.pairs)
+}
+
+// ByUser iterates over the usernames of the set, each with its providers
+func (p PairSet) ByUser() iter.Seq2[string, []string] {
+    return func(yield func(string, []string) bool) {
+        if len(p.pairs) == 0 {
+            return
+        }
+        keys := make([]uint64, 0, len(p.pairs))
+        for key := range p.pairs {
+            keys = append(keys, key)
+        }
+        slices.Sort(keys)
+        table := p.in.table()
+        for i := 0; i < len(keys); {
+            user := keys[i] >> 32
+            var providers []string
+            for ; i < len(keys) && keys[i]>>32 == user; i++ {
+                providers = append(providers, table[uint32(keys[i])])
+            }
+            if !yield(table[user], providers) {
+                return
+            }
+        }
+    }
+}
+
+// Merge adds the pairs of other to the set
+func (p *PairSet) Merge(other PairSet) {
+    if len(other.pairs) == 0 {
+        return
+    }
+    if p.in == nil {
+        p.in = other.in
+    }
+    if p.in != other.in {
+        for username, providers := range other.ByUser() {
+            for _, provider := range providers {
+                p.Add(username, provider)
+            }
+        }
+        return
+    }
+    if p.pairs == nil {
+        p.pairs = make(map[uint64]struct{}, len(other.pairs))
+    }
+    for key := range other.pairs {
+        p.pairs[key] = struct{}{}
+    }
+}
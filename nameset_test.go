@@ -0,0 +1,134 @@
+package main
+
+import (
+    "fmt"
+    "slices"
+    "testing"
+)
+
+// testDay returns a day with the given hits and providers per user
+func testDay(hits int64, visits map[string][]string) *DayStats {
+    day := &DayStats{Hits: hits}
+    for username, providers := range visits {
+        for _, provider := range providers {
+            day.Add(username, provider)
+        }
+    }
+    return day
+}
+
+func TestNameSet(t *testing.T) {
+    var set NameSet
+    if set.Len() != 0 || set.Has("a.example") {
+        t.Fatalf("zero NameSet is not empty")
+    }
+    for _, name := range []string{"c.example", "a.example", "b.example", "a.example"} {
+        set.Add(name)
+    }
+    if set.Len() != 3 {
+        t.Errorf("Len() = %d, want 3", set.Len())
+    }
+    if !set.Has("a.example") || set.Has("d.example") || set.Has("never-interned.example") {
+        t.Errorf("Has() wrong for %v", set.Sorted())
+    }
+    if sorted := set.Sorted(); !slices.Equal(sorted, []string{"a.example", "b.example", "c.example"}) {
+        t.Errorf("Sorted() = %v", sorted)
+    }
+
+    copied := NewNameSet("x.example")
+    copied.Merge(set)
+    if copied.Len() != 4 || set.Len() != 3 {
+        t.Errorf("Merge: %d and %d names, want 4 and 3", copied.Len(), set.Len())
+    }
+}
+
+func TestNameSetBitset(t *testing.T) {
+    var members []string
+    for i := 0; i < 500; i++ {
+        members = append(members, fmt.Sprintf("bitset-user%03d@uni.example", i))
+    }
+    // Intern all names in a new scope first so the IDs are dense
+    StartNameScope()
+    for _, name := range members {
+        currentNames().id(name)
+    }
+
+    var set NameSet
+    for i, name := range members {
+        if i%2 == 0 {
+            set.Add(name)
+        }
+    }
+    if set.bits == nil {
+        t.Errorf("dense set of %d names is not a bitset", set.Len())
+    }
+    if set.Len() != 250 {
+        t.Errorf("Len() = %d, want 250", set.Len())
+    }
+    for i, name := range members {
+        if set.Has(name) != (i%2 == 0) {
+            t.Errorf("Has(%s) = %v", name, !(i%2 == 0))
+        }
+    }
+    set.Add(members[0])
+    set.Add(members[1])
+    if set.Len() != 251 {
+        t.Errorf("Len() after adding one new name = %d, want 251", set.Len())
+    }
+
+    var all []string
+    for name := range set.All() {
+        all = append(all, name)
+    }
+    if len(all) != set.Len() || !slices.Equal(set.Sorted(), func() []string { slices.Sort(all); return all }()) {
+        t.Errorf("All() yielded %d names, Len() is %d", len(all), set.Len())
+    }
+
+    var merged NameSet
+    merged.Add(members[499])
+    merged.Merge(set)
+    if merged.Len() != 252 {
+        t.Errorf("Merge of a bitset: %d names, want 252", merged.Len())
+    }
+
+    // Stopping early
+    var n int
+    for range set.All() {
+        if n++; n == 3 {
+            break
+        }
+    }
+}
+
+func TestNameScope(t *testing.T) {
+    StartNameScope()
+    earlier := NewNameSet("scope-a.example", "scope-b.example")
+    var pairs PairSet
+    pairs.Add("alice", "scope-a.example")
+
+    StartNameScope()
+    later := NewNameSet("scope-c.example")
+    if later.in == earlier.in {
+        t.Fatal("new scope shares the interner of the earlier one")
+    }
+    if _, ok := later.in.lookup("scope-a.example"); ok {
+        t.Error("names of the earlier scope leaked into the new one")
+    }
+    if !earlier.Has("scope-b.example") || earlier.Len() != 2 {
+        t.Errorf("earlier set after a new scope: %v", earlier.Sorted())
+    }
+
+    later.Merge(earlier)
+    if sorted := later.Sorted(); !slices.Equal(sorted, []string{"scope-a.example", "scope-b.example", "scope-c.example"}) {
+        t.Errorf("merge across scopes: %v", sorted)
+    }
+    var merged PairSet
+    merged.Add("alice", "scope-c.example")
+    merged.Merge(pairs)
+    for username, providers := range merged.ByUser() {
+        slices.Sort(providers)
+        if username != "alice" || !slices.Equal(providers, []string{"scope-a.example", "scope-c.example"}) {
+            t.Errorf("pairs merged across scopes: %s %v", username, providers)
+        }
+    }
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// NRO roaming scopes: a service provider in the home country is national
+// roaming, any other one international
+const (
+    ScopeNational      = "national"
+    ScopeInternational = "international"
+)
+
+// NROMonth is one row of the monthly statistics submitted by the NRO
+type NROMonth struct {
+    Month              string
+    UniqueUsers        int
+    NationalUsers      int
+    InternationalUsers int
+    NationalHits       int64
+    InternationalHits  int64
+}
+
+// NROProviderMonth is the monthly activity at one service provider
+type NROProviderMonth struct {
+    Month       string
+    Provider    string
+    Country     string
+    Scope       string
+    UniqueUsers int
+    Hits        int64
+}
+
+// roamingScope returns the scope of a provider seen from homeCountry
+func roamingScope(provider, homeCountry string, countries CountryMap) string {
+    if countries.Country(provider) == homeCountry {
+        return ScopeNational
+    }
+    return ScopeInternational
+}
+
+// NROMonthlyStats aggregates the per-day activity of a result into months
+// (YYYY-MM), split into national and international roaming relative to
+// homeCountry, and per service provider. countries overrides the country of
+// individual providers. Both lists are ordered by month.
+func NROMonthlyStats(result *Result, homeCountry string, countries CountryMap) ([]NROMonth, []NROProviderMonth) {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    type monthData struct {
+        users, national, international  map[string]bool
+        nationalHits, internationalHits int64
+        providerUsers                   map[string]map[string]bool
+        providerHits                    map[string]int64
+    }
+    months := make(map[string]*monthData)
+    for date, day := range result.Days {
+        month := date[:7]
+        data := months[month]
+        if data == nil {
+            data = &monthData{
+                users:         make(map[string]bool),
+                national:      make(map[string]bool),
+                international: make(map[string]bool),
+                providerUsers: make(map[string]map[string]bool),
+                providerHits:  make(map[string]int64),
+            }
+            months[month] = data
+        }
+        for username, providers := range day.Visits.ByUser() {
+            data.users[username] = true
+            for _, provider := range providers {
+                if roamingScope(provider, homeCountry, countries) == ScopeNational {
+                    data.national[username] = true
+                } else {
+                    data.international[username] = true
+                }
+                if data.providerUsers[provider] == nil {
+                    data.providerUsers[provider] = make(map[string]bool)
+                }
+                data.providerUsers[provider][username] = true
+            }
+        }
+        for provider, hits := range day.ProviderHits {
+            data.providerHits[provider] += hits
+            if roamingScope(provider, homeCountry, countries) == ScopeNational {
+                data.nationalHits += hits
+            } else {
+                data.internationalHits += hits
+            }
+        }
+    }
+
+    var rows []NROMonth
+    var providerRows []NROProviderMonth
+    for month, data := range months {
+        rows = append(rows, NROMonth{
+            Month:              month,
+            UniqueUsers:        len(data.users),
+            NationalUsers:      len(data.national),
+            InternationalUsers: len(data.international),
+            NationalHits:       data.nationalHits,
+            InternationalHits:  data.internationalHits,
+        })
+        for provider, users := range data.providerUsers {
+            providerRows = append(providerRows, NROProviderMonth{
+                Month:       month,
+                Provider:    provider,
+                Country:     countries.Country(provider),
+                Scope:       roamingScope(provider, homeCountry, countries),
+                UniqueUsers: len(users),
+                Hits:        data.providerHits[provider],
+            })
+        }
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].Month < rows[j].Month })
+    sort.Slice(providerRows, func(i, j int) bool {
+        if providerRows[i].Month != providerRows[j].Month {
+            return providerRows[i].Month < providerRows[j].Month
+        }
+        return providerRows[i].Provider < providerRows[j].Provider
+    })
+    return rows, providerRows
+}
+
+// ExportNRO writes the monthly statistics in the layout the NRO submits:
+// "-nro-monthly.csv" with unique users and national/international roaming
+// per month, and "-nro-sp.csv" with the users and hits per service provider
+// and month
+func ExportNRO(result *Result, meta ExportMeta) ([]string, error) {
+    rows, providerRows := NROMonthlyStats(result, meta.HomeCountry, meta.Countries)
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+    baseFilename := filepath.Join(outputDir, OutputBaseFilename(meta))
+
+    monthly := [][]string{{"Month", "Realm", "Unique Users", "National Roaming Users", "International Roaming Users", "National Roaming Auths", "International Roaming Auths"}}
+    for _, row := range rows {
+        monthly = append(monthly, []string{
+            row.Month,
+            RealmToUnicode(meta.Domain),
+            strconv.Itoa(row.UniqueUsers),
+            strconv.Itoa(row.NationalUsers),
+            strconv.Itoa(row.InternationalUsers),
+            strconv.FormatInt(row.NationalHits, 10),
+            strconv.FormatInt(row.InternationalHits, 10),
+        })
+    }
+    perSP := [][]string{{"Month", "Service Provider", "Country", "Scope", "Unique Users", "Auths"}}
+    for _, row := range providerRows {
+        perSP = append(perSP, []string{
+            row.Month,
+            row.Provider,
+            row.Country,
+            row.Scope,
+            strconv.Itoa(row.UniqueUsers),
+            strconv.FormatInt(row.Hits, 10),
+        })
+    }
+
+    monthlyFile := baseFilename + "-nro-monthly.csv"
+    if err := writeCSVFile(monthlyFile, monthly); err != nil {
+        return nil, err
+    }
+    spFile := baseFilename + "-nro-sp.csv"
+    if err := writeCSVFile(spFile, perSP); err != nil {
+        return nil, err
+    }
+    return []string{monthlyFile, spFile}, nil
+}
+
+func init() {
+    RegisterExporter("nro", ExporterFunc(ExportNRO))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// DefaultOutlierFactor is the multiple of the median auth count above which
+// a user is flagged as an outlier
+const DefaultOutlierFactor = 10.0
+
+// OutlierUser is a user with an excessive number of authentications
+type OutlierUser struct {
+    Username   string  `json:"username"`
+    Hits       int64   `json:"hits"`
+    Multiple   float64 `json:"median_multiple"`
+    Providers  int     `json:"providers"`
+    ActiveDays int     `json:"active_days"`
+}
+
+// OutlierReport lists the users whose auth count exceeds Factor times the
+// median, typically devices in re-auth loops or shared credentials
+type OutlierReport struct {
+    Factor    float64       `json:"factor"`
+    Median    int64         `json:"median"`
+    Threshold float64       `json:"threshold"`
+    // OutlierHits is the share of all hits caused by the outliers
+    OutlierHits  int64         `json:"outlier_hits"`
+    OutlierShare float64       `json:"outlier_share"`
+    Users        []OutlierUser `json:"users"`
+}
+
+// FindOutliers returns the users with more than factor times the median
+// auths per user, highest first. It returns nil for a result without users
+// or a factor that is not positive.
+func FindOutliers(result *Result, factor float64) *OutlierReport {
+    if factor <= 0 {
+        return nil
+    }
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Users) == 0 {
+        return nil
+    }
+    if len(result.Days) == 0 && result.Saved != nil && result.Saved.Outliers != nil {
+        return result.Saved.Outliers
+    }
+
+    hits := make([]int64, 0, len(result.Users))
+    for _, stats := range result.Users {
+        hits = append(hits, stats.Hits)
+    }
+    sort.Slice(hits, func(i, j int) bool { return hits[i] < hits[j] })
+    median := percentileHits(hits, 50)
+
+    report := &OutlierReport{Factor: factor, Median: median, Threshold: factor * float64(median), Users: []OutlierUser{}}
+    activeDays := make(map[string]int)
+    for _, day := range result.Days {
+        for username := range day.Users.All() {
+            activeDays[username]++
+        }
+    }
+    for username, stats := range result.Users {
+        if float64(stats.Hits) <= report.Threshold {
+            continue
+        }
+        user := OutlierUser{
+            Username:   username,
+            Hits:       stats.Hits,
+            Providers:  stats.Providers.Len(),
+            ActiveDays: activeDays[username],
+        }
+        if median > 0 {
+            user.Multiple = float64(stats.Hits) / float64(median)
+        }
+        report.Users = append(report.Users, user)
+        report.OutlierHits += stats.Hits
+    }
+    if result.TotalHits > 0 {
+        report.OutlierShare = float64(report.OutlierHits) / float64(result.TotalHits)
+    }
+    sort.Slice(report.Users, func(i, j int) bool {
+        if report.Users[i].Hits != report.Users[j].Hits {
+            return report.Users[i].Hits > report.Users[j].Hits
+        }
+        return report.Users[i].Username < report.Users[j].Username
+    })
+    return report
+}
+
+// ExportOutliersCSV writes the outlier users. It returns an empty filename
+// when no user was flagged.
+func ExportOutliersCSV(result *Result, meta ExportMeta) (string, error) {
+    report := FindOutliers(result, meta.OutlierFactor)
+    if report == nil || len(report.Users) == 0 {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Username", "Hits", "Median Multiple", "Providers", "Active Days"}}
+    for _, user := range report.Users {
+        records = append(records, []string{
+            user.Username,
+            strconv.FormatInt(user.Hits, 10),
+            strconv.FormatFloat(user.Multiple, 'f', 1, 64),
+            strconv.Itoa(user.Providers),
+            strconv.Itoa(user.ActiveDays),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-outliers.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
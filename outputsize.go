@@ -0,0 +1,140 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+)
+
+// DefaultMaxOutputTop is the number of users and providers kept in a JSON
+// output cut down by -max-output-size
+const DefaultMaxOutputTop = 1000
+
+// byteUnits are the size suffixes accepted by ParseByteSize, longest first
+var byteUnits = []struct {
+    suffix string
+    size   int64
+}{
+    {"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+    {"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+    {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+    {"B", 1},
+}
+
+// ParseByteSize parses a size such as "100MB", "1.5G" or "4096". Units are
+// binary (1MB is 1024 KB), as in FormatBytes; an empty value or "0" is 0.
+func ParseByteSize(value string) (int64, error) {
+    number := strings.ToUpper(strings.TrimSpace(value))
+    if number == "" {
+        return 0, nil
+    }
+    unit := int64(1)
+    for _, candidate := range byteUnits {
+        if rest, ok := strings.CutSuffix(number, candidate.suffix); ok {
+            number, unit = strings.TrimSpace(rest), candidate.size
+            break
+        }
+    }
+    size, err := strconv.ParseFloat(number, 64)
+    if err != nil || size < 0 {
+        return 0, fmt.Errorf("invalid size %q (e.g. 100MB or 2GB)", value)
+    }
+    return int64(size * float64(unit)), nil
+}
+
+// OutputTruncation records in query_info that a JSON output exceeded
+// -max-output-size and was cut down to the summary and the top entries
+type OutputTruncation struct {
+    MaxBytes  int64    `json:"max_bytes"`
+    FullBytes int64    `json:"full_bytes"`
+    // Top is the number of users and providers kept, 0 when even those
+    // did not fit
+    Top       int      `json:"top"`
+    Users     int      `json:"users"`
+    Providers int      `json:"providers"`
+    // Dropped lists the sections left out or emptied
+    Dropped   []string `json:"dropped_sections,omitempty"`
+}
+
+// truncate keeps the first top users and providers (the lists are in the
+// requested sort order) without the users of each provider, and drops the
+// sections that grow with the number of users or provider-days. It returns
+// the names of the sections that lost entries. Shared lists and reports
+// are copied, not modified.
+func (output *SimplifiedOutputData) truncate(top int) []string {
+    var dropped []string
+    if len(output.UserStats) > top {
+        output.UserStats = output.UserStats[:top]
+        dropped = append(dropped, FieldUsers)
+    }
+    if len(output.ProviderStats) > top {
+        dropped = append(dropped, FieldProviders)
+    }
+    output.ProviderStats = slices.Clone(output.ProviderStats[:min(top, len(output.ProviderStats))])
+    for i := range output.ProviderStats {
+        output.ProviderStats[i].Users = nil
+    }
+    if output.ProviderDaily != nil {
+        output.ProviderDaily = nil
+        dropped = append(dropped, FieldProviderDaily)
+    }
+    if output.Samples != nil {
+        output.Samples = nil
+        dropped = append(dropped, FieldSamples)
+    }
+    if output.MultiProvider != nil && len(output.MultiProvider.Days) > top {
+        multiProvider := *output.MultiProvider
+        multiProvider.Days = multiProvider.Days[:top]
+        output.MultiProvider = &multiProvider
+        dropped = append(dropped, FieldMultiProvider)
+    }
+    if output.Outliers != nil && len(output.Outliers.Users) > top {
+        outliers := *output.Outliers
+        outliers.Users = outliers.Users[:top]
+        output.Outliers = &outliers
+        dropped = append(dropped, FieldOutliers)
+    }
+    if output.Changes != nil && (output.Changes.NewUsers != nil || output.Changes.LostUsers != nil) {
+        changes := *output.Changes
+        changes.NewUsers, changes.LostUsers = nil, nil
+        output.Changes = &changes
+        dropped = append(dropped, "changes_since_last_run.users")
+    }
+    return dropped
+}
+
+// MarshalOutput encodes the JSON output. When the encoding exceeds
+// maxBytes (0 for no limit) the output is cut down to the top entries, and
+// to the summary alone if that is still too large; query_info then records
+// the truncation.
+func MarshalOutput(output SimplifiedOutputData, maxBytes int64, top int) ([]byte, error) {
+    jsonData, err := json.MarshalIndent(output, "", "  ")
+    if err != nil || maxBytes <= 0 || int64(len(jsonData)) <= maxBytes {
+        return jsonData, err
+    }
+
+    truncation := &OutputTruncation{
+        MaxBytes:  maxBytes,
+        FullBytes: int64(len(jsonData)),
+        Users:     len(output.UserStats),
+        Providers: len(output.ProviderStats),
+    }
+    if output.Summary != nil {
+        truncation.Users, truncation.Providers = output.Summary.TotalUsers, output.Summary.TotalProviders
+    }
+    for _, limit := range []int{top, 0} {
+        truncation.Top = limit
+        for _, section := range output.truncate(limit) {
+            if !slices.Contains(truncation.Dropped, section) {
+                truncation.Dropped = append(truncation.Dropped, section)
+            }
+        }
+        output.QueryInfo.Truncation = truncation
+        if jsonData, err = json.MarshalIndent(output, "", "  "); err != nil || int64(len(jsonData)) <= maxBytes {
+            break
+        }
+    }
+    return jsonData, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+    for value, want := range map[string]int64{
+        "":      0,
+        "4096":  4096,
+        "100MB": 100 << 20,
+        "1.5g":  3 << 29,
+        "2 KiB": 2048,
+    } {
+        if size, err := ParseByteSize(value); err != nil || size != want {
+            t.Errorf("ParseByteSize(%q) = %d, %v; want %d", value, size, err, want)
+        }
+    }
+    for _, value := range []string{"MB", "-1MB", "ten"} {
+        if _, err := ParseByteSize(value); err == nil {
+            t.Errorf("ParseByteSize(%q) accepted", value)
+        }
+    }
+}
+
+func TestMarshalOutput(t *testing.T) {
+    result := &Result{Users: make(map[string]*UserStats), Providers: make(map[string]*ProviderStats)}
+    for i := 0; i < 50; i++ {
+        username := fmt.Sprintf("user%02d@uni.example", i)
+        result.Users[username] = &UserStats{Hits: int64(i)}
+        result.Users[username].Providers.Add("sp.example.org")
+    }
+    result.Providers["sp.example.org"] = &ProviderStats{Hits: 1225}
+    for username := range result.Users {
+        result.Providers["sp.example.org"].Users.Add(username)
+    }
+    output := CreateOutputData(result, ExportMeta{Domain: "uni.example", TimeRange: testRange(t, 1)})
+
+    full, err := MarshalOutput(output, 0, 5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    limit := int64(len(full)) / 2
+    truncated, err := MarshalOutput(output, limit, 5)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if int64(len(truncated)) > limit {
+        t.Fatalf("truncated output of %d bytes exceeds %d", len(truncated), limit)
+    }
+    var decoded SimplifiedOutputData
+    if err := json.Unmarshal(truncated, &decoded); err != nil {
+        t.Fatal(err)
+    }
+    truncation := decoded.QueryInfo.Truncation
+    if truncation == nil || truncation.Top != 5 || truncation.Users != 50 || truncation.FullBytes != int64(len(full)) {
+        t.Fatalf("truncation: %+v", truncation)
+    }
+    if len(decoded.UserStats) != 5 || decoded.ProviderStats[0].Users != nil || decoded.Summary.TotalUsers != 50 {
+        t.Errorf("truncated output: %d users, provider users %v", len(decoded.UserStats), decoded.ProviderStats[0].Users)
+    }
+    if len(output.ProviderStats[0].Users) != 50 {
+        t.Error("truncation modified the output data")
+    }
+}
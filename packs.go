@@ -0,0 +1,89 @@
+package main
+
+import (
+    "bufio"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "slices"
+    "strings"
+)
+
+// ErrUnknownPack indicates a -pack with no PACK.<name>.* lines in the
+// properties file
+var ErrUnknownPack = errors.New("unknown report pack")
+
+// packReservedFlags cannot be set by a pack, which is itself read from
+// the properties file
+var packReservedFlags = []string{"pack", "config"}
+
+// ReadPack returns the flag settings of the PACK.<name>.<flag>=<value>
+// lines of the properties file in file order
+func ReadPack(filePath, name string) ([][2]string, error) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return nil, fmt.Errorf("error opening properties file: %w", err)
+    }
+    defer file.Close()
+
+    var settings [][2]string
+    names := make(map[string]bool)
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        key, value, ok := strings.Cut(scanner.Text(), "=")
+        rest, isPack := strings.CutPrefix(strings.TrimSpace(key), "PACK.")
+        if !ok || !isPack {
+            continue
+        }
+        pack, flagName, ok := strings.Cut(rest, ".")
+        if !ok || pack == "" || flagName == "" {
+            continue
+        }
+        names[pack] = true
+        if pack == name {
+            settings = append(settings, [2]string{strings.TrimPrefix(flagName, "-"), strings.TrimSpace(value)})
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("error reading properties file: %w", err)
+    }
+
+    if len(settings) == 0 {
+        available := make([]string, 0, len(names))
+        for pack := range names {
+            available = append(available, pack)
+        }
+        slices.Sort(available)
+        if len(available) == 0 {
+            return nil, fmt.Errorf("%w: %q (no PACK.<name>.* lines in %s)", ErrUnknownPack, name, filePath)
+        }
+        return nil, fmt.Errorf("%w: %q (available: %s)", ErrUnknownPack, name, strings.Join(available, ", "))
+    }
+    return settings, nil
+}
+
+// ApplyPack sets the flags of a pack that were not given on the command
+// line, so explicit flags override the pack
+func ApplyPack(flags *flag.FlagSet, settings [][2]string) error {
+    explicit := make(map[string]bool)
+    flags.Visit(func(f *flag.Flag) {
+        explicit[f.Name] = true
+    })
+    for _, setting := range settings {
+        name, value := setting[0], setting[1]
+        if slices.Contains(packReservedFlags, name) {
+            return fmt.Errorf("-%s cannot be set by a report pack", name)
+        }
+        if flags.Lookup(name) == nil {
+            return fmt.Errorf("report pack sets unknown flag -%s", name)
+        }
+        if explicit[name] {
+            continue
+        }
+        if err := flags.Set(name, value); err != nil {
+            return fmt.Errorf("report pack: invalid value %q for -%s: %w", value, name, err)
+        }
+    }
+    return nil
+}
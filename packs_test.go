@@ -0,0 +1,53 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "testing"
+)
+
+func TestApplyPack(t *testing.T) {
+    path := writeProperties(t, `QW_USER=user
+PACK.board.format=html,csv
+PACK.board.-public=true
+PACK.board.baseline=30d
+PACK.ops.format=json
+PACK.bad.config=other.properties
+`)
+
+    settings, err := ReadPack(path, "board")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(settings) != 3 || settings[1] != [2]string{"public", "true"} {
+        t.Fatalf("settings: %q", settings)
+    }
+
+    flags := flag.NewFlagSet("test", flag.ContinueOnError)
+    format := flags.String("format", "json", "")
+    public := flags.Bool("public", false, "")
+    baseline := flags.String("baseline", "", "")
+    flags.String("config", "", "")
+    if err := flags.Parse([]string{"-baseline", "7d"}); err != nil {
+        t.Fatal(err)
+    }
+    if err := ApplyPack(flags, settings); err != nil {
+        t.Fatal(err)
+    }
+    if *format != "html,csv" || !*public || *baseline != "7d" {
+        t.Errorf("flags: format %q, public %v, baseline %q", *format, *public, *baseline)
+    }
+
+    if settings, err = ReadPack(path, "bad"); err != nil {
+        t.Fatal(err)
+    }
+    if err := ApplyPack(flags, settings); err == nil {
+        t.Error("pack setting -config accepted")
+    }
+    if err := ApplyPack(flags, [][2]string{{"colour", "red"}}); err == nil {
+        t.Error("unknown flag accepted")
+    }
+    if _, err := ReadPack(path, "weekly"); !errors.Is(err, ErrUnknownPack) {
+        t.Errorf("unknown pack: %v", err)
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+)
+
+// AppDirName is the directory name used under the OS config and data dirs
+const AppDirName = "eduroam-idp"
+
+// ConfigSearchPaths returns the locations searched for the properties file,
+// in order: the working directory, then the OS config directory
+// ($XDG_CONFIG_HOME or ~/.config on Unix, %AppData% on Windows,
+// ~/Library/Application Support on macOS)
+func ConfigSearchPaths() []string {
+    paths := []string{PropertiesFile}
+    if dir, err := os.UserConfigDir(); err == nil {
+        paths = append(paths, filepath.Join(dir, AppDirName, PropertiesFile))
+    }
+    return paths
+}
+
+// ResolveConfigPath returns the properties file to use. An explicitly given
+// path is used as is; otherwise the first existing file from
+// ConfigSearchPaths is chosen.
+func ResolveConfigPath(explicit string) (string, error) {
+    if explicit != "" {
+        return explicit, nil
+    }
+    paths := ConfigSearchPaths()
+    for _, path := range paths {
+        if _, err := os.Stat(path); err == nil {
+            return path, nil
+        }
+    }
+    return "", fmt.Errorf("%w: %s not found (searched %v)", ErrMissingConfiguration, PropertiesFile, paths)
+}
+
+// UserDataDir returns the OS data directory: $XDG_DATA_HOME or
+// ~/.local/share on Unix, %LocalAppData% on Windows and
+// ~/Library/Application Support on macOS
+func UserDataDir() (string, error) {
+    switch runtime.GOOS {
+    case "windows":
+        if dir := os.Getenv("LocalAppData"); dir != "" {
+            return dir, nil
+        }
+        return "", errors.New("%LocalAppData% is not defined")
+    case "darwin", "ios":
+        home, err := os.UserHomeDir()
+        if err != nil {
+            return "", err
+        }
+        return filepath.Join(home, "Library", "Application Support"), nil
+    }
+
+    if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+        return dir, nil
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(home, ".local", "share"), nil
+}
+
+// ResolveOutputDir returns the base directory for output files. An explicit
+// directory is used as is. Otherwise an existing ./output directory is kept
+// for compatibility with earlier versions (and the Docker image), and the
+// OS data directory is used when there is none.
+func ResolveOutputDir(explicit string) string {
+    if explicit != "" {
+        return explicit
+    }
+    if info, err := os.Stat(OutputDirBase); err == nil && info.IsDir() {
+        return OutputDirBase
+    }
+    if dir, err := UserDataDir(); err == nil {
+        return filepath.Join(dir, AppDirName, OutputDirBase)
+    }
+    return OutputDirBase
+}
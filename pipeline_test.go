@@ -0,0 +1,422 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// fakeEvent is an Access-Accept stored in fakeQuickwit
+type fakeEvent struct {
+    Username  string
+    Provider  string
+    Timestamp int64
+}
+
+// fakeQuickwit answers search requests from a list of events the way
+// Quickwit does for the queries of this program: counts, the per-day user
+// aggregation and the daily date_histogram of range queries
+type fakeQuickwit struct {
+    events []fakeEvent
+    // status, when set, is returned for every search
+    status int
+    // rejectRanges refuses range queries like an exceeded bucket limit
+    rejectRanges bool
+
+    mu       sync.Mutex
+    requests []map[string]interface{}
+}
+
+// response builds an HTTP response with a JSON body
+func response(status int, body interface{}) *http.Response {
+    data, _ := json.Marshal(body)
+    return &http.Response{
+        StatusCode: status,
+        Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+        Proto:      "HTTP/1.1",
+        Header:     http.Header{"Content-Type": {"application/json"}},
+        Body:       io.NopCloser(bytes.NewReader(data)),
+    }
+}
+
+func (q *fakeQuickwit) Do(req *http.Request) (*http.Response, error) {
+    if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "secret" {
+        return response(http.StatusUnauthorized, map[string]string{"message": "unauthorized"}), nil
+    }
+    if !strings.HasSuffix(req.URL.Path, "/api/v1/nro-logs/search") {
+        return response(http.StatusNotFound, map[string]string{"message": "not found"}), nil
+    }
+    var query map[string]interface{}
+    if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+        return nil, err
+    }
+    q.mu.Lock()
+    q.requests = append(q.requests, query)
+    q.mu.Unlock()
+
+    if q.status != 0 {
+        return response(q.status, map[string]string{"message": "internal error"}), nil
+    }
+
+    start, end := int64(query["start_timestamp"].(float64)), int64(query["end_timestamp"].(float64))
+    var events []fakeEvent
+    for _, event := range q.events {
+        if event.Timestamp >= start && event.Timestamp < end {
+            events = append(events, event)
+        }
+    }
+
+    answer := map[string]interface{}{"num_hits": len(events)}
+    aggs, _ := query["aggs"].(map[string]interface{})
+    if days, ok := aggs["days"].(map[string]interface{}); ok {
+        if q.rejectRanges {
+            return response(http.StatusBadRequest, map[string]string{"message": "too many buckets"}), nil
+        }
+        var offset int64
+        fmt.Sscanf(days["date_histogram"].(map[string]interface{})["offset"].(string), "%ds", &offset)
+        byDay := make(map[int64][]fakeEvent)
+        for _, event := range events {
+            key := (event.Timestamp-offset)/86400*86400 + offset
+            byDay[key] = append(byDay[key], event)
+        }
+        keys := make([]int64, 0, len(byDay))
+        for key := range byDay {
+            keys = append(keys, key)
+        }
+        sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+        var buckets []interface{}
+        for _, key := range keys {
+            buckets = append(buckets, map[string]interface{}{
+                "key":          key * 1000,
+                "doc_count":    len(byDay[key]),
+                "unique_users": userBuckets(byDay[key]),
+            })
+        }
+        answer["aggregations"] = map[string]interface{}{"days": map[string]interface{}{"buckets": buckets}}
+    } else if _, ok := aggs["unique_users"]; ok {
+        answer["aggregations"] = map[string]interface{}{"unique_users": userBuckets(events)}
+    }
+    return response(http.StatusOK, answer), nil
+}
+
+// userBuckets aggregates events into the unique_users terms aggregation
+// with the providers and daily sub-aggregations of BuildJobQuery
+func userBuckets(events []fakeEvent) map[string]interface{} {
+    users := make(map[string]map[string][]fakeEvent)
+    for _, event := range events {
+        if users[event.Username] == nil {
+            users[event.Username] = make(map[string][]fakeEvent)
+        }
+        users[event.Username][event.Provider] = append(users[event.Username][event.Provider], event)
+    }
+    var buckets []interface{}
+    for username, providers := range users {
+        var count int
+        var providerBuckets []interface{}
+        daily := make(map[int64]int)
+        for provider, providerEvents := range providers {
+            count += len(providerEvents)
+            providerBuckets = append(providerBuckets, map[string]interface{}{"key": provider, "doc_count": len(providerEvents)})
+            for _, event := range providerEvents {
+                daily[event.Timestamp/86400*86400*1000]++
+            }
+        }
+        var dailyBuckets []interface{}
+        for key, dayCount := range daily {
+            dailyBuckets = append(dailyBuckets, map[string]interface{}{"key": key, "doc_count": dayCount})
+        }
+        buckets = append(buckets, map[string]interface{}{
+            "key":       username,
+            "doc_count": count,
+            "providers": map[string]interface{}{"buckets": providerBuckets},
+            "daily":     map[string]interface{}{"buckets": dailyBuckets},
+        })
+    }
+    return map[string]interface{}{"buckets": buckets}
+}
+
+// searches returns the number of search requests received
+func (q *fakeQuickwit) searches() int {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return len(q.requests)
+}
+
+// newTestClient returns an HTTPClient whose requests go to backend
+func newTestClient(backend HTTPBackend) *HTTPClient {
+    client := NewHTTPClient(Properties{
+        QWUser:  "user",
+        QWPass:  "secret",
+        QWURL:   "http://quickwit.test",
+        Index:   DefaultIndex,
+        Balance: BalanceFailover,
+    })
+    client.SetBackend(backend)
+    return client
+}
+
+// testEvents returns events on the three days of testRange(t, 3): alice
+// roams at two providers, bob at one, carol only on the last day
+func testEvents(t *testing.T) []fakeEvent {
+    jobs := GenerateJobs(testRange(t, 3))
+    at := func(day int, hour int64) int64 { return jobs[day].StartTimestamp + hour*3600 }
+    return []fakeEvent{
+        {"alice@uni.example", "sp1.example.org", at(0, 8)},
+        {"alice@uni.example", "sp1.example.org", at(0, 9)},
+        {"alice@uni.example", "sp2.example.org", at(1, 10)},
+        {"bob@uni.example", "sp1.example.org", at(1, 11)},
+        {"bob@uni.example", "sp1.example.org", at(2, 7)},
+        {"carol@uni.example", "sp3.example.net", at(2, 12)},
+    }
+}
+
+// testConfig returns a configuration analysing testRange(t, 3) at testNow
+func testConfig(t *testing.T) Config {
+    return Config{
+        Domain:       "uni.example",
+        NumWorkers:   2,
+        TimeRange:    testRange(t, 3),
+        MaxClockSkew: -1,
+        Clock:        FixedClock(testNow),
+    }
+}
+
+// checkResult compares a result with the analysis of testEvents
+func checkResult(t *testing.T, result *Result) {
+    t.Helper()
+    if result.TotalHits != 6 {
+        t.Errorf("TotalHits = %d, want 6", result.TotalHits)
+    }
+    wantUsers := map[string][]string{
+        "alice@uni.example": {"sp1.example.org", "sp2.example.org"},
+        "bob@uni.example":   {"sp1.example.org"},
+        "carol@uni.example": {"sp3.example.net"},
+    }
+    if len(result.Users) != len(wantUsers) {
+        t.Errorf("%d users, want %d", len(result.Users), len(wantUsers))
+    }
+    for username, providers := range wantUsers {
+        stats := result.Users[username]
+        if stats == nil {
+            t.Errorf("user %s missing", username)
+            continue
+        }
+        if stats.Providers.Len() != len(providers) {
+            t.Errorf("user %s has %d providers, want %d", username, stats.Providers.Len(), len(providers))
+        }
+        for _, provider := range providers {
+            if !stats.Providers.Has(provider) {
+                t.Errorf("user %s not seen at %s", username, provider)
+            }
+        }
+    }
+    if hits := result.Users["alice@uni.example"].Hits; hits != 3 {
+        t.Errorf("alice has %d hits, want 3", hits)
+    }
+    if users := result.Providers["sp1.example.org"].Users.Len(); users != 2 {
+        t.Errorf("sp1.example.org has %d users, want 2", users)
+    }
+    if hits := result.Providers["sp1.example.org"].Hits; hits != 4 {
+        t.Errorf("sp1.example.org has %d hits, want 4", hits)
+    }
+
+    jobs := GenerateJobs(testRange(t, 3))
+    for i, want := range []int64{2, 2, 2} {
+        day := result.Days[jobs[i].Date.Format(DateFormat)]
+        if day == nil || day.Hits != want {
+            t.Errorf("day %d: %+v, want %d hits", i, day, want)
+        }
+    }
+    if last := result.Days[jobs[2].Date.Format(DateFormat)]; last != nil && last.Users.Len() != 2 {
+        t.Errorf("last day has %d users, want 2", last.Users.Len())
+    }
+}
+
+func TestRunAnalysisPerDay(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    result, err := RunAnalysis(context.Background(), testConfig(t), newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    checkResult(t, result)
+    if n := quickwit.searches(); n != 3 {
+        t.Errorf("%d searches, want one per day", n)
+    }
+}
+
+func TestRunAnalysisSingleQuery(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    config := testConfig(t)
+    config.SingleQueryDays = DefaultSingleQueryDays
+    result, err := RunAnalysis(context.Background(), config, newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    checkResult(t, result)
+    if n := quickwit.searches(); n != 1 {
+        t.Errorf("%d searches, want a single range query", n)
+    }
+}
+
+func TestRunAnalysisRangeFallback(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t), rejectRanges: true}
+    config := testConfig(t)
+    config.SingleQueryDays = DefaultSingleQueryDays
+    result, err := RunAnalysis(context.Background(), config, newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    checkResult(t, result)
+    if n := quickwit.searches(); n != 4 {
+        t.Errorf("%d searches, want the refused range query and one per day", n)
+    }
+}
+
+func TestRunAnalysisClockSkew(t *testing.T) {
+    events := testEvents(t)
+    // An event on the last day stamped after the clock of the run
+    events = append(events, fakeEvent{"mallory@uni.example", "sp1.example.org", testNow.Unix() + 3600})
+    quickwit := &fakeQuickwit{events: events}
+    config := testConfig(t)
+    config.MaxClockSkew = 0
+
+    result, err := RunAnalysis(context.Background(), config, newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    if result.TimestampCutoff != testNow.Unix() {
+        t.Errorf("TimestampCutoff = %d, want %d", result.TimestampCutoff, testNow.Unix())
+    }
+    if result.Users["mallory@uni.example"] != nil {
+        t.Errorf("future event was counted")
+    }
+    if result.FutureEvents != 1 {
+        t.Errorf("FutureEvents = %d, want 1", result.FutureEvents)
+    }
+    if result.TotalHits != 6 {
+        t.Errorf("TotalHits = %d, want 6", result.TotalHits)
+    }
+    for _, request := range quickwit.requests {
+        if _, ok := request["aggs"]; ok && int64(request["end_timestamp"].(float64)) > testNow.Unix() {
+            t.Errorf("aggregation query ends at %v, after the cutoff", request["end_timestamp"])
+        }
+    }
+}
+
+func TestRunAnalysisQuickwitError(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t), status: http.StatusInternalServerError}
+    _, err := RunAnalysis(context.Background(), testConfig(t), newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if !errors.Is(err, ErrSearcherUnavailable) {
+        t.Errorf("RunAnalysis error = %v, want %v", err, ErrSearcherUnavailable)
+    }
+}
+
+func TestRunAnalysisCancelled(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    result, err := RunAnalysis(ctx, testConfig(t), newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if !errors.Is(err, context.Canceled) || !errors.Is(err, ErrPartialRange) {
+        t.Fatalf("RunAnalysis error = %v, want %v wrapping %v", err, ErrPartialRange, context.Canceled)
+    }
+    if !result.Partial || len(result.UnprocessedDays) != 3 {
+        t.Errorf("cancelled result: partial %v, %d unprocessed days, want partial with 3", result.Partial, len(result.UnprocessedDays))
+    }
+}
+
+func TestRunAnalysisDayVisits(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    config := testConfig(t)
+    date := GenerateJobs(testRange(t, 3))[1].Date.Format(DateFormat)
+    for _, visits := range []bool{false, true} {
+        config.DayVisits = visits
+        result, err := RunAnalysis(context.Background(), config, newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+        if err != nil {
+            t.Fatalf("RunAnalysis: %v", err)
+        }
+        // alice at sp2, bob at sp1
+        day := result.Days[date]
+        if day.Users.Len() != 2 || day.Providers.Len() != 2 {
+            t.Errorf("visits %v: %d users and %d providers, want 2 and 2", visits, day.Users.Len(), day.Providers.Len())
+        }
+        if want := map[bool]int{false: 0, true: 2}[visits]; day.Visits.Len() != want {
+            t.Errorf("visits %v: %d pairs, want %d", visits, day.Visits.Len(), want)
+        }
+    }
+
+    if NeedsDayVisits([]string{"json"}, FieldSet{FieldDaily: true}, PivotIdP, false, false, false) {
+        t.Error("daily series alone keeps the pairs")
+    }
+    if !NeedsDayVisits([]string{"json"}, nil, PivotIdP, false, false, false) || !NeedsDayVisits([]string{"star"}, FieldSet{FieldDaily: true}, PivotIdP, false, false, false) {
+        t.Error("multi_provider section or star format without the pairs")
+    }
+}
+
+func TestRunAnalysisTiming(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    config := testConfig(t)
+    config.Timing = true
+    result, err := RunAnalysis(context.Background(), config, newTestClient(quickwit), map[string]interface{}{"query": "*"}, nil)
+    if err != nil {
+        t.Fatalf("RunAnalysis: %v", err)
+    }
+    report := result.Timing
+    if report == nil {
+        t.Fatalf("no timing report")
+    }
+    if report.Batches != 3 || report.Workers != 2 {
+        t.Errorf("timing of %d batches on %d workers, want 3 on 2", report.Batches, report.Workers)
+    }
+    var days int
+    var hits int64
+    for _, worker := range report.PerWorker {
+        days += worker.Days
+        hits += worker.Hits
+    }
+    if days != 3 || hits != 6 {
+        t.Errorf("workers processed %d days with %d hits, want 3 and 6", days, hits)
+    }
+}
+
+func TestSendQuickwitRequest(t *testing.T) {
+    quickwit := &fakeQuickwit{events: testEvents(t)}
+    client := newTestClient(quickwit)
+    jobs := GenerateJobs(testRange(t, 3))
+
+    count, err := CountHits(context.Background(), client, "*", jobs[0].StartTimestamp, jobs[1].EndTimestamp)
+    if err != nil {
+        t.Fatalf("CountHits: %v", err)
+    }
+    if count != 4 {
+        t.Errorf("CountHits = %d, want 4", count)
+    }
+
+    client.SetProperties(Properties{QWUser: "user", QWPass: "wrong", QWURL: "http://quickwit.test", Index: DefaultIndex})
+    if _, err := client.SendQuickwitRequest(context.Background(), map[string]interface{}{"query": "*"}); !errors.Is(err, ErrAuth) || errors.Is(err, ErrSearcherUnavailable) {
+        t.Errorf("unauthorized request: error %v, want %v", err, ErrAuth)
+    }
+}
+
+func TestBatchWorkerBackendError(t *testing.T) {
+    failing := BackendFunc(func(req *http.Request) (*http.Response, error) {
+        return nil, errors.New("connection refused")
+    })
+    jobs := GenerateJobs(testRange(t, 2))
+    resultChan := make(chan LogEntry, 10)
+    var timing BatchTiming
+    hits, err := BatchWorker(context.Background(), jobs, resultChan, map[string]interface{}{"query": "*"}, QueryOptions{}, newTestClient(failing), &timing)
+    if !errors.Is(err, ErrSearcherUnavailable) {
+        t.Errorf("BatchWorker error = %v, want %v", err, ErrSearcherUnavailable)
+    }
+    if len(hits) != 0 || len(resultChan) != 0 {
+        t.Errorf("BatchWorker returned %d days and %d entries after failing", len(hits), len(resultChan))
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+const (
+    // PivotIdP is the default report: users of a realm and the service
+    // providers they visited
+    PivotIdP = "idp"
+
+    // PivotSP flips the report: users who roamed into a service provider and
+    // the realms they came from
+    PivotSP = "sp"
+)
+
+// ParsePivot validates a -pivot value
+func ParsePivot(value string) (string, error) {
+    switch pivot := strings.ToLower(strings.TrimSpace(value)); pivot {
+    case "", PivotIdP:
+        return PivotIdP, nil
+    case PivotSP:
+        return PivotSP, nil
+    default:
+        return "", fmt.Errorf("invalid pivot %q (available: %s, %s)", value, PivotIdP, PivotSP)
+    }
+}
+
+// BuildServiceProviderQuery returns the query string for the events of
+// messageType of the visitors of a service provider, without the events
+// matched by the exclusion rules
+func BuildServiceProviderQuery(messageType, provider string, exclusions []ExclusionRule) string {
+    return fmt.Sprintf(`message_type:"%s" AND service_provider:"%s"%s`, messageType, provider, exclusionClause(exclusions))
+}
+
+// pivotField returns the field aggregated under each user for a pivot
+func pivotField(pivot string) string {
+    if pivot == PivotSP {
+        return "realm"
+    }
+    return "service_provider"
+}
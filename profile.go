@@ -0,0 +1,63 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    _ "net/http/pprof"
+    "os"
+    "runtime"
+    "runtime/pprof"
+)
+
+// StartPprofServer serves the net/http/pprof endpoints on addr. The handlers
+// live on http.DefaultServeMux, which no other listener in this program uses.
+func StartPprofServer(addr string) {
+    go func() {
+        log.Printf("pprof endpoints available at http://%s/debug/pprof/", addr)
+        if err := http.ListenAndServe(addr, nil); err != nil {
+            log.Printf("pprof server error: %v", err)
+        }
+    }()
+}
+
+// StartProfiling starts a CPU profile written to cpuFile (if set) and returns
+// a function that stops it and writes a heap profile to memFile (if set).
+// The returned function must be called before the program exits; profiles
+// are not written when the program terminates through log.Fatal.
+func StartProfiling(cpuFile, memFile string) (func(), error) {
+    var cpuOut *os.File
+    if cpuFile != "" {
+        f, err := os.Create(cpuFile)
+        if err != nil {
+            return nil, fmt.Errorf("error creating CPU profile: %w", err)
+        }
+        if err := pprof.StartCPUProfile(f); err != nil {
+            f.Close()
+            return nil, fmt.Errorf("error starting CPU profile: %w", err)
+        }
+        cpuOut = f
+    }
+
+    return func() {
+        if cpuOut != nil {
+            pprof.StopCPUProfile()
+            cpuOut.Close()
+            log.Printf("CPU profile written to %s", cpuFile)
+        }
+        if memFile != "" {
+            f, err := os.Create(memFile)
+            if err != nil {
+                log.Printf("Error creating memory profile: %v", err)
+                return
+            }
+            defer f.Close()
+            runtime.GC()
+            if err := pprof.WriteHeapProfile(f); err != nil {
+                log.Printf("Error writing memory profile: %v", err)
+                return
+            }
+            log.Printf("Memory profile written to %s", memFile)
+        }
+    }, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// Progress event types published by the analysis pipeline
+const (
+    ProgressStart = "start"
+    ProgressDay   = "progress"
+    ProgressDone  = "done"
+    ProgressError = "error"
+)
+
+// ProgressEvent describes the state of a running analysis
+type ProgressEvent struct {
+    Type          string  `json:"type"`
+    Domain        string  `json:"domain"`
+    ProcessedDays int     `json:"processed_days"`
+    TotalDays     int     `json:"total_days"`
+    TotalHits     int64   `json:"total_hits"`
+    InFlight      int     `json:"in_flight"`
+    Percent       float64 `json:"percent"`
+    Elapsed       string  `json:"elapsed"`
+    Message       string  `json:"message,omitempty"`
+    // ErrorClass is the ErrorClass of the error of a ProgressError event
+    ErrorClass    string  `json:"error_class,omitempty"`
+    Time          string  `json:"time"`
+}
+
+// ProgressBroker fans out progress events to any number of subscribers
+type ProgressBroker struct {
+    mu          sync.Mutex
+    subscribers map[chan ProgressEvent]struct{}
+    last        *ProgressEvent
+    closed      bool
+}
+
+// NewProgressBroker creates an empty progress broker
+func NewProgressBroker() *ProgressBroker {
+    return &ProgressBroker{
+        subscribers: make(map[chan ProgressEvent]struct{}),
+    }
+}
+
+// Publish sends an event to all subscribers without blocking the caller.
+// Slow subscribers miss intermediate events but always see the latest state
+// on their next read.
+func (b *ProgressBroker) Publish(event ProgressEvent) {
+    if b == nil {
+        return
+    }
+    if event.Time == "" {
+        event.Time = time.Now().Format(time.RFC3339)
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.last = &event
+    for ch := range b.subscribers {
+        select {
+        case ch <- event:
+        default:
+        }
+    }
+}
+
+// Subscribe registers a new subscriber and returns its channel together with
+// a function that must be called to unsubscribe. The channel is closed when
+// the broker is closed.
+func (b *ProgressBroker) Subscribe() (<-chan ProgressEvent, func()) {
+    ch := make(chan ProgressEvent, 16)
+
+    b.mu.Lock()
+    if b.last != nil {
+        ch <- *b.last
+    }
+    if b.closed {
+        close(ch)
+    } else {
+        b.subscribers[ch] = struct{}{}
+    }
+    b.mu.Unlock()
+
+    return ch, func() {
+        b.mu.Lock()
+        delete(b.subscribers, ch)
+        b.mu.Unlock()
+    }
+}
+
+// Close closes the channels of all subscribers, ending their event streams
+func (b *ProgressBroker) Close() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.closed = true
+    for ch := range b.subscribers {
+        close(ch)
+        delete(b.subscribers, ch)
+    }
+}
+
+// Last returns the most recently published event, if any
+func (b *ProgressBroker) Last() (ProgressEvent, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.last == nil {
+        return ProgressEvent{}, false
+    }
+    return *b.last, true
+}
+
+// ServeEvents streams progress events to the client as Server-Sent Events
+func (b *ProgressBroker) ServeEvents(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("Connection", "keep-alive")
+
+    events, unsubscribe := b.Subscribe()
+    defer unsubscribe()
+
+    keepAlive := time.NewTicker(15 * time.Second)
+    defer keepAlive.Stop()
+
+    for {
+        select {
+        case event, ok := <-events:
+            if !ok {
+                return
+            }
+            data, err := json.Marshal(event)
+            if err != nil {
+                log.Printf("Error marshaling progress event: %v", err)
+                continue
+            }
+            fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+            flusher.Flush()
+        case <-keepAlive.C:
+            fmt.Fprint(w, ": keep-alive\n\n")
+            flusher.Flush()
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
+
+// StartStatusServer starts the HTTP status listener exposing run progress
+// and the /healthz and /readyz probes; /readyz is gated on ready. The server
+// is shut down when ctx is cancelled; open event streams are ended by
+// closing the broker, so they do not hold up the shutdown.
+func StartStatusServer(ctx context.Context, addr string, broker *ProgressBroker, ready ReadinessCheck) *http.Server {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/healthz", serveHealthz)
+    mux.HandleFunc("/readyz", readyzHandler(ready))
+    mux.HandleFunc("/events", broker.ServeEvents)
+    mux.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
+        event, _ := broker.Last()
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(event)
+    })
+
+    server := &http.Server{
+        Addr:              addr,
+        Handler:           mux,
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+
+    go func() {
+        if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Printf("Status server error: %v", err)
+        }
+    }()
+
+    server.RegisterOnShutdown(broker.Close)
+
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        if err := server.Shutdown(shutdownCtx); err != nil {
+            server.Close()
+        }
+    }()
+
+    return server
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "io"
+    "net"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestStatusServerEndsEventStreams(t *testing.T) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    addr := listener.Addr().String()
+    listener.Close()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    broker := NewProgressBroker()
+    broker.Publish(ProgressEvent{Type: ProgressStart, Domain: "uni.example"})
+    StartStatusServer(ctx, addr, broker, func(context.Context) error { return nil })
+
+    var resp *http.Response
+    for deadline := time.Now().Add(5 * time.Second); ; {
+        if resp, err = http.Get("http://" + addr + "/events"); err == nil || time.Now().After(deadline) {
+            break
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer resp.Body.Close()
+    if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+        t.Fatal(err)
+    }
+
+    cancel()
+    done := make(chan struct{})
+    go func() {
+        io.Copy(io.Discard, resp.Body)
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(2 * time.Second):
+        t.Fatal("event stream still open after shutdown")
+    }
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func writeProperties(t *testing.T, content string) string {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), PropertiesFile)
+    if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+func TestReadPropertiesEnvironments(t *testing.T) {
+    path := writeProperties(t, `QW_USER=user
+QW_PASS=secret
+QW_URL=http://localhost:7280
+QW_INDEX=nro-logs
+DEFAULT_ENV=staging
+ENV.prod.QW_URL=https://quickwit.example.org
+ENV.prod.QW_PASS=prod-secret
+ENV.staging.QW_URL=http://staging:7280
+PROFILE.uni.QW_URL=http://profile:7280
+PROFILE.uni.QW_INDEX=uni-logs
+`)
+
+    props, err := ReadProperties(path, "", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if props.Env != "staging" || props.QWURL != "http://staging:7280" || props.QWPass != "secret" {
+        t.Errorf("default environment: %+v", props)
+    }
+
+    // The environment wins over the profile for the keys it sets
+    props, err = ReadProperties(path, "uni", "prod")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if props.Env != "prod" || props.QWURL != "https://quickwit.example.org" || props.QWPass != "prod-secret" || props.Index != "uni-logs" {
+        t.Errorf("prod environment: %+v", props)
+    }
+
+    if _, err := ReadProperties(path, "", "test"); !errors.Is(err, ErrUnknownEnvironment) {
+        t.Errorf("unknown environment: %v", err)
+    }
+}
+
+func TestReadPropertiesEnvironmentRequired(t *testing.T) {
+    path := writeProperties(t, `QW_USER=user
+QW_PASS=secret
+ENV.prod.QW_URL=https://quickwit.example.org
+ENV.staging.QW_URL=http://staging:7280
+`)
+    if _, err := ReadProperties(path, "", ""); !errors.Is(err, ErrUnknownEnvironment) {
+        t.Errorf("missing environment: %v", err)
+    }
+
+    // Without environments none has to be selected
+    path = writeProperties(t, "QW_USER=user\nQW_PASS=secret\nQW_URL=http://localhost:7280\n")
+    props, err := ReadProperties(path, "", "")
+    if err != nil || props.Env != "" {
+        t.Errorf("no environments: %+v, %v", props, err)
+    }
+    if _, err := ReadProperties(path, "", "prod"); !errors.Is(err, ErrUnknownEnvironment) {
+        t.Errorf("environment without definitions: %v", err)
+    }
+}
+
+func TestReadPropertiesHeadcount(t *testing.T) {
+    path := writeProperties(t, `QW_USER=user
+QW_PASS=secret
+QW_URL=http://localhost:7280
+QW_INDEX=nro-logs
+HEADCOUNT.ku=40000
+HEADCOUNT.uni.example=2000
+PROFILE.uni.HEADCOUNT.uni.example=2500
+`)
+
+    props, err := ReadProperties(path, "", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := props.Headcount("ku", "ku.ac.th"); got != 40000 {
+        t.Errorf("Headcount(ku) = %d, want 40000", got)
+    }
+    if got := props.Headcount("other", "other.example"); got != 0 {
+        t.Errorf("Headcount(other) = %d, want 0", got)
+    }
+
+    props, err = ReadProperties(path, "uni", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := props.Headcount("uni", "uni.example"); got != 2500 {
+        t.Errorf("profile Headcount(uni) = %d, want 2500", got)
+    }
+
+    path = writeProperties(t, "QW_USER=user\nQW_PASS=secret\nQW_URL=http://localhost:7280\nQW_INDEX=nro-logs\nHEADCOUNT.ku=many\n")
+    if _, err := ReadProperties(path, "", ""); err == nil || !strings.Contains(err.Error(), "HEADCOUNT") {
+        t.Error("invalid HEADCOUNT accepted")
+    }
+}
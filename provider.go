@@ -0,0 +1,228 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "time"
+)
+
+// ProviderDay is the activity at a service provider on one day
+type ProviderDay struct {
+    Date string
+    Hits int64
+    // Users is estimated by Quickwit's cardinality aggregation
+    Users int64
+}
+
+// ProviderReport is the drill-down of a single service provider
+type ProviderReport struct {
+    Provider  string
+    Days      []ProviderDay
+    Hits      int64
+    Users     int64
+    FirstSeen time.Time
+    LastSeen  time.Time
+}
+
+// providerDayBucket is a bucket of the days histogram of ProviderDrillDown
+type providerDayBucket struct {
+    Key      bucketKey   `json:"key"`
+    DocCount docCount    `json:"doc_count"`
+    Users    valueMetric `json:"users"`
+}
+
+// ProviderDrillDown queries the daily hits and approximate unique users of
+// queryString (usually a realm query restricted to one service provider) and
+// the first and last event in the time range
+func ProviderDrillDown(ctx context.Context, client *HTTPClient, provider, queryString string, timeRange TimeRange) (*ProviderReport, error) {
+    jobs := GenerateJobs(timeRange)
+    if len(jobs) == 0 {
+        return &ProviderReport{Provider: provider}, nil
+    }
+    offset := (jobs[0].StartTimestamp%86400 + 86400) % 86400
+    query := map[string]interface{}{
+        "query":           queryString,
+        "start_timestamp": timeRange.StartDate.Unix(),
+        "end_timestamp":   timeRange.EndDate.Unix(),
+        "max_hits":        0,
+        "aggs": map[string]interface{}{
+            "users": map[string]interface{}{
+                "cardinality": map[string]interface{}{"field": "username"},
+            },
+            "days": map[string]interface{}{
+                "date_histogram": map[string]interface{}{
+                    "field":          "timestamp",
+                    "fixed_interval": "86400s",
+                    "offset":         fmt.Sprintf("%ds", offset),
+                },
+                "aggs": map[string]interface{}{
+                    "users": map[string]interface{}{
+                        "cardinality": map[string]interface{}{"field": "username"},
+                    },
+                },
+            },
+        },
+    }
+
+    result, err := client.SendQuickwitRequest(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    var users valueMetric
+    if err := decodeAggregation(result, "users", &users); err != nil {
+        return nil, err
+    }
+    data, err := responseAggregation(result, "days")
+    if err != nil {
+        return nil, err
+    }
+    buckets, err := decodeBuckets[providerDayBucket]("days", data)
+    if err != nil {
+        return nil, err
+    }
+
+    report := &ProviderReport{Provider: provider, Hits: int64(result.NumHits), Users: int64(users.Value)}
+    days := make(map[string]ProviderDay)
+    for _, bucket := range buckets {
+        key, err := bucket.Key.float()
+        if err != nil {
+            return nil, err
+        }
+        day := ProviderDay{
+            Date:  time.UnixMilli(int64(key)).In(timeRange.StartDate.Location()).Format(DateFormat),
+            Hits:  int64(bucket.DocCount),
+            Users: int64(bucket.Users.Value),
+        }
+        days[day.Date] = day
+    }
+    for _, job := range jobs {
+        date := job.Date.Format(DateFormat)
+        day, ok := days[date]
+        if !ok {
+            day = ProviderDay{Date: date}
+        }
+        report.Days = append(report.Days, day)
+    }
+
+    if report.Hits > 0 {
+        if report.FirstSeen, err = eventTimestamp(ctx, client, queryString, timeRange, "timestamp"); err != nil {
+            return nil, err
+        }
+        if report.LastSeen, err = eventTimestamp(ctx, client, queryString, timeRange, "-timestamp"); err != nil {
+            return nil, err
+        }
+    }
+    return report, nil
+}
+
+// eventTimestamp returns the timestamp of the first event of queryString in
+// the time range under sortBy ("timestamp" or "-timestamp")
+func eventTimestamp(ctx context.Context, client *HTTPClient, queryString string, timeRange TimeRange, sortBy string) (time.Time, error) {
+    result, err := client.SendQuickwitRequest(ctx, map[string]interface{}{
+        "query":           queryString,
+        "start_timestamp": timeRange.StartDate.Unix(),
+        "end_timestamp":   timeRange.EndDate.Unix(),
+        "max_hits":        1,
+        "sort_by":         sortBy,
+    })
+    if err != nil {
+        return time.Time{}, err
+    }
+    if len(result.Hits) == 0 {
+        return time.Time{}, nil
+    }
+    timestamp, ok := parseLogTimestamp(fmt.Sprint(result.Hits[0]["timestamp"]))
+    if !ok {
+        return time.Time{}, fmt.Errorf("%w: hit timestamp %v", ErrUnexpectedResponse, result.Hits[0]["timestamp"])
+    }
+    return timestamp, nil
+}
+
+// runProvider implements the "provider" subcommand
+func runProvider(args []string) int {
+    flags := flag.NewFlagSet("provider", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* Quickwit environment of the configuration file (default: DEFAULT_ENV)")
+    domain := flags.String("domain", "", "Only count users of this realm or alias (default: all realms)")
+    messageTypeName := flags.String("message-type", "accept", "RADIUS message type counted: accept, reject, challenge or accounting")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp provider [flags] <service_provider> [days|Ny|yxxxx|DD-MM-YYYY]")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() < 1 || flags.NArg() > 2 {
+        flags.Usage()
+        return 1
+    }
+    provider := flags.Arg(0)
+    messageType, err := ParseMessageType(*messageTypeName)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+
+    timeRange, err := ResolveTimeRange(flags.Arg(1))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+        return 1
+    }
+
+    client, err := LoadClient(*configFile, *profile, *env)
+    if err != nil {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+    props := client.properties()
+    exclusions, err := ParseExclusions(props.Exclusions)
+    if err != nil {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+
+    queryString := BuildServiceProviderQuery(messageType, provider, exclusions)
+    target := "all realms"
+    if *domain != "" {
+        realm := GetDomain(*domain, props.Aliases, false)
+        queryString = fmt.Sprintf(`%s AND service_provider:"%s"`, BuildRealmQuery(messageType, RealmVariants([]string{realm}), exclusions), provider)
+        target = RealmToUnicode(realm)
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    report, err := ProviderDrillDown(ctx, client, provider, queryString, timeRange)
+    if err != nil {
+        log.Printf("Error querying provider: %v", err)
+        return 1
+    }
+
+    fmt.Printf("Service provider %s, users of %s, from %s to %s:\n", provider, target,
+        timeRange.StartDate.Format(DateFormat), timeRange.EndDate.Format(DateFormat))
+    fmt.Printf("  %-12s %10s %10s\n", "Date", "Hits", "Users")
+    for _, day := range report.Days {
+        fmt.Printf("  %-12s %10d %10d\n", day.Date, day.Hits, day.Users)
+    }
+    fmt.Printf("Total hits: %d\n", report.Hits)
+    fmt.Printf("Unique users (approx.): %d\n", report.Users)
+    if report.Hits == 0 {
+        fmt.Println("No events in this period")
+        return 0
+    }
+    fmt.Printf("First seen: %s\n", formatSeen(report.FirstSeen))
+    fmt.Printf("Last seen: %s\n", formatSeen(report.LastSeen))
+    return 0
+}
+
+// formatSeen formats a first/last seen time, which is zero when Quickwit
+// returned no parseable timestamp
+func formatSeen(t time.Time) string {
+    if t.IsZero() {
+        return "unknown"
+    }
+    return t.Local().Format(DateTimeFormat)
+}
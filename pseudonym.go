@@ -0,0 +1,199 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+)
+
+const (
+    // PseudonymKeyFile is the key file of -stable-ids in the user config
+    // dir unless PSEUDONYM_KEY names another one
+    PseudonymKeyFile = "pseudonym.key"
+
+    // pseudonymKeySize is the size in bytes of generated keys
+    pseudonymKeySize = 32
+
+    // pseudonymMinKeySize is the smallest key accepted from a key file
+    pseudonymMinKeySize = 16
+
+    // pseudonymLength is the number of hex digits of an identifier
+    pseudonymLength = 32
+
+    // PseudonymScheme names the identifiers in query_info
+    PseudonymScheme = "hmac-sha256"
+)
+
+// Pseudonymizer replaces usernames by keyed HMAC identifiers. With the
+// same key a username always maps to the same identifier, so outputs of
+// different runs can be joined without ever containing the username.
+type Pseudonymizer struct {
+    key []byte
+}
+
+// NewPseudonymizer returns a pseudonymizer using key
+func NewPseudonymizer(key []byte) *Pseudonymizer {
+    return &Pseudonymizer{key: key}
+}
+
+// ID returns the identifier of username
+func (p *Pseudonymizer) ID(username string) string {
+    mac := hmac.New(sha256.New, p.key)
+    mac.Write([]byte(username))
+    return "u-" + hex.EncodeToString(mac.Sum(nil))[:pseudonymLength]
+}
+
+// Fingerprint identifies the key without revealing it; identifiers are
+// only comparable between outputs with the same fingerprint
+func (p *Pseudonymizer) Fingerprint() string {
+    sum := sha256.Sum256(p.key)
+    return hex.EncodeToString(sum[:])[:12]
+}
+
+// Scheme returns the query_info description of the identifiers
+func (p *Pseudonymizer) Scheme() string {
+    return PseudonymScheme + ":" + p.Fingerprint()
+}
+
+// DefaultPseudonymKeyPath returns the key file in the user config dir
+func DefaultPseudonymKeyPath() (string, error) {
+    dir, err := os.UserConfigDir()
+    if err != nil {
+        return "", fmt.Errorf("error locating the pseudonym key: %w", err)
+    }
+    return filepath.Join(dir, AppDirName, PseudonymKeyFile), nil
+}
+
+// LoadPseudonymKey reads the hex-encoded key of path. A missing file is
+// created with a random key, readable by the owner only; an existing file
+// that other users can read is refused, as anyone with the key can check
+// whether a username is in an output.
+func LoadPseudonymKey(path string) (*Pseudonymizer, error) {
+    data, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        return createPseudonymKey(path)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error reading pseudonym key: %w", err)
+    }
+    if runtime.GOOS != "windows" {
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, fmt.Errorf("error reading pseudonym key: %w", err)
+        }
+        if info.Mode().Perm()&0077 != 0 {
+            return nil, fmt.Errorf("pseudonym key %s is accessible by other users (chmod 600 it)", path)
+        }
+    }
+    key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil || len(key) < pseudonymMinKeySize {
+        return nil, fmt.Errorf("pseudonym key %s must hold at least %d hex-encoded bytes", path, pseudonymMinKeySize)
+    }
+    return NewPseudonymizer(key), nil
+}
+
+// createPseudonymKey writes a new random key to path
+func createPseudonymKey(path string) (*Pseudonymizer, error) {
+    key := make([]byte, pseudonymKeySize)
+    if _, err := rand.Read(key); err != nil {
+        return nil, fmt.Errorf("error generating pseudonym key: %w", err)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+        return nil, fmt.Errorf("error creating pseudonym key: %w", err)
+    }
+    file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+    if err != nil {
+        return nil, fmt.Errorf("error creating pseudonym key: %w", err)
+    }
+    if _, err := fmt.Fprintln(file, hex.EncodeToString(key)); err != nil {
+        file.Close()
+        return nil, fmt.Errorf("error writing pseudonym key: %w", err)
+    }
+    if err := file.Close(); err != nil {
+        return nil, fmt.Errorf("error writing pseudonym key: %w", err)
+    }
+    return NewPseudonymizer(key), nil
+}
+
+// PseudonymizeUsers returns a copy of result whose usernames are replaced
+// by their identifiers in the users, the provider user sets and the days
+func PseudonymizeUsers(result *Result, pseudonyms *Pseudonymizer) *Result {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    ids := make(map[string]string, len(result.Users))
+    id := func(username string) string {
+        if pseudonym, ok := ids[username]; ok {
+            return pseudonym
+        }
+        pseudonym := pseudonyms.ID(username)
+        ids[username] = pseudonym
+        return pseudonym
+    }
+
+    pseudonymized := &Result{
+        Users:           make(map[string]*UserStats, len(result.Users)),
+        Providers:       make(map[string]*ProviderStats, len(result.Providers)),
+        StartDate:       result.StartDate,
+        EndDate:         result.EndDate,
+        TotalHits:       result.TotalHits,
+        Partial:         result.Partial,
+        UnprocessedDays: result.UnprocessedDays,
+        TruncatedDays:   result.TruncatedDays,
+        Pivot:           result.Pivot,
+        Exclusions:      result.Exclusions,
+        FoldRealmCase:   result.FoldRealmCase,
+        Local:           result.Local,
+        Verification:    result.Verification,
+        DataQuality:     result.DataQuality,
+        Forecast:        result.Forecast,
+        Timing:          result.Timing,
+        Resources:       result.Resources,
+        Backpressure:    result.Backpressure,
+        TimestampCutoff: result.TimestampCutoff,
+        FutureEvents:    result.FutureEvents,
+    }
+    for username, stats := range result.Users {
+        pseudonymized.Users[id(username)] = stats
+    }
+    for provider, stats := range result.Providers {
+        copied := &ProviderStats{FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen, Hits: stats.Hits}
+        for username := range stats.Users.All() {
+            copied.Users.Add(id(username))
+        }
+        pseudonymized.Providers[provider] = copied
+    }
+    if result.Realms != nil {
+        pseudonymized.Realms = make(map[string]*RealmStats, len(result.Realms))
+        for realm, stats := range result.Realms {
+            copied := &RealmStats{Hits: stats.Hits}
+            for username := range stats.Users.All() {
+                copied.Users.Add(id(username))
+            }
+            pseudonymized.Realms[realm] = copied
+        }
+    }
+    if result.Days != nil {
+        pseudonymized.Days = make(map[string]*DayStats, len(result.Days))
+        for date, day := range result.Days {
+            copied := &DayStats{Providers: day.Providers, Hits: day.Hits, ProviderHits: day.ProviderHits}
+            for username := range day.Users.All() {
+                copied.Users.Add(id(username))
+            }
+            for username, providers := range day.Visits.ByUser() {
+                for _, provider := range providers {
+                    copied.Visits.Add(id(username), provider)
+                }
+            }
+            pseudonymized.Days[date] = copied
+        }
+    }
+    return pseudonymized
+}
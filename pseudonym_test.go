@@ -0,0 +1,78 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+)
+
+func TestLoadPseudonymKey(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "keys", PseudonymKeyFile)
+    created, err := LoadPseudonymKey(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if info, err := os.Stat(path); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+        t.Fatalf("key file: %v, %v", info, err)
+    }
+
+    loaded, err := LoadPseudonymKey(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    id := loaded.ID("alice@uni.example")
+    if id != created.ID("alice@uni.example") || !strings.HasPrefix(id, "u-") || len(id) != 2+pseudonymLength {
+        t.Errorf("identifier %q differs between loads", id)
+    }
+    if id == loaded.ID("bob@uni.example") || loaded.Fingerprint() != created.Fingerprint() {
+        t.Errorf("identifiers or fingerprints do not match the key")
+    }
+    if other := NewPseudonymizer([]byte("another key of 32 bytes, really")); other.ID("alice@uni.example") == id {
+        t.Errorf("identifier does not depend on the key")
+    }
+
+    if runtime.GOOS != "windows" {
+        if err := os.Chmod(path, 0644); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := LoadPseudonymKey(path); err == nil {
+            t.Error("key readable by other users accepted")
+        }
+    }
+
+    short := filepath.Join(t.TempDir(), PseudonymKeyFile)
+    if err := os.WriteFile(short, []byte("abcd\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadPseudonymKey(short); err == nil {
+        t.Error("short key accepted")
+    }
+}
+
+func TestPseudonymizeUsers(t *testing.T) {
+    pseudonyms := NewPseudonymizer([]byte("0123456789abcdef"))
+    result := &Result{
+        Users:     map[string]*UserStats{"alice": {Hits: 3}, "bob": {Hits: 1}},
+        Providers: map[string]*ProviderStats{"sp.example.org": {Hits: 4}},
+        Days:      map[string]*DayStats{"2025-01-01": testDay(4, map[string][]string{"alice": {"sp.example.org"}})},
+    }
+    result.Providers["sp.example.org"].Users.Add("alice")
+    result.Providers["sp.example.org"].Users.Add("bob")
+
+    pseudonymized := PseudonymizeUsers(result, pseudonyms)
+    alice := pseudonyms.ID("alice")
+    if stats := pseudonymized.Users[alice]; stats == nil || stats.Hits != 3 || len(pseudonymized.Users) != 2 {
+        t.Fatalf("users: %v", pseudonymized.Users)
+    }
+    if users := pseudonymized.Providers["sp.example.org"].Users.Sorted(); len(users) != 2 || !strings.HasPrefix(users[0], "u-") {
+        t.Errorf("provider users: %v", users)
+    }
+    if day := pseudonymized.Days["2025-01-01"]; !day.Users.Has(alice) || day.Visits.Len() != 1 {
+        t.Errorf("day users: %v", day.Users.Sorted())
+    }
+    if _, ok := result.Users["alice"]; !ok {
+        t.Error("original result modified")
+    }
+}
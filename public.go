@@ -0,0 +1,154 @@
+package main
+
+import (
+    "strings"
+)
+
+// UnknownCountry groups providers whose domain has no country-code TLD
+const UnknownCountry = "other"
+
+// PublicFields returns the sections of fields that may be published; the
+// user list, the NAI report, the outliers, the security section, the
+// multi-provider days and the raw samples name users and are dropped. With
+// stableIDs the user list is kept, its usernames replaced by identifiers.
+func PublicFields(fields FieldSet, stableIDs bool) FieldSet {
+    public := make(FieldSet)
+    for _, field := range OutputFields {
+        if field == FieldUsers && stableIDs && fields.Has(field) {
+            public[field] = true
+            continue
+        }
+        if fields.Has(field) && field != FieldUsers && field != FieldNAI && field != FieldOutliers && field != FieldSecurity && field != FieldMultiProvider && field != FieldSamples {
+            public[field] = true
+        }
+    }
+    return public
+}
+
+// ProviderCountry returns the upper-case country-code TLD of a provider
+// domain, or UnknownCountry for generic TLDs such as .org or .edu
+func ProviderCountry(provider string) string {
+    tld := provider[strings.LastIndex(provider, ".")+1:]
+    if len(tld) != 2 || !isASCII(tld) {
+        return UnknownCountry
+    }
+    return strings.ToUpper(tld)
+}
+
+// CountryMap assigns countries to service providers whose domain says
+// nothing or the wrong thing about their location (COUNTRY.<provider>=<CC>)
+type CountryMap map[string]string
+
+// Country returns the configured country of provider, falling back to the
+// TLD heuristic of ProviderCountry
+func (m CountryMap) Country(provider string) string {
+    if country, ok := m[provider]; ok {
+        return country
+    }
+    return ProviderCountry(provider)
+}
+
+// ProvidersByCountry returns a copy of result whose providers are replaced
+// by their countries (CountryMap.Country). Users and days refer to the
+// countries as well, so every section aggregates per country.
+func ProvidersByCountry(result *Result, countries CountryMap) *Result {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    byCountry := &Result{
+        Users:           make(map[string]*UserStats, len(result.Users)),
+        Providers:       make(map[string]*ProviderStats),
+        StartDate:       result.StartDate,
+        EndDate:         result.EndDate,
+        TotalHits:       result.TotalHits,
+        Partial:         result.Partial,
+        UnprocessedDays: result.UnprocessedDays,
+        TruncatedDays:   result.TruncatedDays,
+        Realms:          result.Realms,
+        Pivot:           result.Pivot,
+        Exclusions:      result.Exclusions,
+        FoldRealmCase:   result.FoldRealmCase,
+        Local:           result.Local,
+        Verification:    result.Verification,
+        DataQuality:     result.DataQuality,
+        Forecast:        result.Forecast,
+        Security:        result.Security,
+        Samples:         result.Samples,
+        Timing:          result.Timing,
+        Resources:       result.Resources,
+        Backpressure:    result.Backpressure,
+        TimestampCutoff: result.TimestampCutoff,
+        FutureEvents:    result.FutureEvents,
+    }
+
+    for username, stats := range result.Users {
+        user := &UserStats{FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen, Hits: stats.Hits}
+        for provider := range stats.Providers.All() {
+            user.Providers.Add(countries.Country(provider))
+        }
+        byCountry.Users[username] = user
+    }
+
+    for provider, stats := range result.Providers {
+        country := countries.Country(provider)
+        merged := byCountry.Providers[country]
+        if merged == nil {
+            merged = &ProviderStats{FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen}
+            byCountry.Providers[country] = merged
+        }
+        for username := range stats.Users.All() {
+            merged.Users.Add(username)
+        }
+        if stats.FirstSeen.Before(merged.FirstSeen) {
+            merged.FirstSeen = stats.FirstSeen
+        }
+        if stats.LastSeen.After(merged.LastSeen) {
+            merged.LastSeen = stats.LastSeen
+        }
+        merged.Hits += stats.Hits
+    }
+
+    if result.Days != nil {
+        byCountry.Days = make(map[string]*DayStats, len(result.Days))
+        for date, day := range result.Days {
+            merged := &DayStats{Hits: day.Hits}
+            merged.Users.Merge(day.Users)
+            for provider := range day.Providers.All() {
+                merged.Providers.Add(countries.Country(provider))
+            }
+            for username, providers := range day.Visits.ByUser() {
+                for _, provider := range providers {
+                    merged.Visits.Add(username, countries.Country(provider))
+                }
+            }
+            if day.ProviderHits != nil {
+                merged.ProviderHits = make(map[string]int64)
+                for provider, hits := range day.ProviderHits {
+                    merged.ProviderHits[countries.Country(provider)] += hits
+                }
+            }
+            byCountry.Days[date] = merged
+        }
+    }
+    return byCountry
+}
+
+// stripUsernames removes what identifies users from output data that is
+// to be published; keepUsers keeps the user list of a result whose
+// usernames were replaced by identifiers (PseudonymizeUsers)
+func (output *SimplifiedOutputData) stripUsernames(keepUsers bool) {
+    if !keepUsers {
+        output.UserStats = nil
+    }
+    output.NAIValidation = nil
+    for i := range output.ProviderStats {
+        output.ProviderStats[i].Users = nil
+    }
+    if output.Mobility != nil {
+        output.Mobility.TopRoamers = nil
+    }
+    if output.Changes != nil {
+        output.Changes.NewUsers = nil
+        output.Changes.LostUsers = nil
+    }
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "crypto/tls"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// RunEvent is published when a run completes
+type RunEvent struct {
+    Type      string   `json:"type"`
+    Domain    string   `json:"domain"`
+    StartDate string   `json:"start_date"`
+    EndDate   string   `json:"end_date"`
+    Days      int      `json:"days"`
+    Users     int      `json:"users"`
+    Providers int      `json:"providers"`
+    Hits      int64    `json:"hits"`
+    Partial   bool     `json:"partial,omitempty"`
+    Files     []string `json:"files,omitempty"`
+    RunInfo   RunInfo  `json:"run_info"`
+}
+
+// ProviderEvent is published for every provider of a completed run
+type ProviderEvent struct {
+    Type      string `json:"type"`
+    Domain    string `json:"domain"`
+    StartDate string `json:"start_date"`
+    EndDate   string `json:"end_date"`
+    Provider  string `json:"provider"`
+    Users     int    `json:"users"`
+    Hits      int64  `json:"hits"`
+    FirstSeen string `json:"first_seen"`
+    LastSeen  string `json:"last_seen"`
+}
+
+// Publisher sends run events to a NATS subject (nats:// or tls:// URL,
+// path = subject) or a Kafka topic through a Kafka REST Proxy (http(s)://
+// URL of /topics/<topic>); configured with PUBLISH_URL
+type Publisher struct {
+    URL *url.URL
+}
+
+// NewPublisher returns the publisher configured in props
+func NewPublisher(props Properties) (*Publisher, error) {
+    if props.PublishURL == "" {
+        return nil, fmt.Errorf("%w: PUBLISH_URL", ErrMissingConfiguration)
+    }
+    u, err := url.Parse(props.PublishURL)
+    if err != nil {
+        return nil, fmt.Errorf("invalid PUBLISH_URL: %w", err)
+    }
+    switch u.Scheme {
+    case "nats", "tls":
+        if strings.Trim(u.Path, "/") == "" {
+            return nil, fmt.Errorf("invalid PUBLISH_URL %q: no subject", props.PublishURL)
+        }
+    case "http", "https":
+        if !strings.Contains(u.Path, "/topics/") {
+            return nil, fmt.Errorf("invalid PUBLISH_URL %q: expected a Kafka REST Proxy /topics/<topic> URL", props.PublishURL)
+        }
+    default:
+        return nil, fmt.Errorf("invalid PUBLISH_URL scheme %q (expected nats, tls, http or https)", u.Scheme)
+    }
+    return &Publisher{URL: u}, nil
+}
+
+// String returns the target without credentials
+func (p *Publisher) String() string {
+    u := *p.URL
+    u.User = nil
+    return u.String()
+}
+
+// RunEvents builds the summary event and the provider events of a run
+func RunEvents(result *Result, meta ExportMeta, files []string) (RunEvent, []ProviderEvent) {
+    domain := RealmToUnicode(meta.Domain)
+    start := meta.TimeRange.StartDate.Format(DateTimeFormat)
+    end := meta.TimeRange.EndDate.Format(DateTimeFormat)
+
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    summary := RunEvent{
+        Type:      "run_summary",
+        Domain:    domain,
+        StartDate: start,
+        EndDate:   end,
+        Days:      meta.TimeRange.Days,
+        Users:     len(result.Users),
+        Providers: len(result.Providers),
+        Hits:      result.TotalHits,
+        Partial:   result.Partial,
+        Files:     files,
+        RunInfo:   GetRunInfo(),
+    }
+    var providers []ProviderEvent
+    for _, provider := range SortedProviders(result.Providers, SortByName) {
+        stats := result.Providers[provider]
+        providers = append(providers, ProviderEvent{
+            Type:      "provider",
+            Domain:    domain,
+            StartDate: start,
+            EndDate:   end,
+            Provider:  provider,
+            Users:     stats.Users.Len(),
+            Hits:      stats.Hits,
+            FirstSeen: stats.FirstSeen.Format(DateFormat),
+            LastSeen:  stats.LastSeen.Format(DateFormat),
+        })
+    }
+    return summary, providers
+}
+
+// Publish sends the summary and provider events. On NATS the providers go
+// to <subject>.providers; on Kafka all events share the topic, keyed by
+// domain, and are told apart by their type.
+func (p *Publisher) Publish(ctx context.Context, summary RunEvent, providers []ProviderEvent) error {
+    summaryData, err := json.Marshal(summary)
+    if err != nil {
+        return err
+    }
+    providerData := make([][]byte, len(providers))
+    for i, provider := range providers {
+        if providerData[i], err = json.Marshal(provider); err != nil {
+            return err
+        }
+    }
+    if p.URL.Scheme == "nats" || p.URL.Scheme == "tls" {
+        return p.publishNATS(ctx, summaryData, providerData)
+    }
+    return p.publishKafkaREST(ctx, summary.Domain, append([][]byte{summaryData}, providerData...))
+}
+
+// publishNATS publishes over the NATS text protocol and waits for the
+// server to acknowledge with PONG
+func (p *Publisher) publishNATS(ctx context.Context, summary []byte, providers [][]byte) error {
+    host := p.URL.Host
+    if p.URL.Port() == "" {
+        host = net.JoinHostPort(p.URL.Hostname(), "4222")
+    }
+    dialer := &net.Dialer{Timeout: DefaultHTTPTimeout}
+    conn, err := dialer.DialContext(ctx, "tcp", host)
+    if err != nil {
+        return fmt.Errorf("error connecting to NATS: %w", err)
+    }
+    defer conn.Close()
+    conn.SetDeadline(time.Now().Add(DefaultHTTPTimeout))
+
+    reader := bufio.NewReader(conn)
+    info, err := reader.ReadString('\n')
+    if err != nil || !strings.HasPrefix(info, "INFO ") {
+        return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(info))
+    }
+    var rw io.ReadWriter = conn
+    if p.URL.Scheme == "tls" {
+        tlsConn := tls.Client(conn, &tls.Config{ServerName: p.URL.Hostname()})
+        if err := tlsConn.HandshakeContext(ctx); err != nil {
+            return fmt.Errorf("error connecting to NATS: %w", err)
+        }
+        rw, reader = tlsConn, bufio.NewReader(tlsConn)
+    }
+
+    options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "eduroam-idp", "lang": "go", "version": Version}
+    if user := p.URL.User; user != nil {
+        if pass, ok := user.Password(); ok {
+            options["user"], options["pass"] = user.Username(), pass
+        } else {
+            options["auth_token"] = user.Username()
+        }
+    }
+    connect, _ := json.Marshal(options)
+    subject := strings.ReplaceAll(strings.Trim(p.URL.Path, "/"), "/", ".")
+
+    var buf bytes.Buffer
+    fmt.Fprintf(&buf, "CONNECT %s\r\n", connect)
+    fmt.Fprintf(&buf, "PUB %s %d\r\n%s\r\n", subject, len(summary), summary)
+    for _, provider := range providers {
+        fmt.Fprintf(&buf, "PUB %s.providers %d\r\n%s\r\n", subject, len(provider), provider)
+    }
+    buf.WriteString("PING\r\n")
+    if _, err := rw.Write(buf.Bytes()); err != nil {
+        return fmt.Errorf("error publishing to NATS: %w", err)
+    }
+    for {
+        line, err := reader.ReadString('\n')
+        if err != nil {
+            return fmt.Errorf("error publishing to NATS: %w", err)
+        }
+        line = strings.TrimSpace(line)
+        switch {
+        case line == "PONG":
+            return nil
+        case strings.HasPrefix(line, "-ERR"):
+            return fmt.Errorf("NATS error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+        }
+    }
+}
+
+// publishKafkaREST posts the records to a Kafka REST Proxy topic
+func (p *Publisher) publishKafkaREST(ctx context.Context, key string, records [][]byte) error {
+    type record struct {
+        Key   string          `json:"key"`
+        Value json.RawMessage `json:"value"`
+    }
+    body := struct {
+        Records []record `json:"records"`
+    }{}
+    for _, value := range records {
+        body.Records = append(body.Records, record{Key: key, Value: value})
+    }
+    data, err := json.Marshal(body)
+    if err != nil {
+        return err
+    }
+
+    target := *p.URL
+    target.User = nil
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+    if user := p.URL.User; user != nil {
+        pass, _ := user.Password()
+        req.SetBasicAuth(user.Username(), pass)
+    }
+    client := &http.Client{Timeout: DefaultHTTPTimeout}
+    resp, err := client.Do(req)
+    if err != nil {
+        return fmt.Errorf("error publishing to Kafka: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("error publishing to Kafka: %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+    }
+    return nil
+}
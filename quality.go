@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// DataQualityDay counts the records of one day that skew the statistics
+type DataQualityDay struct {
+    Date            string `json:"date"`
+    MissingUsername int64  `json:"missing_username"`
+    EmptyUsername   int64  `json:"empty_username"`
+    MissingProvider int64  `json:"missing_service_provider"`
+    // FutureTimestamps counts events stamped after the time of the check.
+    // Events outside the queried window are never returned by the
+    // timestamp-filtered search, so a future timestamp is the clock skew
+    // that can be detected.
+    FutureTimestamps int64 `json:"future_timestamps"`
+}
+
+// DataQualityReport is the per-day data-quality section (-data-quality)
+type DataQualityReport struct {
+    Days  []DataQualityDay `json:"days"`
+    Total DataQualityDay   `json:"total"`
+}
+
+// dataQualityChecks are the query clauses counted per day
+var dataQualityChecks = []struct {
+    clause string
+    field  func(*DataQualityDay) *int64
+}{
+    {` AND NOT username:*`, func(d *DataQualityDay) *int64 { return &d.MissingUsername }},
+    {` AND username:""`, func(d *DataQualityDay) *int64 { return &d.EmptyUsername }},
+    {` AND NOT service_provider:*`, func(d *DataQualityDay) *int64 { return &d.MissingProvider }},
+}
+
+// CheckDataQuality counts, for every day of the time range, the records of
+// the query with a missing or empty username or a missing service provider,
+// and those with timestamps in the future, using count-only queries
+func CheckDataQuality(ctx context.Context, client *HTTPClient, query map[string]interface{}, timeRange TimeRange) (*DataQualityReport, error) {
+    queryString, _ := query["query"].(string)
+    now := time.Now().Unix()
+
+    report := &DataQualityReport{Total: DataQualityDay{Date: "total"}}
+    for _, job := range GenerateJobs(timeRange) {
+        day := DataQualityDay{Date: job.Date.Format(DateFormat)}
+        for _, check := range dataQualityChecks {
+            count, err := CountHits(ctx, client, "("+queryString+")"+check.clause, job.StartTimestamp, job.EndTimestamp)
+            if err != nil {
+                return nil, fmt.Errorf("error checking data quality of %s: %w", day.Date, err)
+            }
+            *check.field(&day) = count
+        }
+        if job.EndTimestamp > now {
+            count, err := CountHits(ctx, client, queryString, max(now, job.StartTimestamp), job.EndTimestamp)
+            if err != nil {
+                return nil, fmt.Errorf("error checking data quality of %s: %w", day.Date, err)
+            }
+            day.FutureTimestamps = count
+        }
+
+        // Partial days of the range share a date; add them up
+        if n := len(report.Days); n > 0 && report.Days[n-1].Date == day.Date {
+            day.add(report.Days[n-1])
+            report.Days[n-1] = day
+        } else {
+            report.Days = append(report.Days, day)
+        }
+    }
+    for _, day := range report.Days {
+        report.Total.add(day)
+    }
+    return report, nil
+}
+
+// add adds the counts of other to d
+func (d *DataQualityDay) add(other DataQualityDay) {
+    d.MissingUsername += other.MissingUsername
+    d.EmptyUsername += other.EmptyUsername
+    d.MissingProvider += other.MissingProvider
+    d.FutureTimestamps += other.FutureTimestamps
+}
+
+// Issues returns the number of problem records counted
+func (d DataQualityDay) Issues() int64 {
+    return d.MissingUsername + d.EmptyUsername + d.MissingProvider + d.FutureTimestamps
+}
+
+// ExportDataQualityCSV writes the data-quality section next to the other
+// CSV files. It returns an empty filename when no check was run.
+func ExportDataQualityCSV(result *Result, meta ExportMeta) (string, error) {
+    if result.DataQuality == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Date", "Missing Username", "Empty Username", "Missing Service Provider", "Future Timestamps"}}
+    for _, day := range append(result.DataQuality.Days, result.DataQuality.Total) {
+        records = append(records, []string{
+            day.Date,
+            strconv.FormatInt(day.MissingUsername, 10),
+            strconv.FormatInt(day.EmptyUsername, 10),
+            strconv.FormatInt(day.MissingProvider, 10),
+            strconv.FormatInt(day.FutureTimestamps, 10),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-data-quality.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
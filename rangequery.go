@@ -0,0 +1,133 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "time"
+)
+
+// DefaultSingleQueryDays is the longest range fetched with one query
+// instead of one query per day
+const DefaultSingleQueryDays = 7
+
+// ErrRangeQueryFailed indicates that the single range query could not be
+// sent or was refused, e.g. for exceeding the bucket limit; nothing has been
+// emitted then and the range can still be queried day by day
+var ErrRangeQueryFailed = errors.New("range query failed")
+
+// BuildRangeQuery builds one aggregation query covering all jobs. The
+// per-day aggregation of BuildJobQuery is nested under a daily
+// date_histogram aligned with the job boundaries.
+func BuildRangeQuery(query map[string]interface{}, jobs []Job, options QueryOptions) map[string]interface{} {
+    first, last := jobs[0], jobs[len(jobs)-1]
+    rangeQuery := BuildJobQuery(query, Job{
+        StartTimestamp: first.StartTimestamp,
+        EndTimestamp:   last.EndTimestamp,
+        Date:           first.Date,
+    }, options)
+
+    // Days start at local midnight, buckets at multiples of 86400s UTC
+    offset := (first.StartTimestamp%86400 + 86400) % 86400
+    rangeQuery["aggs"] = map[string]interface{}{
+        "days": map[string]interface{}{
+            "date_histogram": map[string]interface{}{
+                "field":          "timestamp",
+                "fixed_interval": "86400s",
+                "offset":         fmt.Sprintf("%ds", offset),
+            },
+            "aggs": rangeQuery["aggs"],
+        },
+    }
+    return rangeQuery
+}
+
+// RangeWorker fetches all jobs with a single query and splits the daily
+// buckets client-side, emitting the same entries as one Worker per job. It
+// returns the hits of every job, joined with the truncations of days whose
+// user buckets were cut off.
+func RangeWorker(ctx context.Context, jobs []Job, resultChan chan<- LogEntry, query map[string]interface{}, options QueryOptions, client *HTTPClient, timing *BatchTiming) (map[time.Time]int64, error) {
+    queryStart := time.Now()
+    result, err := client.SendQuickwitRequest(ctx, BuildRangeQuery(query, jobs, options))
+    queryTime := time.Since(queryStart)
+    if err != nil {
+        timing.record(queryTime, 0)
+        return nil, fmt.Errorf("%w: %w", ErrRangeQueryFailed, err)
+    }
+    processStart := time.Now()
+    defer func() { timing.record(queryTime, time.Since(processStart)) }()
+
+    data, err := responseAggregation(result, "days")
+    if err != nil {
+        return nil, err
+    }
+    buckets, err := decodeBuckets[dayBucket]("days", data)
+    if err != nil {
+        return nil, err
+    }
+
+    // As in Worker, a fetched range is processed completely
+    ctx = context.WithoutCancel(ctx)
+    hits := make(map[time.Time]int64, len(jobs))
+    for _, job := range jobs {
+        hits[job.Date] = 0
+    }
+    var truncations []error
+    for _, bucket := range buckets {
+        key, err := bucket.Key.float()
+        if err != nil || bucket.UniqueUsers == nil {
+            continue
+        }
+        job, ok := jobAt(jobs, int64(key/1000))
+        if !ok {
+            continue
+        }
+        dayHits, err := processUserAggregation(ctx, bucket.UniqueUsers, resultChan, job.Date)
+        if err != nil && !errors.Is(err, ErrBucketTruncated) {
+            return nil, err
+        }
+        truncations = append(truncations, err)
+        hits[job.Date] += dayHits
+    }
+    return hits, errors.Join(truncations...)
+}
+
+// jobAt returns the job whose time span contains timestamp
+func jobAt(jobs []Job, timestamp int64) (Job, bool) {
+    for _, job := range jobs {
+        if timestamp >= job.StartTimestamp && timestamp < job.EndTimestamp {
+            return job, true
+        }
+    }
+    return Job{}, false
+}
+
+// BatchWorker fetches a batch of jobs, a single job with Worker and several
+// with RangeWorker. When the range query fails the jobs are fetched one by
+// one instead, unless the failure would repeat for every day (ErrAuth,
+// ErrIndexNotFound). On error the hits of the jobs fetched so far are
+// returned. Truncated days do not stop the batch; their errors are joined
+// to the one returned. The time spent is added to timing, which may be nil.
+func BatchWorker(ctx context.Context, jobs []Job, resultChan chan<- LogEntry, query map[string]interface{}, options QueryOptions, client *HTTPClient, timing *BatchTiming) (map[time.Time]int64, error) {
+    if len(jobs) > 1 {
+        hits, err := RangeWorker(ctx, jobs, resultChan, query, options, client, timing)
+        if err == nil || !errors.Is(err, ErrRangeQueryFailed) || errors.Is(err, ErrAuth) || errors.Is(err, ErrIndexNotFound) || ctx.Err() != nil {
+            return hits, err
+        }
+        log.Printf("Query for %s to %s failed, querying day by day: %v",
+            jobs[0].Date.Format(DateFormat), jobs[len(jobs)-1].Date.Format(DateFormat), err)
+    }
+
+    hits := make(map[time.Time]int64, len(jobs))
+    var truncations []error
+    for _, job := range jobs {
+        dayHits, err := Worker(ctx, job, resultChan, query, options, client, timing)
+        if err != nil && !errors.Is(err, ErrBucketTruncated) {
+            return hits, errors.Join(append(truncations, err)...)
+        }
+        truncations = append(truncations, err)
+        hits[job.Date] = dayHits
+    }
+    return hits, errors.Join(truncations...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "context"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+)
+
+const (
+    // DefaultRealmDiscoverySize is the maximum number of realms returned by discovery
+    DefaultRealmDiscoverySize = 10000
+)
+
+// RealmCount is a realm value together with its number of hits
+type RealmCount struct {
+    Realm string
+    Hits  int64
+}
+
+// DiscoverRealms aggregates the distinct realm values with Access-Accept
+// events in the time range, ordered by hits (descending)
+func DiscoverRealms(ctx context.Context, client *HTTPClient, timeRange TimeRange, size int) ([]RealmCount, error) {
+    query := map[string]interface{}{
+        "query":           `message_type:"Access-Accept" NOT service_provider:"client"`,
+        "start_timestamp": timeRange.StartDate.Unix(),
+        "end_timestamp":   timeRange.EndDate.Unix(),
+        "max_hits":        0,
+        "aggs": map[string]interface{}{
+            "realms": map[string]interface{}{
+                "terms": map[string]interface{}{
+                    "field": "realm",
+                    "size":  size,
+                },
+            },
+        },
+    }
+
+    result, err := client.SendQuickwitRequest(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := responseAggregation(result, "realms")
+    if err != nil {
+        return nil, err
+    }
+    buckets, err := decodeBuckets[termsBucket]("realms", data)
+    if err != nil {
+        return nil, err
+    }
+
+    realms := make([]RealmCount, 0, len(buckets))
+    for _, bucket := range buckets {
+        realms = append(realms, RealmCount{Realm: string(bucket.Key), Hits: int64(bucket.DocCount)})
+    }
+
+    sort.Slice(realms, func(i, j int) bool {
+        if realms[i].Hits != realms[j].Hits {
+            return realms[i].Hits > realms[j].Hits
+        }
+        return realms[i].Realm < realms[j].Realm
+    })
+    return realms, nil
+}
+
+// runRealms implements the "realms" subcommand
+func runRealms(args []string) int {
+    flags := flag.NewFlagSet("realms", flag.ExitOnError)
+    configFile := flags.String("config", "", "Path to configuration file")
+    profile := flags.String("profile", "", "Use the PROFILE.<name>.* settings of the configuration file")
+    env := flags.String("env", "", "Use the ENV.<name>.* Quickwit environment of the configuration file (default: DEFAULT_ENV)")
+    size := flags.Int("size", DefaultRealmDiscoverySize, "Maximum number of realms to return")
+    minHits := flags.Int64("min-hits", 1, "Only list realms with at least this many hits")
+    similarTo := flags.String("similar-to", "", "Flag realms within a small edit distance of this domain (e.g. typos)")
+    maxDistance := flags.Int("max-distance", DefaultLookalikeDistance, "Maximum edit distance for -similar-to")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp realms [flags] [days|Ny|yxxxx|DD-MM-YYYY]")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() > 1 {
+        flags.Usage()
+        return 1
+    }
+
+    timeRange, err := ResolveTimeRange(flags.Arg(0))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+        return 1
+    }
+
+    client, err := LoadClient(*configFile, *profile, *env)
+    if err != nil {
+        log.Printf("Error reading properties: %v", err)
+        return 1
+    }
+
+    ctx, cancel := commandContext()
+    defer cancel()
+
+    realms, err := DiscoverRealms(ctx, client, timeRange, *size)
+    if err != nil {
+        log.Printf("Error discovering realms: %v", err)
+        return 1
+    }
+
+    fmt.Printf("Realms from %s to %s:\n", timeRange.StartDate.Format(DateFormat), timeRange.EndDate.Format(DateFormat))
+    listed := 0
+    for _, realm := range realms {
+        if realm.Hits < *minHits {
+            continue
+        }
+        fmt.Printf("  %-50s %12d\n", realm.Realm, realm.Hits)
+        listed++
+    }
+    fmt.Printf("%d realms\n", listed)
+
+    if *similarTo != "" {
+        target := GetDomain(*similarTo, client.properties().Aliases, false)
+        printLookalikes(FindLookalikes(realms, []string{target, *similarTo}, *maxDistance, nil), target)
+    }
+    return 0
+}
+
+// printLookalikes lists realms that look like target
+func printLookalikes(lookalikes []Lookalike, target string) {
+    if len(lookalikes) == 0 {
+        fmt.Printf("No look-alike realms of %s\n", target)
+        return
+    }
+    fmt.Printf("Look-alike realms of %s:\n", target)
+    for _, l := range lookalikes {
+        fmt.Printf("  %-50s %12d  (distance %d)\n", l.Realm, l.Hits, l.Distance)
+    }
+}
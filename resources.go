@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+    "runtime"
+    "runtime/metrics"
+    "sort"
+    "sync"
+    "time"
+)
+
+// resourceSampleInterval is how often the heap size and the channel
+// backlogs are sampled
+const resourceSampleInterval = 100 * time.Millisecond
+
+// heapMetric is the runtime metric sampled for the peak heap size
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// ChannelBacklog is the highest number of entries waiting in a channel
+type ChannelBacklog struct {
+    Name      string `json:"name"`
+    Capacity  int    `json:"capacity"`
+    HighWater int    `json:"high_water"`
+}
+
+// ResourceReport is the memory use of a run, for sizing the machine
+type ResourceReport struct {
+    // PeakRSSBytes is the peak resident set size of the process, 0 where
+    // the operating system does not report it
+    PeakRSSBytes  int64            `json:"peak_rss_bytes,omitempty"`
+    PeakHeapBytes uint64           `json:"peak_heap_bytes"`
+    HeapSysBytes  uint64           `json:"heap_sys_bytes"`
+    GCCycles      uint32           `json:"gc_cycles"`
+    GCPauseMs     float64          `json:"gc_pause_ms"`
+    GCCPUPercent  float64          `json:"gc_cpu_percent"`
+    Backlogs      []ChannelBacklog `json:"channel_backlogs,omitempty"`
+}
+
+// watchedChannel is a channel whose backlog is sampled
+type watchedChannel struct {
+    capacity  int
+    length    func() int
+    highWater int
+}
+
+// ResourceMonitor samples the heap size and the backlog of watched
+// channels in the background. A nil *ResourceMonitor records nothing.
+type ResourceMonitor struct {
+    mu       sync.Mutex
+    peakHeap uint64
+    channels map[string]*watchedChannel
+    stop     chan struct{}
+    stopOnce sync.Once
+}
+
+// StartResourceMonitor starts sampling until Stop is called
+func StartResourceMonitor() *ResourceMonitor {
+    m := &ResourceMonitor{channels: make(map[string]*watchedChannel), stop: make(chan struct{})}
+    go func() {
+        ticker := time.NewTicker(resourceSampleInterval)
+        defer ticker.Stop()
+        samples := []metrics.Sample{{Name: heapMetric}}
+        for {
+            m.sample(samples)
+            select {
+            case <-ticker.C:
+            case <-m.stop:
+                return
+            }
+        }
+    }()
+    return m
+}
+
+// sample records the current heap size and channel backlogs
+func (m *ResourceMonitor) sample(samples []metrics.Sample) {
+    metrics.Read(samples)
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if samples[0].Value.Kind() == metrics.KindUint64 {
+        m.peakHeap = max(m.peakHeap, samples[0].Value.Uint64())
+    }
+    for _, channel := range m.channels {
+        channel.highWater = max(channel.highWater, channel.length())
+    }
+}
+
+// Watch samples the backlog of a channel of the given capacity, read by
+// length. Watching a name again (e.g. the result channel of a second
+// analysis) replaces the channel and keeps the high-water mark.
+func (m *ResourceMonitor) Watch(name string, capacity int, length func() int) {
+    if m == nil {
+        return
+    }
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if channel, ok := m.channels[name]; ok {
+        channel.capacity, channel.length = capacity, length
+        return
+    }
+    m.channels[name] = &watchedChannel{capacity: capacity, length: length}
+}
+
+// Stop ends the sampling
+func (m *ResourceMonitor) Stop() {
+    if m == nil {
+        return
+    }
+    m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Report returns the peak values so far together with the garbage
+// collector statistics of the process
+func (m *ResourceMonitor) Report() *ResourceReport {
+    if m == nil {
+        return nil
+    }
+    var mem runtime.MemStats
+    runtime.ReadMemStats(&mem)
+    report := &ResourceReport{
+        HeapSysBytes: mem.HeapSys,
+        GCCycles:     mem.NumGC,
+        GCPauseMs:    float64(mem.PauseTotalNs) / 1e6,
+        GCCPUPercent: mem.GCCPUFraction * 100,
+    }
+    report.PeakRSSBytes, _ = peakRSS()
+
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    report.PeakHeapBytes = max(m.peakHeap, mem.HeapAlloc)
+    for name, channel := range m.channels {
+        report.Backlogs = append(report.Backlogs, ChannelBacklog{Name: name, Capacity: channel.capacity, HighWater: max(channel.highWater, channel.length())})
+    }
+    sort.Slice(report.Backlogs, func(i, j int) bool { return report.Backlogs[i].Name < report.Backlogs[j].Name })
+    return report
+}
+
+// PrintResourceReport writes the memory use of a run
+func PrintResourceReport(report *ResourceReport) {
+    rss := "unknown"
+    if report.PeakRSSBytes > 0 {
+        rss = FormatBytes(report.PeakRSSBytes)
+    }
+    fmt.Printf("Memory: peak RSS %s, peak heap %s, heap from OS %s, %d GC cycles (%.1f ms paused, %.1f%% CPU)\n",
+        rss, FormatBytes(int64(report.PeakHeapBytes)), FormatBytes(int64(report.HeapSysBytes)),
+        report.GCCycles, report.GCPauseMs, report.GCCPUPercent)
+    for _, backlog := range report.Backlogs {
+        fmt.Printf("  %s channel backlog: high water %d of %d\n", backlog.Name, backlog.HighWater, backlog.Capacity)
+    }
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestResourceMonitor(t *testing.T) {
+    var none *ResourceMonitor
+    none.Watch("results", 1, func() int { return 0 })
+    if none.Report() != nil {
+        t.Error("nil monitor reported")
+    }
+
+    monitor := StartResourceMonitor()
+    defer monitor.Stop()
+    results := make(chan int, 10)
+    for i := 0; i < 7; i++ {
+        results <- i
+    }
+    monitor.Watch("results", cap(results), func() int { return len(results) })
+    time.Sleep(3 * resourceSampleInterval)
+    for len(results) > 0 {
+        <-results
+    }
+
+    // A second analysis replaces the channel, keeping the mark
+    next := make(chan int, 20)
+    monitor.Watch("results", cap(next), func() int { return len(next) })
+    report := monitor.Report()
+    if len(report.Backlogs) != 1 || report.Backlogs[0] != (ChannelBacklog{Name: "results", Capacity: 20, HighWater: 7}) {
+        t.Errorf("backlogs: %+v", report.Backlogs)
+    }
+    if report.PeakHeapBytes == 0 {
+        t.Error("no heap size recorded")
+    }
+    monitor.Stop()
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+    "runtime"
+    "syscall"
+)
+
+// peakRSS returns the peak resident set size of the process in bytes
+func peakRSS() (int64, bool) {
+    var usage syscall.Rusage
+    if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+        return 0, false
+    }
+    // Darwin reports bytes, the other systems kilobytes
+    if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+        return int64(usage.Maxrss), true
+    }
+    return int64(usage.Maxrss) * 1024, true
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package main
+
+// peakRSS reports that the peak resident set size is not read on Windows
+func peakRSS() (int64, bool) {
+    return 0, false
+}
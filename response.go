@@ -0,0 +1,174 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "strconv"
+)
+
+// ErrUnexpectedResponse indicates a Quickwit response whose shape does not
+// match the query, e.g. an aggregation of the wrong type
+var ErrUnexpectedResponse = errors.New("unexpected quickwit response")
+
+// bucketKey is the key of an aggregation bucket. Terms keys are strings,
+// histogram keys numbers; either form is accepted.
+type bucketKey string
+
+func (k *bucketKey) UnmarshalJSON(data []byte) error {
+    if len(data) > 0 && data[0] == '"' {
+        var s string
+        if err := json.Unmarshal(data, &s); err != nil {
+            return err
+        }
+        *k = bucketKey(s)
+        return nil
+    }
+    if bytes.Equal(data, []byte("null")) {
+        return fmt.Errorf("%w: null bucket key", ErrUnexpectedResponse)
+    }
+    *k = bucketKey(data)
+    return nil
+}
+
+// float returns a numeric key, e.g. the milliseconds of a histogram bucket
+func (k bucketKey) float() (float64, error) {
+    value, err := strconv.ParseFloat(string(k), 64)
+    if err != nil {
+        return 0, fmt.Errorf("%w: non-numeric bucket key %q", ErrUnexpectedResponse, string(k))
+    }
+    return value, nil
+}
+
+// docCount is the doc_count of a bucket, accepting integral floats
+type docCount int64
+
+func (c *docCount) UnmarshalJSON(data []byte) error {
+    var value float64
+    if err := json.Unmarshal(data, &value); err != nil {
+        return fmt.Errorf("%w: doc_count %s", ErrUnexpectedResponse, data)
+    }
+    *c = docCount(value)
+    return nil
+}
+
+// termsBucket is a bucket of a terms aggregation without sub-aggregations
+type termsBucket struct {
+    Key      bucketKey `json:"key"`
+    DocCount docCount  `json:"doc_count"`
+}
+
+// histogramBucket is a bucket of a date_histogram; Key is in milliseconds
+type histogramBucket struct {
+    Key      bucketKey `json:"key"`
+    DocCount docCount  `json:"doc_count"`
+}
+
+// userBucket is a bucket of the unique_users aggregation of BuildJobQuery
+type userBucket struct {
+    Key       bucketKey `json:"key"`
+    DocCount  docCount  `json:"doc_count"`
+    Providers struct {
+        Buckets []termsBucket `json:"buckets"`
+    } `json:"providers"`
+    Realms *struct {
+        Buckets []termsBucket `json:"buckets"`
+    } `json:"realms"`
+    Daily struct {
+        Buckets []histogramBucket `json:"buckets"`
+    } `json:"daily"`
+}
+
+// dayBucket is a bucket of the days histogram of BuildRangeQuery
+type dayBucket struct {
+    Key         bucketKey       `json:"key"`
+    UniqueUsers json.RawMessage `json:"unique_users"`
+}
+
+// aggregationBuckets is any aggregation, its buckets left undecoded so that
+// a malformed bucket can be skipped on its own
+type aggregationBuckets struct {
+    Buckets []json.RawMessage `json:"buckets"`
+}
+
+// otherDocCount returns the sum_other_doc_count of a terms aggregation,
+// the documents of the terms beyond its size, or 0 if it has none
+func otherDocCount(data json.RawMessage) int64 {
+    var agg struct {
+        SumOtherDocCount docCount `json:"sum_other_doc_count"`
+    }
+    if err := json.Unmarshal(data, &agg); err != nil {
+        return 0
+    }
+    return int64(agg.SumOtherDocCount)
+}
+
+// SearchResponse is a Quickwit search response, decoded once by
+// SendQuickwitRequest. The aggregations are left as JSON for the query that
+// asked for them to decode into its own bucket types.
+type SearchResponse struct {
+    NumHits      docCount                   `json:"num_hits"`
+    Hits         []map[string]interface{}   `json:"hits,omitempty"`
+    Aggregations map[string]json.RawMessage `json:"aggregations,omitempty"`
+    Error        string                     `json:"error,omitempty"`
+}
+
+// valueMetric is a single-value metric aggregation, e.g. a cardinality
+type valueMetric struct {
+    Value float64 `json:"value"`
+}
+
+// responseAggregation returns the named aggregation of a search response
+// as JSON, ErrNoAggregationsInResponse when the response has none
+func responseAggregation(response *SearchResponse, name string) (json.RawMessage, error) {
+    if response.Aggregations == nil {
+        return nil, fmt.Errorf("%w: %w", ErrUnexpectedResponse, ErrNoAggregationsInResponse)
+    }
+    data, ok := response.Aggregations[name]
+    if !ok {
+        return nil, fmt.Errorf("%w: no %s aggregation", ErrUnexpectedResponse, name)
+    }
+    return data, nil
+}
+
+// decodeAggregation decodes the named aggregation of a search response
+// into v, e.g. a valueMetric
+func decodeAggregation(response *SearchResponse, name string, v interface{}) error {
+    data, err := responseAggregation(response, name)
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, v); err != nil {
+        return fmt.Errorf("%w: %s aggregation: %w", ErrUnexpectedResponse, name, err)
+    }
+    return nil
+}
+
+// decodeBuckets decodes the buckets of an aggregation into T. Buckets that
+// do not decode are skipped and logged, so that a partially unexpected
+// response loses those buckets instead of the whole run.
+func decodeBuckets[T any](name string, data json.RawMessage) ([]T, error) {
+    var agg aggregationBuckets
+    if err := json.Unmarshal(data, &agg); err != nil {
+        return nil, fmt.Errorf("%w: %s aggregation: %w", ErrUnexpectedResponse, name, err)
+    }
+    buckets := make([]T, 0, len(agg.Buckets))
+    var skipped int
+    var firstErr error
+    for _, raw := range agg.Buckets {
+        var bucket T
+        if err := json.Unmarshal(raw, &bucket); err != nil {
+            if skipped++; firstErr == nil {
+                firstErr = err
+            }
+            continue
+        }
+        buckets = append(buckets, bucket)
+    }
+    if skipped > 0 {
+        log.Printf("Warning: skipped %d malformed buckets of the %s aggregation: %v", skipped, name, firstErr)
+    }
+    return buckets, nil
+}
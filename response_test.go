@@ -0,0 +1,183 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "testing"
+    "time"
+)
+
+// decodeResponse parses a Quickwit response body as SendQuickwitRequest does
+func decodeResponse(t *testing.T, body string) *SearchResponse {
+    t.Helper()
+    var response *SearchResponse
+    if err := json.Unmarshal([]byte(body), &response); err != nil {
+        t.Fatal(err)
+    }
+    return response
+}
+
+// collect runs ProcessAggregations on a response and returns its entries
+func collect(t *testing.T, body string) ([]LogEntry, int64, error) {
+    t.Helper()
+    resultChan := make(chan LogEntry, 100)
+    jobDate := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+    hits, err := ProcessAggregations(context.Background(), decodeResponse(t, body), resultChan, jobDate)
+    close(resultChan)
+    var entries []LogEntry
+    for entry := range resultChan {
+        entries = append(entries, entry)
+    }
+    return entries, hits, err
+}
+
+func TestProcessAggregations(t *testing.T) {
+    entries, hits, err := collect(t, `{"aggregations": {"unique_users": {"buckets": [
+        {"key": "alice@uni.example", "doc_count": 3,
+         "providers": {"buckets": [{"key": "sp1.example.org", "doc_count": 2}, {"key": "sp2.example.org", "doc_count": 1}]},
+         "realms": {"buckets": [{"key": "cs.uni.example", "doc_count": 1}, {"key": "uni.example", "doc_count": 2}]},
+         "daily": {"buckets": [{"key": 1710460800000, "doc_count": 3}]}}
+    ]}}}`)
+    if err != nil {
+        t.Fatalf("ProcessAggregations: %v", err)
+    }
+    if hits != 3 || len(entries) != 2 {
+        t.Fatalf("%d hits and %d entries, want 3 and 2", hits, len(entries))
+    }
+    if entries[0].Username != "alice@uni.example" || entries[0].ServiceProvider != "sp1.example.org" || entries[0].Hits != 2 {
+        t.Errorf("first entry = %+v", entries[0])
+    }
+    if entries[0].Realm != "uni.example" {
+        t.Errorf("realm = %q, want the busiest realm uni.example", entries[0].Realm)
+    }
+    if entries[0].Timestamp.Format(DateFormat) != "2024-03-15" {
+        t.Errorf("timestamp = %v, want the job date", entries[0].Timestamp)
+    }
+}
+
+func TestProcessAggregationsUnexpectedShapes(t *testing.T) {
+    tests := []struct {
+        name    string
+        body    string
+        hits    int64
+        entries int
+        err     error
+    }{
+        {name: "no aggregations", body: `{"num_hits": 0}`, err: ErrNoAggregationsInResponse},
+        {name: "missing aggregation", body: `{"aggregations": {}}`, err: ErrUnexpectedResponse},
+        {name: "aggregation not an object", body: `{"aggregations": {"unique_users": [1, 2]}}`, err: ErrUnexpectedResponse},
+        {name: "no buckets", body: `{"aggregations": {"unique_users": {}}}`},
+        {name: "numeric username and float count", body: `{"aggregations": {"unique_users": {"buckets": [
+            {"key": 12345, "doc_count": 2.0, "providers": {"buckets": [{"key": "sp1.example.org", "doc_count": 2}]},
+             "daily": {"buckets": [{"key": 1710460800000, "doc_count": 2}]}}]}}}`, hits: 2, entries: 1},
+        {name: "malformed buckets skipped", body: `{"aggregations": {"unique_users": {"buckets": [
+            "not a bucket",
+            {"key": null, "doc_count": 1},
+            {"key": "bob@uni.example", "doc_count": "many"},
+            {"key": "carol@uni.example", "doc_count": 1, "providers": {"buckets": [{"key": "sp1.example.org", "doc_count": 1}]},
+             "daily": {"buckets": [{"key": 1710460800000, "doc_count": 1}]}}]}}}`, hits: 1, entries: 1},
+        {name: "missing sub-aggregations", body: `{"aggregations": {"unique_users": {"buckets": [
+            {"key": "dave@uni.example", "doc_count": 4}]}}}`, hits: 4},
+        {name: "non-numeric daily key", body: `{"aggregations": {"unique_users": {"buckets": [
+            {"key": "erin@uni.example", "doc_count": 1, "providers": {"buckets": [{"key": "sp1.example.org", "doc_count": 1}]},
+             "daily": {"buckets": [{"key": "yesterday", "doc_count": 1}]}}]}}}`, hits: 1},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            entries, hits, err := collect(t, tt.body)
+            if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+                t.Fatalf("error = %v, want %v", err, tt.err)
+            }
+            if hits != tt.hits || len(entries) != tt.entries {
+                t.Errorf("%d hits and %d entries, want %d and %d", hits, len(entries), tt.hits, tt.entries)
+            }
+        })
+    }
+}
+
+func TestRangeWorkerUnexpectedShapes(t *testing.T) {
+    jobs := GenerateJobs(testRange(t, 2))
+    tests := []struct {
+        name string
+        body string
+        err  error
+    }{
+        {name: "days not an object", body: `{"aggregations": {"days": "none"}}`, err: ErrUnexpectedResponse},
+        {name: "buckets without users", body: fmt.Sprintf(`{"aggregations": {"days": {"buckets": [{"key": %d, "doc_count": 0}]}}}`, jobs[0].StartTimestamp*1000)},
+    }
+    for _, tt := range tests {
+        client := newTestClient(BackendFunc(func(req *http.Request) (*http.Response, error) {
+            return response(http.StatusOK, json.RawMessage(tt.body)), nil
+        }))
+        hits, err := RangeWorker(context.Background(), jobs, make(chan LogEntry, 10), map[string]interface{}{"query": "*"}, QueryOptions{}, client, nil)
+        if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+            t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+        }
+        if tt.err == nil && len(hits) != len(jobs) {
+            t.Errorf("%s: hits of %d days, want %d", tt.name, len(hits), len(jobs))
+        }
+    }
+}
+
+func TestProcessAggregationsTruncated(t *testing.T) {
+    entries, hits, err := collect(t, `{"aggregations": {"unique_users": {"sum_other_doc_count": 40, "buckets": [
+        {"key": "alice@uni.example", "doc_count": 3,
+         "providers": {"buckets": [{"key": "sp1.example.org", "doc_count": 3}]},
+         "daily": {"buckets": [{"key": 1710460800000, "doc_count": 3}]}}
+    ]}}}`)
+    var truncation *BucketTruncationError
+    if !errors.As(err, &truncation) || truncation.OtherHits != 40 || truncation.Date.Format(DateFormat) != "2024-03-15" {
+        t.Fatalf("ProcessAggregations error = %v, want a truncation of 40 hits", err)
+    }
+    if hits != 3 || len(entries) != 1 {
+        t.Errorf("%d hits and %d entries, want the returned users kept", hits, len(entries))
+    }
+}
+
+func TestQueryUnexpectedShapes(t *testing.T) {
+    timeRange := testRange(t, 2)
+    jobs := GenerateJobs(timeRange)
+    probe := func(client *HTTPClient) error {
+        _, err := ProbeDailyHits(context.Background(), client, map[string]interface{}{"query": "*"}, jobs)
+        return err
+    }
+    realms := func(client *HTTPClient) error {
+        _, err := DiscoverRealms(context.Background(), client, timeRange, 10)
+        return err
+    }
+    local := func(client *HTTPClient) error {
+        _, err := CountLocalTraffic(context.Background(), client, "Access-Accept", nil, timeRange, 0)
+        return err
+    }
+    tests := []struct {
+        name  string
+        query func(*HTTPClient) error
+        body  string
+        err   error
+    }{
+        {name: "probe without aggregations", query: probe, body: `{"num_hits": 0}`, err: ErrNoAggregationsInResponse},
+        {name: "probe without days", query: probe, body: `{"aggregations": {}}`, err: ErrUnexpectedResponse},
+        {name: "probe with a non-numeric key", query: probe, body: `{"aggregations": {"days": {"buckets": [{"key": "today", "doc_count": 1}]}}}`, err: ErrUnexpectedResponse},
+        {name: "probe", query: probe, body: fmt.Sprintf(`{"aggregations": {"days": {"buckets": [{"key": %d, "doc_count": 1}]}}}`, jobs[0].StartTimestamp*1000)},
+        {name: "realms not an object", query: realms, body: `{"aggregations": {"realms": 3}}`, err: ErrUnexpectedResponse},
+        {name: "realms", query: realms, body: `{"aggregations": {"realms": {"buckets": [{"key": "uni.example", "doc_count": 2}]}}}`},
+        {name: "local without users", query: local, body: `{"num_hits": 4, "aggregations": {}}`, err: ErrUnexpectedResponse},
+        {name: "local with a string value", query: local, body: `{"num_hits": 4, "aggregations": {"users": {"value": "many"}}}`, err: ErrUnexpectedResponse},
+        {name: "response not an object", query: local, body: `[]`, err: ErrUnexpectedResponse},
+    }
+    for _, tt := range tests {
+        client := newTestClient(BackendFunc(func(req *http.Request) (*http.Response, error) {
+            return response(http.StatusOK, json.RawMessage(tt.body)), nil
+        }))
+        err := tt.query(client)
+        if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+            t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+        }
+        if errors.Is(err, ErrNoAggregationsInResponse) && !errors.Is(err, ErrUnexpectedResponse) {
+            t.Errorf("%s: error = %v, want it wrapped in ErrUnexpectedResponse", tt.name, err)
+        }
+    }
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+    "log"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+const (
+    // DefaultResultBufferMax is the number of entries the result queue
+    // holds in memory beyond the channel buffer before ingestion waits.
+    // An entry takes about 100 bytes, so the default stays around 5 MB;
+    // larger queues are opt-in with -result-buffer-max.
+    DefaultResultBufferMax = 50000
+
+    // resultLagWarning is how long processing may lag ingestion (entries
+    // in the overflow) before a warning is logged, and the interval of
+    // repeated warnings
+    resultLagWarning = 30 * time.Second
+)
+
+// BackpressureStats is the instrumentation of a result queue
+type BackpressureStats struct {
+    // Overflowed is the number of entries that found the channel buffer
+    // full and were queued in the overflow instead of blocking a worker
+    Overflowed        int64   `json:"overflowed_entries"`
+    OverflowHighWater int     `json:"overflow_high_water"`
+    OverflowMax       int     `json:"overflow_max"`
+    // Stalls counts the times the overflow was full and ingestion had to
+    // wait for the processors, StallMs the time it waited
+    Stalls            int64   `json:"stalls"`
+    StallMs           float64 `json:"stall_ms"`
+    LagWarnings       int     `json:"lag_warnings"`
+}
+
+// ResultQueue connects the workers with the result processors. Entries
+// the processors cannot take yet are kept in an overflow that grows up to
+// a maximum, so a slow processor does not stall the queries; only a full
+// overflow makes ingestion wait. Lagging processing is logged.
+type ResultQueue struct {
+    in          chan LogEntry
+    out         chan LogEntry
+    max         int
+    overflowLen atomic.Int64
+    mu          sync.Mutex
+    stats       BackpressureStats
+    done        chan struct{}
+}
+
+// NewResultQueue returns a queue with channel buffers of buffer entries
+// and an overflow of up to limit entries (at least one)
+func NewResultQueue(buffer, limit int) *ResultQueue {
+    q := &ResultQueue{
+        in:   make(chan LogEntry, buffer),
+        out:  make(chan LogEntry, buffer),
+        max:  maxEntries(limit),
+        done: make(chan struct{}),
+    }
+    q.stats.OverflowMax = q.max
+    go q.relay()
+    return q
+}
+
+// maxEntries returns the overflow size for a -result-buffer-max value;
+// one entry is always kept so the relay never blocks on a full channel
+// with an entry in hand
+func maxEntries(limit int) int {
+    if limit < 1 {
+        return 1
+    }
+    return limit
+}
+
+// In returns the channel the workers send their entries to
+func (q *ResultQueue) In() chan<- LogEntry {
+    return q.in
+}
+
+// Out returns the channel the processors read; it is closed once the
+// queue was closed and every entry was handed over
+func (q *ResultQueue) Out() <-chan LogEntry {
+    return q.out
+}
+
+// Close ends ingestion; the entries queued so far are still delivered
+func (q *ResultQueue) Close() {
+    close(q.in)
+}
+
+// Buffered returns the number of entries waiting in the channel buffers
+func (q *ResultQueue) Buffered() int {
+    return len(q.in) + len(q.out)
+}
+
+// Overflow returns the number of entries waiting in the overflow
+func (q *ResultQueue) Overflow() int {
+    return int(q.overflowLen.Load())
+}
+
+// Stats returns the instrumentation once the queue has delivered every
+// entry
+func (q *ResultQueue) Stats() *BackpressureStats {
+    <-q.done
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    stats := q.stats
+    return &stats
+}
+
+// relay moves the entries from in to out, through the overflow when out
+// is full
+func (q *ResultQueue) relay() {
+    defer close(q.done)
+    defer close(q.out)
+
+    ticker := time.NewTicker(time.Second)
+    defer ticker.Stop()
+
+    var overflow []LogEntry
+    var stallStart, lagSince, lastWarning time.Time
+    in := q.in
+    for in != nil || len(overflow) > 0 {
+        var out chan<- LogEntry
+        var next LogEntry
+        if len(overflow) > 0 {
+            out, next = q.out, overflow[0]
+        }
+        receive := in
+        if len(overflow) >= q.max {
+            // A full overflow pushes back on the workers
+            receive = nil
+            if stallStart.IsZero() {
+                stallStart = time.Now()
+                q.mu.Lock()
+                q.stats.Stalls++
+                first := q.stats.Stalls == 1
+                q.mu.Unlock()
+                if first {
+                    log.Printf("Warning: result overflow full (%d entries), queries wait for the result processors", q.max)
+                }
+            }
+        } else if !stallStart.IsZero() {
+            q.mu.Lock()
+            q.stats.StallMs += float64(time.Since(stallStart).Microseconds()) / 1000
+            q.mu.Unlock()
+            stallStart = time.Time{}
+        }
+
+        select {
+        case entry, ok := <-receive:
+            if !ok {
+                in = nil
+                continue
+            }
+            if len(overflow) == 0 {
+                select {
+                case q.out <- entry:
+                    continue
+                default:
+                }
+                lagSince = time.Now()
+            }
+            overflow = append(overflow, entry)
+            q.overflowLen.Store(int64(len(overflow)))
+            q.mu.Lock()
+            q.stats.Overflowed++
+            q.stats.OverflowHighWater = max(q.stats.OverflowHighWater, len(overflow))
+            q.mu.Unlock()
+        case out <- next:
+            overflow[0] = LogEntry{}
+            overflow = overflow[1:]
+            if len(overflow) == 0 {
+                // Release the backing array of a drained overflow
+                overflow = nil
+            }
+            q.overflowLen.Store(int64(len(overflow)))
+        case now := <-ticker.C:
+            if len(overflow) > 0 && now.Sub(lagSince) >= resultLagWarning && now.Sub(lastWarning) >= resultLagWarning {
+                lastWarning = now
+                q.mu.Lock()
+                q.stats.LagWarnings++
+                q.mu.Unlock()
+                log.Printf("Warning: result processing lags the queries by %d queued entries for %v; consider more -processors or fewer -workers",
+                    len(overflow), now.Sub(lagSince).Round(time.Second))
+            }
+        }
+    }
+    if !stallStart.IsZero() {
+        q.mu.Lock()
+        q.stats.StallMs += float64(time.Since(stallStart).Microseconds()) / 1000
+        q.mu.Unlock()
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+)
+
+func TestResultQueue(t *testing.T) {
+    queue := NewResultQueue(2, 5)
+    // Nobody reads yet: two entries fill the output buffer, up to two wait
+    // in the input buffer and five in the overflow
+    for i := 0; i < 9; i++ {
+        queue.In() <- LogEntry{Username: fmt.Sprintf("user%d", i)}
+    }
+    queue.Close()
+
+    var received []string
+    for entry := range queue.Out() {
+        received = append(received, entry.Username)
+    }
+    if len(received) != 9 {
+        t.Fatalf("received %d entries", len(received))
+    }
+    for i, username := range received {
+        if username != fmt.Sprintf("user%d", i) {
+            t.Fatalf("entry %d is %s, order lost", i, username)
+        }
+    }
+
+    stats := queue.Stats()
+    if stats.Overflowed == 0 || stats.OverflowHighWater > 5 || stats.OverflowMax != 5 {
+        t.Errorf("stats: %+v", stats)
+    }
+    if queue.Overflow() != 0 || queue.Buffered() != 0 {
+        t.Errorf("queue not drained: %d overflow, %d buffered", queue.Overflow(), queue.Buffered())
+    }
+}
+
+func TestResultQueueStall(t *testing.T) {
+    queue := NewResultQueue(1, 0)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for i := 0; i < 20; i++ {
+            queue.In() <- LogEntry{Hits: 1}
+        }
+        queue.Close()
+    }()
+
+    var hits int64
+    for entry := range queue.Out() {
+        hits += entry.Hits
+    }
+    <-done
+    if stats := queue.Stats(); hits != 20 || stats.OverflowMax != 1 || stats.OverflowHighWater > 1 {
+        t.Errorf("%d hits, stats %+v", hits, stats)
+    }
+}
@@ -0,0 +1,60 @@
+package main
+
+// RoamingScopeStats are the totals of one roaming scope
+type RoamingScopeStats struct {
+    Users     int   `json:"users"`
+    Providers int   `json:"providers"`
+    Hits      int64 `json:"hits"`
+}
+
+// RoamingSplit divides the roaming of a result into national (domestic)
+// and international, seen from HomeCountry
+type RoamingSplit struct {
+    HomeCountry   string            `json:"home_country"`
+    National      RoamingScopeStats `json:"national"`
+    International RoamingScopeStats `json:"international"`
+    // BothUsers roamed both nationally and internationally
+    BothUsers int `json:"users_in_both"`
+}
+
+// ComputeRoamingSplit splits users, providers and hits of result by the
+// roaming scope of each provider. It returns nil for a result without
+// providers or for the service provider pivot, where providers are realms.
+func ComputeRoamingSplit(result *Result, homeCountry string, countries CountryMap) *RoamingSplit {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Providers) == 0 || result.Pivot == PivotSP {
+        return nil
+    }
+
+    split := &RoamingSplit{HomeCountry: homeCountry}
+    for provider, stats := range result.Providers {
+        scope := &split.International
+        if roamingScope(provider, homeCountry, countries) == ScopeNational {
+            scope = &split.National
+        }
+        scope.Providers++
+        scope.Hits += stats.Hits
+    }
+    for _, stats := range result.Users {
+        national, international := false, false
+        for provider := range stats.Providers.All() {
+            if roamingScope(provider, homeCountry, countries) == ScopeNational {
+                national = true
+            } else {
+                international = true
+            }
+        }
+        if national {
+            split.National.Users++
+        }
+        if international {
+            split.International.Users++
+        }
+        if national && international {
+            split.BothUsers++
+        }
+    }
+    return split
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "html/template"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Rollup periods
+const (
+    PeriodMonthly   = "monthly"
+    PeriodQuarterly = "quarterly"
+    PeriodYearly    = "yearly"
+
+    // DefaultRollupLast is the number of periods in a rollup
+    DefaultRollupLast = 12
+)
+
+// RollupPeriod is one period of a rollup, from Start up to but excluding End
+type RollupPeriod struct {
+    Label string
+    Start time.Time
+    End   time.Time
+}
+
+// RollupRow holds the totals of one period
+type RollupRow struct {
+    Period     string
+    StoredDays int
+    TotalDays  int
+    Users      int
+    // NewUsers were not seen in any earlier period of the rollup
+    NewUsers           int
+    Providers          int
+    Hits               int64
+    NationalUsers      int
+    InternationalUsers int
+}
+
+// RollupPeriods returns the last complete periods before now, oldest first
+func RollupPeriods(period string, last int, now time.Time) ([]RollupPeriod, error) {
+    if last < 1 {
+        return nil, fmt.Errorf("invalid number of periods %d", last)
+    }
+    var months int
+    switch period {
+    case PeriodMonthly:
+        months = 1
+    case PeriodQuarterly:
+        months = 3
+    case PeriodYearly:
+        months = 12
+    default:
+        return nil, fmt.Errorf("invalid period %q (available: %s, %s, %s)", period, PeriodMonthly, PeriodQuarterly, PeriodYearly)
+    }
+
+    // Start of the current, incomplete period
+    month := (int(now.Month())-1)/months*months + 1
+    end := time.Date(now.Year(), time.Month(month), 1, 0, 0, 0, 0, now.Location())
+
+    periods := make([]RollupPeriod, last)
+    for i := last - 1; i >= 0; i-- {
+        start := end.AddDate(0, -months, 0)
+        p := RollupPeriod{Start: start, End: end}
+        switch period {
+        case PeriodMonthly:
+            p.Label = start.Format("2006-01")
+        case PeriodQuarterly:
+            p.Label = fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+        case PeriodYearly:
+            p.Label = strconv.Itoa(start.Year())
+        }
+        periods[i] = p
+        end = start
+    }
+    return periods, nil
+}
+
+// BuildRollup aggregates the stored days of a domain into periods. Users and
+// providers are distinct within each period; the roaming scope of providers
+// is seen from homeCountry.
+func BuildRollup(store *Store, domain string, periods []RollupPeriod, homeCountry string) ([]RollupRow, error) {
+    seen := make(map[string]bool)
+    rows := make([]RollupRow, 0, len(periods))
+    stored := 0
+    for _, period := range periods {
+        timeRange := TimeRange{
+            StartDate: period.Start,
+            EndDate:   period.End,
+            Days:      int(period.End.Sub(period.Start).Hours()/24 + 0.5),
+        }
+        records, err := store.Load(domain, period.Start, period.End.AddDate(0, 0, -1))
+        if err != nil && !errors.Is(err, ErrEmptyStore) {
+            return nil, err
+        }
+        stored += len(records)
+        result, err := BuildResultFromDays(records, timeRange)
+        if err != nil {
+            return nil, err
+        }
+
+        row := RollupRow{
+            Period:     period.Label,
+            StoredDays: len(records),
+            TotalDays:  timeRange.Days,
+            Users:      len(result.Users),
+            Providers:  len(result.Providers),
+            Hits:       result.TotalHits,
+        }
+        for username := range result.Users {
+            if !seen[username] {
+                seen[username] = true
+                row.NewUsers++
+            }
+        }
+        if split := ComputeRoamingSplit(result, homeCountry, nil); split != nil {
+            row.NationalUsers = split.National.Users
+            row.InternationalUsers = split.International.Users
+        }
+        rows = append(rows, row)
+    }
+    if stored == 0 {
+        return nil, ErrEmptyStore
+    }
+    return rows, nil
+}
+
+// ExportRollupCSV writes the rollup table
+func ExportRollupCSV(rows []RollupRow, filename string) error {
+    records := [][]string{{"Period", "Stored Days", "Total Days", "Users", "New Users", "Providers", "Hits", "National Users", "International Users"}}
+    for _, row := range rows {
+        records = append(records, []string{
+            row.Period,
+            strconv.Itoa(row.StoredDays),
+            strconv.Itoa(row.TotalDays),
+            strconv.Itoa(row.Users),
+            strconv.Itoa(row.NewUsers),
+            strconv.Itoa(row.Providers),
+            strconv.FormatInt(row.Hits, 10),
+            strconv.Itoa(row.NationalUsers),
+            strconv.Itoa(row.InternationalUsers),
+        })
+    }
+    return writeCSVFile(filename, records)
+}
+
+// rollupChart is a bar chart of one metric in the HTML rollup
+type rollupChart struct {
+    Title string
+    Bars  []rollupBar
+}
+
+// rollupBar is one bar of a rollupChart, positioned in a 600x200 viewBox
+type rollupBar struct {
+    Label  string
+    Value  string
+    X      float64
+    Y      float64
+    Width  float64
+    Height float64
+    Center float64
+}
+
+// newRollupChart scales values into bars
+func newRollupChart(title string, rows []RollupRow, value func(RollupRow) int64) rollupChart {
+    chart := rollupChart{Title: title}
+    var maxValue int64
+    for _, row := range rows {
+        maxValue = max(maxValue, value(row))
+    }
+    slot := 600.0 / float64(len(rows))
+    for i, row := range rows {
+        height := 0.0
+        if maxValue > 0 {
+            height = 170 * float64(value(row)) / float64(maxValue)
+        }
+        chart.Bars = append(chart.Bars, rollupBar{
+            Label:  row.Period,
+            Value:  strconv.FormatInt(value(row), 10),
+            X:      float64(i)*slot + slot*0.1,
+            Y:      180 - height,
+            Width:  slot * 0.8,
+            Height: height,
+            Center: float64(i)*slot + slot/2,
+        })
+    }
+    return chart
+}
+
+var rollupTemplate = template.Must(template.New("rollup").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Domain}} {{.Period}} rollup</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+svg { width: 100%; max-width: 800px; }
+rect { fill: #3b6ea5; }
+text { font-size: 9px; text-anchor: middle; }
+</style>
+</head>
+<body>
+<h1>{{.Domain}}: {{.Period}} trend</h1>
+<p>Generated {{.Generated}} by eduroam-idp {{.Version}} from the local store.</p>
+<table>
+<tr><th>Period</th><th>Stored days</th><th>Users</th><th>New users</th><th>Providers</th><th>Hits</th><th>National users</th><th>International users</th></tr>
+{{range .Rows}}<tr><td>{{.Period}}</td><td>{{.StoredDays}}/{{.TotalDays}}</td><td>{{.Users}}</td><td>{{.NewUsers}}</td><td>{{.Providers}}</td><td>{{.Hits}}</td><td>{{.NationalUsers}}</td><td>{{.InternationalUsers}}</td></tr>
+{{end}}</table>
+{{range .Charts}}<h2>{{.Title}}</h2>
+<svg viewBox="0 0 600 200">
+{{range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}"><title>{{.Label}}: {{.Value}}</title></rect>
+<text x="{{.Center}}" y="195">{{.Label}}</text>
+{{end}}</svg>
+{{end}}</body>
+</html>
+`))
+
+// ExportRollupHTML writes the rollup table with a bar chart per metric as a
+// self-contained HTML page
+func ExportRollupHTML(rows []RollupRow, domain, period, filename string) error {
+    file, err := os.Create(filename)
+    if err != nil {
+        return fmt.Errorf("error creating rollup HTML file: %w", err)
+    }
+    defer file.Close()
+
+    return rollupTemplate.Execute(file, map[string]interface{}{
+        "Domain":    RealmToUnicode(domain),
+        "Period":    period,
+        "Generated": time.Now().Format(DateTimeFormat),
+        "Version":   Version,
+        "Rows":      rows,
+        "Charts": []rollupChart{
+            newRollupChart("Users", rows, func(r RollupRow) int64 { return int64(r.Users) }),
+            newRollupChart("New users", rows, func(r RollupRow) int64 { return int64(r.NewUsers) }),
+            newRollupChart("Providers", rows, func(r RollupRow) int64 { return int64(r.Providers) }),
+            newRollupChart("Hits", rows, func(r RollupRow) int64 { return r.Hits }),
+        },
+    })
+}
+
+// runRollup implements the "rollup" subcommand, which builds a trend report
+// over several periods from the local store without contacting Quickwit
+func runRollup(args []string) int {
+    flags := flag.NewFlagSet("rollup", flag.ExitOnError)
+    period := flags.String("period", PeriodMonthly, "Period length: monthly, quarterly or yearly")
+    last := flags.Int("last", DefaultRollupLast, "Number of complete periods up to the current one")
+    storeDir := flags.String("store-dir", "", "Directory of the local store (default: the user data dir)")
+    outputDir := flags.String("output-dir", "", "Base directory for output files")
+    homeCountry := flags.String("home-country", "", "Country code separating national from international roaming (default: the realm's top-level domain)")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp rollup [flags] <domain>")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        flags.Usage()
+        return 1
+    }
+    domain := flags.Arg(0)
+
+    periods, err := RollupPeriods(*period, *last, time.Now())
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+    country := strings.ToUpper(*homeCountry)
+    if country == "" {
+        country = ProviderCountry(domain)
+    }
+
+    store, err := OpenStore(ResolveStoreDir(*storeDir))
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    rows, err := BuildRollup(store, domain, periods, country)
+    if err != nil {
+        log.Printf("Error: %v for %s between %s and %s", err, domain,
+            periods[0].Start.Format(DateFormat), periods[len(periods)-1].End.AddDate(0, 0, -1).Format(DateFormat))
+        return 1
+    }
+
+    dir, err := PrepareOutputDir(ResolveOutputDir(*outputDir), domain)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    base := filepath.Join(dir, fmt.Sprintf("rollup-%s-%s-%s", *period, periods[0].Label, periods[len(periods)-1].Label))
+    if err := ExportRollupCSV(rows, base+".csv"); err != nil {
+        log.Printf("Error saving output: %v", err)
+        return 1
+    }
+    if err := ExportRollupHTML(rows, domain, *period, base+".html"); err != nil {
+        log.Printf("Error saving output: %v", err)
+        return 1
+    }
+
+    fmt.Printf("%-10s %8s %10s %10s %10s %12s\n", "Period", "Days", "Users", "New", "Providers", "Hits")
+    for _, row := range rows {
+        fmt.Printf("%-10s %8s %10d %10d %10d %12d\n", row.Period,
+            fmt.Sprintf("%d/%d", row.StoredDays, row.TotalDays), row.Users, row.NewUsers, row.Providers, row.Hits)
+    }
+    fmt.Printf("Results have been saved to:\n  - %s.csv\n  - %s.html\n", base, base)
+    return 0
+}
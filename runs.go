@@ -0,0 +1,257 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+const (
+    // RunHistoryFile is the run history kept in the store directory
+    RunHistoryFile = "runs-history.jsonl"
+
+    // DefaultRunsListLimit is the number of runs listed by "runs list"
+    DefaultRunsListLimit = 20
+)
+
+// ErrUnknownRun indicates a run ID that is not in the history
+var ErrUnknownRun = errors.New("unknown run")
+
+// RunRecord is the summary of one analysis run in the run history
+type RunRecord struct {
+    // ID is the run ID also given to the days of the run in the store,
+    // with a "-N" suffix when several runs started in the same second
+    ID           string   `json:"id"`
+    Domain       string   `json:"domain"`
+    Range        string   `json:"range"`
+    StartDate    string   `json:"start_date"`
+    EndDate      string   `json:"end_date"`
+    Days         int      `json:"days"`
+    Started      string   `json:"started"`
+    DurationMs   int64    `json:"duration_ms"`
+    TotalHits    int64    `json:"total_hits"`
+    Users        int      `json:"users"`
+    Providers    int      `json:"providers"`
+    Partial      bool     `json:"partial,omitempty"`
+    Environment  string   `json:"environment,omitempty"`
+    // PeakRSSBytes is the peak resident set size of the run, if known
+    PeakRSSBytes int64    `json:"peak_rss_bytes,omitempty"`
+    Version      string   `json:"version"`
+    Files        []string `json:"files,omitempty"`
+}
+
+// Duration returns the run time of the run
+func (r RunRecord) Duration() time.Duration {
+    return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// RunHistory is the JSON Lines file of run records in the store directory
+type RunHistory struct {
+    path string
+}
+
+// OpenRunHistory opens the run history of the store at dir, creating the
+// directory if needed
+func OpenRunHistory(dir string) (*RunHistory, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("error creating store directory: %w", err)
+    }
+    return &RunHistory{path: filepath.Join(dir, RunHistoryFile)}, nil
+}
+
+// Append adds a run to the end of the history and returns its ID, made
+// unique if needed
+func (h *RunHistory) Append(record RunRecord) (string, error) {
+    runs, err := h.Runs("")
+    if err != nil {
+        return "", err
+    }
+    ids := make(map[string]bool, len(runs))
+    for _, run := range runs {
+        ids[run.ID] = true
+    }
+    for id, n := record.ID, 2; ids[record.ID]; n++ {
+        record.ID = fmt.Sprintf("%s-%d", id, n)
+    }
+
+    line, err := json.Marshal(record)
+    if err != nil {
+        return "", fmt.Errorf("error encoding run record: %w", err)
+    }
+    file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return "", fmt.Errorf("error opening run history: %w", err)
+    }
+    defer file.Close()
+    if _, err := file.Write(append(line, '\n')); err != nil {
+        return "", fmt.Errorf("error writing run history: %w", err)
+    }
+    return record.ID, nil
+}
+
+// Runs returns the runs of domain, or of every domain if empty, oldest first
+func (h *RunHistory) Runs(domain string) ([]RunRecord, error) {
+    file, err := os.Open(h.path)
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("error opening run history: %w", err)
+    }
+    defer file.Close()
+
+    var runs []RunRecord
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        var record RunRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return nil, fmt.Errorf("error decoding run record: %w", err)
+        }
+        if domain == "" || record.Domain == domain {
+            runs = append(runs, record)
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("error reading run history: %w", err)
+    }
+    return runs, nil
+}
+
+// NewRunRecord summarises a finished run started at started
+func NewRunRecord(runID, rangeParam string, result *Result, meta ExportMeta, started time.Time, files []string) RunRecord {
+    record := RunRecord{
+        ID:          runID,
+        Domain:      meta.Domain,
+        Range:       rangeParam,
+        StartDate:   meta.TimeRange.StartDate.Format(DateTimeFormat),
+        EndDate:     meta.TimeRange.EndDate.Format(DateTimeFormat),
+        Days:        meta.TimeRange.Days,
+        Started:     started.Format(time.RFC3339),
+        DurationMs:  time.Since(started).Milliseconds(),
+        TotalHits:   result.TotalHits,
+        Users:       len(result.Users),
+        Providers:   len(result.Providers),
+        Partial:     result.Partial,
+        Environment: meta.Environment,
+        Version:     Version,
+    }
+    if result.Resources != nil {
+        record.PeakRSSBytes = result.Resources.PeakRSSBytes
+    }
+    for _, file := range files {
+        if abs, err := filepath.Abs(file); err == nil {
+            file = abs
+        }
+        record.Files = append(record.Files, file)
+    }
+    return record
+}
+
+// PrintRunRecord writes the summary of a run
+func PrintRunRecord(record RunRecord) {
+    status := "complete"
+    if record.Partial {
+        status = "partial"
+    }
+    fmt.Printf("Run %s: %s, %s\n", record.ID, record.Domain, status)
+    rangeParam := record.Range
+    if rangeParam == "" {
+        rangeParam = "default"
+    }
+    fmt.Printf("  Range: %s, %s to %s (%d days)\n", rangeParam, record.StartDate, record.EndDate, record.Days)
+    fmt.Printf("  Started: %s, took %v\n", record.Started, record.Duration())
+    if record.Environment != "" {
+        fmt.Printf("  Environment: %s\n", record.Environment)
+    }
+    fmt.Printf("  Version: %s\n", record.Version)
+    fmt.Printf("  Number of users: %d\n", record.Users)
+    fmt.Printf("  Number of providers: %d\n", record.Providers)
+    fmt.Printf("  Total hits: %d\n", record.TotalHits)
+    if record.PeakRSSBytes > 0 {
+        fmt.Printf("  Peak RSS: %s\n", FormatBytes(record.PeakRSSBytes))
+    }
+    if len(record.Files) > 0 {
+        fmt.Printf("  Output files:\n")
+    }
+    for _, file := range record.Files {
+        if _, err := os.Stat(file); err != nil {
+            fmt.Printf("    - %s (missing)\n", file)
+        } else {
+            fmt.Printf("    - %s\n", file)
+        }
+    }
+}
+
+// runRuns implements the "runs" subcommand
+func runRuns(args []string) int {
+    flags := flag.NewFlagSet("runs", flag.ExitOnError)
+    storeDir := flags.String("store-dir", "", "Directory of the local store holding the run history (default: the user data dir)")
+    domain := flags.String("domain", "", "Only list the runs of this domain")
+    limit := flags.Int("n", DefaultRunsListLimit, "Number of most recent runs listed (0 lists all)")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp runs [flags] list")
+        fmt.Fprintln(flags.Output(), "       ./eduroam-idp runs [flags] show <id>")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    action := flags.Arg(0)
+    if !(action == "list" && flags.NArg() == 1) && !(action == "show" && flags.NArg() == 2) {
+        flags.Usage()
+        return 1
+    }
+
+    history, err := OpenRunHistory(ResolveStoreDir(*storeDir))
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    runs, err := history.Runs(*domain)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    if action == "show" {
+        for _, record := range runs {
+            if record.ID == flags.Arg(1) {
+                PrintRunRecord(record)
+                return 0
+            }
+        }
+        log.Printf("Error: %v %q", ErrUnknownRun, flags.Arg(1))
+        return 1
+    }
+
+    if len(runs) == 0 {
+        fmt.Println("No runs recorded")
+        return 0
+    }
+    if *limit > 0 && len(runs) > *limit {
+        runs = runs[len(runs)-*limit:]
+    }
+    fmt.Printf("%-17s  %-24s %-10s %10s %9s %12s %8s %10s  %s\n",
+        "ID", "Domain", "Range", "Start", "Days", "Hits", "Users", "Duration", "Files")
+    partial := false
+    for _, record := range runs {
+        rangeParam := record.Range
+        if record.Partial {
+            rangeParam += "*"
+            partial = true
+        }
+        fmt.Printf("%-17s  %-24s %-10s %10s %9d %12d %8d %10v  %d\n",
+            record.ID, record.Domain, rangeParam, record.StartDate[:min(10, len(record.StartDate))], record.Days,
+            record.TotalHits, record.Users, record.Duration().Round(time.Second/10), len(record.Files))
+    }
+    if partial {
+        fmt.Println("* partial run")
+    }
+    return 0
+}
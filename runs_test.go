@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestRunHistory(t *testing.T) {
+    history, err := OpenRunHistory(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if runs, err := history.Runs(""); err != nil || len(runs) != 0 {
+        t.Fatalf("empty history: %v, %v", runs, err)
+    }
+
+    for _, domain := range []string{"uni", "chula.ac.th", "uni"} {
+        if _, err := history.Append(RunRecord{ID: "20240315-143000", Domain: domain}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    runs, err := history.Runs("")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var ids []string
+    for _, run := range runs {
+        ids = append(ids, run.ID)
+    }
+    if len(ids) != 3 || ids[0] != "20240315-143000" || ids[1] != "20240315-143000-2" || ids[2] != "20240315-143000-3" {
+        t.Errorf("ids = %v", ids)
+    }
+
+    if runs, _ := history.Runs("uni"); len(runs) != 2 || runs[1].ID != "20240315-143000-3" {
+        t.Errorf("runs of uni = %+v", runs)
+    }
+}
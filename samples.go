@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// SampleHit is one raw event of a provider sample
+type SampleHit struct {
+    Timestamp string `json:"timestamp"`
+    Username  string `json:"username"`
+    // Fields holds the other fields of the raw document, such as station
+    // ids, as indexed
+    Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ProviderSample holds the most recent raw events of one provider
+type ProviderSample struct {
+    Provider string      `json:"provider"`
+    Hits     []SampleHit `json:"hits"`
+}
+
+// sampleCoreFields are reported outside SampleHit.Fields
+var sampleCoreFields = map[string]bool{"timestamp": true, "username": true}
+
+// FetchProviderSamples queries the most recent n raw events of every
+// provider of the result, one query per provider, so that disputed numbers
+// can be checked against concrete records. Under -pivot sp the samples are
+// per visiting realm.
+func FetchProviderSamples(ctx context.Context, client *HTTPClient, query map[string]interface{}, result *Result, n int) ([]ProviderSample, error) {
+    result.mu.RLock()
+    providers := make([]string, 0, len(result.Providers))
+    for provider := range result.Providers {
+        providers = append(providers, provider)
+    }
+    field := pivotField(result.Pivot)
+    result.mu.RUnlock()
+    sort.Strings(providers)
+
+    escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+    samples := make([]ProviderSample, 0, len(providers))
+    for _, provider := range providers {
+        response, err := client.SendQuickwitRequest(ctx, map[string]interface{}{
+            "query":           fmt.Sprintf(`%v AND %s:"%s"`, query["query"], field, escape.Replace(provider)),
+            "start_timestamp": query["start_timestamp"],
+            "end_timestamp":   query["end_timestamp"],
+            "max_hits":        n,
+            "sort_by":         "-timestamp",
+        })
+        if err != nil {
+            return samples, fmt.Errorf("error sampling %s: %w", provider, err)
+        }
+        sample := ProviderSample{Provider: provider, Hits: []SampleHit{}}
+        for _, hit := range response.Hits {
+            entry := SampleHit{Username: fmt.Sprint(hit["username"])}
+            if timestamp, ok := parseLogTimestamp(fmt.Sprint(hit["timestamp"])); ok {
+                entry.Timestamp = timestamp.Format(DateTimeFormat)
+            }
+            for name, value := range hit {
+                if !sampleCoreFields[name] {
+                    if entry.Fields == nil {
+                        entry.Fields = make(map[string]interface{})
+                    }
+                    entry.Fields[name] = value
+                }
+            }
+            sample.Hits = append(sample.Hits, entry)
+        }
+        samples = append(samples, sample)
+    }
+    return samples, nil
+}
+
+// ExportSamplesCSV writes the sampled events with their other fields as a
+// JSON object. It returns an empty filename when no samples were taken.
+func ExportSamplesCSV(result *Result, meta ExportMeta) (string, error) {
+    if result.Samples == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Provider", "Timestamp", "Username", "Fields"}}
+    for _, sample := range result.Samples {
+        for _, hit := range sample.Hits {
+            fields, err := json.Marshal(hit.Fields)
+            if err != nil {
+                return "", fmt.Errorf("error marshaling sample fields: %w", err)
+            }
+            records = append(records, []string{sample.Provider, hit.Timestamp, hit.Username, string(fields)})
+        }
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-samples.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strconv"
+    "time"
+)
+
+// SdNotify sends a state string (e.g. "READY=1") to the service manager
+// through $NOTIFY_SOCKET. It does nothing when not run under systemd with
+// Type=notify.
+func SdNotify(state string) error {
+    socket := os.Getenv("NOTIFY_SOCKET")
+    if socket == "" {
+        return nil
+    }
+    // Abstract namespace sockets are given with a leading "@"
+    if socket[0] == '@' {
+        socket = "\x00" + socket[1:]
+    }
+
+    conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+    if err != nil {
+        return fmt.Errorf("error connecting to notify socket: %w", err)
+    }
+    defer conn.Close()
+
+    if _, err := conn.Write([]byte(state)); err != nil {
+        return fmt.Errorf("error writing to notify socket: %w", err)
+    }
+    return nil
+}
+
+// WatchdogInterval returns how often the service manager expects watchdog
+// pings (half of $WATCHDOG_USEC), or 0 when the watchdog is not enabled for
+// this process
+func WatchdogInterval() time.Duration {
+    usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+    if err != nil || usec <= 0 {
+        return 0
+    }
+    if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+        return 0
+    }
+    return time.Duration(usec) * time.Microsecond / 2
+}
+
+// StartWatchdog pings the service manager's watchdog until stop is closed.
+// alive is consulted before every ping so a stuck process stops pinging.
+func StartWatchdog(stop <-chan struct{}, alive func() bool) {
+    interval := WatchdogInterval()
+    if interval == 0 {
+        return
+    }
+    go func() {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-ticker.C:
+                if !alive() {
+                    continue
+                }
+                if err := SdNotify("WATCHDOG=1"); err != nil {
+                    log.Printf("Watchdog: %v", err)
+                }
+            }
+        }
+    }()
+}
+
+// WatchReloadSignal calls reload whenever the reload signal (SIGHUP where
+// supported) is received
+func WatchReloadSignal(reload func()) {
+    signals := make(chan os.Signal, 1)
+    if !notifyReloadSignal(signals) {
+        return
+    }
+    go func() {
+        for range signals {
+            reload()
+        }
+    }()
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "math"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    // DefaultTravelSpeed is the travel speed in km/h above which moving
+    // between two providers is considered impossible
+    DefaultTravelSpeed = 1000.0
+
+    // DefaultTravelDistance is the minimum distance in km between two
+    // providers for an impossible-travel check; nearby campuses are ignored
+    DefaultTravelDistance = 300.0
+
+    // travelResolution is the bucket size of the sub-day timestamps
+    travelResolution = 15 * time.Minute
+
+    // travelUsersPerQuery bounds the usernames queried at once
+    travelUsersPerQuery = 100
+
+    // earthRadiusKm is the mean radius of the earth
+    earthRadiusKm = 6371.0
+)
+
+// TravelIncident is a user seen at two distant providers within a time no
+// traveller could cover, a sign of shared credentials
+type TravelIncident struct {
+    Username   string  `json:"username"`
+    Date       string  `json:"date"`
+    From       string  `json:"from"`
+    To         string  `json:"to"`
+    FromTime   string  `json:"from_time"`
+    ToTime     string  `json:"to_time"`
+    DistanceKm float64 `json:"distance_km"`
+    // SpeedKmh is the lowest speed that explains both authentications
+    SpeedKmh float64 `json:"speed_kmh"`
+}
+
+// SecurityReport holds the credential-sharing signals of a result
+type SecurityReport struct {
+    MaxSpeedKmh      float64          `json:"max_speed_kmh"`
+    MinDistanceKm    float64          `json:"min_distance_km"`
+    ImpossibleTravel []TravelIncident `json:"impossible_travel"`
+}
+
+// haversineKm returns the great-circle distance between two locations
+func haversineKm(a, b ProviderLocation) float64 {
+    lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+    dLat := lat2 - lat1
+    dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+    h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// travelCandidates returns, per date, the users who visited two located
+// providers at least minDistance apart on that day
+func travelCandidates(result *Result, locations map[string]ProviderLocation, minDistance float64) map[string][]string {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    candidates := make(map[string][]string)
+    for date, day := range result.Days {
+        for username, providers := range day.Visits.ByUser() {
+            var located []ProviderLocation
+            for _, provider := range providers {
+                if location, ok := locations[provider]; ok {
+                    located = append(located, location)
+                }
+            }
+            if farApart(located, minDistance) {
+                candidates[date] = append(candidates[date], username)
+            }
+        }
+    }
+    for date := range candidates {
+        sort.Strings(candidates[date])
+    }
+    return candidates
+}
+
+// farApart reports whether any two locations are at least minDistance apart
+func farApart(locations []ProviderLocation, minDistance float64) bool {
+    for i := range locations {
+        for j := i + 1; j < len(locations); j++ {
+            if haversineKm(locations[i], locations[j]) >= minDistance {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// usernameClause returns a query clause matching any of the usernames
+func usernameClause(usernames []string) string {
+    escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+    terms := make([]string, len(usernames))
+    for i, username := range usernames {
+        terms[i] = fmt.Sprintf(`username:"%s"`, escape.Replace(username))
+    }
+    return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// travelVisit is a user's presence at a provider in one time bucket
+type travelVisit struct {
+    provider string
+    at       time.Time
+}
+
+// CheckImpossibleTravel looks for users who authenticated at two providers
+// at least minDistance km apart faster than maxSpeed km/h allows. Only
+// users whose daily provider set makes this possible are re-queried, with
+// timestamps at travelResolution; the elapsed time is taken at its longest
+// within the buckets, so incidents are never overstated. The worst incident
+// per user and day is reported.
+func CheckImpossibleTravel(ctx context.Context, client *HTTPClient, query map[string]interface{}, result *Result, locations map[string]ProviderLocation, maxSpeed, minDistance float64) (*SecurityReport, error) {
+    report := &SecurityReport{MaxSpeedKmh: maxSpeed, MinDistanceKm: minDistance, ImpossibleTravel: []TravelIncident{}}
+    candidates := travelCandidates(result, locations, minDistance)
+
+    dates := make([]string, 0, len(candidates))
+    for date := range candidates {
+        dates = append(dates, date)
+    }
+    sort.Strings(dates)
+
+    location := result.StartDate.Location()
+    for _, date := range dates {
+        day, err := time.ParseInLocation(DateFormat, date, location)
+        if err != nil {
+            return nil, err
+        }
+        usernames := candidates[date]
+        for len(usernames) > 0 {
+            batch := usernames[:min(len(usernames), travelUsersPerQuery)]
+            usernames = usernames[len(batch):]
+
+            visits, err := fetchTravelVisits(ctx, client, query, batch, day)
+            if err != nil {
+                return nil, fmt.Errorf("error checking travel on %s: %w", date, err)
+            }
+            for _, username := range batch {
+                if incident, ok := worstTravel(visits[username], locations, maxSpeed, minDistance); ok {
+                    incident.Username = username
+                    incident.Date = date
+                    report.ImpossibleTravel = append(report.ImpossibleTravel, incident)
+                }
+            }
+        }
+    }
+    return report, nil
+}
+
+// travelUserBucket is a bucket of the users aggregation of
+// fetchTravelVisits with the times the user was seen at each provider
+type travelUserBucket struct {
+    Key       bucketKey `json:"key"`
+    Providers struct {
+        Buckets []struct {
+            Key   bucketKey `json:"key"`
+            Times struct {
+                Buckets []histogramBucket `json:"buckets"`
+            } `json:"times"`
+        } `json:"buckets"`
+    } `json:"providers"`
+}
+
+// fetchTravelVisits queries the provider visits of usernames on one day at
+// travelResolution
+func fetchTravelVisits(ctx context.Context, client *HTTPClient, query map[string]interface{}, usernames []string, day time.Time) (map[string][]travelVisit, error) {
+    travelQuery := map[string]interface{}{
+        "query":           fmt.Sprintf("%v AND %s", query["query"], usernameClause(usernames)),
+        "start_timestamp": day.Unix(),
+        "end_timestamp":   day.AddDate(0, 0, 1).Unix(),
+        "max_hits":        0,
+        "aggs": map[string]interface{}{
+            "users": map[string]interface{}{
+                "terms": map[string]interface{}{
+                    "field": "username",
+                    "size":  len(usernames),
+                },
+                "aggs": map[string]interface{}{
+                    "providers": map[string]interface{}{
+                        "terms": map[string]interface{}{
+                            "field": "service_provider",
+                            "size":  1000,
+                        },
+                        "aggs": map[string]interface{}{
+                            "times": map[string]interface{}{
+                                "date_histogram": map[string]interface{}{
+                                    "field":          "timestamp",
+                                    "fixed_interval": fmt.Sprintf("%ds", int(travelResolution.Seconds())),
+                                    "min_doc_count":  1,
+                                },
+                            },
+                        },
+                    },
+                },
+            },
+        },
+    }
+    result, err := client.SendQuickwitRequest(ctx, travelQuery)
+    if err != nil {
+        return nil, err
+    }
+    data, err := responseAggregation(result, "users")
+    if err != nil {
+        return nil, err
+    }
+    buckets, err := decodeBuckets[travelUserBucket]("users", data)
+    if err != nil {
+        return nil, err
+    }
+
+    visits := make(map[string][]travelVisit)
+    for _, userBucket := range buckets {
+        username := string(userBucket.Key)
+        for _, providerBucket := range userBucket.Providers.Buckets {
+            for _, timeBucket := range providerBucket.Times.Buckets {
+                if timeBucket.DocCount == 0 {
+                    continue
+                }
+                key, err := timeBucket.Key.float()
+                if err != nil {
+                    return nil, err
+                }
+                visits[username] = append(visits[username], travelVisit{provider: string(providerBucket.Key), at: time.UnixMilli(int64(key))})
+            }
+        }
+    }
+    return visits, nil
+}
+
+// worstTravel returns the fastest required travel between consecutive
+// visits at distant located providers, if it exceeds maxSpeed
+func worstTravel(visits []travelVisit, locations map[string]ProviderLocation, maxSpeed, minDistance float64) (TravelIncident, bool) {
+    sort.Slice(visits, func(i, j int) bool {
+        if !visits[i].at.Equal(visits[j].at) {
+            return visits[i].at.Before(visits[j].at)
+        }
+        return visits[i].provider < visits[j].provider
+    })
+
+    var worst TravelIncident
+    found := false
+    for i := 1; i < len(visits); i++ {
+        from, to := visits[i-1], visits[i]
+        fromLocation, ok1 := locations[from.provider]
+        toLocation, ok2 := locations[to.provider]
+        if !ok1 || !ok2 || from.provider == to.provider {
+            continue
+        }
+        distance := haversineKm(fromLocation, toLocation)
+        if distance < minDistance {
+            continue
+        }
+        // The events may lie anywhere within their buckets
+        hours := (to.at.Sub(from.at) + travelResolution).Hours()
+        speed := distance / hours
+        if speed <= maxSpeed || (found && speed <= worst.SpeedKmh) {
+            continue
+        }
+        worst = TravelIncident{
+            From:       from.provider,
+            To:         to.provider,
+            FromTime:   from.at.Format(DateTimeFormat),
+            ToTime:     to.at.Format(DateTimeFormat),
+            DistanceKm: math.Round(distance),
+            SpeedKmh:   math.Round(speed),
+        }
+        found = true
+    }
+    return worst, found
+}
+
+// ExportSecurityCSV writes the impossible-travel incidents. It returns an
+// empty filename when the check was not run.
+func ExportSecurityCSV(result *Result, meta ExportMeta) (string, error) {
+    if result.Security == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Username", "Date", "From", "From Time", "To", "To Time", "Distance (km)", "Speed (km/h)"}}
+    for _, incident := range result.Security.ImpossibleTravel {
+        records = append(records, []string{
+            incident.Username,
+            incident.Date,
+            incident.From,
+            incident.FromTime,
+            incident.To,
+            incident.ToTime,
+            strconv.FormatFloat(incident.DistanceKm, 'f', 0, 64),
+            strconv.FormatFloat(incident.SpeedKmh, 'f', 0, 64),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-security.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "runtime"
+    "sync"
+)
+
+// DefaultProcessors is the number of result processors of a run: one per
+// CPU core available to the program
+var DefaultProcessors = runtime.GOMAXPROCS(0)
+
+// shardOf returns the processor of a username (FNV-1a hash modulo shards)
+func shardOf(username string, shards int) int {
+    hash := uint32(2166136261)
+    for i := 0; i < len(username); i++ {
+        hash ^= uint32(username[i])
+        hash *= 16777619
+    }
+    return int(hash % uint32(shards))
+}
+
+// ProcessResultsSharded is ProcessResults spread over several processors.
+// Entries are routed by username, so every processor aggregates a disjoint
+// set of users into its own Result; the shards are merged into result once
+// resultChan is closed and drained. With fewer than two processors it is
+// ProcessResults.
+func ProcessResultsSharded(ctx context.Context, resultChan <-chan LogEntry, result *Result, processors int) {
+    if processors < 2 {
+        ProcessResults(ctx, resultChan, result)
+        return
+    }
+
+    shards := make([]*Result, processors)
+    inputs := make([]chan LogEntry, processors)
+    var wg sync.WaitGroup
+    for i := range shards {
+        shards[i] = &Result{
+            Users:         make(map[string]*UserStats),
+            Providers:     make(map[string]*ProviderStats),
+            Exclusions:    result.Exclusions,
+            FoldRealmCase: result.FoldRealmCase,
+            DayVisits:     result.DayVisits,
+        }
+        if result.Days != nil {
+            shards[i].Days = make(map[string]*DayStats)
+        }
+        inputs[i] = make(chan LogEntry, ResultChanBuffer/processors+1)
+        wg.Add(1)
+        go func(shard *Result, input <-chan LogEntry) {
+            defer wg.Done()
+            ProcessResults(ctx, input, shard)
+        }(shards[i], inputs[i])
+    }
+
+    // As in ProcessResults, the channel is drained even after cancellation
+    for entry := range resultChan {
+        inputs[shardOf(entry.Username, processors)] <- entry
+    }
+    for _, input := range inputs {
+        close(input)
+    }
+    wg.Wait()
+
+    for _, shard := range shards {
+        mergeShard(result, shard)
+    }
+}
+
+// mergeShard adds the aggregates of a processor's shard to result. Shards
+// hold disjoint users, so users are moved as they are and only providers,
+// realms and days, which several shards share, are combined.
+func mergeShard(result, shard *Result) {
+    result.mu.Lock()
+    defer result.mu.Unlock()
+
+    for username, stats := range shard.Users {
+        result.Users[username] = stats
+    }
+
+    for name, stats := range shard.Providers {
+        provider := result.Providers[name]
+        if provider == nil {
+            result.Providers[name] = stats
+            continue
+        }
+        provider.Users.Merge(stats.Users)
+        if stats.FirstSeen.Before(provider.FirstSeen) {
+            provider.FirstSeen = stats.FirstSeen
+        }
+        if stats.LastSeen.After(provider.LastSeen) {
+            provider.LastSeen = stats.LastSeen
+        }
+        provider.Hits += stats.Hits
+    }
+
+    for name, stats := range shard.Realms {
+        if result.Realms == nil {
+            result.Realms = make(map[string]*RealmStats)
+        }
+        realm := result.Realms[name]
+        if realm == nil {
+            result.Realms[name] = stats
+            continue
+        }
+        realm.Users.Merge(stats.Users)
+        realm.Hits += stats.Hits
+    }
+
+    for date, stats := range shard.Days {
+        day := result.Days[date]
+        if day == nil {
+            result.Days[date] = stats
+            continue
+        }
+        day.Merge(stats)
+    }
+}
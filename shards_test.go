@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "testing"
+    "time"
+)
+
+// testEntries returns entries of users spread over providers, realms and
+// days, with a per-entry hit count as ProcessUserProviderDaily emits them
+func testEntries(users, perUser int) []LogEntry {
+    start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+    entries := make([]LogEntry, 0, users*perUser)
+    for u := 0; u < users; u++ {
+        for i := 0; i < perUser; i++ {
+            entries = append(entries, LogEntry{
+                Username:        fmt.Sprintf("user%d@uni.example", u),
+                ServiceProvider: fmt.Sprintf("sp%d.example.org", (u+i)%23),
+                Timestamp:       start.Add(time.Duration(u*7+i*13) % (10 * 24) * time.Hour),
+                Realm:           []string{"uni.example", "cs.uni.example"}[u%2],
+                Hits:            int64(i%3 + 1),
+            })
+        }
+    }
+    return entries
+}
+
+// aggregate runs the entries through ProcessResultsSharded
+func aggregate(entries []LogEntry, processors int) *Result {
+    result := &Result{
+        Users:     make(map[string]*UserStats),
+        Providers: make(map[string]*ProviderStats),
+        Days:      make(map[string]*DayStats),
+    }
+    resultChan := make(chan LogEntry, ResultChanBuffer)
+    go func() {
+        for _, entry := range entries {
+            resultChan <- entry
+        }
+        close(resultChan)
+    }()
+    ProcessResultsSharded(context.Background(), resultChan, result, processors)
+    return result
+}
+
+func TestProcessResultsSharded(t *testing.T) {
+    entries := testEntries(300, 8)
+    want := aggregate(entries, 1)
+    for _, processors := range []int{2, 3, 8} {
+        got := aggregate(entries, processors)
+
+        if len(got.Users) != len(want.Users) || len(got.Providers) != len(want.Providers) {
+            t.Fatalf("%d processors: %d users, %d providers, want %d and %d", processors,
+                len(got.Users), len(got.Providers), len(want.Users), len(want.Providers))
+        }
+        for username, stats := range want.Users {
+            other := got.Users[username]
+            if other == nil || other.Hits != stats.Hits || !other.FirstSeen.Equal(stats.FirstSeen) ||
+                !other.LastSeen.Equal(stats.LastSeen) || !slices.Equal(other.Providers.Sorted(), stats.Providers.Sorted()) {
+                t.Errorf("%d processors: user %s = %+v, want %+v", processors, username, other, stats)
+            }
+        }
+        for name, stats := range want.Providers {
+            other := got.Providers[name]
+            if other == nil || other.Hits != stats.Hits || !other.FirstSeen.Equal(stats.FirstSeen) ||
+                !other.LastSeen.Equal(stats.LastSeen) || !slices.Equal(other.Users.Sorted(), stats.Users.Sorted()) {
+                t.Errorf("%d processors: provider %s differs", processors, name)
+            }
+        }
+        for name, stats := range want.Realms {
+            other := got.Realms[name]
+            if other == nil || other.Hits != stats.Hits || other.Users.Len() != stats.Users.Len() {
+                t.Errorf("%d processors: realm %s differs", processors, name)
+            }
+        }
+        if len(got.Days) != len(want.Days) {
+            t.Errorf("%d processors: %d days, want %d", processors, len(got.Days), len(want.Days))
+        }
+        for date, day := range want.Days {
+            other := got.Days[date]
+            if other == nil || other.Users.Len() != day.Users.Len() || other.Visits.Len() != day.Visits.Len() || len(other.ProviderHits) != len(day.ProviderHits) {
+                t.Errorf("%d processors: day %s differs", processors, date)
+                continue
+            }
+            for provider, hits := range day.ProviderHits {
+                if other.ProviderHits[provider] != hits {
+                    t.Errorf("%d processors: day %s provider %s has %d hits, want %d", processors, date, provider, other.ProviderHits[provider], hits)
+                }
+            }
+        }
+    }
+}
+
+func TestShardOf(t *testing.T) {
+    counts := make([]int, 4)
+    for u := 0; u < 4000; u++ {
+        username := fmt.Sprintf("user%d@uni.example", u)
+        shard := shardOf(username, 4)
+        if shard != shardOf(username, 4) {
+            t.Fatalf("shardOf(%s) is not stable", username)
+        }
+        counts[shard]++
+    }
+    for shard, n := range counts {
+        if n < 800 || n > 1200 {
+            t.Errorf("shard %d got %d of 4000 users", shard, n)
+        }
+    }
+}
+
+func BenchmarkProcessResults(b *testing.B) {
+    entries := testEntries(20000, 20)
+    for _, processors := range []int{1, 2, 4, 8} {
+        b.Run(fmt.Sprintf("processors=%d", processors), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                aggregate(entries, processors)
+            }
+        })
+    }
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+)
+
+// Sort orders for the user and provider lists
+const (
+    SortByCount     = "count"
+    SortByName      = "name"
+    SortByFirstSeen = "first_seen"
+
+    // DefaultUserSort and DefaultProviderSort are the orders used when no
+    // flag is given
+    DefaultUserSort     = SortByName
+    DefaultProviderSort = SortByCount
+)
+
+// ParseUserSort validates a -sort-users value
+func ParseUserSort(value string) (string, error) {
+    switch value {
+    case "":
+        return DefaultUserSort, nil
+    case SortByCount, SortByName, SortByFirstSeen:
+        return value, nil
+    }
+    return "", fmt.Errorf("invalid user sort order %q (available: %s, %s, %s)", value, SortByCount, SortByName, SortByFirstSeen)
+}
+
+// ParseProviderSort validates a -sort-providers value
+func ParseProviderSort(value string) (string, error) {
+    switch value {
+    case "":
+        return DefaultProviderSort, nil
+    case SortByCount, SortByName:
+        return value, nil
+    }
+    return "", fmt.Errorf("invalid provider sort order %q (available: %s, %s)", value, SortByCount, SortByName)
+}
+
+// SortedUsernames returns the usernames ordered by order: count sorts by
+// number of providers (descending), first_seen by first activity; ties and
+// the name order fall back to the username
+func SortedUsernames(users map[string]*UserStats, order string) []string {
+    names := make([]string, 0, len(users))
+    for name := range users {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        a, b := users[names[i]], users[names[j]]
+        switch order {
+        case SortByCount:
+            if a.Providers.Len() != b.Providers.Len() {
+                return a.Providers.Len() > b.Providers.Len()
+            }
+        case SortByFirstSeen:
+            if !a.FirstSeen.Equal(b.FirstSeen) {
+                return a.FirstSeen.Before(b.FirstSeen)
+            }
+        }
+        return names[i] < names[j]
+    })
+    return names
+}
+
+// SortedProviders returns the providers ordered by order: count sorts by
+// number of users (descending) with ties by name
+func SortedProviders(providers map[string]*ProviderStats, order string) []string {
+    names := make([]string, 0, len(providers))
+    for name := range providers {
+        names = append(names, name)
+    }
+    sort.Slice(names, func(i, j int) bool {
+        if order != SortByName {
+            a, b := providers[names[i]], providers[names[j]]
+            if a.Users.Len() != b.Users.Len() {
+                return a.Users.Len() > b.Users.Len()
+            }
+        }
+        return names[i] < names[j]
+    })
+    return names
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// starKeys assigns the surrogate keys 1..n to names in sorted order
+func starKeys[V any](names map[string]V) ([]string, map[string]int) {
+    sorted := make([]string, 0, len(names))
+    for name := range names {
+        sorted = append(sorted, name)
+    }
+    sort.Strings(sorted)
+    keys := make(map[string]int, len(sorted))
+    for i, name := range sorted {
+        keys[name] = i + 1
+    }
+    return sorted, keys
+}
+
+// dateKey returns the integer key of a day, e.g. 20240131
+func dateKey(day time.Time) string {
+    return day.Format("20060102")
+}
+
+// ExportStar writes the result as a star schema for BI tools: the fact
+// table fact_activity has one row per day, user and provider the user
+// authenticated at, joined by integer keys to dim_user, dim_provider and
+// dim_date. dim_date covers every day of the range, including days without
+// activity. Public outputs leave out dim_user; its keys stay pseudonymous.
+func ExportStar(result *Result, meta ExportMeta) ([]string, error) {
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+    baseFilename := filepath.Join(outputDir, OutputBaseFilename(meta))
+
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    usernames, userKeys := starKeys(result.Users)
+    providers, providerKeys := starKeys(result.Providers)
+
+    var facts [][]string
+    facts = append(facts, []string{"date_key", "user_key", "provider_key"})
+    dates := make([]string, 0, len(result.Days))
+    for date := range result.Days {
+        dates = append(dates, date)
+    }
+    sort.Strings(dates)
+    for _, date := range dates {
+        day, err := time.Parse(DateFormat, date)
+        if err != nil {
+            continue
+        }
+        users := make(map[string][]string)
+        for username, providers := range result.Days[date].Visits.ByUser() {
+            users[username] = providers
+        }
+        names := make([]string, 0, len(users))
+        for username := range users {
+            names = append(names, username)
+        }
+        sort.Strings(names)
+        for _, username := range names {
+            visited := users[username]
+            sort.Strings(visited)
+            for _, provider := range visited {
+                facts = append(facts, []string{dateKey(day), strconv.Itoa(userKeys[username]), strconv.Itoa(providerKeys[provider])})
+            }
+        }
+    }
+
+    dimUsers := [][]string{{"user_key", "username", "realm", "providers", "hits", "first_seen", "last_seen"}}
+    for _, username := range usernames {
+        stats := result.Users[username]
+        realm := ""
+        if at := strings.LastIndex(username, "@"); at >= 0 {
+            realm = username[at+1:]
+        }
+        dimUsers = append(dimUsers, []string{
+            strconv.Itoa(userKeys[username]),
+            username,
+            realm,
+            strconv.Itoa(stats.Providers.Len()),
+            strconv.FormatInt(stats.Hits, 10),
+            stats.FirstSeen.Format(DateFormat),
+            stats.LastSeen.Format(DateFormat),
+        })
+    }
+
+    dimProviders := [][]string{{"provider_key", "provider", "country", "users", "hits", "first_seen", "last_seen"}}
+    for _, provider := range providers {
+        stats := result.Providers[provider]
+        dimProviders = append(dimProviders, []string{
+            strconv.Itoa(providerKeys[provider]),
+            provider,
+            meta.Countries.Country(provider),
+            strconv.Itoa(stats.Users.Len()),
+            strconv.FormatInt(stats.Hits, 10),
+            stats.FirstSeen.Format(DateFormat),
+            stats.LastSeen.Format(DateFormat),
+        })
+    }
+
+    dimDates := [][]string{{"date_key", "date", "year", "quarter", "month", "month_name", "day", "weekday", "iso_week", "is_weekend", "users", "hits"}}
+    for _, job := range GenerateJobs(TimeRange{StartDate: result.StartDate, EndDate: result.EndDate}) {
+        day := job.Date
+        year, week := day.ISOWeek()
+        var users int
+        var hits int64
+        if stats := result.Days[day.Format(DateFormat)]; stats != nil {
+            users, hits = stats.Users.Len(), stats.Hits
+        }
+        weekend := day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+        dimDates = append(dimDates, []string{
+            dateKey(day),
+            day.Format(DateFormat),
+            strconv.Itoa(day.Year()),
+            strconv.Itoa((int(day.Month())-1)/3 + 1),
+            strconv.Itoa(int(day.Month())),
+            day.Month().String(),
+            strconv.Itoa(day.Day()),
+            day.Weekday().String(),
+            strconv.Itoa(year*100 + week),
+            strconv.FormatBool(weekend),
+            strconv.Itoa(users),
+            strconv.FormatInt(hits, 10),
+        })
+    }
+
+    tables := []struct {
+        name    string
+        records [][]string
+    }{
+        {"fact_activity", facts},
+        {"dim_user", dimUsers},
+        {"dim_provider", dimProviders},
+        {"dim_date", dimDates},
+    }
+    var filenames []string
+    for _, table := range tables {
+        if table.name == "dim_user" && meta.Public {
+            continue
+        }
+        filename := baseFilename + "-" + table.name + ".csv"
+        if err := writeCSVFile(filename, table.records); err != nil {
+            return filenames, err
+        }
+        filenames = append(filenames, filename)
+    }
+    return filenames, nil
+}
+
+func init() {
+    RegisterExporter("star", ExporterFunc(ExportStar))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "log"
+    "os"
+    "runtime"
+)
+
+// LogStatus writes a snapshot of the current run to the log: progress from
+// the broker's latest event plus Go runtime memory statistics
+func LogStatus(broker *ProgressBroker) {
+    var mem runtime.MemStats
+    runtime.ReadMemStats(&mem)
+
+    if event, ok := broker.Last(); ok {
+        log.Printf("Status: %s %d/%d days (%.1f%%), %d hits, %d in-flight queries, elapsed %s",
+            event.Domain, event.ProcessedDays, event.TotalDays, event.Percent,
+            event.TotalHits, event.InFlight, event.Elapsed)
+    } else {
+        log.Printf("Status: no analysis started yet")
+    }
+    log.Printf("Memory: heap %d MiB in use, %d MiB from OS, %d goroutines, %d GC cycles",
+        mem.HeapInuse/1024/1024, mem.Sys/1024/1024, runtime.NumGoroutine(), mem.NumGC)
+}
+
+// WatchStatusSignal logs a status snapshot whenever the status signal
+// (SIGUSR1 where supported) is received, without interrupting the run
+func WatchStatusSignal(broker *ProgressBroker) {
+    signals := make(chan os.Signal, 1)
+    if !notifyStatusSignal(signals) {
+        return
+    }
+    go func() {
+        for range signals {
+            LogStatus(broker)
+        }
+    }()
+}
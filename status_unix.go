@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// notifyStatusSignal relays SIGUSR1 to signals
+func notifyStatusSignal(signals chan<- os.Signal) bool {
+    signal.Notify(signals, syscall.SIGUSR1)
+    return true
+}
+
+// notifyReloadSignal relays SIGHUP to signals
+func notifyReloadSignal(signals chan<- os.Signal) bool {
+    signal.Notify(signals, syscall.SIGHUP)
+    return true
+}
@@ -0,0 +1,15 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// notifyStatusSignal reports that no status signal exists on Windows
+func notifyStatusSignal(signals chan<- os.Signal) bool {
+    return false
+}
+
+// notifyReloadSignal reports that no reload signal exists on Windows
+func notifyReloadSignal(signals chan<- os.Signal) bool {
+    return false
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// StoreDirName is the directory name of the local store under the data dir
+const StoreDirName = "store"
+
+// ErrEmptyStore indicates that the store holds no data for the request
+var ErrEmptyStore = errors.New("no stored data")
+
+// DayRecord is the stored aggregate of one domain and day
+type DayRecord struct {
+    Domain   string              `json:"domain"`
+    Date     string              `json:"date"`
+    Hits     int64               `json:"hits"`
+    Users    map[string][]string `json:"users"`
+    RunID    string              `json:"run_id"`
+    StoredAt string              `json:"stored_at"`
+}
+
+// Store is a cumulative local database of per-day aggregates. Each domain
+// is kept in its own JSON Lines file, one DayRecord per line; runs are
+// appended and days superseded by a later run are compacted away.
+type Store struct {
+    dir string
+}
+
+// ResolveStoreDir returns the store directory: the explicit path if given,
+// otherwise "store" under the user data dir (or the working directory)
+func ResolveStoreDir(explicit string) string {
+    if explicit != "" {
+        return explicit
+    }
+    if dir, err := UserDataDir(); err == nil {
+        return filepath.Join(dir, AppDirName, StoreDirName)
+    }
+    return StoreDirName
+}
+
+// OpenStore opens the store at dir, creating the directory if needed
+func OpenStore(dir string) (*Store, error) {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, fmt.Errorf("error creating store directory: %w", err)
+    }
+    return &Store{dir: dir}, nil
+}
+
+// path returns the file holding a domain's records
+func (s *Store) path(domain string) string {
+    name := strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(domain)
+    return filepath.Join(s.dir, name+".jsonl")
+}
+
+// Append stores the per-day aggregates of a run for a domain and returns the
+// number of days written and the dates that were already stored. Overlapping
+// days are superseded by the new records and the file is compacted, so
+// re-running a range to fix a gap never double counts.
+func (s *Store) Append(domain string, days map[string]*DayStats, runID string) (int, []string, error) {
+    existing, err := s.StoredDates(domain)
+    if err != nil {
+        return 0, nil, err
+    }
+    var overlaps []string
+    for date := range days {
+        if _, ok := existing[date]; ok {
+            overlaps = append(overlaps, date)
+        }
+    }
+    sort.Strings(overlaps)
+
+    written, err := s.appendRecords(domain, days, runID)
+    if err != nil {
+        return 0, nil, err
+    }
+    if len(overlaps) > 0 {
+        if err := s.Compact(domain); err != nil {
+            return written, overlaps, err
+        }
+    }
+    return written, overlaps, nil
+}
+
+// appendRecords writes one record per day to the end of a domain's file
+func (s *Store) appendRecords(domain string, days map[string]*DayStats, runID string) (int, error) {
+    file, err := os.OpenFile(s.path(domain), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+    if err != nil {
+        return 0, fmt.Errorf("error opening store: %w", err)
+    }
+    defer file.Close()
+
+    dates := make([]string, 0, len(days))
+    for date := range days {
+        dates = append(dates, date)
+    }
+    sort.Strings(dates)
+
+    writer := bufio.NewWriter(file)
+    storedAt := time.Now().Format(time.RFC3339)
+    for _, date := range dates {
+        day := days[date]
+        record := DayRecord{
+            Domain:   domain,
+            Date:     date,
+            Hits:     day.Hits,
+            Users:    make(map[string][]string, day.Users.Len()),
+            RunID:    runID,
+            StoredAt: storedAt,
+        }
+        for username, providers := range day.Visits.ByUser() {
+            sort.Strings(providers)
+            record.Users[username] = providers
+        }
+
+        line, err := json.Marshal(record)
+        if err != nil {
+            return 0, fmt.Errorf("error encoding store record: %w", err)
+        }
+        writer.Write(line)
+        writer.WriteByte('\n')
+    }
+
+    if err := writer.Flush(); err != nil {
+        return 0, fmt.Errorf("error writing store: %w", err)
+    }
+    return len(dates), nil
+}
+
+// readAll returns every record of a domain in file order
+func (s *Store) readAll(domain string) ([]DayRecord, error) {
+    file, err := os.Open(s.path(domain))
+    if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, fmt.Errorf("%w for %s", ErrEmptyStore, domain)
+        }
+        return nil, fmt.Errorf("error opening store: %w", err)
+    }
+    defer file.Close()
+
+    var records []DayRecord
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 1024*1024), 1024*1024*1024)
+    for scanner.Scan() {
+        var record DayRecord
+        if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+            return nil, fmt.Errorf("error decoding store record: %w", err)
+        }
+        records = append(records, record)
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("error reading store: %w", err)
+    }
+    return records, nil
+}
+
+// latestPerDay keeps only the most recently appended record for each date,
+// so days stored by overlapping runs are never counted twice. The result is
+// sorted by date.
+func latestPerDay(records []DayRecord) []DayRecord {
+    latest := make(map[string]DayRecord, len(records))
+    for _, record := range records {
+        latest[record.Date] = record
+    }
+    deduped := make([]DayRecord, 0, len(latest))
+    for _, record := range latest {
+        deduped = append(deduped, record)
+    }
+    sort.Slice(deduped, func(i, j int) bool { return deduped[i].Date < deduped[j].Date })
+    return deduped
+}
+
+// Load returns the records of a domain whose date lies within [from, to].
+// When several runs stored the same day, only the latest record is returned.
+func (s *Store) Load(domain string, from, to time.Time) ([]DayRecord, error) {
+    all, err := s.readAll(domain)
+    if err != nil {
+        return nil, err
+    }
+
+    fromDay, toDay := from.Format(DateFormat), to.Format(DateFormat)
+    var records []DayRecord
+    for _, record := range latestPerDay(all) {
+        if record.Date < fromDay || record.Date > toDay {
+            continue
+        }
+        records = append(records, record)
+    }
+    return records, nil
+}
+
+// StoredDates returns the dates already stored for a domain with the run
+// that stored them last
+func (s *Store) StoredDates(domain string) (map[string]string, error) {
+    records, err := s.readAll(domain)
+    if errors.Is(err, ErrEmptyStore) {
+        return map[string]string{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    dates := make(map[string]string, len(records))
+    for _, record := range records {
+        dates[record.Date] = record.RunID
+    }
+    return dates, nil
+}
+
+// Compact rewrites a domain's file keeping only the latest record per day
+func (s *Store) Compact(domain string) error {
+    records, err := s.readAll(domain)
+    if err != nil {
+        return err
+    }
+
+    path := s.path(domain)
+    tmp, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return fmt.Errorf("error compacting store: %w", err)
+    }
+    defer os.Remove(tmp.Name())
+
+    writer := bufio.NewWriter(tmp)
+    for _, record := range latestPerDay(records) {
+        line, err := json.Marshal(record)
+        if err != nil {
+            tmp.Close()
+            return fmt.Errorf("error encoding store record: %w", err)
+        }
+        writer.Write(line)
+        writer.WriteByte('\n')
+    }
+    if err := writer.Flush(); err != nil {
+        tmp.Close()
+        return fmt.Errorf("error compacting store: %w", err)
+    }
+    if err := tmp.Close(); err != nil {
+        return fmt.Errorf("error compacting store: %w", err)
+    }
+    if err := os.Chmod(tmp.Name(), 0600); err != nil {
+        return fmt.Errorf("error compacting store: %w", err)
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// BuildResultFromDays aggregates stored day records into a Result covering
+// timeRange, as if the days had been queried from Quickwit
+func BuildResultFromDays(records []DayRecord, timeRange TimeRange) (*Result, error) {
+    result := &Result{
+        Users:     make(map[string]*UserStats),
+        Providers: make(map[string]*ProviderStats),
+        StartDate: timeRange.StartDate,
+        EndDate:   timeRange.EndDate,
+        Days:      make(map[string]*DayStats),
+    }
+
+    for _, record := range records {
+        date, err := time.ParseInLocation(DateFormat, record.Date, timeRange.StartDate.Location())
+        if err != nil {
+            return nil, fmt.Errorf("invalid date %q in store: %w", record.Date, err)
+        }
+
+        day := result.Days[record.Date]
+        if day == nil {
+            day = &DayStats{}
+            result.Days[record.Date] = day
+        }
+        day.Hits += record.Hits
+        result.TotalHits += record.Hits
+
+        for username, providers := range record.Users {
+            user := result.Users[username]
+            if user == nil {
+                user = &UserStats{FirstSeen: date, LastSeen: date}
+                result.Users[username] = user
+            }
+            if date.Before(user.FirstSeen) {
+                user.FirstSeen = date
+            }
+            if date.After(user.LastSeen) {
+                user.LastSeen = date
+            }
+            for _, provider := range providers {
+                user.Providers.Add(provider)
+                day.Add(username, provider)
+
+                stats := result.Providers[provider]
+                if stats == nil {
+                    stats = &ProviderStats{FirstSeen: date, LastSeen: date}
+                    result.Providers[provider] = stats
+                }
+                if date.Before(stats.FirstSeen) {
+                    stats.FirstSeen = date
+                }
+                if date.After(stats.LastSeen) {
+                    stats.LastSeen = date
+                }
+                stats.Users.Add(username)
+            }
+        }
+    }
+
+    return result, nil
+}
+
+// runReport implements the "report" subcommand, which answers queries from
+// the local store without contacting Quickwit
+func runReport(args []string) int {
+    flags := flag.NewFlagSet("report", flag.ExitOnError)
+    fromStore := flags.Bool("from-store", false, "Build the report from the local store (required)")
+    storeDir := flags.String("store-dir", "", "Directory of the local store (default: the user data dir)")
+    outputFormat := flags.String("format", DefaultOutputFormat, "Comma-separated output formats (e.g. json,csv)")
+    outputDir := flags.String("output-dir", "", "Base directory for output files")
+    flags.Usage = func() {
+        fmt.Fprintln(flags.Output(), "Usage: ./eduroam-idp report -from-store [flags] <domain> [days|Ny|yxxxx|DD-MM-YYYY]")
+        flags.PrintDefaults()
+    }
+    flags.Parse(args)
+
+    if !*fromStore || flags.NArg() < 1 || flags.NArg() > 2 {
+        flags.Usage()
+        return 1
+    }
+
+    formats, err := ParseFormats(*outputFormat)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return 1
+    }
+
+    domain := flags.Arg(0)
+    timeRange, err := ResolveTimeRange(flags.Arg(1))
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error parsing time range parameter: %v\n", err)
+        return 1
+    }
+
+    store, err := OpenStore(ResolveStoreDir(*storeDir))
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    records, err := store.Load(domain, timeRange.StartDate, timeRange.EndDate)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    if len(records) == 0 {
+        log.Printf("Error: %v for %s between %s and %s", ErrEmptyStore, domain,
+            timeRange.StartDate.Format(DateFormat), timeRange.EndDate.Format(DateFormat))
+        return 1
+    }
+
+    result, err := BuildResultFromDays(records, timeRange)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+
+    fmt.Printf("Loaded %d stored days for %s\n", len(result.Days), domain)
+    fmt.Printf("Number of users: %d\n", len(result.Users))
+    fmt.Printf("Number of providers: %d\n", len(result.Providers))
+    fmt.Printf("Total hits: %d\n", result.TotalHits)
+
+    history, err := store.ProviderFirstDates(domain)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    timeline := BuildProviderTimeline(result, history, timeRange)
+    printNewProviders(timeline)
+    lost, err := FindLostProvidersInStore(store, domain, result, timeRange)
+    if err != nil {
+        log.Printf("Error: %v", err)
+        return 1
+    }
+    if lost != nil {
+        printLostProviders(lost)
+    }
+
+    filenames, err := RunExporters(formats, result, ExportMeta{
+        Domain:           domain,
+        TimeRange:        timeRange,
+        RunTime:          time.Now(),
+        OutputDir:        ResolveOutputDir(*outputDir),
+        HomeCountry:      ProviderCountry(domain),
+        OutlierFactor:    DefaultOutlierFactor,
+        MultiProviderMin: DefaultMultiProviderMin,
+        ProviderHistory:  history,
+        LostProviders:    lost,
+    })
+    if err != nil {
+        log.Printf("Error saving output: %v", err)
+        return 1
+    }
+    fmt.Printf("Results have been saved to:\n")
+    for _, filename := range filenames {
+        fmt.Printf("  - %s\n", filename)
+    }
+    return 0
+}
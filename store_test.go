@@ -0,0 +1,74 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+    "time"
+)
+
+func TestStoreOverlappingRuns(t *testing.T) {
+    store, err := OpenStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    first := map[string]*DayStats{
+        "2024-03-01": testDay(10, map[string][]string{"alice": {"a.example"}}),
+        "2024-03-02": testDay(20, map[string][]string{"alice": {"a.example"}, "bob": {"b.example"}}),
+        "2024-03-03": testDay(30, map[string][]string{"bob": {"b.example"}}),
+    }
+    if written, overlaps, err := store.Append("uni.example", first, "run-1"); err != nil || written != 3 || len(overlaps) != 0 {
+        t.Fatalf("first Append = %d, %v, %v", written, overlaps, err)
+    }
+
+    // The second run re-fetches the last day of the first one
+    second := map[string]*DayStats{
+        "2024-03-03": testDay(35, map[string][]string{"bob": {"b.example"}, "carol": {"c.example"}}),
+        "2024-03-04": testDay(40, map[string][]string{"carol": {"c.example"}}),
+    }
+    written, overlaps, err := store.Append("uni.example", second, "run-2")
+    if err != nil || written != 2 {
+        t.Fatalf("second Append = %d, %v", written, err)
+    }
+    if !reflect.DeepEqual(overlaps, []string{"2024-03-03"}) {
+        t.Errorf("overlaps = %v, want [2024-03-03]", overlaps)
+    }
+
+    // The file is compacted to the latest record of every day
+    records, err := store.readAll("uni.example")
+    if err != nil {
+        t.Fatal(err)
+    }
+    runs := make(map[string]string)
+    for _, record := range records {
+        if _, ok := runs[record.Date]; ok {
+            t.Errorf("%s stored twice", record.Date)
+        }
+        runs[record.Date] = record.RunID
+    }
+    want := map[string]string{"2024-03-01": "run-1", "2024-03-02": "run-1", "2024-03-03": "run-2", "2024-03-04": "run-2"}
+    if !reflect.DeepEqual(runs, want) {
+        t.Errorf("compacted runs = %v, want %v", runs, want)
+    }
+
+    // An uncompacted duplicate, as left by an interrupted compaction, is
+    // not counted either
+    if _, err := store.appendRecords("uni.example", map[string]*DayStats{"2024-03-02": testDay(25, map[string][]string{"bob": {"b.example"}})}, "run-3"); err != nil {
+        t.Fatal(err)
+    }
+    loaded, err := store.Load("uni.example", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), time.Date(2024, 3, 4, 0, 0, 0, 0, time.Local))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var hits int64
+    users := make(map[string]int)
+    for _, record := range loaded {
+        hits += record.Hits
+        users[record.Date] = len(record.Users)
+    }
+    if len(loaded) != 4 || hits != 10+25+35+40 {
+        t.Errorf("loaded %d days with %d hits, want 4 days with %d", len(loaded), hits, 10+25+35+40)
+    }
+    if want := map[string]int{"2024-03-01": 1, "2024-03-02": 1, "2024-03-03": 2, "2024-03-04": 1}; !reflect.DeepEqual(users, want) {
+        t.Errorf("users per day = %v, want %v", users, want)
+    }
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// SubrealmStat is the per-realm line of a sub-realm breakdown
+type SubrealmStat struct {
+    Realm     string `json:"realm"`
+    UserCount int    `json:"user_count"`
+    Hits      int64  `json:"hits"`
+}
+
+// IsWildcardDomain reports whether a domain argument is a realm wildcard
+// such as "*.ac.th"
+func IsWildcardDomain(domain string) bool {
+    return strings.HasPrefix(domain, "*.")
+}
+
+// MatchSubrealms returns the realms that belong to domain. A wildcard
+// domain ("*.ac.th") matches every realm ending in the suffix; otherwise the
+// realm itself (domain or its resolved realm) and any realm below it match.
+// Realms are compared in their Unicode form.
+func MatchSubrealms(realms []RealmCount, domain, realm string) []string {
+    domain, realm = RealmToUnicode(domain), RealmToUnicode(realm)
+    var suffixes, exact []string
+    if IsWildcardDomain(domain) {
+        suffixes = []string{domain[1:]}
+    } else {
+        exact = []string{realm, domain}
+        suffixes = []string{"." + realm, "." + domain}
+    }
+
+    var matched []string
+    for _, realm := range realms {
+        name := strings.ToLower(RealmToUnicode(realm.Realm))
+        ok := false
+        for _, e := range exact {
+            ok = ok || name == strings.ToLower(e)
+        }
+        for _, s := range suffixes {
+            ok = ok || strings.HasSuffix(name, strings.ToLower(s))
+        }
+        if ok {
+            matched = append(matched, realm.Realm)
+        }
+    }
+    return matched
+}
+
+// ResolveSubrealms discovers the realms seen in the time range that belong
+// to domain, whose resolved realm is realm. It also returns every discovered
+// realm, e.g. for look-alike detection.
+func ResolveSubrealms(ctx context.Context, client *HTTPClient, domain, realm string, timeRange TimeRange) ([]string, []RealmCount, error) {
+    realms, err := DiscoverRealms(ctx, client, timeRange, DefaultRealmDiscoverySize)
+    if err != nil {
+        return nil, nil, fmt.Errorf("error discovering realms: %w", err)
+    }
+    matched := MatchSubrealms(realms, domain, realm)
+    if len(matched) == 0 {
+        return nil, realms, fmt.Errorf("no realms matching %q in the time range", domain)
+    }
+    return matched, realms, nil
+}
+
+// CaseVariants returns the realms together with every discovered realm that
+// differs from one of them only in letter case, plus the lower and upper case
+// forms, so that a query matches however a service provider cased the realm
+func CaseVariants(realms []string, discovered []RealmCount) []string {
+    seen := make(map[string]bool)
+    var variants []string
+    add := func(realm string) {
+        if !seen[realm] {
+            seen[realm] = true
+            variants = append(variants, realm)
+        }
+    }
+    for _, realm := range realms {
+        add(realm)
+        add(strings.ToLower(realm))
+        add(strings.ToUpper(realm))
+        for _, d := range discovered {
+            if strings.EqualFold(d.Realm, realm) {
+                add(d.Realm)
+            }
+        }
+    }
+    return variants
+}
+
+// ParseRealmAlias parses a "name=realm1,realm2" alias into its name and realms
+func ParseRealmAlias(value string) (string, []string, error) {
+    name, list, ok := strings.Cut(value, "=")
+    name = strings.TrimSpace(name)
+    if !ok || name == "" {
+        return "", nil, fmt.Errorf("invalid realm alias %q, expected name=realm1,realm2", value)
+    }
+    var realms []string
+    for _, realm := range strings.Split(list, ",") {
+        if realm = strings.TrimSpace(realm); realm != "" {
+            realms = append(realms, realm)
+        }
+    }
+    if len(realms) == 0 {
+        return "", nil, fmt.Errorf("realm alias %q lists no realms", name)
+    }
+    return name, realms, nil
+}
+
+// realmClause returns the query clause matching any of the realms
+func realmClause(realms []string) string {
+    if len(realms) == 1 {
+        return fmt.Sprintf(`realm:"%s"`, realms[0])
+    }
+    terms := make([]string, len(realms))
+    for i, realm := range realms {
+        terms[i] = fmt.Sprintf(`realm:"%s"`, realm)
+    }
+    return "(" + strings.Join(terms, " OR ") + ")"
+}
+
+// BuildRealmQuery returns the query string for the events of messageType
+// of one or more realms, without the events matched by the exclusion rules;
+// several realms are OR-ed together
+func BuildRealmQuery(messageType string, realms []string, exclusions []ExclusionRule) string {
+    return fmt.Sprintf(`message_type:"%s" AND %s%s`, messageType, realmClause(realms), exclusionClause(exclusions))
+}
+
+// BuildLocalQuery returns the query string for the local (non-roaming)
+// events of messageType of the realms, which the default exclusion drops
+func BuildLocalQuery(messageType string, realms []string) string {
+    return fmt.Sprintf(`message_type:"%s" AND %s AND service_provider:"client"`, messageType, realmClause(realms))
+}
+
+// SubrealmStats returns the sub-realm breakdown of a result ordered by hits
+func SubrealmStats(result *Result) []SubrealmStat {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Realms) == 0 {
+        return nil
+    }
+    stats := make([]SubrealmStat, 0, len(result.Realms))
+    for realm, realmStats := range result.Realms {
+        stats = append(stats, SubrealmStat{
+            Realm:     realm,
+            UserCount: realmStats.Users.Len(),
+            Hits:      realmStats.Hits,
+        })
+    }
+    sort.Slice(stats, func(i, j int) bool {
+        if stats[i].Hits != stats[j].Hits {
+            return stats[i].Hits > stats[j].Hits
+        }
+        return stats[i].Realm < stats[j].Realm
+    })
+    return stats
+}
+
+// ExportSubrealmsCSV writes the sub-realm breakdown next to the other CSV
+// files. It returns an empty filename when the result has no breakdown.
+func ExportSubrealmsCSV(result *Result, meta ExportMeta) (string, error) {
+    stats := SubrealmStats(result)
+    if stats == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-subrealms.csv")
+    file, err := os.Create(filename)
+    if err != nil {
+        return "", fmt.Errorf("error creating subrealms CSV file: %w", err)
+    }
+    defer file.Close()
+
+    writer := csv.NewWriter(file)
+    if err := writer.Write([]string{"Realm", "Users Count", "Hits"}); err != nil {
+        return "", fmt.Errorf("error writing subrealms CSV header: %w", err)
+    }
+    for _, stat := range stats {
+        record := []string{stat.Realm, strconv.Itoa(stat.UserCount), strconv.FormatInt(stat.Hits, 10)}
+        if err := writer.Write(record); err != nil {
+            return "", fmt.Errorf("error writing subrealm record: %w", err)
+        }
+    }
+    writer.Flush()
+    if err := writer.Error(); err != nil {
+        return "", fmt.Errorf("error writing subrealms CSV file: %w", err)
+    }
+    return filename, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "text/template"
+)
+
+// TemplateFuncs are the helper functions available to report templates
+var TemplateFuncs = template.FuncMap{
+    "join":  strings.Join,
+    "upper": strings.ToUpper,
+    "lower": strings.ToLower,
+    "add": func(a, b int) int {
+        return a + b
+    },
+    "percent": func(part, total int) float64 {
+        if total == 0 {
+            return 0
+        }
+        return float64(part) * 100 / float64(total)
+    },
+}
+
+// TemplateExporter renders the output data through a user-supplied Go
+// text/template. The template receives the same structure that is written
+// to JSON, so field names follow SimplifiedOutputData (e.g. .QueryInfo.Domain,
+// .Summary.TotalUsers, range .ProviderStats).
+type TemplateExporter struct {
+    path string
+    tmpl *template.Template
+}
+
+// NewTemplateExporter parses the template file at path. Numbers and dates
+// rendered through the number, decimal and date helpers follow locale.
+func NewTemplateExporter(path string, locale Locale) (*TemplateExporter, error) {
+    tmpl, err := template.New(filepath.Base(path)).Funcs(TemplateFuncs).Funcs(locale.TemplateFuncs()).ParseFiles(path)
+    if err != nil {
+        return nil, fmt.Errorf("error parsing template: %w", err)
+    }
+    return &TemplateExporter{path: path, tmpl: tmpl}, nil
+}
+
+// Write renders the template into the domain's output directory. The output
+// file is named after the template with a trailing ".tmpl" removed, so
+// "report.md.tmpl" produces "<timestamp>-<range>-report.md".
+func (e *TemplateExporter) Write(result *Result, meta ExportMeta) ([]string, error) {
+    outputData := CreateOutputData(result, meta)
+
+    var buf bytes.Buffer
+    if err := e.tmpl.Execute(&buf, outputData); err != nil {
+        return nil, fmt.Errorf("error rendering template: %w", err)
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+
+    name := strings.TrimSuffix(filepath.Base(e.path), ".tmpl")
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-"+name)
+    if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+        return nil, fmt.Errorf("error writing file: %w", err)
+    }
+
+    return []string{filename}, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strconv"
+)
+
+// ProviderHistory is the first stored day of each provider of a domain in
+// the local store, used to tell first-ever appearances from returning ones
+type ProviderHistory struct {
+    // From is the first day in the store
+    From  string
+    First map[string]string
+}
+
+// ProviderAppearance is a provider with the date it first appeared
+type ProviderAppearance struct {
+    Provider  string `json:"provider"`
+    FirstSeen string `json:"first_seen"`
+    Users     int    `json:"users"`
+    Hits      int64  `json:"hits"`
+    // FirstEver is the first day the provider appeared in the local store
+    // or the range, and New marks providers never seen before the range
+    FirstEver string `json:"first_ever,omitempty"`
+    New       bool   `json:"new,omitempty"`
+}
+
+// ProviderTimeline lists the providers of a result in order of their first
+// appearance. With a store history, StoreFrom is its first day and New
+// counts the providers first seen in the range.
+type ProviderTimeline struct {
+    StoreFrom string               `json:"store_from,omitempty"`
+    New       int                  `json:"new_providers"`
+    Providers []ProviderAppearance `json:"providers"`
+}
+
+// ProviderFirstDates returns the first stored day of every provider of a
+// domain, or an empty history if nothing is stored
+func (s *Store) ProviderFirstDates(domain string) (*ProviderHistory, error) {
+    history := &ProviderHistory{First: make(map[string]string)}
+    records, err := s.readAll(domain)
+    if errors.Is(err, ErrEmptyStore) {
+        return history, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    for _, record := range latestPerDay(records) {
+        if history.From == "" {
+            history.From = record.Date
+        }
+        for _, providers := range record.Users {
+            for _, provider := range providers {
+                if _, ok := history.First[provider]; !ok {
+                    history.First[provider] = record.Date
+                }
+            }
+        }
+    }
+    return history, nil
+}
+
+// BuildProviderTimeline returns the providers of result ordered by first
+// appearance. Providers are only marked new when history starts before
+// the range, so a store begun within the range flags nothing.
+func BuildProviderTimeline(result *Result, history *ProviderHistory, timeRange TimeRange) *ProviderTimeline {
+    result.mu.RLock()
+    defer result.mu.RUnlock()
+
+    if len(result.Days) == 0 && result.Saved != nil && result.Saved.Timeline != nil {
+        return result.Saved.Timeline
+    }
+
+    timeline := &ProviderTimeline{Providers: make([]ProviderAppearance, 0, len(result.Providers))}
+    rangeStart := timeRange.StartDate.Format(DateFormat)
+    known := history != nil && history.From != "" && history.From < rangeStart
+    if known {
+        timeline.StoreFrom = history.From
+    }
+    for name, stats := range result.Providers {
+        appearance := ProviderAppearance{
+            Provider:  name,
+            FirstSeen: stats.FirstSeen.Format(DateTimeFormat),
+            Users:     stats.Users.Len(),
+            Hits:      stats.Hits,
+        }
+        if known {
+            appearance.FirstEver = stats.FirstSeen.Format(DateFormat)
+            if stored, ok := history.First[name]; ok && stored < appearance.FirstEver {
+                appearance.FirstEver = stored
+            }
+            if appearance.FirstEver >= rangeStart {
+                appearance.New = true
+                timeline.New++
+            }
+        }
+        timeline.Providers = append(timeline.Providers, appearance)
+    }
+    sort.Slice(timeline.Providers, func(i, j int) bool {
+        a, b := timeline.Providers[i], timeline.Providers[j]
+        if a.FirstSeen != b.FirstSeen {
+            return a.FirstSeen < b.FirstSeen
+        }
+        return a.Provider < b.Provider
+    })
+    return timeline
+}
+
+// printNewProviders lists the providers of the timeline first seen in the
+// range
+func printNewProviders(timeline *ProviderTimeline) {
+    if timeline.StoreFrom == "" {
+        return
+    }
+    fmt.Printf("New providers since %s: %d\n", timeline.StoreFrom, timeline.New)
+    for _, appearance := range timeline.Providers {
+        if appearance.New {
+            fmt.Printf("  %s  %-40s %6d users\n", appearance.FirstSeen[:10], appearance.Provider, appearance.Users)
+        }
+    }
+}
+
+// ExportProviderTimelineCSV writes the provider timeline. It returns an
+// empty filename for a result without providers.
+func ExportProviderTimelineCSV(result *Result, meta ExportMeta) (string, error) {
+    timeline := BuildProviderTimeline(result, meta.ProviderHistory, meta.TimeRange)
+    if len(timeline.Providers) == 0 {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"First Seen", "Provider", "Users", "Hits", "First Ever", "New"}}
+    for _, appearance := range timeline.Providers {
+        records = append(records, []string{
+            appearance.FirstSeen,
+            appearance.Provider,
+            strconv.Itoa(appearance.Users),
+            strconv.FormatInt(appearance.Hits, 10),
+            appearance.FirstEver,
+            strconv.FormatBool(appearance.New),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-provider-timeline.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
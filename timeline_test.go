@@ -0,0 +1,51 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestBuildProviderTimeline(t *testing.T) {
+    timeRange := testRange(t, 3)
+    before := timeRange.StartDate.AddDate(0, 0, -5)
+
+    store, err := OpenStore(t.TempDir())
+    if err != nil {
+        t.Fatal(err)
+    }
+    history, err := store.ProviderFirstDates("uni.example")
+    if err != nil || history.From != "" {
+        t.Fatalf("empty store: %+v, %v", history, err)
+    }
+    if _, _, err := store.Append("uni.example", map[string]*DayStats{
+        before.Format(DateFormat): testDay(1, map[string][]string{"alice": {"sp1.example.org"}}),
+    }, "run-1"); err != nil {
+        t.Fatal(err)
+    }
+    if history, err = store.ProviderFirstDates("uni.example"); err != nil {
+        t.Fatal(err)
+    }
+
+    result := &Result{
+        Providers: map[string]*ProviderStats{
+            "sp2.example.org": {FirstSeen: timeRange.StartDate.AddDate(0, 0, 1), Users: NewNameSet("bob"), Hits: 3},
+            "sp1.example.org": {FirstSeen: timeRange.StartDate, Users: NewNameSet("alice", "bob"), Hits: 5},
+        },
+    }
+    timeline := BuildProviderTimeline(result, history, timeRange)
+    if timeline.StoreFrom != before.Format(DateFormat) || timeline.New != 1 || len(timeline.Providers) != 2 {
+        t.Fatalf("timeline: %+v", timeline)
+    }
+    first, second := timeline.Providers[0], timeline.Providers[1]
+    if first.Provider != "sp1.example.org" || first.New || first.FirstEver != before.Format(DateFormat) || first.Users != 2 {
+        t.Errorf("returning provider: %+v", first)
+    }
+    if second.Provider != "sp2.example.org" || !second.New || second.FirstEver != timeRange.StartDate.AddDate(0, 0, 1).Format(DateFormat) {
+        t.Errorf("new provider: %+v", second)
+    }
+
+    // Without a history from before the range nothing is new
+    timeline = BuildProviderTimeline(result, &ProviderHistory{From: timeRange.StartDate.Format(DateFormat)}, timeRange)
+    if timeline.StoreFrom != "" || timeline.New != 0 || timeline.Providers[1].FirstEver != "" {
+        t.Errorf("history within the range: %+v", timeline)
+    }
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+// testNow is the fixed time the tests run at
+var testNow = time.Date(2024, 3, 15, 14, 30, 0, 0, time.UTC)
+
+func TestParseTimeRangeAt(t *testing.T) {
+    tests := []struct {
+        param     string
+        days      int
+        start     time.Time
+        end       time.Time
+        specific  bool
+        year      int
+    }{
+        {param: "1", days: 1, start: testNow, end: testNow},
+        {param: "7", days: 7, start: testNow.AddDate(0, 0, -6), end: testNow},
+        {param: "1y", days: 365, start: testNow.AddDate(-1, 0, 0), end: testNow},
+        {param: "y2024", days: 366, start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local),
+            end: time.Date(2024, 12, 31, 23, 59, 59, 999999999, time.Local), year: 2024},
+        {param: "y2023", days: 365, start: time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local),
+            end: time.Date(2023, 12, 31, 23, 59, 59, 999999999, time.Local), year: 2023},
+        {param: "29-02-2024", days: 1, start: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+            end: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), specific: true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.param, func(t *testing.T) {
+            timeRange, err := ParseTimeRangeAt(tt.param, testNow)
+            if err != nil {
+                t.Fatalf("ParseTimeRangeAt(%q): %v", tt.param, err)
+            }
+            if timeRange.Days != tt.days {
+                t.Errorf("Days = %d, want %d", timeRange.Days, tt.days)
+            }
+            if !timeRange.StartDate.Equal(tt.start) || !timeRange.EndDate.Equal(tt.end) {
+                t.Errorf("range = %v - %v, want %v - %v", timeRange.StartDate, timeRange.EndDate, tt.start, tt.end)
+            }
+            if timeRange.SpecificDate != tt.specific {
+                t.Errorf("SpecificDate = %v, want %v", timeRange.SpecificDate, tt.specific)
+            }
+            if timeRange.SpecificYear != (tt.year != 0) || timeRange.Year != tt.year {
+                t.Errorf("SpecificYear, Year = %v, %d, want year %d", timeRange.SpecificYear, timeRange.Year, tt.year)
+            }
+        })
+    }
+}
+
+func TestParseTimeRangeAtErrors(t *testing.T) {
+    for _, param := range []string{"0", "-1", "3651", "0y", "11y", "y1999", "y2101", "yabcd", "2024-03-01", "31-02-2024", "week"} {
+        if timeRange, err := ParseTimeRangeAt(param, testNow); err == nil {
+            t.Errorf("ParseTimeRangeAt(%q) = %+v, want an error", param, timeRange)
+        }
+    }
+}
+
+func TestResolveTimeRangeAt(t *testing.T) {
+    tests := []struct {
+        param string
+        days  int
+        start string
+        end   string
+    }{
+        // The default covers yesterday and today
+        {param: "", days: 1, start: "2024-03-14", end: "2024-03-15"},
+        {param: "1", days: 1, start: "2024-03-15", end: "2024-03-15"},
+        {param: "3", days: 3, start: "2024-03-13", end: "2024-03-15"},
+        {param: "01-03-2024", days: 1, start: "2024-03-01", end: "2024-03-02"},
+    }
+    for _, tt := range tests {
+        timeRange, err := ResolveTimeRangeAt(tt.param, testNow)
+        if err != nil {
+            t.Fatalf("ResolveTimeRangeAt(%q): %v", tt.param, err)
+        }
+        if timeRange.Days != tt.days {
+            t.Errorf("ResolveTimeRangeAt(%q).Days = %d, want %d", tt.param, timeRange.Days, tt.days)
+        }
+        start, end := timeRange.StartDate, timeRange.EndDate
+        if start.Format(DateFormat) != tt.start || end.Format(DateFormat) != tt.end {
+            t.Errorf("ResolveTimeRangeAt(%q) = %s - %s, want %s - %s", tt.param,
+                start.Format(DateFormat), end.Format(DateFormat), tt.start, tt.end)
+        }
+        if start.Hour() != 0 || start.Minute() != 0 || start.Second() != 0 || start.Nanosecond() != 0 {
+            t.Errorf("ResolveTimeRangeAt(%q) starts at %v, want midnight", tt.param, start)
+        }
+        if end.Hour() != 23 || end.Minute() != 59 || end.Second() != 59 || end.Nanosecond() != 999999999 {
+            t.Errorf("ResolveTimeRangeAt(%q) ends at %v, want the end of the day", tt.param, end)
+        }
+    }
+}
+
+func TestIsLeapYear(t *testing.T) {
+    for year, leap := range map[int]bool{2000: true, 2023: false, 2024: true, 2100: false, 2400: true} {
+        if isLeapYear(year) != leap {
+            t.Errorf("isLeapYear(%d) = %v, want %v", year, !leap, leap)
+        }
+    }
+}
+
+func TestClocks(t *testing.T) {
+    if now := FixedClock(testNow).Now(); !now.Equal(testNow) {
+        t.Errorf("FixedClock.Now() = %v, want %v", now, testNow)
+    }
+    if now := (Config{Clock: FixedClock(testNow)}).now(); !now.Equal(testNow) {
+        t.Errorf("Config.now() = %v, want %v", now, testNow)
+    }
+    before := time.Now()
+    if now := (Config{}).now(); now.Before(before) || now.After(time.Now()) {
+        t.Errorf("Config.now() without a clock = %v, want the wall clock", now)
+    }
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// timingSlowest is the number of slowest batches listed in a timing report
+const timingSlowest = 5
+
+// BatchTiming accumulates the time one batch spent waiting for Quickwit and
+// processing the answers. A nil *BatchTiming records nothing.
+type BatchTiming struct {
+    Query   time.Duration
+    Process time.Duration
+}
+
+// record adds the durations of one query and its processing
+func (t *BatchTiming) record(query, process time.Duration) {
+    if t == nil {
+        return
+    }
+    t.Query += query
+    t.Process += process
+}
+
+// JobTiming is the timing of one batch of days
+type JobTiming struct {
+    Start     string  `json:"start"`
+    End       string  `json:"end"`
+    Days      int     `json:"days"`
+    Worker    int     `json:"worker"`
+    QueryMs   float64 `json:"query_ms"`
+    ProcessMs float64 `json:"process_ms"`
+    Hits      int64   `json:"hits"`
+}
+
+// WorkerTiming is the throughput of one worker
+type WorkerTiming struct {
+    Worker        int     `json:"worker"`
+    Batches       int     `json:"batches"`
+    Days          int     `json:"days"`
+    Hits          int64   `json:"hits"`
+    BusyMs        float64 `json:"busy_ms"`
+    HitsPerSecond float64 `json:"hits_per_second"`
+}
+
+// TimingReport breaks down where the time of a run went: waiting for
+// Quickwit (query), handling answers (process) and workers without work
+// (idle, the rest of workers x wall time)
+type TimingReport struct {
+    WallMs       float64        `json:"wall_ms"`
+    Workers      int            `json:"workers"`
+    Batches      int            `json:"batches"`
+    QueryMs      float64        `json:"query_ms"`
+    ProcessMs    float64        `json:"process_ms"`
+    IdleMs       float64        `json:"idle_ms"`
+    AvgLatencyMs float64        `json:"avg_latency_ms"`
+    P95LatencyMs float64        `json:"p95_latency_ms"`
+    MaxLatencyMs float64        `json:"max_latency_ms"`
+    Slowest      []JobTiming    `json:"slowest"`
+    PerWorker    []WorkerTiming `json:"per_worker"`
+}
+
+// TimingRecorder collects the batch timings of a run from all workers
+type TimingRecorder struct {
+    mu    sync.Mutex
+    start time.Time
+    jobs  []JobTiming
+}
+
+// NewTimingRecorder starts recording at the current time
+func NewTimingRecorder() *TimingRecorder {
+    return &TimingRecorder{start: time.Now()}
+}
+
+// milliseconds converts a duration to fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+    return float64(d.Microseconds()) / 1000
+}
+
+// Record adds the timing of a batch fetched by worker. A nil recorder
+// records nothing.
+func (r *TimingRecorder) Record(worker int, batch []Job, timing BatchTiming, hits int64) {
+    if r == nil || len(batch) == 0 {
+        return
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.jobs = append(r.jobs, JobTiming{
+        Start:     batch[0].Date.Format(DateFormat),
+        End:       batch[len(batch)-1].Date.Format(DateFormat),
+        Days:      len(batch),
+        Worker:    worker,
+        QueryMs:   milliseconds(timing.Query),
+        ProcessMs: milliseconds(timing.Process),
+        Hits:      hits,
+    })
+}
+
+// Report summarises the recorded batches of a run with workers workers
+func (r *TimingRecorder) Report(workers int) *TimingReport {
+    if r == nil {
+        return nil
+    }
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    report := &TimingReport{
+        WallMs:    milliseconds(time.Since(r.start)),
+        Workers:   workers,
+        Batches:   len(r.jobs),
+        Slowest:   []JobTiming{},
+        PerWorker: []WorkerTiming{},
+    }
+    latencies := make([]float64, 0, len(r.jobs))
+    perWorker := make(map[int]*WorkerTiming)
+    for _, job := range r.jobs {
+        report.QueryMs += job.QueryMs
+        report.ProcessMs += job.ProcessMs
+        latencies = append(latencies, job.QueryMs)
+
+        w := perWorker[job.Worker]
+        if w == nil {
+            w = &WorkerTiming{Worker: job.Worker}
+            perWorker[job.Worker] = w
+        }
+        w.Batches++
+        w.Days += job.Days
+        w.Hits += job.Hits
+        w.BusyMs += job.QueryMs + job.ProcessMs
+    }
+    report.IdleMs = max(0, report.WallMs*float64(workers)-report.QueryMs-report.ProcessMs)
+
+    if len(latencies) > 0 {
+        sort.Float64s(latencies)
+        report.AvgLatencyMs = report.QueryMs / float64(len(latencies))
+        report.P95LatencyMs = latencies[(len(latencies)*95+99)/100-1]
+        report.MaxLatencyMs = latencies[len(latencies)-1]
+    }
+
+    slowest := append([]JobTiming(nil), r.jobs...)
+    sort.Slice(slowest, func(i, j int) bool {
+        if slowest[i].QueryMs != slowest[j].QueryMs {
+            return slowest[i].QueryMs > slowest[j].QueryMs
+        }
+        return slowest[i].Start < slowest[j].Start
+    })
+    report.Slowest = append(report.Slowest, slowest[:min(len(slowest), timingSlowest)]...)
+
+    for _, w := range perWorker {
+        if w.BusyMs > 0 {
+            w.HitsPerSecond = float64(w.Hits) / (w.BusyMs / 1000)
+        }
+        report.PerWorker = append(report.PerWorker, *w)
+    }
+    sort.Slice(report.PerWorker, func(i, j int) bool { return report.PerWorker[i].Worker < report.PerWorker[j].Worker })
+    return report
+}
+
+// PrintTimingReport writes the timing breakdown to stdout
+func PrintTimingReport(report *TimingReport) {
+    ms := func(value float64) time.Duration {
+        return (time.Duration(value*1000) * time.Microsecond).Round(time.Millisecond)
+    }
+    fmt.Printf("Timing (%d batches, %d workers, %v wall):\n", report.Batches, report.Workers, ms(report.WallMs))
+    fmt.Printf("  Waiting for Quickwit: %v, processing: %v, idle: %v\n", ms(report.QueryMs), ms(report.ProcessMs), ms(report.IdleMs))
+    fmt.Printf("  Query latency: avg %v, p95 %v, max %v\n", ms(report.AvgLatencyMs), ms(report.P95LatencyMs), ms(report.MaxLatencyMs))
+    fmt.Printf("  Slowest batches:\n")
+    for _, job := range report.Slowest {
+        days := job.Start
+        if job.Days > 1 {
+            days += " to " + job.End
+        }
+        fmt.Printf("    %-24s worker %-3d %10v query %10v process %10d hits\n", days, job.Worker, ms(job.QueryMs), ms(job.ProcessMs), job.Hits)
+    }
+    fmt.Printf("  Workers:\n")
+    for _, w := range report.PerWorker {
+        fmt.Printf("    worker %-3d %5d batches %6d days %12d hits %10v busy %12.0f hits/s\n",
+            w.Worker, w.Batches, w.Days, w.Hits, ms(w.BusyMs), w.HitsPerSecond)
+    }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "path"
+    "path/filepath"
+    "strings"
+)
+
+// UploadTarget is the SFTP or WebDAV endpoint output files are copied to
+// (UPLOAD_URL, UPLOAD_USER, UPLOAD_PASS and UPLOAD_KEY properties)
+type UploadTarget struct {
+    URL *url.URL
+    // User and Pass authenticate WebDAV requests; SFTP takes the user from
+    // the URL or User and authenticates with Key or the ssh agent
+    User   string
+    Pass   string
+    Key    string
+    // client sends the WebDAV requests
+    client *http.Client
+}
+
+// NewUploadTarget returns the upload target configured in props
+func NewUploadTarget(props Properties) (*UploadTarget, error) {
+    if props.UploadURL == "" {
+        return nil, fmt.Errorf("%w: UPLOAD_URL", ErrMissingConfiguration)
+    }
+    u, err := url.Parse(props.UploadURL)
+    if err != nil {
+        return nil, fmt.Errorf("invalid UPLOAD_URL: %w", err)
+    }
+    switch u.Scheme {
+    case "sftp":
+        if props.UploadPass != "" {
+            return nil, fmt.Errorf("UPLOAD_PASS is not supported for sftp, use UPLOAD_KEY or an ssh agent")
+        }
+        if _, err := exec.LookPath("sftp"); err != nil {
+            return nil, fmt.Errorf("sftp is needed for sftp uploads: %w", err)
+        }
+    case "http", "https":
+    default:
+        return nil, fmt.Errorf("invalid UPLOAD_URL scheme %q (expected sftp, http or https)", u.Scheme)
+    }
+    if u.Host == "" {
+        return nil, fmt.Errorf("invalid UPLOAD_URL %q: no host", props.UploadURL)
+    }
+    return &UploadTarget{URL: u, User: props.UploadUser, Pass: props.UploadPass, Key: props.UploadKey, client: &http.Client{Timeout: DefaultHTTPTimeout}}, nil
+}
+
+// String returns the target URL without credentials
+func (t *UploadTarget) String() string {
+    u := *t.URL
+    u.User = nil
+    return u.String()
+}
+
+// Upload copies the files into the directory dir below the target path,
+// creating it if needed
+func (t *UploadTarget) Upload(ctx context.Context, files []string, dir string) error {
+    if t.URL.Scheme == "sftp" {
+        return t.uploadSFTP(ctx, files, dir)
+    }
+    return t.uploadWebDAV(ctx, files, dir)
+}
+
+// uploadSFTP runs the sftp client in batch mode
+func (t *UploadTarget) uploadSFTP(ctx context.Context, files []string, dir string) error {
+    remote := path.Join(t.URL.Path, dir)
+    if !strings.HasPrefix(t.URL.Path, "/") {
+        // sftp://host/path is relative to the login directory
+        remote = path.Join(".", dir)
+    }
+    var batch strings.Builder
+    // A leading "-" ignores the error of an existing directory
+    fmt.Fprintf(&batch, "-mkdir %s\n", sftpQuote(remote))
+    for _, file := range files {
+        fmt.Fprintf(&batch, "put %s %s\n", sftpQuote(file), sftpQuote(path.Join(remote, filepath.Base(file))))
+    }
+
+    args := []string{"-b", "-", "-o", "BatchMode=yes"}
+    if t.Key != "" {
+        args = append(args, "-i", t.Key)
+    }
+    if port := t.URL.Port(); port != "" {
+        args = append(args, "-P", port)
+    }
+    host := t.URL.Hostname()
+    if user := t.URL.User.Username(); user != "" {
+        host = user + "@" + host
+    } else if t.User != "" {
+        host = t.User + "@" + host
+    }
+    args = append(args, host)
+
+    cmd := exec.CommandContext(ctx, "sftp", args...)
+    cmd.Stdin = strings.NewReader(batch.String())
+    if output, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("sftp upload to %s failed: %w: %s", t, err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// sftpQuote quotes a path for an sftp batch file
+func sftpQuote(p string) string {
+    return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + `"`
+}
+
+// uploadWebDAV creates the collection and PUTs every file into it
+func (t *UploadTarget) uploadWebDAV(ctx context.Context, files []string, dir string) error {
+    base := *t.URL
+    base.Path = strings.TrimSuffix(base.Path, "/") + "/" + dir + "/"
+
+    // 405 Method Not Allowed is the answer for an existing collection
+    if err := t.webDAVRequest(ctx, "MKCOL", base.String(), nil, http.StatusMethodNotAllowed); err != nil {
+        return err
+    }
+    for _, file := range files {
+        f, err := os.Open(file)
+        if err != nil {
+            return fmt.Errorf("error uploading %s: %w", file, err)
+        }
+        target := base
+        target.Path += filepath.Base(file)
+        err = t.webDAVRequest(ctx, http.MethodPut, target.String(), f)
+        f.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// webDAVRequest sends one request and accepts 2xx and the extra statuses
+func (t *UploadTarget) webDAVRequest(ctx context.Context, method, target string, body *os.File, accept ...int) error {
+    var req *http.Request
+    var err error
+    if body != nil {
+        req, err = http.NewRequestWithContext(ctx, method, target, body)
+        if err == nil {
+            if info, statErr := body.Stat(); statErr == nil {
+                req.ContentLength = info.Size()
+            }
+        }
+    } else {
+        req, err = http.NewRequestWithContext(ctx, method, target, nil)
+    }
+    if err != nil {
+        return fmt.Errorf("error creating %s request: %w", method, err)
+    }
+    if t.User != "" {
+        req.SetBasicAuth(t.User, t.Pass)
+    }
+    resp, err := t.client.Do(req)
+    if err != nil {
+        return fmt.Errorf("WebDAV %s failed: %w", method, err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+        return nil
+    }
+    for _, status := range accept {
+        if resp.StatusCode == status {
+            return nil
+        }
+    }
+    return fmt.Errorf("WebDAV %s %s failed: %s", method, req.URL.Redacted(), resp.Status)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "slices"
+    "sync"
+    "testing"
+)
+
+func TestUploadWebDAVPaths(t *testing.T) {
+    var mu sync.Mutex
+    var requests []string
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock()
+        requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+        mu.Unlock()
+        w.WriteHeader(http.StatusCreated)
+    }))
+    defer server.Close()
+
+    file := filepath.Join(t.TempDir(), "report.json")
+    if err := os.WriteFile(file, []byte("{}"), 0600); err != nil {
+        t.Fatal(err)
+    }
+    target, err := NewUploadTarget(Properties{UploadURL: server.URL + "/dav/"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := target.Upload(context.Background(), []string{file}, "uni.example 2024"); err != nil {
+        t.Fatal(err)
+    }
+
+    want := []string{"MKCOL /dav/uni.example%202024/", "PUT /dav/uni.example%202024/report.json"}
+    if !slices.Equal(requests, want) {
+        t.Errorf("requests = %q, want %q", requests, want)
+    }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// UserChunkFile is one numbered file of a split user list
+type UserChunkFile struct {
+    File   string `json:"file"`
+    Users  int    `json:"users"`
+    Bytes  int64  `json:"bytes"`
+    SHA256 string `json:"sha256"`
+}
+
+// UserChunkIndex is the index file of a user list split by
+// -users-per-file; file names are relative to the index
+type UserChunkIndex struct {
+    Domain       string          `json:"domain"`
+    Users        int             `json:"users"`
+    UsersPerFile int             `json:"users_per_file"`
+    Files        []UserChunkFile `json:"files"`
+}
+
+// WriteUserChunks moves the user list of output into numbered JSON files
+// of meta.UsersPerFile users each, <name>-users-0001.json and on, and
+// writes their index to <name>-users-index.json, which output then refers
+// to. Lists that fit into one file are left in place. It returns the paths
+// of the files written, the index last.
+func WriteUserChunks(output *SimplifiedOutputData, meta ExportMeta) ([]string, error) {
+    perFile := meta.UsersPerFile
+    if perFile <= 0 || len(output.UserStats) <= perFile {
+        return nil, nil
+    }
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return nil, err
+    }
+    base := OutputBaseFilename(meta)
+
+    index := UserChunkIndex{Domain: output.QueryInfo.Domain, Users: len(output.UserStats), UsersPerFile: perFile}
+    var filenames []string
+    for start := 0; start < len(output.UserStats); start += perFile {
+        users := output.UserStats[start:min(start+perFile, len(output.UserStats))]
+        name := fmt.Sprintf("%s-users-%04d.json", base, len(index.Files)+1)
+        jsonData, err := json.MarshalIndent(users, "", "  ")
+        if err != nil {
+            return filenames, fmt.Errorf("error marshaling JSON: %w", err)
+        }
+        filename := filepath.Join(outputDir, name)
+        if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+            return filenames, fmt.Errorf("error writing file: %w", err)
+        }
+        filenames = append(filenames, filename)
+        sum := sha256.Sum256(jsonData)
+        index.Files = append(index.Files, UserChunkFile{File: name, Users: len(users), Bytes: int64(len(jsonData)), SHA256: hex.EncodeToString(sum[:])})
+    }
+
+    indexName := base + "-users-index.json"
+    jsonData, err := json.MarshalIndent(index, "", "  ")
+    if err != nil {
+        return filenames, fmt.Errorf("error marshaling JSON: %w", err)
+    }
+    filename := filepath.Join(outputDir, indexName)
+    if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+        return filenames, fmt.Errorf("error writing file: %w", err)
+    }
+    output.UserStats = nil
+    output.UserStatsIndex = indexName
+    return append(filenames, filename), nil
+}
+
+// LoadUserChunks restores the user list of an output read from dir whose
+// users were split into chunk files, checking every file against the index
+func LoadUserChunks(dir string, data *SimplifiedOutputData) error {
+    if data.UserStatsIndex == "" {
+        return nil
+    }
+    content, err := os.ReadFile(filepath.Join(dir, data.UserStatsIndex))
+    if err != nil {
+        return fmt.Errorf("error reading user index: %w", err)
+    }
+    var index UserChunkIndex
+    if err := json.Unmarshal(content, &index); err != nil {
+        return fmt.Errorf("error parsing user index %s: %w", data.UserStatsIndex, err)
+    }
+
+    users := data.UserStats[:0:0]
+    for _, file := range index.Files {
+        content, err := os.ReadFile(filepath.Join(dir, file.File))
+        if err != nil {
+            return fmt.Errorf("error reading user file: %w", err)
+        }
+        if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != file.SHA256 {
+            return fmt.Errorf("user file %s does not match its index", file.File)
+        }
+        chunk := data.UserStats[:0:0]
+        if err := json.Unmarshal(content, &chunk); err != nil {
+            return fmt.Errorf("error parsing user file %s: %w", file.File, err)
+        }
+        users = append(users, chunk...)
+    }
+    if len(users) != index.Users {
+        return fmt.Errorf("user files of %s hold %d users, the index lists %d", data.UserStatsIndex, len(users), index.Users)
+    }
+    data.UserStats = users
+    return nil
+}
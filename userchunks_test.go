@@ -0,0 +1,47 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestWriteUserChunks(t *testing.T) {
+    result := &Result{Users: make(map[string]*UserStats), Providers: make(map[string]*ProviderStats)}
+    for i := 0; i < 7; i++ {
+        username := fmt.Sprintf("user%d@uni.example", i)
+        result.Users[username] = &UserStats{Hits: int64(i + 1)}
+        result.Users[username].Providers.Add("sp.example.org")
+    }
+    meta := ExportMeta{Domain: "uni.example", TimeRange: testRange(t, 3), OutputDir: t.TempDir(), BaseName: "report", UsersPerFile: 3, SortUsers: SortByName}
+
+    files, err := RunExporters([]string{"json"}, result, meta)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // The output, three user files and the index
+    if len(files) != 5 || filepath.Base(files[4]) != "report-3d-users-index.json" || filepath.Base(files[1]) != "report-3d-users-0001.json" {
+        t.Fatalf("files: %v", files)
+    }
+
+    data, err := LoadOutput(files[0])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if data.UserStatsIndex != "report-3d-users-index.json" || len(data.UserStats) != 7 || data.UserStats[6].Username != "user6@uni.example" {
+        t.Fatalf("loaded users: %d, index %q", len(data.UserStats), data.UserStatsIndex)
+    }
+
+    if err := os.WriteFile(files[2], []byte("[]"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadOutput(files[0]); err == nil {
+        t.Error("modified user file accepted")
+    }
+
+    meta.UsersPerFile, meta.BaseName = 10, "small"
+    if files, err = RunExporters([]string{"json"}, result, meta); err != nil || len(files) != 1 {
+        t.Errorf("short list split: %v, %v", files, err)
+    }
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "path/filepath"
+    "strconv"
+)
+
+// DayVerification compares the hits of one day derived from the user
+// aggregation with Quickwit's own count
+type DayVerification struct {
+    Date           string `json:"date"`
+    CountedHits    int64  `json:"counted_hits"`
+    AggregatedHits int64  `json:"aggregated_hits"`
+    // Missing is positive when bucket size limits dropped events
+    Missing int64 `json:"missing"`
+}
+
+// VerificationReport is the result of a -verify pass
+type VerificationReport struct {
+    Days       []DayVerification `json:"days"`
+    Mismatches int               `json:"mismatches"`
+}
+
+// VerifyResult re-counts every day of the time range with a count-only
+// (max_hits 0, no aggregations) query and compares the counts with the
+// per-day hits of the result. A mismatch means the terms size limits of the
+// aggregation silently dropped users or providers.
+func VerifyResult(ctx context.Context, client *HTTPClient, query map[string]interface{}, result *Result, timeRange TimeRange) (*VerificationReport, error) {
+    report := &VerificationReport{}
+    for _, job := range ClampJobs(GenerateJobs(timeRange), result.TimestampCutoff) {
+        numHits, err := CountHits(ctx, client, query["query"], job.StartTimestamp, job.EndTimestamp)
+        if err != nil {
+            return nil, fmt.Errorf("error verifying %s: %w", job.Date.Format(DateFormat), err)
+        }
+
+        day := job.Date.Format(DateFormat)
+        var aggregated int64
+        result.mu.RLock()
+        if dayStats := result.Days[day]; dayStats != nil {
+            aggregated = dayStats.Hits
+        }
+        result.mu.RUnlock()
+
+        // Partial days of the range share a date; add them up
+        if n := len(report.Days); n > 0 && report.Days[n-1].Date == day {
+            report.Days[n-1].CountedHits += numHits
+            continue
+        }
+        report.Days = append(report.Days, DayVerification{
+            Date:           day,
+            CountedHits:    numHits,
+            AggregatedHits: aggregated,
+        })
+    }
+
+    for i := range report.Days {
+        day := &report.Days[i]
+        day.Missing = day.CountedHits - day.AggregatedHits
+        if day.Missing != 0 {
+            report.Mismatches++
+        }
+    }
+    return report, nil
+}
+
+// CountHits returns the number of events matching queryString between the
+// timestamps, using a count-only query
+func CountHits(ctx context.Context, client *HTTPClient, queryString interface{}, start, end int64) (int64, error) {
+    response, err := client.SendQuickwitRequest(ctx, map[string]interface{}{
+        "query":           queryString,
+        "start_timestamp": start,
+        "end_timestamp":   end,
+        "max_hits":        0,
+    })
+    if err != nil {
+        return 0, err
+    }
+    return int64(response.NumHits), nil
+}
+
+// ExportVerificationCSV writes the per-day verification next to the other
+// CSV files. It returns an empty filename when no verification was run.
+func ExportVerificationCSV(result *Result, meta ExportMeta) (string, error) {
+    if result.Verification == nil {
+        return "", nil
+    }
+
+    outputDir, err := PrepareOutputDir(meta.OutputDir, meta.Domain)
+    if err != nil {
+        return "", err
+    }
+    records := [][]string{{"Date", "Counted Hits", "Aggregated Hits", "Missing"}}
+    for _, day := range result.Verification.Days {
+        records = append(records, []string{
+            day.Date,
+            strconv.FormatInt(day.CountedHits, 10),
+            strconv.FormatInt(day.AggregatedHits, 10),
+            strconv.FormatInt(day.Missing, 10),
+        })
+    }
+    filename := filepath.Join(outputDir, OutputBaseFilename(meta)+"-verification.csv")
+    if err := writeCSVFile(filename, records); err != nil {
+        return "", err
+    }
+    return filename, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "runtime"
+    "runtime/debug"
+)
+
+// Build provenance, injected at build time with
+//   go build -ldflags "-X main.Version=2.3.0.0 -X main.GitCommit=$(git rev-parse --short HEAD) -X main.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+    Version   = "2.3.0.0"
+    GitCommit = ""
+    BuildDate = ""
+)
+
+// RunInfo describes the build and run that produced an output file
+type RunInfo struct {
+    Version   string `json:"version"`
+    GitCommit string `json:"git_commit,omitempty"`
+    BuildDate string `json:"build_date,omitempty"`
+    GoVersion string `json:"go_version"`
+    // Environment is the Quickwit environment queried (-env)
+    Environment string `json:"environment,omitempty"`
+    // Timing is the latency breakdown of the run (-timing)
+    Timing *TimingReport `json:"timing,omitempty"`
+    // Resources is the memory use and channel backlog of the run
+    Resources *ResourceReport `json:"resources,omitempty"`
+    // Backpressure is the result queue instrumentation of the run
+    Backpressure *BackpressureStats `json:"backpressure,omitempty"`
+}
+
+// GetRunInfo returns the build provenance of the running binary. When the
+// commit or build date were not injected, the VCS information embedded by
+// the Go toolchain is used instead.
+func GetRunInfo() RunInfo {
+    info := RunInfo{
+        Version:   Version,
+        GitCommit: GitCommit,
+        BuildDate: BuildDate,
+        GoVersion: runtime.Version(),
+    }
+
+    if buildInfo, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range buildInfo.Settings {
+            switch setting.Key {
+            case "vcs.revision":
+                if info.GitCommit == "" {
+                    info.GitCommit = setting.Value
+                    if len(info.GitCommit) > 12 {
+                        info.GitCommit = info.GitCommit[:12]
+                    }
+                }
+            case "vcs.time":
+                if info.BuildDate == "" {
+                    info.BuildDate = setting.Value
+                }
+            case "vcs.modified":
+                if setting.Value == "true" && GitCommit == "" && info.GitCommit != "" {
+                    info.GitCommit += "-dirty"
+                }
+            }
+        }
+    }
+
+    return info
+}
+
+// runVersion implements the "version" subcommand
+func runVersion(args []string) int {
+    info := GetRunInfo()
+    fmt.Fprintf(os.Stdout, "eduroam-idp %s\n", info.Version)
+    fmt.Fprintf(os.Stdout, "  commit:     %s\n", valueOrUnknown(info.GitCommit))
+    fmt.Fprintf(os.Stdout, "  build date: %s\n", valueOrUnknown(info.BuildDate))
+    fmt.Fprintf(os.Stdout, "  go version: %s\n", info.GoVersion)
+    fmt.Fprintf(os.Stdout, "  platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+    return 0
+}
+
+// valueOrUnknown returns value, or "unknown" when it is empty
+func valueOrUnknown(value string) string {
+    if value == "" {
+        return "unknown"
+    }
+    return value
+}
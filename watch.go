@@ -0,0 +1,196 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+)
+
+// MergeResults combines two results into a new one, leaving both inputs
+// untouched. Days present in both are taken from b, and so is the clock
+// skew cutoff of b, the later run.
+func MergeResults(a, b *Result) *Result {
+    merged := &Result{
+        Users:           make(map[string]*UserStats),
+        Providers:       make(map[string]*ProviderStats),
+        Days:            make(map[string]*DayStats),
+        StartDate:       a.StartDate,
+        EndDate:         a.EndDate,
+        Pivot:           a.Pivot,
+        Exclusions:      a.Exclusions,
+        FoldRealmCase:   a.FoldRealmCase,
+        DayVisits:       a.DayVisits || b.DayVisits,
+        TimestampCutoff: max(a.TimestampCutoff, b.TimestampCutoff),
+    }
+    if b.StartDate.Before(merged.StartDate) {
+        merged.StartDate = b.StartDate
+    }
+    if b.EndDate.After(merged.EndDate) {
+        merged.EndDate = b.EndDate
+    }
+
+    for _, source := range []*Result{a, b} {
+        source.mu.RLock()
+        merged.TotalHits += source.TotalHits
+        merged.FutureEvents += source.FutureEvents
+        merged.Partial = merged.Partial || source.Partial
+        merged.UnprocessedDays = append(merged.UnprocessedDays, source.UnprocessedDays...)
+        merged.TruncatedDays = append(merged.TruncatedDays, source.TruncatedDays...)
+
+        for username, stats := range source.Users {
+            user := merged.Users[username]
+            if user == nil {
+                user = &UserStats{FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen}
+                merged.Users[username] = user
+            }
+            for provider := range stats.Providers.All() {
+                user.Providers.Add(provider)
+            }
+            if stats.FirstSeen.Before(user.FirstSeen) {
+                user.FirstSeen = stats.FirstSeen
+            }
+            if stats.LastSeen.After(user.LastSeen) {
+                user.LastSeen = stats.LastSeen
+            }
+            user.Hits += stats.Hits
+        }
+
+        for name, stats := range source.Providers {
+            provider := merged.Providers[name]
+            if provider == nil {
+                provider = &ProviderStats{FirstSeen: stats.FirstSeen, LastSeen: stats.LastSeen}
+                merged.Providers[name] = provider
+            }
+            for username := range stats.Users.All() {
+                provider.Users.Add(username)
+            }
+            if stats.FirstSeen.Before(provider.FirstSeen) {
+                provider.FirstSeen = stats.FirstSeen
+            }
+            if stats.LastSeen.After(provider.LastSeen) {
+                provider.LastSeen = stats.LastSeen
+            }
+            provider.Hits += stats.Hits
+        }
+
+        for day, stats := range source.Days {
+            merged.Days[day] = stats
+        }
+
+        for name, stats := range source.Realms {
+            if merged.Realms == nil {
+                merged.Realms = make(map[string]*RealmStats)
+            }
+            realm := merged.Realms[name]
+            if realm == nil {
+                realm = &RealmStats{}
+                merged.Realms[name] = realm
+            }
+            for username := range stats.Users.All() {
+                realm.Users.Add(username)
+            }
+            realm.Hits += stats.Hits
+        }
+        source.mu.RUnlock()
+    }
+    return merged
+}
+
+// startOfDay returns midnight of t's day
+func startOfDay(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// dayRange returns a TimeRange from start to the end of end's day
+func dayRange(start, end time.Time) TimeRange {
+    endOfDay := startOfDay(end).Add(24*time.Hour - time.Second)
+    days := int(startOfDay(end).Sub(startOfDay(start)).Hours()/24+0.5) + 1
+    return TimeRange{StartDate: start, EndDate: endOfDay, Days: days}
+}
+
+// RunWatch runs the analysis for config.TimeRange once and then, every
+// interval, re-queries only the current day and merges it with the earlier
+// days. update is called with the combined result after every query. When
+// the date changes the finished day is queried a last time and kept, so the
+// covered range grows with the watch. A failed query is logged and
+// published, the last output is kept and the query is retried on the next
+// tick. RunWatch returns when ctx is done.
+func RunWatch(ctx context.Context, config Config, client *HTTPClient, query map[string]interface{}, broker *ProgressBroker, interval time.Duration, update func(*Result, TimeRange) error) error {
+    start := config.TimeRange.StartDate
+    today := startOfDay(time.Now())
+    if config.TimeRange.EndDate.Before(today) {
+        return fmt.Errorf("%w: -watch needs a range that includes today", ErrInvalidDateRange)
+    }
+
+    // analyse runs the analysis for the given days
+    analyse := func(timeRange TimeRange) (*Result, error) {
+        rangeConfig := config
+        rangeConfig.TimeRange = timeRange
+        return RunAnalysis(ctx, rangeConfig, client, query, broker)
+    }
+
+    // failed reports a query that is retried on the next tick
+    failed := func(what string, err error) {
+        log.Printf("Error querying %s, retrying in %v: %v (%s)", what, interval, err, ErrorFields(err))
+        broker.Publish(ProgressEvent{
+            Type:       ProgressError,
+            Domain:     config.Domain,
+            Message:    fmt.Sprintf("watch: %v", err),
+            ErrorClass: ErrorClass(err),
+        })
+    }
+
+    // base holds the days before today once they have been queried
+    var base *Result
+    if !start.Before(today) {
+        base = &Result{
+            Users:     make(map[string]*UserStats),
+            Providers: make(map[string]*ProviderStats),
+            Days:      make(map[string]*DayStats),
+            StartDate: start,
+            EndDate:   start,
+            Pivot:     config.Query.Pivot,
+        }
+    }
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        if base == nil {
+            earlier, err := analyse(dayRange(start, today.Add(-time.Second)))
+            if err == nil {
+                base = earlier
+            } else if ctx.Err() == nil {
+                failed("the earlier days", err)
+            }
+        }
+        if now := startOfDay(time.Now()); base != nil && now.After(today) {
+            // Close the finished day before moving on
+            finished, err := analyse(dayRange(today, today))
+            if err == nil {
+                base = MergeResults(base, finished)
+                today = now
+            } else if ctx.Err() == nil {
+                failed("the finished day", err)
+            }
+        }
+
+        if base != nil && !startOfDay(time.Now()).After(today) {
+            live, err := analyse(dayRange(today, today))
+            if err == nil {
+                if err := update(MergeResults(base, live), dayRange(start, today)); err != nil {
+                    log.Printf("Error updating watch output: %v", err)
+                }
+            } else if ctx.Err() == nil {
+                failed("the current day", err)
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-ticker.C:
+        }
+    }
+}
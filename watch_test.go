@@ -0,0 +1,62 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRunWatchRetries(t *testing.T) {
+    quickwit := &fakeQuickwit{}
+    var calls atomic.Int32
+    // The first query of the watch fails, the next ones succeed
+    backend := BackendFunc(func(req *http.Request) (*http.Response, error) {
+        if calls.Add(1) == 1 {
+            return response(http.StatusBadRequest, map[string]string{"message": "bad request"}), nil
+        }
+        return quickwit.Do(req)
+    })
+    config := testConfig(t)
+    config.Clock = nil
+    config.TimeRange = dayRange(startOfDay(time.Now()), time.Now())
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    broker := NewProgressBroker()
+    events, unsubscribe := broker.Subscribe()
+    defer unsubscribe()
+    var updates int
+    err := RunWatch(ctx, config, newTestClient(backend), map[string]interface{}{"query": "*"}, broker, 10*time.Millisecond, func(result *Result, timeRange TimeRange) error {
+        updates++
+        cancel()
+        return nil
+    })
+    if err != context.Canceled || updates != 1 {
+        t.Fatalf("RunWatch = %v after %d updates, want cancelled after 1", err, updates)
+    }
+
+    var published bool
+    for len(events) > 0 {
+        if event := <-events; event.Type == ProgressError && event.ErrorClass != "" {
+            published = true
+        }
+    }
+    if !published {
+        t.Error("failed query not published")
+    }
+}
+
+func TestMergeResultsKeepsSettings(t *testing.T) {
+    exclusions, err := ParseExclusions([]string{`service_provider:"client"`})
+    if err != nil {
+        t.Fatal(err)
+    }
+    a := &Result{Exclusions: exclusions, FoldRealmCase: true, TimestampCutoff: 100, FutureEvents: 2}
+    b := &Result{Exclusions: exclusions, FoldRealmCase: true, TimestampCutoff: 200, FutureEvents: 3}
+    merged := MergeResults(a, b)
+    if len(merged.Exclusions) != 1 || !merged.FoldRealmCase || merged.TimestampCutoff != 200 || merged.FutureEvents != 5 {
+        t.Errorf("merged settings: %+v", merged)
+    }
+}